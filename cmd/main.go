@@ -1,31 +1,389 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/brainexe/gosh/pkg"
 	"github.com/spf13/pflag"
 )
 
 func main() {
-	// Parse flags
-	command := pflag.StringP("command", "c", "", "Command to execute on all hosts")
-	user := pflag.StringP("user", "u", "", "Username for SSH connections")
-	noColor := pflag.Bool("no-color", false, "Disable colored output")
-	verbose := pflag.BoolP("verbose", "v", false, "Enable verbose output")
-	pflag.Parse()
-
-	hosts := pflag.Args()
-	if len(hosts) == 0 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [flags] host1 [host2 ...]\n", os.Args[0])
-		pflag.PrintDefaults()
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if args[0] == "help" {
+			printHelp(args[1:])
+			return
+		}
+		if sub := findSubcommand(args[0]); sub != nil {
+			sub.main(args[1:])
+			return
+		}
+	}
+	runLegacy(args)
+}
+
+// printLegacyUsage prints the usage of the flat invocation with all flags
+func printLegacyUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s run|upload|download|shell|serve [flags] ...   (see %s help)\n", os.Args[0], os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] host1 [host2 ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] --script <file> host1 [host2 ...] [-- args...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] --commands-file <file> host1 [host2 ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] grep <pattern> <file>... -- host1 [host2 ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] bench -c <command> [--runs 10] host1 [host2 ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s maintenance add|remove|list [--until 2h] [host ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s daemon [--socket path] [host ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s completion bash|zsh|fish\n", os.Args[0])
+	pflag.PrintDefaults()
+}
+
+// runLegacy handles the flat invocation, which accepts every flag: without a subcommand word it runs -c,
+// --script or --commands-file, or opens an interactive session
+func runLegacy(args []string) {
+	var o options
+	o.allFlags(pflag.CommandLine)
+	o.parse(pflag.CommandLine, args)
+	o.apply()
+
+	if o.cleanupSockets {
+		fmt.Printf("🧹 Removed %d stale socket(s)\n", pkg.CleanupSockets())
+		return
+	}
+
+	if pflag.NArg() == 0 && o.k8sNodes == "" {
+		printLegacyUsage()
+		os.Exit(1)
+	}
+
+	selectors := pflag.Args()
+	var grepArgs, scriptArgs []string
+	if o.script != "" {
+		if dash := pflag.CommandLine.ArgsLenAtDash(); dash >= 0 {
+			selectors, scriptArgs = selectors[:dash], selectors[dash:]
+		}
+		if len(selectors) == 0 && o.k8sNodes == "" {
+			fmt.Fprintf(os.Stderr, "Usage: %s [flags] --script <file> host1 [host2 ...] [-- args...]\n", os.Args[0])
+			os.Exit(1)
+		}
+	}
+	if len(selectors) > 0 && selectors[0] == "grep" {
+		dash := pflag.CommandLine.ArgsLenAtDash()
+		if dash < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s [flags] grep <pattern> <file>... -- host1 [host2 ...]\n", os.Args[0])
+			os.Exit(1)
+		}
+		grepArgs, selectors = selectors[1:dash], selectors[dash:]
+	}
+
+	bench := len(selectors) > 0 && selectors[0] == "bench"
+	if bench {
+		if o.command == "" || (len(selectors) == 1 && o.k8sNodes == "") {
+			fmt.Fprintf(os.Stderr, "Usage: %s [flags] bench -c <command> [--runs 10] host1 [host2 ...]\n", os.Args[0])
+			os.Exit(1)
+		}
+		selectors = selectors[1:]
+	}
+
+	groups := o.loadInventory()
+
+	if len(selectors) > 0 {
+		switch selectors[0] {
+		case "maintenance":
+			runMaintenance(o.maintenanceFile, selectors[1:], groups, o.until)
+			return
+		case "completion":
+			runCompletion(selectors[1:], groups)
+			return
+		case "serve":
+			runServeCommand(&o, groups)
+			return
+		case "daemon":
+			runDaemon(&o, selectors[1:], groups)
+			return
+		}
+	}
+
+	hosts, dropped := o.resolveHosts(selectors, groups)
+	o.execute(hosts, dropped, grepArgs, scriptArgs, bench)
+}
+
+// execute runs what the flags ask for on hosts: a grep, a benchmark, a script, a runbook or a command,
+// optionally watched or shown in the dashboard or tmux, and an interactive session otherwise
+func (o *options) execute(hosts, dropped, grepArgs, scriptArgs []string, bench bool) {
+	var err error
+	if o.at != "" {
+		if o.command == "" && o.script == "" {
+			fmt.Fprintln(os.Stderr, "❌ Error: --at requires -c or --script")
+			os.Exit(1)
+		}
+		pkg.At, err = time.Parse(time.RFC3339, o.at)
+		if err != nil {
+			fatalf("--at: %v", err)
+		}
+		if time.Until(pkg.At) <= 0 {
+			pkg.Log.Warn("--at is in the past, running immediately")
+		}
+	}
+
+	if o.sudo && grepArgs == nil {
+		if err := pkg.PromptSudoPassword(); err != nil {
+			fatalf("--sudo: %v", err)
+		}
+		pkg.Sudo = true
+	}
+
+	if o.script != "" {
+		if o.command != "" || pkg.Sudo {
+			fmt.Fprintln(os.Stderr, "❌ Error: --script cannot be combined with -c or --sudo")
+			os.Exit(1)
+		}
+		scriptCommand, content, err := pkg.ScriptCommand(o.script, scriptArgs)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if err := pkg.ConfirmDangerous(string(content), len(hosts)); err != nil {
+			fatalf("%v", err)
+		}
+		o.command = scriptCommand
+		pkg.Stdin = bytes.NewReader(content)
+	}
+
+	if o.watch != 0 && (o.command == "" || o.script != "" || o.at != "" || o.commandsFile != "" || o.watch < 0) {
+		fmt.Fprintln(os.Stderr, "❌ Error: --watch needs a positive interval and -c, without --script, --at or --commands-file")
+		os.Exit(1)
+	}
+
+	// A runbook comes from --commands-file, or from piped stdin when no command was given
+	if o.commandsFile != "" || (o.command == "" && grepArgs == nil && pkg.PipedStdin() != nil) {
+		if o.command != "" {
+			fmt.Fprintln(os.Stderr, "❌ Error: --commands-file cannot be combined with -c or --script")
+			os.Exit(1)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		exitOnError(ctx, runRunbook(ctx, hosts, o.commandsFile, o.onFailure, o.user, o.noColor))
+		if len(dropped) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if o.command != "" && o.script == "" {
+		if err := pkg.ConfirmDangerous(o.command, len(hosts)); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	// Ctrl+C cancels the remote commands; interactive mode handles it per command instead
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch {
+	case o.tmux:
+		exitOnError(ctx, pkg.Tmux(ctx, hosts, o.user))
+	case o.tui:
+		exitOnError(ctx, pkg.TUI(ctx, hosts, o.command, o.user, o.noColor))
+	case bench:
+		exitOnError(ctx, pkg.Bench(ctx, hosts, o.command, o.user, o.runs))
+	case grepArgs != nil:
+		exitOnError(ctx, pkg.Grep(ctx, hosts, grepArgs[0], grepArgs[1:], o.user, o.noColor, pkg.GrepOptions{
+			MaxCount:         o.maxCount,
+			FilesWithMatches: o.filesWithMatches,
+			IgnoreCase:       o.ignoreCase,
+		}))
+	case o.watch > 0:
+		pkg.Watch(ctx, hosts, o.command, o.user, o.noColor, o.watch)
+		fmt.Println("🛑 Watch stopped")
+	case o.command != "":
+		if pkg.Stdin == nil && !pkg.Sudo { // The sudo password owns stdin
+			pkg.Stdin = pkg.PipedStdin()
+		}
+		exitOnError(ctx, pkg.ExecuteCommand(ctx, hosts, o.command, o.user, o.noColor))
+	default:
+		stop()
+		if pkg.Aliases, err = pkg.LoadAliases(o.aliasesFile); err != nil {
+			fatalf("%v", err)
+		}
+		pkg.AliasesFile = o.aliasesFile
+		if pkg.HostTags, err = pkg.LoadTags(o.tagsFile); err != nil {
+			fatalf("%v", err)
+		}
+		pkg.TagsFile = o.tagsFile
+		exitOnError(context.Background(), pkg.InteractiveMode(context.Background(), hosts, o.user, o.noColor, o.verbose > 0))
+	}
+
+	// Skipped hosts fail one-shot runs, as they did when ssh reported them
+	if len(dropped) > 0 && (o.command != "" || grepArgs != nil) {
+		os.Exit(1)
+	}
+}
+
+// exitOnError exits with status 1 when err is set. Failures on single hosts were already printed with
+// their output, so only other errors are reported here.
+func exitOnError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	var hostErr *pkg.HostError
+	switch {
+	case ctx.Err() != nil:
+		fmt.Fprintln(os.Stderr, "🛑 Command interrupted by user")
+	case !errors.As(err, &hostErr):
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+	}
+	os.Exit(1)
+}
+
+// runRunbook runs the commands of a runbook file, or of stdin when file is empty, step by step on all hosts
+func runRunbook(ctx context.Context, hosts []string, file, onFailure, user string, noColor bool) error {
+	policy, err := pkg.ParseFailurePolicy(onFailure)
+	if err != nil {
+		return fmt.Errorf("--on-failure: %w", err)
+	}
+
+	source := pkg.PipedStdin()
+	if file != "" {
+		f, err := os.Open(file) // #nosec G304 -- runbook path is chosen by the local user
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		source = f
+	}
+
+	commands, err := pkg.ReadCommands(source)
+	if err != nil {
+		return err
+	}
+	for _, command := range commands {
+		if err := pkg.ConfirmDangerous(command, len(hosts)); err != nil {
+			return err
+		}
+	}
+	return pkg.RunCommands(ctx, hosts, commands, user, noColor, policy)
+}
+
+// runServeCommand handles the "serve" subcommand until Ctrl+C
+func runServeCommand(o *options, groups map[string][]string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	exitOnError(ctx, runServe(ctx, o.listen, o.token, o.user, o.maintenanceFile, groups, o.runnerOptions()))
+}
+
+// runDaemon handles the "daemon" subcommand until Ctrl+C or SIGTERM, keeping connections to warm open
+func runDaemon(o *options, warm []string, groups map[string][]string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	path := o.daemonSocket
+	if path == "" {
+		path = pkg.DefaultDaemonSocket()
+	}
+	fmt.Fprintf(os.Stderr, "🔌 Listening on %s\n", path)
+	if o.metricsListen != "" {
+		go func() {
+			if err := pkg.ServeMetrics(ctx, o.metricsListen); err != nil {
+				fmt.Fprintf(os.Stderr, "❌ Error: --metrics-listen: %v\n", err)
+			}
+		}()
+	}
+	exitOnError(ctx, pkg.ServeDaemon(ctx, path, &pkg.Daemon{Groups: groups, User: o.user, Warm: warm}))
+}
+
+// runServe serves the web dashboard and API until ctx ends, logging every run to stderr
+func runServe(ctx context.Context, listen, token, user, maintenanceFile string, groups map[string][]string, options []pkg.Option) error {
+	fmt.Fprintf(os.Stderr, "🌐 Serving on http://%s\n", listen)
+	if token == "" {
+		token = rand.Text()
+		fmt.Fprintf(os.Stderr, "🔑 No --token given, generated one for this run: %s\n", token)
+		fmt.Fprintf(os.Stderr, "   Dashboard: http://%s/#token=%s\n", listen, token)
+	}
+	return pkg.Serve(ctx, listen, &pkg.Server{
+		Groups:          groups,
+		User:            user,
+		Token:           token,
+		MaintenanceFile: maintenanceFile,
+		Options:         options,
+		Audit:           os.Stderr,
+	})
+}
+
+// completionValues are the values the shell completion scripts offer for flags taking one of a fixed set
+var completionValues = map[string][]string{
+	"backend":                  {pkg.BackendSSH, pkg.BackendDocker, pkg.BackendKubectl},
+	"shell":                    {"sh", "bash", "zsh"},
+	"on-failure":               {"stop", "continue", "drop-hosts"},
+	"k8s-address":              {"InternalIP", "ExternalIP", "Hostname"},
+	"log-level":                pkg.LogLevels,
+	"log-format":               pkg.LogFormats,
+	"strict-host-key-checking": pkg.HostKeyPolicies,
+}
+
+// runCompletion handles the "completion" subcommand: it prints the completion script of a shell, or the
+// groups and known hosts the scripts offer as targets for "completion hosts"
+func runCompletion(args []string, groups map[string][]string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s completion bash|zsh|fish\n", os.Args[0])
 		os.Exit(1)
 	}
+	if args[0] == "hosts" {
+		for _, target := range pkg.CompletionTargets(groups, pkg.DefaultKnownHostsFile()) {
+			fmt.Println(target)
+		}
+		return
+	}
 
-	if *command != "" {
-		pkg.ExecuteCommand(hosts, *command, *user, *noColor)
-	} else {
-		pkg.InteractiveMode(hosts, *user, *noColor, *verbose)
+	var flags []pkg.CompletionFlag
+	pflag.VisitAll(func(flag *pflag.Flag) {
+		valueType := flag.Value.Type()
+		flags = append(flags, pkg.CompletionFlag{
+			Name:       flag.Name,
+			Shorthand:  flag.Shorthand,
+			Usage:      flag.Usage,
+			TakesValue: valueType != "bool" && valueType != "count",
+			Repeatable: valueType == "stringArray",
+			Values:     completionValues[flag.Name],
+		})
+	})
+	if err := pkg.WriteCompletionScript(os.Stdout, args[0], flags); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runMaintenance handles the "maintenance" subcommand
+func runMaintenance(path string, args []string, groups map[string][]string, until time.Duration) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	hosts, err := pkg.ResolveHosts(args[1:], groups)
+	if err == nil {
+		switch args[0] {
+		case "add":
+			err = pkg.AddMaintenance(path, hosts, until)
+		case "remove":
+			err = pkg.RemoveMaintenance(path, hosts)
+		case "list":
+		default:
+			err = fmt.Errorf("unknown maintenance action %q, expected add, remove or list", args[0])
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	entries, err := pkg.LoadMaintenance(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
 	}
+	pkg.PrintMaintenance(entries)
 }
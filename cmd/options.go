@@ -0,0 +1,644 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brainexe/gosh/pkg"
+	"github.com/spf13/pflag"
+)
+
+// options holds the values of all command line flags. Each subcommand registers the flag groups it
+// understands on its own flag set; the legacy flat invocation registers all of them.
+type options struct {
+	// Connection
+	configFile     string
+	parallel       int
+	user           string
+	identities     []string
+	sshOpts        []string
+	connectTimeout time.Duration
+	controlPersist time.Duration
+	keepalive      time.Duration
+	keepaliveCount int
+	idleTimeout    time.Duration
+	directFallback bool
+	socketDir      string
+	backend        string
+	local          bool
+	profile        string
+	hostKeyPolicy  string
+	knownHosts     string
+	forwardAgent   bool
+
+	// Host selection
+	limit           []string
+	exclude         []string
+	sample          int
+	shuffle         bool
+	preflight       bool
+	keepDuplicates  bool
+	dnsExpand       bool
+	k8sNodes        string
+	k8sAddress      string
+	groupsFile      string
+	discoveryFile   string
+	maintenanceFile string
+
+	// Display
+	noColor    bool
+	hashColors bool
+	theme      string
+
+	// Logging
+	verbose   verbosity
+	logLevel  string
+	logFormat string
+
+	// Output
+	quiet         bool
+	onlyFailures  bool
+	showDuration  bool
+	noEcho        bool
+	grepOutput    string
+	outputDir     string
+	outputMaxSize string
+	outputKeep    int
+
+	// Remote execution
+	shell      string
+	tty        bool
+	sudo       bool
+	becomeUser string
+	env        []string
+	yes        bool
+	confirm    bool
+	when       string
+	dangerous  []string
+	allowList  string
+	denyList   string
+
+	// One-shot runs
+	command      string
+	script       string
+	commandsFile string
+	onFailure    string
+	at           string
+	watch        time.Duration
+	tui          bool
+
+	// Interactive sessions
+	aliasesFile       string
+	tagsFile          string
+	tags              []string
+	completeHosts     int
+	completeIntersect bool
+	redirectRaw       bool
+	tmux              bool
+	forwardL          []string
+	forwardR          []string
+	gatherFacts       bool
+
+	// serve
+	listen string
+	token  string
+
+	// upload and download
+	dest string
+
+	// Legacy subcommands
+	cleanupSockets   bool
+	filesWithMatches bool
+	maxCount         int
+	ignoreCase       bool
+	runs             int
+	until            time.Duration
+	metricsListen    string
+	daemonSocket     string
+
+	flags    *pflag.FlagSet
+	settings map[string][]string // Config file settings of the selected profile
+}
+
+// connectionFlags registers how hosts are reached
+func (o *options) connectionFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", pkg.DefaultConfigFile(), "Config file with defaults and profiles for these flags")
+	fs.IntVar(&o.parallel, "parallel", 0, "Run at most this many ssh/scp processes at a time, 0 for all hosts at once")
+	fs.StringVarP(&o.user, "user", "u", "", "Username for SSH connections")
+	fs.StringArrayVarP(&o.identities, "identity", "i", nil, "Private key file for SSH connections (repeatable)")
+	fs.StringArrayVarP(&o.sshOpts, "ssh-opt", "o", nil, "Extra ssh option as Key=Value, passed to every ssh/scp invocation (repeatable)")
+	fs.DurationVar(&o.connectTimeout, "connect-timeout", pkg.ConnectTimeout, "Timeout for establishing SSH connections")
+	fs.DurationVar(&o.controlPersist, "control-persist", pkg.ControlPersist, "How long idle persistent connections stay open")
+	fs.DurationVar(&o.keepalive, "keepalive", pkg.ServerAliveInterval, "Interval of keepalives on persistent connections, 0 disables them")
+	fs.IntVar(&o.keepaliveCount, "keepalive-count", pkg.ServerAliveCountMax, "Unanswered keepalives before a persistent connection is dropped")
+	fs.DurationVar(&o.idleTimeout, "idle-timeout", 0, "Close persistent connections unused for this long and re-establish them on the next command, 0 disables it")
+	fs.BoolVar(&o.directFallback, "direct-fallback", false, "Connect anew for every command to hosts where no persistent connection can be established, instead of leaving them out")
+	fs.StringVar(&o.socketDir, "socket-dir", "", "Directory for control sockets (default: $XDG_RUNTIME_DIR/gosh or the temp dir)")
+	fs.StringVar(&o.backend, "backend", pkg.BackendSSH, "Transport to hosts: ssh, docker (containers via docker exec) or kubectl (pods via kubectl exec)")
+	fs.BoolVar(&o.local, "local", false, "Run commands for localhost targets directly instead of over ssh")
+	fs.StringVar(&o.profile, "profile", "", "Environment profile; host keys are pinned per profile in ~/.gosh/known_hosts/<profile>")
+	fs.StringVar(&o.hostKeyPolicy, "strict-host-key-checking", "", "Host key policy: yes (refuse unknown hosts), accept-new (pin new keys) or no (default: ssh_config, accept-new with --profile)")
+	fs.StringVar(&o.knownHosts, "known-hosts", "", "known_hosts file to check host keys against instead of ~/.ssh/known_hosts or that of the profile")
+	fs.BoolVarP(&o.forwardAgent, "forward-agent", "A", false, "Forward the local ssh-agent to the hosts, e.g. for git over ssh there")
+}
+
+// inventoryFlags registers the files that define groups, discovery endpoints and hosts in maintenance
+func (o *options) inventoryFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.groupsFile, "groups-file", pkg.DefaultGroupsFile(), "File with host group definitions")
+	fs.StringVar(&o.discoveryFile, "discovery-file", pkg.DefaultDiscoveryFile(), "File with the Consul and etcd endpoints used by @consul: and @etcd: selectors")
+	fs.StringVar(&o.maintenanceFile, "maintenance-file", pkg.DefaultMaintenanceFile(), "File listing hosts in maintenance")
+}
+
+// targetFlags registers how the host selectors are expanded and narrowed down
+func (o *options) targetFlags(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&o.limit, "limit", nil, "Only target hosts matching this glob or /regex/ (repeatable)")
+	fs.StringArrayVar(&o.exclude, "exclude", nil, "Skip hosts matching this glob or /regex/ (repeatable)")
+	fs.IntVar(&o.sample, "sample", 0, "Only target this many randomly picked hosts")
+	fs.BoolVar(&o.shuffle, "shuffle", false, "Target hosts in random order")
+	fs.BoolVar(&o.preflight, "preflight", false, "Probe the ssh port of all hosts in parallel first and skip those that don't answer")
+	fs.BoolVar(&o.keepDuplicates, "keep-duplicates", false, "Keep hosts that resolve to the same machine instead of merging them")
+	fs.BoolVar(&o.dnsExpand, "dns-expand", false, "Target every address of names with several A/AAAA records, and the targets of _service._proto SRV names")
+	fs.StringVar(&o.k8sNodes, "k8s-nodes", "", "Target Kubernetes nodes matching this label selector via kubectl (all nodes without a value)")
+	fs.Lookup("k8s-nodes").NoOptDefVal = pkg.AllNodes
+	fs.StringVar(&o.k8sAddress, "k8s-address", "InternalIP", "Node address type used with --k8s-nodes: InternalIP, ExternalIP or Hostname")
+	o.inventoryFlags(fs)
+}
+
+// logFlags registers which diagnostics gosh writes to stderr
+func (o *options) logFlags(fs *pflag.FlagSet) {
+	fs.VarP(&o.verbose, "verbose", "v", "Enable verbose output like --log-level info; -vv and -vvv also pass -v and -vv to ssh, whose debug output is printed per host on stderr")
+	fs.Lookup("verbose").NoOptDefVal = "+1"
+	fs.StringVar(&o.logLevel, "log-level", "warn", "Minimum level of diagnostics written to stderr: debug, info or warn")
+	fs.StringVar(&o.logFormat, "log-format", "text", "Format of diagnostics: text or json")
+}
+
+// verbosity is the value of -v, counting repetitions like -vv. The config file and environment may also
+// give true or false.
+type verbosity int
+
+// String returns the count
+func (v *verbosity) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+// Set counts another -v for "+1", and takes a count or true or false otherwise
+func (v *verbosity) Set(value string) error {
+	switch value {
+	case "+1":
+		*v++
+	case "true":
+		*v = max(*v, 1)
+	case "false":
+		*v = 0
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("expected a count, true or false, got %q", value)
+		}
+		*v = verbosity(n)
+	}
+	return nil
+}
+
+// Type returns "count" like pflag's counting flags
+func (v *verbosity) Type() string {
+	return "count"
+}
+
+// displayFlags registers how host output is colored
+func (o *options) displayFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.noColor, "no-color", false, "Disable colored output")
+	fs.BoolVar(&o.hashColors, "hash-colors", false, "Derive host colors from the hostname instead of its position")
+	fs.StringVar(&o.theme, "theme", "default", "Color theme: default, solarized, high-contrast or path to a theme file")
+}
+
+// outputFlags registers which command output is shown and where it is written
+func (o *options) outputFlags(fs *pflag.FlagSet) {
+	fs.BoolVarP(&o.quiet, "quiet", "q", false, "Suppress non-error host output")
+	fs.BoolVar(&o.onlyFailures, "only-failures", false, "Only print output from hosts whose command failed")
+	fs.BoolVar(&o.showDuration, "show-duration", false, "Print each host's command wall time after its output and list the slowest hosts")
+	fs.BoolVar(&o.noEcho, "no-echo", false, "Keep echoed commands and connection banners out of the output")
+	fs.StringVar(&o.grepOutput, "grep", "", "Only display host output lines matching this regular expression")
+	fs.StringVar(&o.outputDir, "output-dir", "", "Write each host's output to <dir>/<host>.log")
+	fs.StringVar(&o.outputMaxSize, "output-max-size", "0", "Rotate per-host logs beyond this size (e.g. 10M), 0 disables rotation")
+	fs.IntVar(&o.outputKeep, "output-keep", 5, "Number of gzip-compressed rotated logs kept per host")
+}
+
+// execFlags registers how remote commands are started
+func (o *options) execFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.shell, "shell", "", "Run commands through this login shell on every host: sh, bash or zsh (default: the user's login shell)")
+	fs.BoolVarP(&o.tty, "tty", "t", false, "Request a pseudo-terminal for remote commands (stderr is merged into stdout)")
+	fs.BoolVar(&o.sudo, "sudo", false, "Run commands through sudo; the password is prompted once and sent to each host's stdin")
+	fs.StringVar(&o.becomeUser, "become-user", "", "Run commands as this user via sudo (combine with --sudo if a password is needed)")
+	fs.StringArrayVar(&o.env, "env", nil, "Export KEY=VALUE into every remote command (repeatable)")
+	fs.BoolVarP(&o.yes, "yes", "y", false, "Run commands matching a dangerous pattern without asking to type the host count")
+	fs.StringArrayVar(&o.dangerous, "dangerous-pattern", nil, "Also ask before commands matching this regular expression (repeatable)")
+	fs.BoolVar(&o.confirm, "confirm-each", false, "Ask y/n/all/quit on the terminal before running each command on each host")
+	fs.StringVar(&o.when, "when", "", "Only run commands on hosts where this guard command succeeds (e.g. 'test -f /etc/redhat-release')")
+}
+
+// restrictFlags registers which commands may run on hosts at all
+func (o *options) restrictFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.allowList, "allow-list", "", "Only run commands matching a pattern of this file (* wildcards, one per line)")
+	fs.StringVar(&o.denyList, "deny-list", "", "Refuse commands matching a pattern of this file (* wildcards, one per line)")
+}
+
+// runFlags registers what a one-shot run executes and when
+func (o *options) runFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&o.command, "command", "c", "", "Command to execute on all hosts")
+	fs.StringVar(&o.script, "script", "", "Run a local script on all hosts; script arguments follow -- after the hosts")
+	fs.StringVar(&o.commandsFile, "commands-file", "", "Run each line of this file as a command on all hosts, one step after another")
+	fs.StringVar(&o.onFailure, "on-failure", "stop", "Runbook policy when a step fails: stop, continue or drop-hosts")
+	fs.StringVar(&o.at, "at", "", "Start the -c command on all hosts at this RFC 3339 time (e.g. 2025-01-10T02:00:00Z)")
+	fs.DurationVar(&o.watch, "watch", 0, "Re-run the -c command on all hosts at this interval (e.g. 5s) until Ctrl+C")
+	fs.BoolVar(&o.tui, "tui", false, "Show a full-screen dashboard with a row per host instead of prefixed lines")
+}
+
+// shellFlags registers the settings of interactive sessions
+func (o *options) shellFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.aliasesFile, "aliases-file", pkg.DefaultAliasesFile(), "File with interactive command aliases")
+	fs.StringVar(&o.tagsFile, "tags-file", pkg.DefaultTagsFile(), "File with host tags for @key=value targeting in interactive mode")
+	fs.StringArrayVar(&o.tags, "tag", nil, "Tag a host or glob pattern for this run, e.g. 'web*:role=web' (repeatable)")
+	fs.IntVar(&o.completeHosts, "complete-hosts", pkg.CompletionHosts, "Number of hosts Tab completion asks, 0 for all")
+	fs.BoolVar(&o.completeIntersect, "complete-intersect", false, "Only complete names that exist on every asked host")
+	fs.BoolVar(&o.redirectRaw, "redirect-raw", false, "Write output redirected with !> in interactive mode without host prefixes")
+	fs.BoolVar(&o.tmux, "tmux", false, "Open a tmux session with an interactive ssh pane per host and synchronized input")
+	fs.StringArrayVar(&o.forwardL, "forward-L", nil, "Forward [bind:]port:host:hostport from this machine to every host once connected, on consecutive ports (repeatable)")
+	fs.StringArrayVar(&o.forwardR, "forward-R", nil, "Forward [bind:]port:host:hostport from every host to this machine once connected (repeatable)")
+	fs.BoolVar(&o.gatherFacts, "gather-facts", false, "Gather and show the facts of every host once connected, like :facts")
+}
+
+// serveFlags registers the web dashboard settings
+func (o *options) serveFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.listen, "listen", "127.0.0.1:8080", "serve: address to listen on")
+	fs.StringVar(&o.token, "token", os.Getenv("GOSH_SERVE_TOKEN"), "serve: bearer token required by the API (default $GOSH_SERVE_TOKEN, otherwise a random one)")
+}
+
+// legacyFlags registers the flags of the subcommands only reachable through the flat invocation
+func (o *options) legacyFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.cleanupSockets, "cleanup-sockets", false, "Remove control sockets left behind by crashed sessions and exit")
+	fs.BoolVar(&o.filesWithMatches, "files-with-matches", false, "grep: only list files containing a match")
+	fs.IntVar(&o.maxCount, "max-count", 20, "grep: maximum matching lines per file, 0 for unlimited")
+	fs.BoolVar(&o.ignoreCase, "ignore-case", false, "grep: match case-insensitively")
+	fs.IntVar(&o.runs, "runs", 10, "bench: number of times the command runs on each host")
+	fs.DurationVar(&o.until, "until", 0, "maintenance add: keep hosts in maintenance for this long (e.g. 2h), 0 until removed")
+	fs.StringVar(&o.metricsListen, "metrics-listen", "", "daemon: also serve Prometheus metrics on this TCP address (e.g. 127.0.0.1:9273)")
+	fs.StringVar(&o.daemonSocket, "socket", "", "daemon: Unix socket to listen on (default: daemon.sock in the socket directory)")
+}
+
+// allFlags registers every flag, as the legacy flat invocation accepts them all
+func (o *options) allFlags(fs *pflag.FlagSet) {
+	o.connectionFlags(fs)
+	o.restrictFlags(fs)
+	o.logFlags(fs)
+	o.targetFlags(fs)
+	o.displayFlags(fs)
+	o.outputFlags(fs)
+	o.execFlags(fs)
+	o.runFlags(fs)
+	o.shellFlags(fs)
+	o.serveFlags(fs)
+	o.legacyFlags(fs)
+}
+
+// parse parses args with fs, turning exclusion selectors like -@canary into !@canary first as they would
+// otherwise be taken for flags, and fills in flags not given from the config file. Flag values and the
+// arguments after "--", e.g. those of a script, are passed on unchanged.
+func (o *options) parse(fs *pflag.FlagSet, args []string) {
+	value := false
+	for i, arg := range args {
+		if value {
+			value = false
+			continue
+		}
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-@") {
+			args[i] = "!" + arg[1:]
+			continue
+		}
+		value = takesValue(fs, arg)
+	}
+	o.flags = fs
+	_ = fs.Parse(args)
+	o.loadConfig()
+}
+
+// takesValue reports whether arg is a flag of fs, or a group of shorthand flags ending in one, that takes
+// the next argument as its value
+func takesValue(fs *pflag.FlagSet, arg string) bool {
+	if name, ok := strings.CutPrefix(arg, "--"); ok {
+		flag := fs.Lookup(name)
+		return flag != nil && flag.NoOptDefVal == ""
+	}
+	shorthands, ok := strings.CutPrefix(arg, "-")
+	if !ok {
+		return false
+	}
+	for i := range len(shorthands) {
+		flag := fs.ShorthandLookup(shorthands[i : i+1])
+		if flag == nil {
+			return false
+		}
+		if flag.NoOptDefVal == "" {
+			return i == len(shorthands)-1 // Otherwise the rest of arg is the value
+		}
+	}
+	return false
+}
+
+// loadConfig sets the flags that weren't given on the command line from GOSH_* environment variables, then
+// from the selected profile and the defaults of the config file. The profile may itself come from either.
+func (o *options) loadConfig() {
+	known := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	new(options).allFlags(known)
+	env := make(map[string][]string)
+	sources := make(map[string]string)
+	known.VisitAll(func(flag *pflag.Flag) {
+		values, variable := pkg.EnvSetting(flag.Name, flag.Value.Type() == "stringArray")
+		if len(values) > 0 {
+			env[flag.Name], sources[flag.Name] = values, variable
+		}
+	})
+	if values := env["config"]; len(values) > 0 && !o.flags.Changed("config") {
+		o.configFile = values[len(values)-1]
+	}
+	delete(env, "config")
+
+	config, err := pkg.LoadConfig(o.configFile)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	profile := o.profile
+	if !o.flags.Changed("profile") {
+		values := env["profile"]
+		if len(values) == 0 {
+			values = config.Defaults["profile"]
+		}
+		if len(values) > 0 {
+			profile = values[len(values)-1]
+		}
+	}
+	o.settings = config.Settings(profile)
+	maps.Copy(o.settings, env)
+
+	// Settings of flags that other subcommands have are skipped, unknown ones are mistakes
+	for _, name := range slices.Sorted(maps.Keys(o.settings)) {
+		if slices.Contains(pkg.ProfileConfigKeys, name) {
+			continue
+		}
+		source := cmp.Or(sources[name], o.configFile)
+		if known.Lookup(name) == nil || name == "config" {
+			fatalf("%s: unknown setting %q", source, name)
+		}
+		if o.flags.Lookup(name) == nil || o.flags.Changed(name) {
+			continue
+		}
+		for _, value := range o.settings[name] {
+			if err := o.flags.Set(name, value); err != nil {
+				fatalf("%s: %s: %v", source, name, err)
+			}
+		}
+	}
+}
+
+// apply hands the parsed flags to pkg. Every subcommand registers the connection flags; other flags it
+// doesn't register keep their zero value, which means the default of pkg.
+func (o *options) apply() {
+	if pkg.AutoNoColor() {
+		o.noColor = true
+	}
+	pkg.Verbose = o.verbose > 0
+	pkg.SSHVerbosity = min(max(int(o.verbose)-1, 0), 3) // ssh takes at most -vvv
+	if err := pkg.SetLogging(cmp.Or(o.logLevel, "warn"), cmp.Or(o.logFormat, "text")); err != nil {
+		fatalf("%v", err)
+	}
+	pkg.HashColors = o.hashColors
+	pkg.Parallelism = o.parallel
+	pkg.ConnectTimeout = o.connectTimeout
+	pkg.ControlPersist = o.controlPersist
+	pkg.ServerAliveInterval = o.keepalive
+	pkg.ServerAliveCountMax = o.keepaliveCount
+	pkg.IdleTimeout = o.idleTimeout
+	pkg.SocketDir = o.socketDir
+	pkg.DirectFallback = o.directFallback
+	pkg.SSHOptions = o.sshOpts
+	if err := pkg.SetStrictHostKeyChecking(o.hostKeyPolicy); err != nil {
+		fatalf("--strict-host-key-checking: %v", err)
+	}
+	pkg.KnownHostsFile = o.knownHosts
+	pkg.ForwardAgent = o.forwardAgent
+	pkg.KeepDuplicates = o.keepDuplicates
+	pkg.NoEcho = o.noEcho
+	pkg.BecomeUser = o.becomeUser
+	pkg.TTY = o.tty
+	pkg.RedirectRaw = o.redirectRaw
+	if err := pkg.SetBackend(cmp.Or(o.backend, pkg.BackendSSH)); err != nil {
+		fatalf("--backend: %v", err)
+	}
+	pkg.LocalExec = o.local
+	if err := pkg.SetShell(o.shell); err != nil {
+		fatalf("--shell: %v", err)
+	}
+	if o.grepOutput != "" {
+		if err := pkg.SetOutputFilter(o.grepOutput); err != nil {
+			fatalf("--grep: %v", err)
+		}
+	}
+	pkg.OutputDir = o.outputDir
+	pkg.OutputKeep = o.outputKeep
+	maxSize, err := pkg.ParseSize(cmp.Or(o.outputMaxSize, "0"))
+	if err != nil {
+		fatalf("--output-max-size: %v", err)
+	}
+	pkg.OutputMaxSize = maxSize
+	for _, assignment := range o.env {
+		if err := pkg.SetEnv(assignment); err != nil {
+			fatalf("--env: %v", err)
+		}
+	}
+	pkg.ShowDuration = o.showDuration
+	pkg.AssumeYes = o.yes
+	for _, pattern := range o.dangerous {
+		if err := pkg.AddDangerousPattern(pattern); err != nil {
+			fatalf("--dangerous-pattern: %v", err)
+		}
+	}
+	if o.confirm && pkg.PipedStdin() != nil {
+		fatalf("--confirm-each needs a terminal to ask on, not input from a pipe or file")
+	}
+	pkg.ConfirmEach = o.confirm
+	pkg.When = o.when
+	if o.allowList != "" {
+		if err := pkg.LoadAllowList(o.allowList); err != nil {
+			fatalf("--allow-list: %v", err)
+		}
+	}
+	if o.denyList != "" {
+		if err := pkg.LoadDenyList(o.denyList); err != nil {
+			fatalf("--deny-list: %v", err)
+		}
+	}
+	if o.flags.Lookup("complete-hosts") != nil {
+		pkg.CompletionHosts = o.completeHosts
+	}
+	pkg.CompletionIntersect = o.completeIntersect
+	for _, spec := range slices.Concat(o.forwardL, o.forwardR) {
+		if err := pkg.ValidateForward(spec); err != nil {
+			fatalf("--forward-L/--forward-R: %v", err)
+		}
+	}
+	pkg.LocalForwards, pkg.RemoteForwards = o.forwardL, o.forwardR
+	for _, setting := range o.tags {
+		if err := pkg.AddTag(setting); err != nil {
+			fatalf("--tag: %v", err)
+		}
+	}
+	pkg.GatherFacts = o.gatherFacts
+	switch {
+	case o.onlyFailures:
+		pkg.Output = pkg.OutputOnlyFailures
+	case o.quiet:
+		pkg.Output = pkg.OutputQuiet
+	}
+	pkg.Profile = o.profile
+	if o.profile != "" {
+		settings, err := pkg.LoadProfile(o.profile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		pkg.CurrentProfile = settings
+	}
+	if err := pkg.CurrentProfile.Configure(o.settings); err != nil {
+		fatalf("%v", err)
+	}
+	pkg.IdentityFiles = append(o.identities, pkg.CurrentProfile.Identities...)
+	if err := pkg.SetTheme(cmp.Or(o.theme, "default")); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// runnerOptions returns the options that configure a pkg.Runner like the flags configure pkg in apply, as
+// Runners only take their settings from options
+func (o *options) runnerOptions() []pkg.Option {
+	options := []pkg.Option{
+		pkg.WithConnectTimeout(o.connectTimeout),
+		pkg.WithSSHOptions(pkg.ConnectionOptions()...),
+		pkg.WithBackend(cmp.Or(o.backend, pkg.BackendSSH)),
+		pkg.WithEnv(o.env...),
+		pkg.WithShell(o.shell),
+		pkg.WithBecomeUser(o.becomeUser),
+	}
+	if o.local {
+		options = append(options, pkg.WithLocalExec())
+	}
+	if o.noEcho {
+		options = append(options, pkg.WithNoEcho())
+	}
+	if o.allowList != "" {
+		patterns, err := pkg.ReadCommandList(o.allowList)
+		if err != nil {
+			fatalf("--allow-list: %v", err)
+		}
+		options = append(options, pkg.WithAllowList(patterns...))
+	}
+	if o.denyList != "" {
+		patterns, err := pkg.ReadCommandList(o.denyList)
+		if err != nil {
+			fatalf("--deny-list: %v", err)
+		}
+		options = append(options, pkg.WithDenyList(patterns...))
+	}
+	return options
+}
+
+// loadInventory reads the groups and discovery files
+func (o *options) loadInventory() map[string][]string {
+	groups, err := pkg.LoadGroups(o.groupsFile)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	pkg.Discovery, err = pkg.LoadDiscovery(o.discoveryFile)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	return groups
+}
+
+// resolveHosts expands selectors (and --k8s-nodes) to the hosts to run on, dropping hosts in maintenance,
+// hosts filtered out by the target flags and hosts that don't resolve or answer. The dropped hosts are
+// returned as they fail one-shot runs.
+func (o *options) resolveHosts(selectors []string, groups map[string][]string) (hosts, dropped []string) {
+	if o.k8sNodes != "" {
+		nodes, err := pkg.KubernetesNodes(context.Background(), o.k8sNodes, o.k8sAddress)
+		if err == nil && len(nodes) == 0 {
+			err = errors.New("no Kubernetes nodes match the selector")
+		}
+		if err != nil {
+			fatalf("--k8s-nodes: %v", err)
+		}
+		selectors = append(selectors, nodes...)
+	}
+
+	// Pods selected with @k8s/ are only reachable through kubectl
+	if backend := o.flags.Lookup("backend"); (backend == nil || !backend.Changed) &&
+		slices.ContainsFunc(selectors, func(s string) bool { return strings.Contains(s, "@k8s/") }) {
+		pkg.Backend = pkg.BackendKubectl
+	}
+
+	hosts, err := pkg.ResolveHosts(selectors, groups)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	maintenance, err := pkg.LoadMaintenance(o.maintenanceFile)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	hosts, skipped := pkg.ExcludeMaintenance(hosts, maintenance)
+	if len(skipped) > 0 {
+		pkg.Log.Warn("Skipping hosts in maintenance", "hosts", strings.Join(skipped, ","))
+	}
+	if o.dnsExpand {
+		hosts = pkg.ExpandDNS(context.Background(), hosts)
+	}
+	if hosts, err = pkg.FilterHosts(hosts, o.limit, o.exclude); err != nil {
+		fatalf("%v", err)
+	}
+	hosts = pkg.SampleHosts(hosts, o.sample, o.shuffle)
+	hosts, dropped = pkg.ValidateHosts(context.Background(), hosts)
+	if len(dropped) > 0 {
+		pkg.Log.Warn("Skipping hosts that don't resolve", "hosts", strings.Join(dropped, ","))
+	}
+	if o.preflight {
+		var dead []string
+		hosts, dead = pkg.Preflight(context.Background(), hosts)
+		if len(dead) > 0 {
+			pkg.Log.Warn("Skipping unreachable hosts", "hosts", strings.Join(dead, ","))
+		}
+		dropped = append(dropped, dead...)
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintln(os.Stderr, "❌ Error: host selectors matched no hosts")
+		os.Exit(1)
+	}
+
+	pkg.InitFDBudget(len(hosts))
+	pkg.CheckAgent(context.Background())
+	return hosts, dropped
+}
+
+// fatalf reports an error and exits with status 1
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "❌ Error: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/brainexe/gosh/pkg"
+	"github.com/spf13/pflag"
+)
+
+// subcommand is a word like "run" selecting what gosh does, with its own flags and help
+type subcommand struct {
+	name    string
+	usage   string // Arguments after the flags
+	summary string
+	flags   func(o *options, fs *pflag.FlagSet)
+	run     func(o *options, args []string)
+}
+
+// subcommands are listed by "gosh help" in this order
+var subcommands = []subcommand{
+	{
+		name:    "run",
+		usage:   "(-c <command> | --script <file> | --commands-file <file>) host1 [host2 ...] [-- script args...]",
+		summary: "Run a command, local script or runbook on all hosts in parallel and exit.\nWithout -c, --script or --commands-file the commands are read from piped stdin.",
+		flags: func(o *options, fs *pflag.FlagSet) {
+			o.connectionFlags(fs)
+			o.restrictFlags(fs)
+			o.logFlags(fs)
+			o.targetFlags(fs)
+			o.displayFlags(fs)
+			o.outputFlags(fs)
+			o.execFlags(fs)
+			o.runFlags(fs)
+		},
+		run: func(o *options, args []string) {
+			selectors, scriptArgs := args, []string(nil)
+			if dash := o.flags.ArgsLenAtDash(); dash >= 0 && o.script != "" {
+				selectors, scriptArgs = args[:dash], args[dash:]
+			}
+			if o.command == "" && o.script == "" && o.commandsFile == "" && pkg.PipedStdin() == nil {
+				fmt.Fprintln(os.Stderr, "❌ Error: run needs -c, --script, --commands-file or commands on stdin")
+				os.Exit(1)
+			}
+			requireHosts(o, selectors)
+			hosts, dropped := o.resolveHosts(selectors, o.loadInventory())
+			o.execute(hosts, dropped, nil, scriptArgs, false)
+		},
+	},
+	{
+		name:    "shell",
+		usage:   "host1 [host2 ...]",
+		summary: "Open an interactive session on all hosts, or with --tmux a tmux window with a pane per host.",
+		flags: func(o *options, fs *pflag.FlagSet) {
+			o.connectionFlags(fs)
+			o.restrictFlags(fs)
+			o.logFlags(fs)
+			o.targetFlags(fs)
+			o.displayFlags(fs)
+			o.outputFlags(fs)
+			o.execFlags(fs)
+			o.shellFlags(fs)
+		},
+		run: func(o *options, args []string) {
+			requireHosts(o, args)
+			hosts, dropped := o.resolveHosts(args, o.loadInventory())
+			o.execute(hosts, dropped, nil, nil, false)
+		},
+	},
+	{
+		name:    "upload",
+		usage:   "<file> host1 [host2 ...]",
+		summary: "Copy a local file to all hosts in parallel, into the home directory or --dest.",
+		flags: func(o *options, fs *pflag.FlagSet) {
+			o.connectionFlags(fs)
+			o.restrictFlags(fs)
+			o.logFlags(fs)
+			o.targetFlags(fs)
+			o.displayFlags(fs)
+			fs.StringVar(&o.dest, "dest", "", "Remote directory to copy the file into (default: the home directory)")
+		},
+		run: func(o *options, args []string) {
+			if len(args) == 0 {
+				o.flags.Usage()
+				os.Exit(1)
+			}
+			requireHosts(o, args[1:])
+			hosts, dropped := o.resolveHosts(args[1:], o.loadInventory())
+			transfer(dropped, func(ctx context.Context) error {
+				return pkg.Upload(ctx, hosts, args[0], o.dest, o.user, o.noColor)
+			})
+		},
+	},
+	{
+		name:    "download",
+		usage:   "<remote-path> host1 [host2 ...]",
+		summary: "Copy a remote file from all hosts in parallel to <dest>/<host>/, so the copies don't overwrite each other.",
+		flags: func(o *options, fs *pflag.FlagSet) {
+			o.connectionFlags(fs)
+			o.restrictFlags(fs)
+			o.logFlags(fs)
+			o.targetFlags(fs)
+			o.displayFlags(fs)
+			fs.StringVar(&o.dest, "dest", ".", "Local directory receiving a directory per host")
+		},
+		run: func(o *options, args []string) {
+			if len(args) == 0 {
+				o.flags.Usage()
+				os.Exit(1)
+			}
+			requireHosts(o, args[1:])
+			hosts, dropped := o.resolveHosts(args[1:], o.loadInventory())
+			transfer(dropped, func(ctx context.Context) error {
+				return pkg.Download(ctx, hosts, args[0], o.dest, o.user, o.noColor)
+			})
+		},
+	},
+	{
+		name:    "serve",
+		usage:   "",
+		summary: "Serve the web dashboard and HTTP API for running commands on hosts.",
+		flags: func(o *options, fs *pflag.FlagSet) {
+			o.connectionFlags(fs)
+			o.restrictFlags(fs)
+			o.logFlags(fs)
+			o.inventoryFlags(fs)
+			o.serveFlags(fs)
+		},
+		run: func(o *options, args []string) {
+			if len(args) > 0 {
+				o.flags.Usage()
+				os.Exit(1)
+			}
+			runServeCommand(o, o.loadInventory())
+		},
+	},
+}
+
+// findSubcommand returns the subcommand called name, or nil
+func findSubcommand(name string) *subcommand {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i]
+		}
+	}
+	return nil
+}
+
+// flagSet returns the flags of the subcommand bound to o, printing the subcommand's help for --help
+func (s *subcommand) flagSet(o *options) *pflag.FlagSet {
+	fs := pflag.NewFlagSet("gosh "+s.name, pflag.ExitOnError)
+	s.flags(o, fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n%s", os.Args[0], s.name, s.usage, s.summary, fs.FlagUsages())
+	}
+	return fs
+}
+
+// main parses the flags of the subcommand and runs it
+func (s *subcommand) main(args []string) {
+	var o options
+	o.parse(s.flagSet(&o), args)
+	o.apply()
+	s.run(&o, o.flags.Args())
+}
+
+// printHelp lists the subcommands, or prints the help of the named one
+func printHelp(args []string) {
+	if len(args) > 0 {
+		sub := findSubcommand(args[0])
+		if sub == nil {
+			fatalf("unknown command %q, see %s help", args[0], os.Args[0])
+		}
+		sub.flagSet(&options{}).Usage()
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", os.Args[0])
+	for _, sub := range subcommands {
+		summary, _, _ := strings.Cut(sub.summary, "\n")
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", sub.name, summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s help <command>' for the flags of a command. The flat invocation\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "'%s [flags] host1 [host2 ...]' accepts every flag and keeps working.\n", os.Args[0])
+}
+
+// requireHosts exits with the subcommand's help when no hosts were selected
+func requireHosts(o *options, selectors []string) {
+	if len(selectors) == 0 && o.k8sNodes == "" {
+		o.flags.Usage()
+		os.Exit(1)
+	}
+}
+
+// transfer runs an upload or download until Ctrl+C; hosts dropped before the transfer fail it
+func transfer(dropped []string, run func(ctx context.Context) error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	exitOnError(ctx, run(ctx))
+	if len(dropped) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,95 @@
+// Package gosh runs shell commands on many hosts in parallel over ssh.
+//
+// It is the importable API of the gosh CLI:
+//
+//	runner := gosh.NewRunner([]string{"web1", "web2"}, gosh.WithUser("deploy"), gosh.WithTimeout(time.Minute))
+//	results, err := runner.Run(ctx, "systemctl is-active myapp")
+//	for _, result := range results {
+//		fmt.Println(result.Host, result.ExitCode, string(result.Stdout))
+//	}
+package gosh
+
+import (
+	"time"
+
+	"github.com/brainexe/gosh/pkg"
+)
+
+type (
+	// Runner runs commands on a fixed set of hosts in parallel and collects their output
+	Runner = pkg.Runner
+	// Result is the outcome of a command on one host
+	Result = pkg.Result
+	// Option configures a Runner
+	Option = pkg.Option
+	// Stream identifies which output stream a line came from
+	Stream = pkg.Stream
+)
+
+const (
+	// StreamStdout is the remote command's standard output
+	StreamStdout = pkg.StreamStdout
+	// StreamStderr is the remote command's standard error
+	StreamStderr = pkg.StreamStderr
+)
+
+// Backends select the transport that carries commands to hosts
+const (
+	BackendSSH     = pkg.BackendSSH
+	BackendDocker  = pkg.BackendDocker
+	BackendKubectl = pkg.BackendKubectl
+)
+
+// NewRunner returns a Runner for hosts. Commands may use the {host}, {shorthost} and {index} placeholders.
+func NewRunner(hosts []string, opts ...Option) *Runner { return pkg.NewRunner(hosts, opts...) }
+
+// WithUser logs in as user instead of the ssh default
+func WithUser(user string) Option { return pkg.WithUser(user) }
+
+// WithConcurrency runs the command on at most n hosts at a time; 0 runs on all hosts at once
+func WithConcurrency(n int) Option { return pkg.WithConcurrency(n) }
+
+// WithTimeout limits how long the command may run on each host
+func WithTimeout(timeout time.Duration) Option { return pkg.WithTimeout(timeout) }
+
+// WithSSHOptions passes extra "-o" options to ssh, e.g. "StrictHostKeyChecking=accept-new"
+func WithSSHOptions(options ...string) Option { return pkg.WithSSHOptions(options...) }
+
+// WithConnectTimeout bounds how long connecting to each host may take, 5 seconds by default
+func WithConnectTimeout(timeout time.Duration) Option { return pkg.WithConnectTimeout(timeout) }
+
+// WithBackend selects the transport by name, BackendSSH by default
+func WithBackend(backend string) Option { return pkg.WithBackend(backend) }
+
+// WithLocalExec runs commands for localhost targets directly instead of over the backend
+func WithLocalExec() Option { return pkg.WithLocalExec() }
+
+// WithEnv exports "VAR=value" assignments to the command on every host
+func WithEnv(assignments ...string) Option { return pkg.WithEnv(assignments...) }
+
+// WithShell runs commands in a login shell, one of sh, bash or zsh, instead of the user's default shell
+func WithShell(shell string) Option { return pkg.WithShell(shell) }
+
+// WithSudo runs commands through sudo, which reads password from stdin
+func WithSudo(password []byte) Option { return pkg.WithSudo(password) }
+
+// WithBecomeUser runs commands as user via sudo
+func WithBecomeUser(user string) Option { return pkg.WithBecomeUser(user) }
+
+// WithNoEcho turns off terminal echo and keeps connection banners out of the output
+func WithNoEcho() Option { return pkg.WithNoEcho() }
+
+// WithAllowList refuses commands unless each of their simple commands matches one of the patterns
+func WithAllowList(patterns ...string) Option { return pkg.WithAllowList(patterns...) }
+
+// WithDenyList refuses commands of which any simple command matches one of the patterns
+func WithDenyList(patterns ...string) Option { return pkg.WithDenyList(patterns...) }
+
+// WithStdin sends input to the command on every host
+func WithStdin(input []byte) Option { return pkg.WithStdin(input) }
+
+// WithLineHandler calls fn for every complete line of output as it arrives, in addition to collecting it.
+// fn is called concurrently from per-host goroutines.
+func WithLineHandler(fn func(host string, stream Stream, line string)) Option {
+	return pkg.WithLineHandler(fn)
+}
@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ForwardAgent forwards the local ssh-agent to the hosts, so commands there can authenticate with its keys,
+// e.g. git over ssh, without copying private keys to them
+var ForwardAgent bool
+
+// agentArgs returns the ssh arguments that forward the agent when ForwardAgent is set
+func agentArgs() []string {
+	if !ForwardAgent {
+		return nil
+	}
+	return []string{"-A"}
+}
+
+// CheckAgent warns when ForwardAgent is set but there is no agent with keys to forward, as commands needing
+// them would otherwise fail on the hosts with a bare "Permission denied (publickey)"
+func CheckAgent(ctx context.Context) {
+	if !ForwardAgent || Backend != BackendSSH {
+		return
+	}
+	if problem := agentProblem(ctx); problem != "" {
+		Log.Warn("Agent forwarding is enabled, but " + problem)
+	}
+}
+
+// agentProblem returns why the agent of SSH_AUTH_SOCK can't provide keys, or "" if it holds some
+func agentProblem(ctx context.Context) string {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return "SSH_AUTH_SOCK is not set; start ssh-agent and add a key with ssh-add"
+	}
+
+	dialer := net.Dialer{Timeout: time.Second}
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return fmt.Sprintf("the agent doesn't answer: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	keys, err := agent.NewClient(conn).List()
+	switch {
+	case err != nil:
+		return fmt.Sprintf("the agent can't list its keys: %v", err)
+	case len(keys) == 0:
+		return "the agent holds no keys; add one with ssh-add"
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startFakeAgent serves keyring on a Unix socket and points SSH_AUTH_SOCK at it
+func startFakeAgent(t *testing.T, keyring agent.Agent) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	lc := &net.ListenConfig{}
+	listener, err := lc.Listen(t.Context(), "unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+}
+
+func TestAgentForwarding(t *testing.T) {
+	defer func() { ForwardAgent = false }()
+	if slices.Contains(extraSSHOptions(), "-A") {
+		t.Error("expected no agent forwarding by default")
+	}
+	ForwardAgent = true
+	if !slices.Contains(extraSSHOptions(), "-A") {
+		t.Error("expected new connections to forward the agent")
+	}
+}
+
+func TestCheckAgent(t *testing.T) {
+	var out bytes.Buffer
+	defer func(logger *slog.Logger) { Log, ForwardAgent = logger, false }(Log)
+	Log = slog.New(newConsoleHandler(&out, logLevel))
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	CheckAgent(t.Context())
+	if out.Len() != 0 {
+		t.Errorf("expected no warning without agent forwarding, got %q", out.String())
+	}
+
+	ForwardAgent = true
+	CheckAgent(t.Context())
+	if !strings.Contains(out.String(), "SSH_AUTH_SOCK is not set") {
+		t.Errorf("expected a warning about the missing agent, got %q", out.String())
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "gone.sock"))
+	if problem := agentProblem(t.Context()); !strings.Contains(problem, "doesn't answer") {
+		t.Errorf("expected a dead socket to be reported, got %q", problem)
+	}
+
+	keyring := agent.NewKeyring()
+	startFakeAgent(t, keyring)
+	if problem := agentProblem(t.Context()); !strings.Contains(problem, "no keys") {
+		t.Errorf("expected an empty agent to be reported, got %q", problem)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	if problem := agentProblem(t.Context()); problem != "" {
+		t.Errorf("expected an agent with a key to be fine, got %q", problem)
+	}
+}
@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Aliases maps alias names to the commands they stand for in interactive mode
+var Aliases = map[string]string{}
+
+// AliasesFile is where :alias and :unalias persist changes, empty keeps them for the session only
+var AliasesFile string
+
+func init() {
+	paletteSources = append(paletteSources, aliasEntries)
+}
+
+// DefaultAliasesFile returns the default location of the aliases file
+func DefaultAliasesFile() string {
+	return filepath.Join(homeDir(), ".gosh", "aliases")
+}
+
+// LoadAliases reads alias definitions of the form "name: command"; lines starting with # are comments.
+// A missing file yields no aliases.
+func LoadAliases(path string) (map[string]string, error) {
+	aliases := make(map[string]string)
+
+	file, err := os.Open(path) // #nosec G304 -- aliases file path is chosen by the local user
+	if errors.Is(err, os.ErrNotExist) {
+		return aliases, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open aliases file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, command, _ := strings.Cut(line, ":")
+		name, command = strings.TrimSpace(name), strings.TrimSpace(command)
+		if !isAliasName(name) || command == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"name: command\"", path, lineNo)
+		}
+		aliases[name] = command
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read aliases file %s: %w", path, err)
+	}
+
+	return aliases, nil
+}
+
+// SaveAliases writes aliases sorted by name
+func SaveAliases(path string, aliases map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	for _, name := range aliasNames(aliases) {
+		sb.WriteString(name + ": " + aliases[name] + "\n")
+	}
+
+	return os.WriteFile(path, []byte(sb.String()), 0o600)
+}
+
+// isAliasName reports whether name can be used as an alias: letters, digits, "_", "-" and "."
+func isAliasName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && r != '-' && r != '.' && (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// aliasNames returns the names of aliases in sorted order
+func aliasNames(aliases map[string]string) []string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// parseAliasDefinition splits `name=command` or `name='command'` as typed after :alias
+func parseAliasDefinition(definition string) (name, command string, err error) {
+	name, command, found := strings.Cut(definition, "=")
+	name, command = strings.TrimSpace(name), strings.TrimSpace(command)
+	if !found || command == "" {
+		return "", "", fmt.Errorf("expected name='command', got %q", definition)
+	}
+	if !isAliasName(name) {
+		return "", "", fmt.Errorf("invalid alias name %q", name)
+	}
+	if len(command) >= 2 && (command[0] == '\'' || command[0] == '"') && command[len(command)-1] == command[0] {
+		command = command[1 : len(command)-1]
+	}
+	return name, command, nil
+}
+
+// expandAlias replaces an alias in the first word of line with its command, keeping the arguments.
+// Like the shell, the result is not expanded again.
+func expandAlias(line string) string {
+	word, args, _ := strings.Cut(line, " ")
+	command, ok := Aliases[word]
+	if !ok {
+		return line
+	}
+	if args = strings.TrimSpace(args); args != "" {
+		return command + " " + args
+	}
+	return command
+}
+
+// aliasEntries returns the palette entries of all aliases
+func aliasEntries() []commandInfo {
+	entries := make([]commandInfo, 0, len(Aliases))
+	for _, name := range aliasNames(Aliases) {
+		entries = append(entries, commandInfo{name, "", "Alias for " + Aliases[name]})
+	}
+	return entries
+}
+
+// editAliases lists aliases, or defines or removes one and persists the change
+func editAliases(args string, remove bool) {
+	switch {
+	case args == "" && remove:
+		fmt.Println("🏷️  Usage: :unalias <name>")
+		return
+	case args == "":
+		if len(Aliases) == 0 {
+			fmt.Println("🏷️  No aliases defined")
+		}
+		for _, name := range aliasNames(Aliases) {
+			fmt.Printf("  %s='%s'\n", name, Aliases[name])
+		}
+		return
+	case remove:
+		for _, name := range strings.Fields(args) {
+			if _, ok := Aliases[name]; !ok {
+				fmt.Printf("⚠️  %s is not an alias\n", name)
+			}
+			delete(Aliases, name)
+		}
+	default:
+		name, command, err := parseAliasDefinition(args)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		Aliases[name] = command
+	}
+
+	if AliasesFile != "" {
+		if err := SaveAliases(AliasesFile, Aliases); err != nil {
+			fmt.Printf("❌ Error: failed to save aliases: %v\n", err)
+		}
+	}
+}
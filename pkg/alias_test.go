@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseAliasDefinition(t *testing.T) {
+	tests := []struct {
+		definition, name, command string
+		wantErr                   bool
+	}{
+		{"restart='sudo systemctl restart myapp'", "restart", "sudo systemctl restart myapp", false},
+		{`logs="journalctl -u myapp"`, "logs", "journalctl -u myapp", false},
+		{"ll=ls -la", "ll", "ls -la", false},
+		{"ll", "", "", true},
+		{"bad name=ls", "", "", true},
+		{"empty=", "", "", true},
+	}
+	for _, tt := range tests {
+		name, command, err := parseAliasDefinition(tt.definition)
+		if (err != nil) != tt.wantErr || name != tt.name || command != tt.command {
+			t.Errorf("parseAliasDefinition(%q) = %q, %q, %v", tt.definition, name, command, err)
+		}
+	}
+}
+
+func TestExpandAlias(t *testing.T) {
+	Aliases = map[string]string{"restart": "sudo systemctl restart", "ll": "ls -la"}
+	defer func() { Aliases = map[string]string{} }()
+
+	tests := map[string]string{
+		"restart myapp": "sudo systemctl restart myapp",
+		"ll":            "ls -la",
+		"ll  /tmp":      "ls -la /tmp",
+		"echo ll":       "echo ll",
+		"llama":         "llama",
+	}
+	for line, want := range tests {
+		if got := expandAlias(line); got != want {
+			t.Errorf("expandAlias(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestAliasPersistence(t *testing.T) {
+	AliasesFile = filepath.Join(t.TempDir(), "gosh", "aliases")
+	Aliases = map[string]string{}
+	defer func() { AliasesFile, Aliases = "", map[string]string{} }()
+
+	captureStdout(t, func() {
+		editAliases("restart='sudo systemctl restart myapp'", false)
+		editAliases("ll=ls -la", false)
+		editAliases("ll", true)
+	})
+
+	content, err := os.ReadFile(AliasesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "restart: sudo systemctl restart myapp\n" {
+		t.Errorf("unexpected aliases file: %q", content)
+	}
+
+	loaded, err := LoadAliases(AliasesFile)
+	if err != nil || loaded["restart"] != "sudo systemctl restart myapp" || len(loaded) != 1 {
+		t.Errorf("unexpected aliases %v: %v", loaded, err)
+	}
+
+	if err := os.WriteFile(AliasesFile, []byte("# comment\nno command\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAliases(AliasesFile); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("expected a line number in the error, got %v", err)
+	}
+}
+
+func TestAliasCompletionAndPalette(t *testing.T) {
+	Aliases = map[string]string{"restart": "sudo systemctl restart myapp"}
+	defer func() { Aliases = map[string]string{} }()
+
+	if got := completerWithWord("res", "res", []string{"web1"}, nil); !slices.Contains(got, "tart") {
+		t.Errorf("expected alias completion, got %q", got)
+	}
+	if got := completerWithWord("echo res", "res", []string{"web1"}, nil); slices.Contains(got, "tart") {
+		t.Errorf("aliases should only complete the first word, got %q", got)
+	}
+	if matches := searchPalette("restart"); len(matches) == 0 || matches[0].name != "restart" {
+		t.Errorf("expected the alias in the palette, got %v", matches)
+	}
+}
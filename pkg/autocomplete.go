@@ -3,8 +3,14 @@ package pkg
 import (
 	"bytes"
 	"context"
-	"os/exec"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
 )
 
 const maxCompletions = 10
@@ -22,8 +28,14 @@ type customCompleter struct {
 	hosts   []string
 	noColor bool
 	connMgr *SSHConnectionManager
+	out     io.Writer       // Receives notes about completions, nil drops them
+	history *commandHistory // Previously run commands, suggested before anything else
+	fuzzy   []rune          // Line replacing the input on Tab when nothing completes it, see OnChange
 }
 
+// fuzzyMinLength is the number of typed characters from which Tab falls back to fuzzy history matching
+const fuzzyMinLength = 2
+
 // Do implements the AutoCompleter interface
 func (c *customCompleter) Do(line []rune, pos int) ([][]rune, int) {
 	// Convert current line to string up to cursor position
@@ -45,8 +57,15 @@ func (c *customCompleter) Do(line []rune, pos int) ([][]rune, int) {
 	currentWord := string(line[wordStart:pos])
 
 	// Get completions using our logic - pass both line and current word
-	completions := completerWithWord(lineStr, currentWord, c.hosts, c.connMgr)
-	completions = limitCompletions(completions)
+	completions, partial := completeLine(lineStr, currentWord, c.hosts, c.connMgr)
+	completions = limitCompletions(c.withHistory(lineStr, completions))
+	c.fuzzy = nil
+	if len(completions) == 0 && pos == len(line) {
+		c.fuzzy = c.fuzzyLine(lineStr)
+	}
+	if c.out != nil {
+		printPartialCompletions(c.out, currentWord, completions, partial)
+	}
 
 	// Convert completions back to rune slices
 	result := make([][]rune, len(completions))
@@ -57,140 +76,303 @@ func (c *customCompleter) Do(line []rune, pos int) ([][]rune, int) {
 	return result, wordStart
 }
 
-// getLocalFileCompletions gets file completions from current directory (used in :upload only)
+// withHistory puts the rest of the history entries continuing line in front of completions, most recent first
+func (c *customCompleter) withHistory(line string, completions []string) []string {
+	if c.history == nil || strings.TrimSpace(line) == "" {
+		return completions
+	}
+	var merged []string
+	for _, entry := range c.history.completions(line) {
+		merged = append(merged, entry[len(line):])
+	}
+	for _, completion := range completions {
+		if !slices.Contains(merged, completion) {
+			merged = append(merged, completion)
+		}
+	}
+	return merged
+}
+
+// fuzzyLine returns the alias or history entry that best matches line as a fuzzy subsequence, e.g.
+// "systl" for "systemctl status nginx", or nil if none does
+func (c *customCompleter) fuzzyLine(line string) []rune {
+	query := strings.TrimSpace(line)
+	if c.history == nil || len([]rune(query)) < fuzzyMinLength || strings.HasSuffix(line, " ") {
+		return nil
+	}
+	if match := c.history.fuzzyMatch(query, aliasNames(Aliases)); match != "" {
+		return []rune(match)
+	}
+	return nil
+}
+
+// OnChange implements the readline.Listener interface. readline only appends completions, so when Tab found
+// nothing to append but a fuzzy match, the line is replaced by the match here, which runs right after Do.
+func (c *customCompleter) OnChange(line []rune, pos int, key rune) ([]rune, int, bool) {
+	fuzzy := c.fuzzy
+	c.fuzzy = nil
+	if key != readline.CharTab || fuzzy == nil {
+		return nil, 0, false
+	}
+	return fuzzy, len(fuzzy), true
+}
+
+// getLocalFileCompletions gets file completions from the current directory (used in :upload only)
 func getLocalFileCompletions(prefix string) []string {
-	cmd := exec.CommandContext(context.Background(), "bash", "-c", "compgen -f "+prefix) // #nosec G204 -- prefix originates from user typing for local path completion; risk limited to glob expansion. Only used for tab-completion, not executed.
-	output, err := cmd.Output()
+	return limitCompletions(localPathCompletions(prefix))
+}
+
+// localPathCompletions returns the local files and directories starting with prefix, which may include a
+// directory part like "logs/app", or "logs\app" on Windows. Directories end with "/"; hidden entries are only
+// offered once their leading dot is typed.
+func localPathCompletions(prefix string) []string {
+	i := strings.LastIndexFunc(prefix, func(r rune) bool { return r == '/' || r == os.PathSeparator })
+	dir, base := prefix[:i+1], prefix[i+1:]
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(filepath.FromSlash(readDir))
 	if err != nil {
 		return []string{}
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
 	var completions []string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" && line != "." && line != ".." {
-			// Check if it's a directory and add trailing slash
-			if strings.HasSuffix(line, "/") {
-				// Already has trailing slash
-				completions = append(completions, line)
-			} else {
-				// Check if it's a directory by running test -d
-				testCmd := exec.CommandContext(context.Background(), "bash", "-c", "test -d '"+line+"' && echo 'dir' || echo 'file'") // #nosec G204 -- line values come from compgen output (local filesystem entries), used only to test directory existence.
-				testOutput, testErr := testCmd.Output()
-				if testErr == nil && strings.TrimSpace(string(testOutput)) == "dir" {
-					completions = append(completions, line+"/")
-				} else {
-					completions = append(completions, line)
-				}
-			}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		completion := dir + name
+		// Symlinks to directories complete like directories
+		if info, err := os.Stat(filepath.FromSlash(completion)); err == nil && info.IsDir() {
+			completion += "/"
 		}
+		completions = append(completions, completion)
 	}
-	return limitCompletions(completions)
+	return completions
+}
+
+// localFileExists reports whether a local file or directory exists at path
+func localFileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
-// getSSHCompletions runs completion command on the first host using socket connection
+// completionSuffixes returns what readline appends to word for each completion starting with it
+func completionSuffixes(completions []string, word string) []string {
+	var suffixes []string
+	for _, completion := range completions {
+		if suffix, ok := strings.CutPrefix(completion, word); ok && suffix != "" {
+			suffixes = append(suffixes, suffix)
+		}
+	}
+	return suffixes
+}
+
+// completionCacheTTL is how long the command names of a host are cached for completion
+const completionCacheTTL = 5 * time.Minute
+
+// cachedCommands are the command names available on a host, as listed by compgen -c
+type cachedCommands struct {
+	names   []string
+	fetched time.Time
+}
+
+// completionWait is how long Tab waits for a remote lookup before offering what is available locally
+const completionWait = 300 * time.Millisecond
+
+// completionTimeout ends remote lookups on slow or dead hosts
+const completionTimeout = 5 * time.Second
+
+// completionLookup is a compgen command running on a host in the background
+type completionLookup struct {
+	done     chan struct{}
+	result   []string
+	finished time.Time
+}
+
+// getSSHCompletions completes word from the first host: command names from the per-host cache, and paths,
+// which change too often to be cached, with a live lookup over the socket connection. Lookups that take
+// longer than completionWait continue in the background and their results show on the next Tab.
 func getSSHCompletions(word string, firstHost string, connMgr *SSHConnectionManager) []string {
-	if firstHost == "" {
+	if firstHost == "" || connMgr == nil {
 		return []string{}
 	}
+	completions, _ := hostCompletions(word, firstHost, connMgr)
+	return completions
+}
+
+// hostCompletions completes word from one host like getSSHCompletions. It reports false when the host
+// didn't answer in time or can't complete at all.
+func hostCompletions(word string, host string, connMgr *SSHConnectionManager) ([]string, bool) {
+	// compgen is a bash builtin: run it via bash when the login shell isn't bash, skip hosts without bash
+	shell := connMgr.shellOf(host)
+	if shell == shellPOSIX || shell == shellRestricted {
+		return []string{}, false
+	}
 
-	socketPath := connMgr.getSocketPath(firstHost)
-	var args []string
-	args = append(args, "-S", socketPath, "-o", "BatchMode=yes")
-	args = append(args, firstHost)
+	if !strings.Contains(word, "/") {
+		names, ok := connMgr.remoteCommands(host, shell)
+		var commands []string
+		for _, name := range names {
+			if strings.HasPrefix(name, word) {
+				commands = append(commands, name)
+			}
+		}
+		if len(commands) > 0 || word == "" || !ok {
+			return commands, ok
+		}
+		// Not a command, so complete a file name in the working directory
+		return connMgr.lookupCompletions(host, shell, "compgen -f "+shellQuote(word))
+	}
+	return connMgr.lookupCompletions(host, shell, "compgen -d "+shellQuote(word)+" || compgen -f "+shellQuote(word))
+}
 
-	// Build compgen command to run on remote host
-	var compgenCmd string
-	switch {
-	case word == "":
-		compgenCmd = "compgen -c"
-	case strings.Contains(word, "/"):
-		// Handle path completion
-		compgenCmd = "compgen -d '" + word + "' || compgen -f '" + word + "'"
-	default:
-		// Try command completion first, then file completion
-		compgenCmd = "compgen -c '" + word + "' || compgen -f '" + word + "'"
+// remoteCommands returns the command names available on host, from the cache while it is fresh. It reports
+// false while they are still being fetched.
+func (cm *SSHConnectionManager) remoteCommands(host string, shell shellCapability) ([]string, bool) {
+	cm.mu.Lock()
+	cached, ok := cm.commands[host]
+	cm.mu.Unlock()
+	if ok && time.Since(cached.fetched) < completionCacheTTL {
+		return cached.names, true
 	}
 
-	args = append(args, compgenCmd)
+	names, ok := cm.lookupCompletions(host, shell, "compgen -c")
+	if !ok {
+		return nil, false
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	if len(names) == 0 {
+		return names, true // Probably a failed lookup, try again next time
+	}
+	cm.mu.Lock()
+	if cm.commands == nil {
+		cm.commands = make(map[string]cachedCommands)
+	}
+	cm.commands[host] = cachedCommands{names: names, fetched: time.Now()}
+	cm.mu.Unlock()
+	return names, true
+}
 
-	cmd := exec.CommandContext(context.Background(), "ssh", args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// lookupCompletions runs a compgen command on host in the background and returns its completions if they
+// arrive within completionWait. Otherwise it reports false, and a later call with the same command picks up
+// the result, as long as it isn't older than completionTimeout.
+func (cm *SSHConnectionManager) lookupCompletions(host string, shell shellCapability, compgenCmd string) ([]string, bool) {
+	key := host + "\x00" + compgenCmd
+	cm.mu.Lock()
+	if cm.lookups == nil {
+		cm.lookups = make(map[string]*completionLookup)
+	}
+	lookup, ok := cm.lookups[key]
+	if ok {
+		select {
+		case <-lookup.done:
+			ok = time.Since(lookup.finished) < completionTimeout
+		default:
+		}
+	}
+	if !ok {
+		lookup = &completionLookup{done: make(chan struct{})}
+		cm.lookups[key] = lookup
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+			defer cancel()
+			lookup.result = runCompgen(ctx, cm, host, shell, compgenCmd)
+			lookup.finished = time.Now()
+			close(lookup.done)
+		}()
+	}
+	cm.mu.Unlock()
 
-	err := cmd.Run()
-	if err != nil {
-		return []string{}
+	select {
+	case <-lookup.done:
+		cm.mu.Lock()
+		if cm.lookups[key] == lookup {
+			delete(cm.lookups, key)
+		}
+		cm.mu.Unlock()
+		return lookup.result, true
+	case <-time.After(completionWait):
+		return nil, false
 	}
+}
+
+// refreshCompletions drops the cached command names of all hosts, e.g. after installing software
+func (cm *SSHConnectionManager) refreshCompletions() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	clear(cm.commands)
+}
 
-	output := strings.TrimSpace(stdout.String())
-	if output == "" {
+// runCompgen runs a compgen command on host and returns the completions it prints
+func runCompgen(ctx context.Context, cm *SSHConnectionManager, host string, shell shellCapability, compgenCmd string) []string {
+	if shell == shellBashAvailable {
+		compgenCmd = "bash -c " + shellQuote(compgenCmd)
+	}
+
+	cmd := cm.sessionCommand(ctx, host, compgenCmd)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
 		return []string{}
 	}
 
-	lines := strings.Split(output, "\n")
 	var completions []string
-	for _, line := range lines {
+	for line := range strings.Lines(stdout.String()) {
 		line = strings.TrimSpace(line)
 		if line != "" && line != "." && line != ".." {
 			completions = append(completions, line)
 		}
 	}
-
 	return completions
 }
 
 // completerWithWord handles tab completion with proper word-based logic
 func completerWithWord(line string, currentWord string, hosts []string, connMgr *SSHConnectionManager) []string {
+	completions, _ := completeLine(line, currentWord, hosts, connMgr)
+	return completions
+}
+
+// completeLine returns the suffixes completing currentWord in line, and which remote completions exist on
+// some of the asked hosts only (see remoteCompletions)
+func completeLine(line string, currentWord string, hosts []string, connMgr *SSHConnectionManager) ([]string, map[string]string) {
 	if line == "" {
-		return []string{}
+		return []string{}, nil
 	}
 
 	// Handle internal commands starting with ":"
 	if strings.HasPrefix(line, ":") {
-		if strings.HasPrefix(line, ":upload ") {
-			// Complete filenames for :upload command
-			parts := strings.SplitN(line, " ", 2)
-			if len(parts) == 2 {
-				prefix := parts[1]
-				// Get all matching files
-				cmd := exec.CommandContext(context.Background(), "bash", "-c", "compgen -f "+prefix) // #nosec G204 -- prefix fragment from interactive user input for path suggestions only.
-				output, err := cmd.Output()
-				if err != nil {
-					return []string{}
-				}
-
-				lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-				var completions []string
-				for _, line := range lines {
-					line = strings.TrimSpace(line)
-					if line != "" && line != "." && line != ".." {
-						// For internal commands, return the suffix that should be appended
-						if strings.HasPrefix(line, prefix) {
-							suffix := line[len(prefix):]
-							if suffix != "" {
-								completions = append(completions, suffix)
-							}
-						}
-					}
-				}
-				return completions
+		// :upload completes a local file, then a remote directory once the file is complete. The file is the
+		// rest of the line, so names may contain spaces.
+		if prefix, ok := strings.CutPrefix(line, ":upload "); ok {
+			if i := strings.LastIndex(prefix, " "); i > 0 && localFileExists(strings.TrimSpace(prefix[:i])) {
+				completions, partial := remotePathCompletions(prefix[i+1:], hosts, connMgr, true)
+				return completionSuffixes(completions, prefix[i+1:]), partial
 			}
-			// Empty prefix - return all files as full names
-			cmd := exec.CommandContext(context.Background(), "bash", "-c", "compgen -f")
-			output, err := cmd.Output()
-			if err != nil {
-				return []string{}
+			return completionSuffixes(localPathCompletions(prefix), prefix), nil
+		}
+		// :download completes a remote path, then a local directory
+		if args, ok := strings.CutPrefix(line, ":download "); ok {
+			if !strings.Contains(args, " ") {
+				completions, partial := remotePathCompletions(args, hosts, connMgr, false)
+				return completionSuffixes(completions, args), partial
+			}
+			var dirs []string
+			for _, completion := range localPathCompletions(currentWord) {
+				if strings.HasSuffix(completion, "/") {
+					dirs = append(dirs, completion)
+				}
 			}
-			return strings.Split(strings.TrimSpace(string(output)), "\n")
+			return completionSuffixes(dirs, currentWord), nil
 		}
 
 		// Complete internal commands - return suffixes
-		commands := []string{":upload", ":exit", ":help", ":hosts", ":verbose"}
 		var matches []string
-		for _, cmd := range commands {
+		for _, cmd := range commandNames() {
 			if strings.HasPrefix(cmd, currentWord) {
 				suffix := cmd[len(currentWord):]
 				if suffix != "" {
@@ -198,22 +380,15 @@ func completerWithWord(line string, currentWord string, hosts []string, connMgr
 				}
 			}
 		}
-		return matches
+		return matches, nil
 	}
 
-	// For regular commands, use SSH completion on the first host
-	sshCompletions := getSSHCompletions(currentWord, hosts[0], connMgr)
-
-	// For all completions (commands and paths), return suffixes as expected by readline
-	var filteredCompletions []string
-	for _, completion := range sshCompletions {
-		if strings.HasPrefix(completion, currentWord) {
-			suffix := completion[len(currentWord):]
-			if suffix != "" {
-				filteredCompletions = append(filteredCompletions, suffix)
-			}
-		}
+	// For regular commands, use SSH completion on the hosts; aliases complete as command names
+	sshCompletions, partial := remoteCompletions(currentWord, hosts, connMgr)
+	if !strings.Contains(line, " ") {
+		sshCompletions = append(aliasNames(Aliases), sshCompletions...)
 	}
 
-	return filteredCompletions
+	// For all completions (commands and paths), return suffixes as expected by readline
+	return completionSuffixes(sshCompletions, currentWord), partial
 }
@@ -2,7 +2,11 @@ package pkg
 
 import (
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAutocompleteGetLocalFileCompletions(t *testing.T) {
@@ -112,3 +116,141 @@ func TestAutocompleteGetLocalFileCompletions(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalPathCompletions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, dir := range []string{"logs", ".cache"} {
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"logs/app one.log", "logs/app two.log", "logs/db.log", ".env"} {
+		if err := os.WriteFile(file, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{"", []string{"logs/"}},
+		{".", []string{".cache/", ".env"}},
+		{"logs/app", []string{"logs/app one.log", "logs/app two.log"}},
+		{"logs/app o", []string{"logs/app one.log"}},
+		{"logs" + string(os.PathSeparator) + "db", []string{"logs" + string(os.PathSeparator) + "db.log"}},
+		{"missing/", []string{}},
+	}
+	for _, test := range tests {
+		if result := localPathCompletions(test.prefix); !slices.Equal(result, test.expected) && len(result)+len(test.expected) > 0 {
+			t.Errorf("localPathCompletions(%q) = %q, expected %q", test.prefix, result, test.expected)
+		}
+	}
+
+	// :upload completes the rest of the line, spaces included
+	if result := completerWithWord(":upload logs/app t", "t", nil, nil); !slices.Equal(result, []string{"wo.log"}) {
+		t.Errorf("unexpected :upload completions %q", result)
+	}
+}
+
+func TestRemoteCommandCache(t *testing.T) {
+	// A fake ssh logs every lookup and knows a few commands and files
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\nfor last; do :; done\necho \"$last\" >> " + calls + "\ncase $last in\n" +
+		"'compgen -c') printf 'ls\\nless\\nls\\ncat\\n' ;;\n" +
+		"*compgen\\ -f*) echo notes.txt ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cm := &SSHConnectionManager{connections: map[string]*SSHConnection{"web1": {host: "web1", shell: shellBash}}}
+	if result := getSSHCompletions("l", "web1", cm); !slices.Equal(result, []string{"less", "ls"}) {
+		t.Errorf("unexpected command completions %q", result)
+	}
+	if result := getSSHCompletions("c", "web1", cm); !slices.Equal(result, []string{"cat"}) {
+		t.Errorf("unexpected command completions %q", result)
+	}
+	// Words that are no command complete file names live
+	if result := getSSHCompletions("no", "web1", cm); !slices.Equal(result, []string{"notes.txt"}) {
+		t.Errorf("unexpected file completions %q", result)
+	}
+
+	cm.refreshCompletions()
+	getSSHCompletions("l", "web1", cm)
+	logged, err := os.ReadFile(calls) // #nosec G304 -- test file
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookups := strings.Count(string(logged), "compgen -c\n"); lookups != 2 {
+		t.Errorf("expected one command lookup before and one after the refresh, got %d in %q", lookups, logged)
+	}
+}
+
+func TestSlowCompletionRunsInBackground(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\nsleep 1\nprintf 'ls\\nless\\n'\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// Aliases are offered right away while the slow host is still looking up its commands
+	defer func(aliases map[string]string) { Aliases = aliases }(Aliases)
+	Aliases = map[string]string{"lsa": "ls -la"}
+	cm := &SSHConnectionManager{connections: map[string]*SSHConnection{"web1": {host: "web1", shell: shellBash}}}
+	start := time.Now()
+	if result := completerWithWord("l", "l", []string{"web1"}, cm); !slices.Equal(result, []string{"sa"}) {
+		t.Errorf("expected only the alias before the lookup completes, got %q", result)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("completion blocked for %s", elapsed)
+	}
+
+	// The next Tab after the lookup completed merges the remote commands
+	time.Sleep(time.Second)
+	if result := completerWithWord("l", "l", []string{"web1"}, cm); !slices.Equal(result, []string{"sa", "ess", "s"}) {
+		t.Errorf("expected the alias and remote commands, got %q", result)
+	}
+}
+
+func TestMultiHostCompletion(t *testing.T) {
+	// A fake ssh answers with different commands depending on the host
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor arg; do host=$last; last=$arg; done\ncase $host in\n" +
+		"web1) printf 'ls\\nless\\n' ;;\n" +
+		"web2) printf 'ls\\nlsof\\n' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func() { CompletionHosts, CompletionIntersect = 1, false }()
+
+	cm := &SSHConnectionManager{connections: map[string]*SSHConnection{
+		"web1": {host: "web1", shell: shellBash},
+		"web2": {host: "web2", shell: shellBash},
+	}}
+	hosts := []string{"web1", "web2"}
+	if completions, partial := remoteCompletions("l", hosts, cm); !slices.Equal(completions, []string{"less", "ls"}) || len(partial) != 0 {
+		t.Errorf("expected the first host only, got %q and %v", completions, partial)
+	}
+
+	CompletionHosts = 0
+	completions, partial := remoteCompletions("l", hosts, cm)
+	if !slices.Equal(completions, []string{"less", "ls", "lsof"}) || partial["less"] != "1/2" || partial["lsof"] != "1/2" || partial["ls"] != "" {
+		t.Errorf("expected merged completions, got %q and %v", completions, partial)
+	}
+	var out strings.Builder
+	printPartialCompletions(&out, "l", []string{"ess", "s", "sof"}, partial)
+	if out.String() != "💡 On some hosts only: less (1/2), lsof (1/2)\n" {
+		t.Errorf("unexpected note %q", out.String())
+	}
+
+	CompletionIntersect = true
+	if completions, _ := remoteCompletions("l", hosts, cm); !slices.Equal(completions, []string{"ls"}) {
+		t.Errorf("expected only the common command, got %q", completions)
+	}
+}
@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Backends select the transport that carries commands to hosts; output, prefixes and parallelism are the same for all
+const (
+	BackendSSH     = "ssh"     // ssh with persistent control master connections
+	BackendDocker  = "docker"  // docker exec; hosts are container names or IDs, $DOCKER_HOST selects a remote engine
+	BackendKubectl = "kubectl" // kubectl exec; hosts are pod names or namespace/pod in the current kubeconfig context
+	BackendLocal   = "local"   // sh on this machine, used for localhost targets with LocalExec
+)
+
+// Backend is the transport used for all hosts
+var Backend = BackendSSH
+
+// LocalExec runs commands for localhost targets directly instead of ssh'ing to this machine
+var LocalExec bool
+
+// backendOf returns the transport used for host
+func backendOf(host string) string {
+	return backendFor(Backend, LocalExec, host)
+}
+
+// backendFor returns the transport used for host with backend, or the local one for localhost targets
+// with localExec
+func backendFor(backend string, localExec bool, host string) string {
+	if localExec && isLocalHost(host) {
+		return BackendLocal
+	}
+	return backend
+}
+
+// isLocalHost reports whether host names this machine
+func isLocalHost(host string) bool {
+	switch host {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	hostname, err := os.Hostname()
+	return err == nil && strings.EqualFold(host, hostname)
+}
+
+// SetBackend selects the transport by name
+func SetBackend(name string) error {
+	if err := checkBackend(name); err != nil {
+		return err
+	}
+	Backend = name
+	return nil
+}
+
+// checkBackend returns an error unless name is a backend that can be selected
+func checkBackend(name string) error {
+	switch name {
+	case BackendSSH, BackendDocker, BackendKubectl:
+		return nil
+	default:
+		return fmt.Errorf("unknown backend %q, expected ssh, docker or kubectl", name)
+	}
+}
+
+// hostCommand returns the command that runs a shell command on host over a new connection, with a
+// pseudo-terminal if tty is set
+func hostCommand(ctx context.Context, host, command, user string, tty bool) *exec.Cmd {
+	if backend := backendOf(host); backend != BackendSSH {
+		return backendCommand(ctx, backend, host, command, user, tty)
+	}
+
+	args := buildSSHArgs(host, command, user)
+	if tty {
+		args = append(ttyArgs(), args...)
+	}
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// backendCommand returns the command that runs a shell command on host with a backend other than ssh
+func backendCommand(ctx context.Context, backend, host, command, user string, tty bool) *exec.Cmd {
+	switch backend {
+	case BackendDocker:
+		return exec.CommandContext(ctx, "docker", dockerExecArgs(host, command, user, tty)...)
+	case BackendKubectl:
+		return exec.CommandContext(ctx, "kubectl", kubectlExecArgs(host, command, tty)...)
+	default: // BackendLocal
+		// Like a login over ssh, commands start in the home directory
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		if _, err := exec.LookPath("sh"); err != nil && runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command) // Without Git Bash or similar
+		}
+		cmd.Dir = homeDir()
+		return cmd
+	}
+}
+
+// dockerExecArgs returns the docker arguments that run a shell command in a container
+func dockerExecArgs(container, command, user string, tty bool) []string {
+	args := []string{"exec", "-i"}
+	if tty {
+		args = append(args, "-t")
+	}
+	if user != "" {
+		args = append(args, "-u", user)
+	}
+	return append(args, "--", container, "sh", "-c", command)
+}
+
+// dockerShellArgs returns the docker arguments that open an interactive shell in a container
+func dockerShellArgs(container, user string) []string {
+	args := []string{"exec", "-it"}
+	if user != "" {
+		args = append(args, "-u", user)
+	}
+	return append(args, "--", container, "sh")
+}
+
+// kubectlExecArgs returns the kubectl arguments that run a shell command in a pod, given as "pod" or
+// "namespace/pod". Commands run in the pod's default container as the image's user.
+func kubectlExecArgs(pod, command string, tty bool) []string {
+	args := []string{"exec", "-i"}
+	if tty {
+		args = append(args, "-t")
+	}
+	return append(append(args, podArgs(pod)...), "--", "sh", "-c", command)
+}
+
+// kubectlShellArgs returns the kubectl arguments that open an interactive shell in a pod
+func kubectlShellArgs(pod string) []string {
+	return append(append([]string{"exec", "-it"}, podArgs(pod)...), "--", "sh")
+}
+
+// podArgs returns the kubectl arguments that name a pod given as "pod" or "namespace/pod"
+func podArgs(pod string) []string {
+	if namespace, name, found := strings.Cut(pod, "/"); found {
+		return []string{"-n", namespace, name}
+	}
+	return []string{pod}
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useFakeDocker puts a docker on PATH that logs its arguments to the returned file and runs the last one with sh -c
+func useFakeDocker(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\nfor last; do :; done\nexec sh -c \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestSetBackend(t *testing.T) {
+	defer func() { Backend = BackendSSH }()
+	if err := SetBackend("docker"); err != nil || Backend != BackendDocker {
+		t.Errorf("expected the docker backend, got %q, %v", Backend, err)
+	}
+	if err := SetBackend("telnet"); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
+
+func TestDockerBackend(t *testing.T) {
+	log := useFakeDocker(t)
+	Backend = BackendDocker
+	defer func() { Backend = BackendSSH }()
+
+	output := captureStdout(t, func() {
+		if err := ExecuteCommand(context.Background(), []string{"app-1", "app-2"}, "echo hi {host}", "www-data", true); err != nil {
+			t.Errorf("ExecuteCommand failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, "app-1: hi app-1") || !strings.Contains(output, "app-2: hi app-2") {
+		t.Errorf("expected prefixed output from both containers, got %q", output)
+	}
+
+	args, _ := os.ReadFile(log)
+	if !strings.Contains(string(args), "exec -i -u www-data -- app-1 sh -c") {
+		t.Errorf("expected docker exec into app-1, got %q", args)
+	}
+
+	// Interactive sessions check containers instead of opening control masters
+	cm := NewSSHConnectionManager("")
+	if err := cm.establishConnection(context.Background(), "app-1"); err != nil {
+		t.Fatalf("establishConnection failed: %v", err)
+	}
+	if !cm.checkConnection("app-1") {
+		t.Error("expected the container to count as connected")
+	}
+	if command := tmuxSSHCommand("app-1", ""); command != "docker 'exec' '-it' '--' 'app-1' 'sh'" {
+		t.Errorf("unexpected tmux pane command %q", command)
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	useFakeSSH(t)
+	t.Setenv("HOME", t.TempDir())
+	LocalExec = true
+	defer func() { LocalExec = false }()
+
+	// The fake ssh runs commands in the test's directory, local commands start in the home directory
+	output := captureStdout(t, func() {
+		if err := ExecuteCommand(context.Background(), []string{"localhost", "web1"}, "echo {host} $(pwd)", "", true); err != nil {
+			t.Errorf("ExecuteCommand failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, "localhost: localhost "+os.Getenv("HOME")) || strings.Contains(output, "web1: web1 "+os.Getenv("HOME")) {
+		t.Errorf("expected only localhost to run locally, got %q", output)
+	}
+	if backendOf("127.0.0.1") != BackendLocal || backendOf("web1") != BackendSSH {
+		t.Error("expected local execution for loopback addresses only")
+	}
+}
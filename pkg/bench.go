@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchHost holds what Bench measured on one host
+type benchHost struct {
+	connected bool            // Whether the persistent connection was established
+	connect   time.Duration   // Time to establish the persistent connection
+	latencies []time.Duration // Wall time of the successful runs
+	failed    int             // Runs that exited non-zero or couldn't start
+	err       error           // Why the host couldn't be benchmarked, or the last failed run
+}
+
+// Bench establishes persistent connections to hosts and runs command runs times on each of them, one run
+// after another per host and all hosts in parallel, discarding the output. It prints the connect time and
+// the min, median, p95 and max latency per host and over all hosts, which shows the overhead of ssh and
+// the control sockets as well as slow hosts. Hosts with failed runs are returned as joined *HostError values.
+func Bench(ctx context.Context, hosts []string, command, user string, runs int) error {
+	if runs < 1 {
+		return errors.New("the number of runs must be at least 1")
+	}
+	if err := checkCommand(command); err != nil {
+		return err
+	}
+	cm := NewSSHConnectionManager(user)
+	defer cm.closeAllConnections()
+
+	fmt.Fprintf(os.Stderr, "⏱️  Running %q %d time(s) on %d host(s)\n", command, runs, len(hosts))
+	results := make([]benchHost, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Go(func() { results[i] = benchRuns(ctx, cm, host, prepareCommand(command), runs) })
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	printBench(os.Stdout, hosts, results)
+	errs := make([]error, len(hosts))
+	for i, result := range results {
+		errs[i] = result.err
+	}
+	return joinHostErrors(hosts, errs)
+}
+
+// benchRuns connects to host and times runs runs of command over the connection
+func benchRuns(ctx context.Context, cm *SSHConnectionManager, host, command string, runs int) benchHost {
+	var result benchHost
+	start := time.Now()
+	if err := cm.establishConnection(ctx, host); err != nil {
+		result.err = err
+		return result
+	}
+	result.connected, result.connect = true, time.Since(start)
+
+	for range runs {
+		if ctx.Err() != nil {
+			break
+		}
+		cmd := cm.sessionCommand(ctx, host, command)
+		start := time.Now()
+		acquireFDs()
+		err := cmd.Run()
+		releaseFDs()
+		if err != nil {
+			result.failed++
+			result.err = err
+			continue
+		}
+		result.latencies = append(result.latencies, time.Since(start))
+	}
+	return result
+}
+
+// printBench writes the latency table, with an "all" row over every successful run when several hosts ran
+func printBench(out io.Writer, hosts []string, results []benchHost) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCONNECT\tMIN\tMEDIAN\tP95\tMAX\tFAILED")
+	var all []time.Duration
+	failed := 0
+	for i, host := range hosts {
+		result := results[i]
+		if !result.connected {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t%v\n", host, result.err)
+			continue
+		}
+		all = append(all, result.latencies...)
+		failed += result.failed
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", host, formatLatency(result.connect), latencyColumns(result.latencies), result.failed)
+	}
+	if len(hosts) > 1 {
+		fmt.Fprintf(w, "all\t\t%s\t%d\n", latencyColumns(all), failed)
+	}
+	_ = w.Flush()
+}
+
+// latencyColumns returns the min, median, p95 and max of latencies as tab separated columns
+func latencyColumns(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "-\t-\t-\t-"
+	}
+	sorted := slices.Sorted(slices.Values(latencies))
+	return formatLatency(sorted[0]) + "\t" + formatLatency(percentile(sorted, 0.5)) + "\t" +
+		formatLatency(percentile(sorted, 0.95)) + "\t" + formatLatency(sorted[len(sorted)-1])
+}
+
+// percentile returns the nearest-rank percentile p (0..1] of sorted, which must not be empty
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// formatLatency rounds a latency to a readable precision, e.g. "12.3ms"
+func formatLatency(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
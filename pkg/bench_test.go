@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 20)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for p, expected := range map[float64]time.Duration{0.5: 10 * time.Millisecond, 0.95: 19 * time.Millisecond, 1: 20 * time.Millisecond, 0: time.Millisecond} {
+		if result := percentile(sorted, p); result != expected {
+			t.Errorf("percentile(%v) = %s, expected %s", p, result, expected)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+	useFakeSSH(t)
+	var err error
+	output := captureStdout(t, func() {
+		err = Bench(context.Background(), []string{"web1", "web2"}, "true", "", 3)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := strings.Split(strings.TrimSpace(output), "\n")
+	if len(rows) != 4 || !strings.HasPrefix(rows[0], "HOST") || !strings.HasPrefix(rows[3], "all") {
+		t.Fatalf("expected a header, two hosts and the overall row, got %q", output)
+	}
+	if fields := strings.Fields(rows[1]); fields[0] != "web1" || len(fields) != 7 || fields[6] != "0" {
+		t.Errorf("unexpected row %q", rows[1])
+	}
+
+	// Failed runs are counted and fail the host
+	output = captureStdout(t, func() { err = Bench(context.Background(), []string{"web1"}, "false", "", 2) })
+	var hostErr *HostError
+	if !errors.As(err, &hostErr) || hostErr.Host != "web1" {
+		t.Errorf("expected web1 to fail, got %v", err)
+	}
+	if fields := strings.Fields(strings.Split(strings.TrimSpace(output), "\n")[1]); fields[len(fields)-1] != "2" {
+		t.Errorf("expected two failed runs, got %q", output)
+	}
+}
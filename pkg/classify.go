@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FailureClass names why ssh could not reach a host
+type FailureClass string
+
+// Failure classes of ConnectionError
+const (
+	FailureDNS         FailureClass = "dns"
+	FailureRefused     FailureClass = "refused"
+	FailureUnreachable FailureClass = "unreachable"
+	FailureTimeout     FailureClass = "timeout"
+	FailureHostKey     FailureClass = "host-key-mismatch"
+	FailureAuth        FailureClass = "auth-failed"
+)
+
+// failureClasses lists what ssh prints for each class, checked in this order
+var failureClasses = []struct {
+	class    FailureClass
+	messages []string
+	hint     string
+}{
+	{FailureHostKey, []string{"REMOTE HOST IDENTIFICATION HAS CHANGED", "Host key verification failed", "host key is known for"},
+		"The host key is unknown or changed. Accept new hosts with --strict-host-key-checking accept-new; after a reinstall, check the new key and :trust <host>."},
+	{FailureDNS, []string{"Could not resolve hostname", "Name or service not known", "nodename nor servname", "Temporary failure in name resolution"},
+		"The name doesn't resolve. Check its spelling, the DNS servers or /etc/hosts."},
+	{FailureRefused, []string{"Connection refused"},
+		"Nothing listens on the ssh port. Check that sshd runs and the port is right (-o Port=...)."},
+	{FailureUnreachable, []string{"No route to host", "Network is unreachable"},
+		"There is no network route to the host. Check the address, the VPN and the routing."},
+	{FailureTimeout, []string{"timed out", "Timeout, server", "not responding"},
+		"The host doesn't answer in time. Check that it is up and no firewall drops port 22, or raise --connect-timeout."},
+	{FailureAuth, []string{"Permission denied", "Too many authentication failures", "no mutual signature algorithm"},
+		"No key was accepted. Check the user (-u), the key (-i or ssh-add -l) and the host's authorized_keys."},
+}
+
+// ConnectionError is a failure of ssh itself to reach a host, classified from what it printed
+type ConnectionError struct {
+	Class  FailureClass
+	Detail string // The message of ssh the class was derived from
+	Err    error  // Carries ssh's exit status 255
+}
+
+// Error returns "class: detail"
+func (e *ConnectionError) Error() string {
+	return string(e.Class) + ": " + e.Detail
+}
+
+// Unwrap returns the underlying error
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// Hint returns what to check to fix failures of the class
+func (e *ConnectionError) Hint() string {
+	for _, c := range failureClasses {
+		if c.class == e.Class {
+			return c.hint
+		}
+	}
+	return ""
+}
+
+// matchFailure returns the class of an ssh error message and the message without the "ssh: " prefix,
+// or "" when the line explains no connection failure
+func matchFailure(line []byte) (FailureClass, string) {
+	for _, c := range failureClasses {
+		for _, message := range c.messages {
+			if bytes.Contains(line, []byte(message)) {
+				detail := strings.TrimSpace(string(line))
+				return c.class, strings.TrimPrefix(detail, "ssh: ")
+			}
+		}
+	}
+	return "", ""
+}
+
+// classifySSHFailure turns err of an ssh invocation into a *ConnectionError when ssh exited with 255, its
+// status for failures of its own, and stderr explains why. Other errors are returned unchanged.
+func classifySSHFailure(host string, stderr []byte, err error) error {
+	if backendOf(host) != BackendSSH {
+		return err
+	}
+	return classifyFailure(stderr, err)
+}
+
+// classifyFailure is classifySSHFailure for a command known to be ssh
+func classifyFailure(stderr []byte, err error) error {
+	if exitCode(err) != 255 {
+		return err
+	}
+	for line := range bytes.Lines(stderr) {
+		if class, detail := matchFailure(line); class != "" {
+			return &ConnectionError{Class: class, Detail: detail, Err: err}
+		}
+	}
+	return err
+}
+
+// writeFailureSummary lists the hosts ssh couldn't reach, indexed like hosts in errs, grouped by class with
+// a hint for each. Hosts that failed otherwise are left out, as their output already tells why.
+func writeFailureSummary(w io.Writer, hosts []string, errs []error) {
+	byClass := make(map[FailureClass][]string)
+	count := 0
+	for i, err := range errs {
+		var connErr *ConnectionError
+		if errors.As(err, &connErr) {
+			byClass[connErr.Class] = append(byClass[connErr.Class], hosts[i])
+			count++
+		}
+	}
+	if count == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "❌ Could not connect to %d host(s):\n", count)
+	for _, c := range failureClasses {
+		failed := byClass[c.class]
+		if len(failed) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %s (%d): %s\n", c.class, len(failed), strings.Join(failed, ", "))
+		fmt.Fprintf(w, "    💡 %s\n", c.hint)
+	}
+}
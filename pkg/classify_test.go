@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestMatchFailure(t *testing.T) {
+	tests := []struct {
+		line   string
+		class  FailureClass
+		detail string
+	}{
+		{"ssh: Could not resolve hostname web9: Name or service not known", FailureDNS, "Could not resolve hostname web9: Name or service not known"},
+		{"ssh: connect to host web1 port 22: Connection refused\r\n", FailureRefused, "connect to host web1 port 22: Connection refused"},
+		{"ssh: connect to host 10.0.0.9 port 22: No route to host", FailureUnreachable, "connect to host 10.0.0.9 port 22: No route to host"},
+		{"ssh: connect to host web2 port 22: Connection timed out", FailureTimeout, "connect to host web2 port 22: Connection timed out"},
+		{"Host key verification failed.", FailureHostKey, "Host key verification failed."},
+		{"root@web3: Permission denied (publickey).", FailureAuth, "root@web3: Permission denied (publickey)."},
+		{"ls: cannot access '/nope': No such file or directory", "", ""},
+	}
+	for _, test := range tests {
+		class, detail := matchFailure([]byte(test.line))
+		if class != test.class || detail != test.detail {
+			t.Errorf("%q: expected %q %q, got %q %q", test.line, test.class, test.detail, class, detail)
+		}
+	}
+}
+
+func TestClassifySSHFailure(t *testing.T) {
+	stderr := []byte("Warning: Permanently added 'web1' to the list of known hosts.\nssh: connect to host web1 port 22: Connection refused\n")
+
+	exit255 := exec.CommandContext(context.Background(), "sh", "-c", "exit 255").Run()
+	var connErr *ConnectionError
+	if err := classifySSHFailure("web1", stderr, exit255); !errors.As(err, &connErr) || connErr.Class != FailureRefused {
+		t.Fatalf("expected a refused connection, got %v", err)
+	}
+	if exitCode(connErr) != 255 || connErr.Hint() == "" {
+		t.Errorf("expected the exit status and a hint to be kept, got %d %q", exitCode(connErr), connErr.Hint())
+	}
+
+	// The remote command failing with another status says nothing about the connection
+	exit1 := exec.CommandContext(context.Background(), "sh", "-c", "exit 1").Run()
+	if err := classifySSHFailure("web1", stderr, exit1); err != exit1 {
+		t.Errorf("expected the error to be left alone, got %v", err)
+	}
+	if err := classifySSHFailure("web1", []byte("something else\n"), exit255); err != exit255 {
+		t.Errorf("expected an unexplained failure to be left alone, got %v", err)
+	}
+}
+
+func TestWriteFailureSummary(t *testing.T) {
+	hosts := []string{"web1", "web2", "web3", "web4"}
+	errs := []error{
+		&ConnectionError{Class: FailureAuth, Detail: "Permission denied"},
+		nil,
+		errors.New("exit status 1"),
+		&ConnectionError{Class: FailureDNS, Detail: "Could not resolve hostname web4"},
+	}
+	var out bytes.Buffer
+	writeFailureSummary(&out, hosts, errs)
+	expected := "❌ Could not connect to 2 host(s):\n" +
+		"  dns (1): web4\n    💡 " + (&ConnectionError{Class: FailureDNS}).Hint() + "\n" +
+		"  auth-failed (1): web1\n    💡 " + (&ConnectionError{Class: FailureAuth}).Hint() + "\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	writeFailureSummary(&out, hosts[1:3], errs[1:3])
+	if out.Len() != 0 {
+		t.Errorf("expected no summary without connection failures, got %q", out.String())
+	}
+}
+
+func TestStreamCommandClassifiesFailure(t *testing.T) {
+	var err error
+	captureStdout(t, func() {
+		cmd := exec.CommandContext(context.Background(), "sh", "-c", "echo 'ssh: connect to host web1 port 22: Connection refused' >&2; exit 255")
+		err = streamCommand(context.Background(), cmd, "web1", "web1", "uptime")
+	})
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) || connErr.Class != FailureRefused {
+		t.Errorf("expected a refused connection, got %v", err)
+	}
+}
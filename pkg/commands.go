@@ -1,189 +1,388 @@
 package pkg
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 )
 
-// executeCommandStreaming runs a command on all hosts using persistent SSH connections with streaming output and context cancellation
-func executeCommandStreaming(ctx context.Context, cm *SSHConnectionManager, hosts []string, command string, noColor bool) {
+// executeCommandStreaming runs a command on the targeted hosts using persistent SSH connections with streaming output and context cancellation.
+// Colors and padding are derived from the full host list so they stay stable when only a subset is targeted; nil targets all hosts.
+// Host placeholders such as {host} are expanded per host. Every host gets its own copy of stdin unless it is nil.
+// It returns each host's error indexed like hosts, nil for hosts that succeeded or weren't targeted.
+func executeCommandStreaming(ctx context.Context, cm *SSHConnectionManager, hosts []string, targets map[string]bool, command string, stdin io.Reader, noColor bool) []error {
 	maxHostLen := maxLen(hosts)
+	errs := make([]error, len(hosts))
 	var wg sync.WaitGroup
 
+	inputs, cleanup, err := hostInputs(len(hosts), stdin)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	defer cleanup()
+
+	if usesFacts(command) {
+		// Facts are gathered once per session, on first use
+		cm.gatherFacts(ctx, slices.DeleteFunc(slices.Clone(hosts), func(host string) bool { return targets != nil && !targets[host] }), false)
+	}
+
 	for i, host := range hosts {
+		if targets != nil && !targets[host] {
+			continue
+		}
 		wg.Go(func() {
-			cm.runSSHStreaming(ctx, host, command, i, maxHostLen, noColor)
+			expanded, err := cm.expandFacts(expandHostTemplate(command, host, i), host)
+			if err != nil {
+				printOutput("%s: ❌ Error: %v\n", formatHostPrefix(host, i, maxHostLen, noColor), err)
+				errs[i] = err
+				return
+			}
+			if errs[i] = refuseCommand(ctx, host, formatHostPrefix(host, i, maxHostLen, noColor), expanded); errs[i] != nil {
+				return
+			}
+			errs[i] = cm.runSSHStreaming(ctx, host, expanded, inputs[i], i, maxHostLen, noColor)
 		})
 	}
 
 	wg.Wait()
+	return errs
 }
 
-// ExecuteCommand runs a command on all hosts with streaming output and interrupt handling (no persistent connections)
-func ExecuteCommand(hosts []string, command, user string, noColor bool) {
-	// Create a cancellable context for interrupt handling
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// hostInputs gives each of count hosts its own reader of src; all readers are nil when src is nil.
+// cleanup removes the buffered input once the commands are done.
+func hostInputs(count int, src io.Reader) (inputs []io.Reader, cleanup func(), err error) {
+	inputs = make([]io.Reader, count)
+	if src == nil {
+		return inputs, func() {}, nil
+	}
 
-	// Set up signal handling for Ctrl+C
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
-	defer signal.Stop(sigChan)
+	spool, err := newStdinSpool()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to buffer stdin: %w", err)
+	}
+	go spool.run(src)
+	for i := range inputs {
+		inputs[i] = spool.reader()
+	}
+	return inputs, spool.close, nil
+}
+
+// ExecuteCommand runs a command on all hosts with streaming output over new connections until ctx ends.
+// It returns ctx's error when interrupted, otherwise the failed hosts as joined *HostError values.
+func ExecuteCommand(ctx context.Context, hosts []string, command, user string, noColor bool) error {
+	defer closeHostLogs()
 
-	// Goroutine to handle interrupt signal
-	go func() {
-		<-sigChan
-		fmt.Println("\n🛑 Command interrupted by user")
-		cancel()
-	}()
+	if !At.IsZero() {
+		fmt.Fprintf(os.Stderr, "⏰ Command scheduled for %s (in %s)\n", At.Local().Format(time.DateTime), time.Until(At).Truncate(time.Second))
+	}
+
+	if banner := CurrentProfile.bannerLine(len(hosts), noColor); banner != "" {
+		fmt.Fprintln(os.Stderr, banner)
+	}
+
+	// Every host gets its own copy of the local stdin
+	inputs, cleanup, err := hostInputs(len(hosts), Stdin)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
+	var durations *hostDurations
+	if ShowDuration {
+		ctx, durations = withDurations(ctx)
+	}
+	var targets map[string]bool
+	var skipped []string
+	var guardErrs []error
+	if When != "" {
+		targets, skipped, guardErrs = checkGuardOnHosts(ctx, hosts, nil, user, noColor)
+	}
+	errs := runOnHostsConfirmed(ctx, hosts, targets, command, user, inputs, noColor)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	flushOutput()
+	if When != "" {
+		errs = mergeGuardErrors(errs, guardErrs)
+		printSkipped(os.Stderr, When, skipped)
+	}
+	writeFailureSummary(os.Stderr, hosts, errs)
+	if durations != nil {
+		durations.printSlowest(os.Stderr)
+	}
+	return joinHostErrors(hosts, errs)
+}
+
+// runOnHosts runs a command on the targeted hosts over new connections and returns each host's error,
+// indexed like hosts. Colors and padding come from the full host list; nil targets all hosts, nil inputs none.
+func runOnHosts(ctx context.Context, hosts []string, targets map[string]bool, command, user string, inputs []io.Reader, noColor bool) []error {
 	maxHostLen := maxLen(hosts)
+	errs := make([]error, len(hosts))
 	var wg sync.WaitGroup
 
 	for i, host := range hosts {
+		if targets != nil && !targets[host] {
+			continue
+		}
+		var stdin io.Reader
+		if inputs != nil {
+			stdin = inputs[i]
+		}
 		wg.Go(func() {
-			runSSHStreaming(ctx, host, command, user, i, maxHostLen, noColor)
+			errs[i] = runSSHStreaming(ctx, host, expandHostTemplate(command, host, i), user, stdin, i, maxHostLen, noColor)
 		})
 	}
 
 	wg.Wait()
+	return errs
+}
+
+// Upload copies a local file to all hosts in parallel, into remoteDir or the home directory when it is empty,
+// and returns the failed hosts as joined *HostError values
+func Upload(ctx context.Context, hosts []string, file, remoteDir, user string, noColor bool) error {
+	return uploadFile(ctx, hosts, file, remoteDir, user, noColor)
 }
 
-// uploadFile uploads a file to all hosts in parallel
-func uploadFile(hosts []string, filepath, user string, noColor bool) {
+// uploadFile uploads a file to all hosts in parallel, into remoteDir or the home directory when it is empty,
+// and returns the failed hosts as joined *HostError values
+func uploadFile(ctx context.Context, hosts []string, filepath, remoteDir, user string, noColor bool) error {
+	if Restricted() {
+		return fmt.Errorf("%w: uploads are not possible with an allow list", ErrRestricted)
+	}
 	// Check if local file exists
 	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		fmt.Printf("❌ Error: File '%s' does not exist\n", filepath)
-		return
+		return fmt.Errorf("file '%s' does not exist", filepath)
 	}
 
 	maxHostLen := maxLen(hosts)
+	errs := make([]error, len(hosts))
 	var wg sync.WaitGroup
 
 	for i, host := range hosts {
 		wg.Go(func() {
-			runSCP(host, filepath, user, i, maxHostLen, noColor)
+			errs[i] = runSCP(ctx, host, filepath, remoteDir, user, i, maxHostLen, noColor)
 		})
 	}
 
 	wg.Wait()
+	flushOutput()
+	return joinHostErrors(hosts, errs)
 }
 
-// runSCP uploads a file to a single host using scp
-func runSCP(host, filepath, user string, idx, maxHostLen int, noColor bool) {
-	args := []string{"-o", "ConnectTimeout=5", "-o", "BatchMode=yes"}
+// scpArgs returns the scp options shared by uploads and downloads
+func scpArgs(user string) []string {
+	args := []string{"-o", "ConnectTimeout=" + sshSeconds(ConnectTimeout), "-o", "BatchMode=yes"}
+	args = append(args, extraSSHOptions()...)
 
 	if user != "" {
 		args = append(args, "-o", "User="+user)
 	}
+	return args
+}
+
+// scpHost returns host as scp expects it before the colon; IPv6 addresses need brackets
+func scpHost(host string) string {
+	destination := sshDestination(host)
+	if strings.Contains(destination, ":") {
+		destination = "[" + destination + "]"
+	}
+	return destination
+}
 
+// runSCP uploads a file to a single host using scp and prints the outcome
+func runSCP(ctx context.Context, host, localPath, remoteDir, user string, idx, maxHostLen int, noColor bool) error {
 	// Get just the filename for the destination
-	filename := filepath
-	if strings.Contains(filepath, "/") {
-		parts := strings.Split(filepath, "/")
-		filename = parts[len(parts)-1]
+	filename := filepath.Base(localPath)
+	if remoteDir != "" {
+		filename = path.Join(remoteDir, filename)
 	}
 
-	// scp source destination
-	args = append(args, filepath, host+":"+filename)
-	cmd := exec.CommandContext(context.Background(), "scp", args...)
+	args := append(scpArgs(user), "--", localPath, scpHost(host)+":"+filename)
+	cmd := exec.CommandContext(ctx, "scp", args...)
+	if backendOf(host) != BackendSSH {
+		// Without scp the file is streamed into the working directory of the container, or the local home directory
+		file, err := os.Open(localPath) // #nosec G304 -- upload path is chosen by the local user
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		cmd = hostCommand(ctx, host, "cat > "+quoteRemotePath(filename), user, false)
+		cmd.Stdin = file
+	}
 
+	acquireFDs()
 	output, err := cmd.CombinedOutput()
+	releaseFDs()
 	prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
+	output = splitSSHDebug(prefix, output)
+	err = classifySSHFailure(host, output, err)
 
 	if err != nil {
-		fmt.Printf("%s: ❌ UPLOAD ERROR: %v\n", prefix, err)
-		if len(output) > 0 {
-			fmt.Printf("%s: %s\n", prefix, strings.TrimSpace(string(output)))
+		if output = bytes.TrimSpace(output); len(output) > 0 {
+			printOutput("%s: ❌ UPLOAD ERROR: %v\n%s: %s\n", prefix, err, prefix, output)
+		} else {
+			printOutput("%s: ❌ UPLOAD ERROR: %v\n", prefix, err)
 		}
-		return
+		return err
 	}
 
-	fmt.Printf("%s: ✅ Upload successful: %s\n", prefix, filename)
+	printOutput("%s: ✅ Upload successful: %s\n", prefix, filename)
+	return nil
 }
 
-// runSSHStreaming executes SSH command for a single host with real-time streaming output
-func runSSHStreaming(ctx context.Context, host, command, user string, idx, maxHostLen int, noColor bool) {
-	args := []string{"-o", "ConnectTimeout=5", "-o", "BatchMode=yes"}
+// At schedules commands to start at this time on every host's own clock; zero runs them immediately
+var At time.Time
 
-	if user != "" {
-		args = append(args, "-l", user)
+// scheduleCommand delays command remotely until the given time, with millisecond precision where date supports %N
+func scheduleCommand(command string, at time.Time) string {
+	return fmt.Sprintf(`t=%d; n=$(date +%%s%%N 2>/dev/null); case "$n" in *N|"") n=$(date +%%s)000000000;; esac; `+
+		`d=$(( (t - n) / 1000000 )); if [ "$d" -gt 0 ]; then sleep "$(printf '%%d.%%03d' $((d / 1000)) $((d %% 1000)))"; fi; %s`,
+		at.UnixNano(), command)
+}
+
+// Shell runs commands through this shell as a login shell instead of the user's login shell parsing them
+var Shell string
+
+// supportedShells lists the values accepted for Shell
+var supportedShells = []string{"sh", "bash", "zsh"}
+
+// SetShell selects the remote shell, empty for the user's login shell
+func SetShell(shell string) error {
+	if err := checkShell(shell); err != nil {
+		return err
 	}
+	Shell = shell
+	return nil
+}
 
-	args = append(args, host, command)
-	cmd := exec.CommandContext(ctx, "ssh", args...)
+// checkShell returns an error unless shell is empty or one of supportedShells
+func checkShell(shell string) error {
+	if shell != "" && !slices.Contains(supportedShells, shell) {
+		return fmt.Errorf("unsupported shell %q, use one of %s", shell, strings.Join(supportedShells, ", "))
+	}
+	return nil
+}
 
-	// Get stdout and stderr pipes
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
-		fmt.Printf("%s: ERROR: Failed to get stdout pipe: %v\n", prefix, err)
-		return
+// commandSettings are the transformations applied to a command before it is sent to a host
+type commandSettings struct {
+	env        []string  // VAR=value assignments exported to the command
+	shell      string    // Login shell the command runs in, empty for the remote default
+	sudo       bool      // Run through sudo, which reads its password from stdin
+	becomeUser string    // Run as this user via sudo, empty for the login user
+	noEcho     bool      // Turn off terminal echo first
+	at         time.Time // Schedule the command instead of running it now, unless zero
+}
+
+// sessionCommandSettings returns the command settings of the session
+func sessionCommandSettings() commandSettings {
+	return commandSettings{env: Env, shell: Shell, sudo: Sudo, becomeUser: BecomeUser, noEcho: NoEcho, at: At}
+}
+
+// prepareCommand applies the session-wide command transformations before a command is sent to a host
+func prepareCommand(command string) string {
+	return sessionCommandSettings().prepare(command)
+}
+
+// prepare applies the settings to command
+func (s commandSettings) prepare(command string) string {
+	command = envPrefix(s.env) + command // Inside sudo, which resets the environment
+	if s.shell != "" {
+		command = s.shell + " -lc " + shellQuote(command)
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
-		fmt.Printf("%s: ERROR: Failed to get stderr pipe: %v\n", prefix, err)
-		return
+	if s.sudo || s.becomeUser != "" {
+		command = wrapSudo(command, s.becomeUser, s.sudo)
+	}
+	if s.noEcho {
+		command = noEchoPrefix + command
+	}
+	if !s.at.IsZero() {
+		command = scheduleCommand(command, s.at)
 	}
+	return command
+}
 
-	prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
+// buildSSHArgs returns the ssh arguments to run a command on a host over a new connection, or over the
+// control master of another gosh process if one is open
+func buildSSHArgs(host, command, user string) []string {
+	args := []string{"-o", "ConnectTimeout=" + sshSeconds(ConnectTimeout), "-o", "BatchMode=yes"}
+	args = append(args, extraSSHOptions()...)
 
-	// Start goroutines to read and display output in real-time
-	var wg sync.WaitGroup
+	if user != "" {
+		args = append(args, "-l", user)
+	}
 
-	// Handle stdout
-	wg.Go(func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				line := scanner.Text()
-				fmt.Printf("%s: %s\n", prefix, line)
-			}
-		}
-		if err := scanner.Err(); err != nil && ctx.Err() == nil {
-			fmt.Printf("%s: ERROR: Failed to read stdout: %v\n", prefix, err)
-		}
-	})
-
-	// Handle stderr
-	wg.Go(func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				line := scanner.Text()
-				fmt.Printf("%s: %s\n", prefix, line)
-			}
-		}
-		if err := scanner.Err(); err != nil && ctx.Err() == nil {
-			fmt.Printf("%s: ERROR: Failed to read stderr: %v\n", prefix, err)
-		}
-	})
+	// Ride on a live control master if there is one; ssh connects on its own if the master went away
+	if socket := sharedSocket(host, user); socket != "" {
+		args = append(args, "-o", "ControlPath="+socket, "-o", "ControlMaster=no")
+	}
+
+	return append(args, "--", sshDestination(host), command)
+}
+
+// shellQuote quotes s for safe use as a single word in a POSIX shell command
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("%s: ERROR: Failed to start command: %v\n", prefix, err)
-		return
+// shellJoin quotes every word and joins them into a single command line
+func shellJoin(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = shellQuote(word)
 	}
+	return strings.Join(quoted, " ")
+}
 
-	// Wait for command to complete and output readers to finish
-	if err := cmd.Wait(); err != nil {
-		// Only show error if context wasn't cancelled
-		if ctx.Err() == nil {
-			fmt.Printf("%s: ERROR: Command failed: %v\n", prefix, err)
-		}
+// portCheckTimeout is the number of seconds a remote port check waits for a connection
+const portCheckTimeout = 3
+
+// buildPortCheckCommand returns a remote command that reports whether target:port accepts TCP connections.
+// It prefers bash's /dev/tcp and falls back to nc; the result is printed as open, closed or timeout, or as an
+// error when the host lacks timeout or nc, which the shell reports with status 127.
+func buildPortCheckCommand(target, port string) (string, error) {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
 	}
-	wg.Wait()
+	if target == "" || strings.IndexFunc(target, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(".-_:", r)
+	}) >= 0 {
+		return "", fmt.Errorf("invalid target host %q", target)
+	}
+
+	probe := fmt.Sprintf("timeout %d bash -c 'exec 3<>/dev/tcp/%s/%s'", portCheckTimeout, target, port)
+	fallback := fmt.Sprintf("timeout %d nc -z %s %s", portCheckTimeout, target, port)
+	label := target + ":" + port
+
+	return "if command -v bash >/dev/null 2>&1; then " + probe + "; else " + fallback + "; fi >/dev/null 2>&1; " +
+		"rc=$?; if [ $rc -eq 0 ]; then echo '" + label + " open'; " +
+		"elif [ $rc -eq 124 ]; then echo '" + label + " timeout'; " +
+		"elif [ $rc -eq 127 ]; then echo '" + label + " error: timeout or nc not available on host'; " +
+		"else echo '" + label + " closed'; fi", nil
+}
+
+// runSSHStreaming executes SSH command for a single host with real-time streaming output; stdin may be nil.
+// It returns the command's error, which carries the remote exit status.
+func runSSHStreaming(ctx context.Context, host, command, user string, stdin io.Reader, idx, maxHostLen int, noColor bool) error {
+	prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
+	if err := refuseCommand(ctx, host, prefix, command); err != nil {
+		return err
+	}
+	cmd := hostCommand(ctx, host, prepareCommand(command), user, TTY)
+	cmd.Stdin = stdin
+	return streamCommand(ctx, cmd, host, prefix, command)
 }
@@ -0,0 +1,324 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds the settings of the config file: defaults for every run and named profiles overriding them.
+// Settings are named like the long command line flags and hold one value, or several for repeatable flags.
+type Config struct {
+	Defaults map[string][]string
+	Profiles map[string]map[string][]string
+}
+
+// DefaultConfigFile returns the location of the config file, $XDG_CONFIG_HOME/gosh/config.yaml or
+// ~/.config/gosh/config.yaml
+func DefaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(homeDir(), ".config")
+	}
+	return filepath.Join(dir, "gosh", "config.yaml")
+}
+
+// LoadConfig reads the config file at path. A missing file yields an empty config.
+//
+// The file is a YAML subset: nested mappings by indentation, plain or quoted scalars, and lists either
+// as "[a, b]" or as "- item" lines. The top level has the mappings "defaults" and "profiles":
+//
+//	defaults:
+//	  user: deploy
+//	  parallel: 50
+//	  ssh-opt:
+//	    - StrictHostKeyChecking=accept-new
+//	profiles:
+//	  prod:
+//	    user: admin
+//	    banner: PRODUCTION
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.Open(path) // #nosec G304 -- config path is chosen by the local user
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	config, err := parseConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// EnvSetting returns the values of the GOSH_* environment variable of a flag, GOSH_NO_COLOR for no-color,
+// and the variable it was read from. Repeatable flags also read the plural like GOSH_SSH_OPTS and take several
+// values separated by ";". Unset and empty variables yield no values.
+func EnvSetting(flag string, repeatable bool) (values []string, variable string) {
+	variable = "GOSH_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+	value := os.Getenv(variable)
+	if value == "" && repeatable {
+		variable += "S"
+		value = os.Getenv(variable)
+	}
+	if value == "" {
+		return nil, ""
+	}
+	if !repeatable {
+		return []string{value}, variable
+	}
+	for item := range strings.SplitSeq(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values, variable
+}
+
+// Settings returns the defaults with the settings of profile applied on top, profile may be empty
+func (c *Config) Settings(profile string) map[string][]string {
+	settings := maps.Clone(c.Defaults)
+	if settings == nil {
+		settings = make(map[string][]string)
+	}
+	maps.Copy(settings, c.Profiles[profile])
+	return settings
+}
+
+// parseConfig reads a config file, see LoadConfig
+func parseConfig(r io.Reader) (*Config, error) {
+	lines, err := readYAMLLines(r)
+	if err != nil {
+		return nil, err
+	}
+	document, next, err := parseYAMLMapping(lines, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].number)
+	}
+
+	config := &Config{Profiles: make(map[string]map[string][]string)}
+	for key, value := range document {
+		switch key {
+		case "defaults":
+			if config.Defaults, err = configSettings(key, value); err != nil {
+				return nil, err
+			}
+		case "profiles":
+			profiles, ok := value.(map[string]any)
+			if !ok && value != "" {
+				return nil, errors.New("profiles must be a mapping of profile names")
+			}
+			for name, value := range profiles {
+				if config.Profiles[name], err = configSettings("profile "+name, value); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown section %q, expected defaults or profiles", key)
+		}
+	}
+	return config, nil
+}
+
+// configSettings converts a parsed mapping of settings, which may not nest further
+func configSettings(section string, value any) (map[string][]string, error) {
+	settings := make(map[string][]string)
+	mapping, ok := value.(map[string]any)
+	if !ok {
+		if value == "" {
+			return settings, nil
+		}
+		return nil, fmt.Errorf("%s must be a mapping of settings", section)
+	}
+	for key, value := range mapping {
+		switch value := value.(type) {
+		case string:
+			settings[key] = []string{value}
+		case []string:
+			settings[key] = value
+		default:
+			return nil, fmt.Errorf("%s: %s must be a value or a list", section, key)
+		}
+	}
+	return settings, nil
+}
+
+// yamlLine is a non-empty line of a YAML document without its comment
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// readYAMLLines splits a document into lines, dropping blank lines, comments and document markers
+func readYAMLLines(r io.Reader) ([]yamlLine, error) {
+	var lines []yamlLine
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		raw := scanner.Text()
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", number)
+		}
+		text = strings.TrimSpace(stripYAMLComment(text))
+		if text == "" || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{number: number, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text})
+	}
+	return lines, scanner.Err()
+}
+
+// stripYAMLComment removes a "#" comment that starts the line or follows a space outside quotes
+func stripYAMLComment(text string) string {
+	var quote rune
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || text[i-1] == ' '):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// parseYAMLMapping parses the "key: value" lines at indent starting at lines[i] into a mapping whose values
+// are strings, lists of strings or nested mappings. It returns the index of the first line after it.
+func parseYAMLMapping(lines []yamlLine, i, indent int) (map[string]any, int, error) {
+	mapping := make(map[string]any)
+	for i < len(lines) && lines[i].indent >= indent {
+		line := lines[i]
+		if line.indent > indent {
+			return nil, 0, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+		if strings.HasPrefix(line.text, "- ") || line.text == "-" {
+			return nil, 0, fmt.Errorf("line %d: unexpected list item", line.number)
+		}
+		key, value, ok := cutYAMLKey(line.text)
+		if !ok {
+			return nil, 0, fmt.Errorf("line %d: expected key: value", line.number)
+		}
+		if _, dup := mapping[key]; dup {
+			return nil, 0, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+		i++
+
+		var err error
+		switch {
+		case value != "":
+			mapping[key], err = parseYAMLValue(value)
+			if err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", line.number, err)
+			}
+		case i < len(lines) && lines[i].indent >= indent && strings.HasPrefix(lines[i].text, "-"):
+			// Block lists may sit at the indentation of their key
+			itemIndent := lines[i].indent
+			var items []string
+			for ; i < len(lines) && lines[i].indent == itemIndent && strings.HasPrefix(lines[i].text, "-"); i++ {
+				item, err := parseYAMLScalar(strings.TrimSpace(strings.TrimPrefix(lines[i].text, "-")))
+				if err != nil {
+					return nil, 0, fmt.Errorf("line %d: %w", lines[i].number, err)
+				}
+				items = append(items, item)
+			}
+			mapping[key] = items
+		case i < len(lines) && lines[i].indent > indent:
+			if mapping[key], i, err = parseYAMLMapping(lines, i, lines[i].indent); err != nil {
+				return nil, 0, err
+			}
+		default:
+			mapping[key] = ""
+		}
+	}
+	return mapping, i, nil
+}
+
+// cutYAMLKey splits "key: value" at the first colon followed by a space or ending the line, so values
+// like "ProxyJump=bastion:2222" keep their colons
+func cutYAMLKey(text string) (key, value string, ok bool) {
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			key, err := parseYAMLScalar(strings.TrimSpace(text[:i]))
+			return key, strings.TrimSpace(text[i+1:]), err == nil && key != ""
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLValue parses a scalar or a flow list like "[a, 'b c']"
+func parseYAMLValue(text string) (any, error) {
+	inner, ok := strings.CutPrefix(text, "[")
+	if !ok {
+		return parseYAMLScalar(text)
+	}
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return nil, errors.New("unterminated list")
+	}
+	items := []string{}
+	if strings.TrimSpace(inner) == "" {
+		return items, nil
+	}
+	for _, item := range splitFlowList(inner) {
+		item, err := parseYAMLScalar(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// splitFlowList splits the items of a flow list at commas outside of quotes
+func splitFlowList(inner string) []string {
+	var items []string
+	quote, start := byte(0), 0
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case quote == '"' && c == '\\':
+			i++ // Escaped character
+		case quote != 0:
+			if c == quote {
+				quote = 0 // A doubled '' reopens the quote right away
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, inner[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, inner[start:])
+}
+
+// parseYAMLScalar unquotes a single or double quoted scalar and returns plain ones unchanged
+func parseYAMLScalar(text string) (string, error) {
+	switch {
+	case len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"':
+		return strconv.Unquote(text)
+	case len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'':
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'"):
+		return "", fmt.Errorf("unterminated string %s", text)
+	}
+	return text, nil
+}
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `---
+# Shared by the team
+defaults:
+  user: deploy
+  parallel: 50   # at most 50 hosts at once
+  theme: "solarized"
+  ssh-opt:
+    - StrictHostKeyChecking=accept-new
+    - 'ProxyJump=bastion:2222'
+  identity: [~/.ssh/id_ed25519, "~/.ssh/team key"]
+profiles:
+  prod:
+    user: admin
+    banner: PRODUCTION #1
+    color: red
+    ssh-opt: ['ProxyJump=a,b', "SendEnv=\"X,Y\"", 'SetEnv=NOTE=it''s, fine']
+  lab:
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"user":     {"deploy"},
+		"parallel": {"50"},
+		"theme":    {"solarized"},
+		"ssh-opt":  {"StrictHostKeyChecking=accept-new", "ProxyJump=bastion:2222"},
+		"identity": {"~/.ssh/id_ed25519", "~/.ssh/team key"},
+	}
+	if !reflect.DeepEqual(config.Settings(""), expected) {
+		t.Errorf("unexpected defaults %v", config.Settings(""))
+	}
+
+	prod := config.Settings("prod")
+	if prod["user"][0] != "admin" || prod["banner"][0] != "PRODUCTION" || prod["parallel"][0] != "50" {
+		t.Errorf("expected the profile on top of the defaults, got %v", prod)
+	}
+	if options := []string{"ProxyJump=a,b", `SendEnv="X,Y"`, "SetEnv=NOTE=it's, fine"}; !reflect.DeepEqual(prod["ssh-opt"], options) {
+		t.Errorf("expected commas in quoted list items to be kept, got %q", prod["ssh-opt"])
+	}
+	if len(config.Settings("lab")) != len(expected) || len(config.Settings("unknown")) != len(expected) {
+		t.Error("expected the defaults for profiles without settings")
+	}
+
+	if config, err := LoadConfig(filepath.Join(t.TempDir(), "missing")); err != nil || len(config.Settings("prod")) != 0 {
+		t.Errorf("expected an empty config for a missing file, got %v, %v", config, err)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"defaults:\n  user: a\n   parallel: 2\n": "unexpected indentation",
+		"defaults:\n\tuser: a\n":                 "tabs",
+		"defaults:\n  user: a\n  user: b\n":      "duplicate key",
+		"defaults:\n  user\n":                    "expected key: value",
+		"hosts:\n  web: a\n":                     "unknown section",
+		"defaults:\n  ssh:\n    opt: a\n":        "must be a value or a list",
+		"defaults:\n  user: \"deploy\n":          "unterminated string",
+		"defaults:\n  identity: [a, b\n":         "unterminated list",
+		"profiles:\n  prod: admin\n":             "profile prod must be a mapping",
+		"defaults:\n  - user\n":                  "defaults must be a mapping",
+		"defaults:\n  user: a\n  - b\n":          "unexpected list item",
+	}
+	for content, expected := range tests {
+		if _, err := parseConfig(strings.NewReader(content)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("parseConfig(%q) = %v, expected an error containing %q", content, err, expected)
+		}
+	}
+}
+
+func TestProfileConfigure(t *testing.T) {
+	settings := ProfileSettings{Banner: "from file", Color: "blue"}
+	if err := settings.Configure(map[string][]string{"banner": {"PRODUCTION"}}); err != nil || settings.Banner != "PRODUCTION" || settings.Color != "blue" {
+		t.Errorf("unexpected settings %+v, %v", settings, err)
+	}
+	if err := settings.Configure(map[string][]string{"color": {"nope"}}); err == nil {
+		t.Error("expected an invalid color to be rejected")
+	}
+}
+
+func TestEnvSetting(t *testing.T) {
+	t.Setenv("GOSH_NO_COLOR", "1")
+	t.Setenv("GOSH_SSH_OPTS", "StrictHostKeyChecking=no; Ciphers=aes128-ctr,aes256-ctr;")
+	t.Setenv("GOSH_USER", "")
+
+	if values, variable := EnvSetting("no-color", false); !reflect.DeepEqual(values, []string{"1"}) || variable != "GOSH_NO_COLOR" {
+		t.Errorf("unexpected no-color setting %q from %s", values, variable)
+	}
+	values, variable := EnvSetting("ssh-opt", true)
+	if !reflect.DeepEqual(values, []string{"StrictHostKeyChecking=no", "Ciphers=aes128-ctr,aes256-ctr"}) || variable != "GOSH_SSH_OPTS" {
+		t.Errorf("unexpected ssh-opt setting %q from %s", values, variable)
+	}
+	t.Setenv("GOSH_SSH_OPT", "BatchMode=yes")
+	if values, _ := EnvSetting("ssh-opt", true); !reflect.DeepEqual(values, []string{"BatchMode=yes"}) {
+		t.Errorf("expected the singular to take precedence, got %q", values)
+	}
+	if values, variable := EnvSetting("user", false); values != nil || variable != "" {
+		t.Errorf("expected an empty variable to be ignored, got %q from %s", values, variable)
+	}
+}
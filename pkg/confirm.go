@@ -0,0 +1,219 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// AssumeYes runs commands matching a dangerous pattern without asking, for automation
+var AssumeYes bool
+
+// dangerousPattern is a kind of command that asks for confirmation before it runs
+type dangerousPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// dangerousPatterns are checked before every command; AddDangerousPattern extends them
+var dangerousPatterns = []dangerousPattern{
+	{"recursive rm", regexp.MustCompile(`\brm\s+(\S+\s+)*-(-recursive\b|[a-zA-Z]*[rR])`)},
+	{"mkfs", regexp.MustCompile(`\bmkfs(\.\w+)?\b`)},
+	{"shutdown", regexp.MustCompile(`(^|[\s;&|(])(shutdown|reboot|poweroff|halt)($|[\s;&|)])`)},
+	{"dd onto a device", regexp.MustCompile(`\bdd\b.*\bof=/dev/`)},
+}
+
+// AddDangerousPattern makes commands matching the regular expression expr ask for confirmation as well
+func AddDangerousPattern(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	dangerousPatterns = append(dangerousPatterns, dangerousPattern{name: expr, re: re})
+	return nil
+}
+
+// dangerousMatch returns the name of the first dangerous pattern command matches and the matching line,
+// which tells which line of a script is the dangerous one, or "" if none matches
+func dangerousMatch(command string) (name, line string) {
+	for _, pattern := range dangerousPatterns {
+		for line := range strings.Lines(command) {
+			if pattern.re.MatchString(line) {
+				return pattern.name, strings.TrimSpace(line)
+			}
+		}
+	}
+	return "", ""
+}
+
+// confirmDangerous asks through readLine whether command may run on hostCount hosts when it matches a
+// dangerous pattern, which takes typing the host count. It reports true right away for other commands
+// and with AssumeYes.
+func confirmDangerous(out io.Writer, command string, hostCount int, readLine func(prompt string) (string, error)) (bool, error) {
+	name, line := dangerousMatch(command)
+	if AssumeYes || name == "" {
+		return true, nil
+	}
+	fmt.Fprintf(out, "⚠️  %s matches a dangerous pattern (%s)\n", line, name)
+	answer, err := readLine(fmt.Sprintf("⚠️  Run on %d host(s)? Type %d to confirm: ", hostCount, hostCount))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(answer) == strconv.Itoa(hostCount), nil
+}
+
+// ConfirmDangerous asks on the terminal whether command may run on hostCount hosts when it matches a
+// dangerous pattern. Without a terminal such commands need --yes.
+func ConfirmDangerous(command string, hostCount int) error {
+	name, line := dangerousMatch(command)
+	if AssumeYes || name == "" {
+		return nil
+	}
+	if !readline.IsTerminal(int(os.Stdin.Fd())) { // #nosec G115 -- file descriptors fit in int
+		return fmt.Errorf("%q matches a dangerous pattern (%s), pass --yes to run it without a terminal", line, name)
+	}
+	confirmed, err := confirmDangerous(os.Stderr, command, hostCount, readline.Line)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return errors.New("not confirmed, nothing was run")
+	}
+	return nil
+}
+
+// confirm asks in the session whether command may run on hostCount hosts if it is dangerous
+func (s *session) confirm(command string, hostCount int) bool {
+	confirmed, err := confirmDangerous(os.Stdout, command, hostCount, s.readAnswer)
+	switch {
+	case err != nil:
+		fmt.Printf("❌ Error: %v\n", err)
+	case !confirmed:
+		fmt.Println("🛑 Not confirmed, nothing was run")
+	}
+	return confirmed && err == nil
+}
+
+// ConfirmEach asks before a command runs on each host, for a rolling human check of risky changes
+var ConfirmEach bool
+
+// errNotConfirmed is the error of hosts skipped at the ConfirmEach question
+var errNotConfirmed = errors.New("skipped, not confirmed")
+
+// confirmEach runs a command host by host through run, which gets the hosts to run on as targets, asking
+// through readLine before each targeted host: y runs it there, n skips the host, a runs it on all remaining
+// hosts at once and q skips them. It stops asking when ctx ends and returns each host's error indexed like
+// hosts, errNotConfirmed for skipped ones.
+func confirmEach(ctx context.Context, out io.Writer, hosts []string, targets map[string]bool, readLine func(prompt string) (string, error), run func(targets map[string]bool) []error) []error {
+	errs := make([]error, len(hosts))
+	collect := func(targets map[string]bool) {
+		for i, err := range run(targets) {
+			if err != nil {
+				errs[i] = err
+			}
+		}
+	}
+
+	for i, host := range hosts {
+		if targets != nil && !targets[host] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return errs
+		}
+		switch askHost(out, host, readLine) {
+		case "y":
+			collect(map[string]bool{host: true})
+		case "n":
+			errs[i] = errNotConfirmed
+		case "a":
+			remaining := make(map[string]bool)
+			for _, host := range hosts[i:] {
+				if targets == nil || targets[host] {
+					remaining[host] = true
+				}
+			}
+			collect(remaining)
+			return errs
+		default:
+			skipped := 0
+			for j, host := range hosts[i:] {
+				if targets == nil || targets[host] {
+					errs[i+j] = errNotConfirmed
+					skipped++
+				}
+			}
+			fmt.Fprintf(out, "🛑 Skipped %d host(s)\n", skipped)
+			return errs
+		}
+	}
+	return errs
+}
+
+// askHost asks whether to run on host until the answer is y, n, a or q, which it returns. Failing to read
+// an answer, e.g. on Ctrl+C, quits.
+func askHost(out io.Writer, host string, readLine func(prompt string) (string, error)) string {
+	for {
+		answer, err := readLine(fmt.Sprintf("❓ Run on %s? [y]es, [n]o, [a]ll, [q]uit: ", host))
+		if err != nil {
+			return "q"
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return "y"
+		case "n", "no":
+			return "n"
+		case "a", "all":
+			return "a"
+		case "q", "quit":
+			return "q"
+		}
+		fmt.Fprintln(out, "Answer y, n, a or q")
+	}
+}
+
+// runOnHostsConfirmed is runOnHosts asking on the terminal before each host with ConfirmEach
+func runOnHostsConfirmed(ctx context.Context, hosts []string, targets map[string]bool, command, user string, inputs []io.Reader, noColor bool) []error {
+	if !ConfirmEach {
+		return runOnHosts(ctx, hosts, targets, command, user, inputs, noColor)
+	}
+	return confirmEach(ctx, os.Stderr, hosts, targets, readline.Line, func(targets map[string]bool) []error {
+		return runOnHosts(ctx, hosts, targets, command, user, inputs, noColor)
+	})
+}
+
+// toggleConfirmEach turns asking before each host on or off
+func toggleConfirmEach(mode string) {
+	switch mode {
+	case "on":
+		ConfirmEach = true
+		fmt.Println("❓ Commands ask before running on each host")
+	case "off":
+		ConfirmEach = false
+		fmt.Println("❓ Commands run on all hosts without asking")
+	default:
+		fmt.Println("❓ Usage: :confirm on|off")
+	}
+}
+
+// confirmEach asks in the session before running on each targeted host, see confirmEach
+func (s *session) confirmEach(ctx context.Context, targets map[string]bool, run func(targets map[string]bool) []error) []error {
+	return confirmEach(ctx, os.Stdout, s.hosts, targets, s.readAnswer, run)
+}
+
+// readAnswer reads the answer to a question from the session's terminal, asking with prompt
+func (s *session) readAnswer(prompt string) (string, error) {
+	if s.rl == nil {
+		return "", errors.New("no terminal to confirm on")
+	}
+	s.rl.SetPrompt(prompt)
+	defer s.rl.SetPrompt(s.prompt())
+	return s.rl.Readline()
+}
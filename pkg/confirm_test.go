@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDangerousMatch(t *testing.T) {
+	tests := map[string]string{
+		"rm -rf /var/lib/app":             "recursive rm",
+		"sudo rm -f -R /tmp/x":            "recursive rm",
+		"rm --recursive old":              "recursive rm",
+		"mkfs.ext4 /dev/sdb1":             "mkfs",
+		"sudo systemctl reboot":           "shutdown",
+		"shutdown -h now":                 "shutdown",
+		"dd if=image.iso of=/dev/sda":     "dd onto a device",
+		"rm -f /tmp/lock":                 "",
+		"ls -R /etc":                      "",
+		"grep -r error /var/log/asphalt":  "",
+		"echo farm -rf":                   "",
+		"dd if=/dev/zero of=/tmp/zeroes":  "",
+		"cat /var/log/reboot-history.txt": "",
+		"uptime; reboot":                  "shutdown",
+	}
+	for command, expected := range tests {
+		if name, _ := dangerousMatch(command); name != expected {
+			t.Errorf("dangerousMatch(%q) = %q, expected %q", command, name, expected)
+		}
+	}
+
+	if name, line := dangerousMatch("set -e\ncd /srv\nrm -rf cache\n"); name != "recursive rm" || line != "rm -rf cache" {
+		t.Errorf("expected the matching script line, got %q (%s)", line, name)
+	}
+}
+
+func TestAddDangerousPattern(t *testing.T) {
+	defer func(patterns []dangerousPattern) { dangerousPatterns = patterns }(dangerousPatterns)
+
+	if err := AddDangerousPattern(`\bDROP\s+TABLE\b`); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := dangerousMatch(`psql -c "DROP TABLE users"`); name != `\bDROP\s+TABLE\b` {
+		t.Errorf("expected the added pattern to match, got %q", name)
+	}
+	if err := AddDangerousPattern("("); err == nil {
+		t.Error("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestConfirmDangerous(t *testing.T) {
+	answer := func(text string) func(string) (string, error) {
+		return func(prompt string) (string, error) {
+			if !strings.Contains(prompt, "Type 3 to confirm") {
+				t.Errorf("unexpected prompt %q", prompt)
+			}
+			return text, nil
+		}
+	}
+	var out bytes.Buffer
+	if ok, err := confirmDangerous(&out, "rm -rf /srv/app", 3, answer("3")); !ok || err != nil {
+		t.Errorf("expected the host count to confirm, got %v, %v", ok, err)
+	}
+	if !strings.Contains(out.String(), "rm -rf /srv/app matches a dangerous pattern (recursive rm)") {
+		t.Errorf("unexpected warning %q", out.String())
+	}
+	for _, text := range []string{"y", "yes", "2", ""} {
+		if ok, _ := confirmDangerous(&out, "rm -rf /srv/app", 3, answer(text)); ok {
+			t.Errorf("expected %q not to confirm", text)
+		}
+	}
+	if ok, err := confirmDangerous(&out, "rm -rf /srv/app", 3, func(string) (string, error) { return "", errors.New("EOF") }); ok || err == nil {
+		t.Error("expected a failed read not to confirm")
+	}
+
+	never := func(string) (string, error) {
+		t.Error("expected no prompt")
+		return "", nil
+	}
+	if ok, _ := confirmDangerous(&out, "uptime", 3, never); !ok {
+		t.Error("expected harmless commands to run")
+	}
+	AssumeYes = true
+	defer func() { AssumeYes = false }()
+	if ok, _ := confirmDangerous(&out, "reboot", 3, never); !ok {
+		t.Error("expected --yes to skip the confirmation")
+	}
+}
+
+func TestSessionRefusesDangerousCommandsWithoutTerminal(t *testing.T) {
+	s := &session{hosts: []string{"web1", "web2"}}
+	var confirmed bool
+	output := captureStdout(t, func() { confirmed = s.confirm("rm -rf /", 2) })
+	if confirmed || !strings.Contains(output, "no terminal to confirm on") {
+		t.Errorf("expected the command to be refused, got %v: %q", confirmed, output)
+	}
+	if !s.confirm("uptime", 2) {
+		t.Error("expected harmless commands to run")
+	}
+}
+
+func TestConfirmEach(t *testing.T) {
+	hosts := []string{"web1", "web2", "web3", "web4", "db1"}
+	targets := map[string]bool{"web1": true, "web2": true, "web3": true, "web4": true}
+	confirm := func(answers ...string) (runs [][]string, errs []error) {
+		readLine := func(prompt string) (string, error) {
+			if len(answers) == 0 {
+				return "", errors.New("EOF")
+			}
+			answer := answers[0]
+			answers = answers[1:]
+			return answer, nil
+		}
+		run := func(targets map[string]bool) []error {
+			runs = append(runs, slices.Sorted(maps.Keys(targets)))
+			errs := make([]error, len(hosts))
+			if targets["web2"] {
+				errs[1] = errors.New("exit status 1")
+			}
+			return errs
+		}
+		var out bytes.Buffer
+		return runs, confirmEach(context.Background(), &out, hosts, targets, readLine, run)
+	}
+
+	runs, errs := confirm("y", "maybe", "n", "all")
+	if expected := [][]string{{"web1"}, {"web3", "web4"}}; !slices.EqualFunc(runs, expected, slices.Equal) {
+		t.Errorf("expected runs %v, got %v", expected, runs)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], errNotConfirmed) || errs[2] != nil || errs[4] != nil {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	runs, errs = confirm("Yes", "y", "q")
+	if expected := [][]string{{"web1"}, {"web2"}}; !slices.EqualFunc(runs, expected, slices.Equal) {
+		t.Errorf("expected runs %v, got %v", expected, runs)
+	}
+	if errs[1] == nil || errors.Is(errs[1], errNotConfirmed) || !errors.Is(errs[2], errNotConfirmed) || !errors.Is(errs[3], errNotConfirmed) || errs[4] != nil {
+		t.Errorf("expected the failure of web2 and the rest skipped, got %v", errs)
+	}
+
+	runs, errs = confirm()
+	if len(runs) != 0 || !errors.Is(errs[0], errNotConfirmed) {
+		t.Errorf("expected a failed read to quit, got %v, %v", runs, errs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	never := func(string) (string, error) {
+		t.Error("expected no question after the context ended")
+		return "", nil
+	}
+	confirmEach(ctx, &bytes.Buffer{}, hosts, nil, never, func(map[string]bool) []error { return nil })
+}
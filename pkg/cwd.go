@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// quoteRemotePath quotes a path for the remote shell while keeping a leading ~ expandable
+func quoteRemotePath(path string) string {
+	switch {
+	case path == "~":
+		return `"$HOME"`
+	case strings.HasPrefix(path, "~/"):
+		return `"$HOME"/` + shellQuote(path[2:])
+	default:
+		return shellQuote(path)
+	}
+}
+
+// cdPrefix returns the command prefix that enters dir, nothing for the home directory
+func cdPrefix(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return "cd " + shellQuote(dir) + " && "
+}
+
+// plainCd reports whether line is a lone cd command and returns its argument, e.g. "/var/log" for "cd /var/log"
+func plainCd(line string) (string, bool) {
+	if line != "cd" && !strings.HasPrefix(line, "cd ") {
+		return "", false
+	}
+	arg := strings.TrimSpace(strings.TrimPrefix(line, "cd"))
+	if strings.ContainsAny(arg, ";&|<>`$()") || len(strings.Fields(arg)) > 1 {
+		return "", false // Compound commands run as typed
+	}
+	return arg, true
+}
+
+// workDir returns the directory commands on host start in, empty for the login directory
+func (cm *SSHConnectionManager) workDir(host string) string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.cwd[host]
+}
+
+// setWorkDir sets the directory commands on host start in; empty resets it to the login directory
+func (cm *SSHConnectionManager) setWorkDir(host, dir string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if dir == "" {
+		delete(cm.cwd, host)
+		return
+	}
+	cm.cwd[host] = dir
+}
+
+// changeDir resolves dir on host relative to its current working directory and remembers the result.
+// An empty dir returns to the login directory.
+func (cm *SSHConnectionManager) changeDir(host, dir string) (string, error) {
+	if dir == "" {
+		cm.setWorkDir(host, "")
+		return "~", nil
+	}
+
+	command := cdPrefix(cm.workDir(host)) + "cd " + quoteRemotePath(dir) + " && pwd"
+	cmd := cm.sessionCommand(context.Background(), host, command)
+	stdout, stderr, err := runCmdWithSeparateOutput(cmd)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+
+	resolved := strings.TrimSpace(stdout)
+	cm.setWorkDir(host, resolved)
+	return resolved, nil
+}
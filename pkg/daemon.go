@@ -0,0 +1,313 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultDaemonSocket returns the Unix socket the daemon listens on by default, next to the control sockets
+func DefaultDaemonSocket() string {
+	return filepath.Join(socketDirectory(), "daemon.sock")
+}
+
+// Daemon keeps persistent connections to sets of hosts open and runs commands on them for clients of a
+// local HTTP API, so automation doesn't pay for a new process and ssh handshakes on every command.
+type Daemon struct {
+	Groups map[string][]string // Host groups usable as @group selectors
+	User   string              // ssh login user, empty for the ssh default
+	Warm   []string            // Host selectors connected at startup, whose connections one-shot gosh runs reuse
+
+	mu       sync.Mutex
+	sessions map[string]*daemonSession
+	nextID   int
+}
+
+// daemonSession is a set of hosts with established connections
+type daemonSession struct {
+	ID       string    `json:"id"`
+	Hosts    []string  `json:"hosts"`
+	Created  time.Time `json:"created"`
+	Commands int       `json:"commands"`
+
+	cm     *SSHConnectionManager
+	cancel context.CancelFunc // Stops the health monitor
+}
+
+// daemonEvent is one line of the NDJSON stream returned by a run
+type daemonEvent struct {
+	Type    string       `json:"type"` // "line" or "done"
+	Host    string       `json:"host,omitempty"`
+	Stream  Stream       `json:"stream,omitempty"`
+	Line    string       `json:"line,omitempty"`
+	Results []hostResult `json:"results,omitempty"`
+}
+
+// Handler returns the HTTP routes of the daemon API
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", d.handleList)
+	mux.HandleFunc("POST /sessions", d.handleCreate)
+	mux.HandleFunc("DELETE /sessions/{id}", d.handleClose)
+	mux.HandleFunc("POST /sessions/{id}/run", d.handleRun)
+	mux.Handle("GET /metrics", MetricsHandler())
+	return mux
+}
+
+// handleList lists the open sessions
+func (d *Daemon) handleList(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	sessions := make([]daemonSession, 0, len(d.sessions))
+	for _, session := range d.sessions {
+		sessions = append(sessions, *session)
+	}
+	d.mu.Unlock()
+
+	slices.SortFunc(sessions, func(a, b daemonSession) int { return a.Created.Compare(b.Created) })
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// handleCreate connects to the hosts of {"hosts": [...]} and opens a session with the reachable ones
+func (d *Daemon) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, failed, err := d.open(r.Context(), req.Hosts, true)
+	switch {
+	case errors.Is(err, errNoReachableHosts):
+		writeJSON(w, http.StatusBadGateway, map[string]any{"error": err.Error(), "failed": failed})
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeJSON(w, http.StatusCreated, map[string]any{"session": info, "failed": failed})
+	}
+}
+
+// errNoReachableHosts is returned when a session can't connect to any of its hosts
+var errNoReachableHosts = errors.New("no hosts are reachable")
+
+// open connects to the hosts of selectors and opens a session with the reachable ones. failed maps the
+// other hosts to their connection error. Private sessions keep their control sockets in a directory of their
+// own, so closing one doesn't end masters another session uses; the others share theirs with one-shot runs.
+func (d *Daemon) open(ctx context.Context, selectors []string, private bool) (info daemonSession, failed map[string]string, err error) {
+	hosts, err := ResolveHosts(selectors, d.Groups)
+	if err == nil && len(hosts) == 0 {
+		err = errors.New("host selectors matched no hosts")
+	}
+	if err != nil {
+		return info, nil, err
+	}
+
+	cm := NewSSHConnectionManager(d.User)
+	if private {
+		if cm.socketDir, err = os.MkdirTemp(cm.socketDir, "s"); err != nil {
+			return info, nil, err
+		}
+	}
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Go(func() { errs[i] = cm.establishConnection(ctx, host) })
+	}
+	wg.Wait()
+
+	failed = make(map[string]string)
+	connected := make([]string, 0, len(hosts))
+	for i, host := range hosts {
+		if errs[i] != nil {
+			failed[host] = errs[i].Error()
+			continue
+		}
+		connected = append(connected, host)
+	}
+	if len(connected) == 0 {
+		cm.closeAllConnections() // Removes the socket directory of a private session
+		return info, failed, errNoReachableHosts
+	}
+
+	// Sessions outlive the request; the monitor reconnects hosts whose control master went away
+	monitorCtx, cancel := context.WithCancel(context.Background())
+	cm.startHealthMonitor(monitorCtx, healthCheckInterval, io.Discard)
+
+	d.mu.Lock()
+	if d.sessions == nil {
+		d.sessions = make(map[string]*daemonSession)
+	}
+	d.nextID++
+	session := &daemonSession{ID: strconv.Itoa(d.nextID), Hosts: connected, Created: time.Now(), cm: cm, cancel: cancel}
+	d.sessions[session.ID] = session
+	info = *session
+	d.mu.Unlock()
+
+	return info, failed, nil
+}
+
+// handleClose closes a session and its connections
+func (d *Daemon) handleClose(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	session := d.sessions[r.PathValue("id")]
+	delete(d.sessions, r.PathValue("id"))
+	d.mu.Unlock()
+
+	if session == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	session.close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRun runs {"command": "..."} on all hosts of a session and streams NDJSON events: a "line" event
+// per output line and a final "done" event with every host's result. Closing the request interrupts the command.
+func (d *Daemon) handleRun(w http.ResponseWriter, r *http.Request) {
+	var req runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || req.Command == "" {
+		http.Error(w, "invalid request: expected {\"command\": \"...\"}", http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	session := d.sessions[r.PathValue("id")]
+	if session != nil {
+		session.Commands++
+	}
+	d.mu.Unlock()
+	if session == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	sink := &collectingSink{results: make(map[string]*Result), onLine: func(host string, stream Stream, line string) {
+		_ = encoder.Encode(daemonEvent{Type: "line", Host: host, Stream: stream, Line: line})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}}
+
+	executeCommandStreaming(withSink(r.Context(), sink), session.cm, session.Hosts, nil, req.Command, nil, true)
+
+	results := make([]hostResult, len(session.Hosts))
+	for i, host := range session.Hosts {
+		results[i] = newHostResult(sink.result(host))
+	}
+	_ = encoder.Encode(daemonEvent{Type: "done", Results: results})
+}
+
+// close stops the session's health monitor and its connections
+func (s *daemonSession) close() {
+	s.cancel()
+	s.cm.closeAllConnections()
+}
+
+// Close closes all sessions
+func (d *Daemon) Close() {
+	d.mu.Lock()
+	sessions := d.sessions
+	d.sessions = nil
+	d.mu.Unlock()
+
+	for _, session := range sessions {
+		session.close()
+	}
+}
+
+// collectingSink collects the output of every host and passes each line on as it arrives
+type collectingSink struct {
+	mu      sync.Mutex
+	results map[string]*Result
+	onLine  func(host string, stream Stream, line string)
+}
+
+// WriteLine appends the line to the host's output and forwards it
+func (c *collectingSink) WriteLine(host string, stream Stream, line []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := c.resultLocked(host)
+	if stream == StreamStderr {
+		result.Stderr = append(append(result.Stderr, line...), '\n')
+	} else {
+		result.Stdout = append(append(result.Stdout, line...), '\n')
+	}
+	c.onLine(host, stream, string(line))
+}
+
+// Finish records the host's exit status next to its collected output
+func (c *collectingSink) Finish(finished Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := c.resultLocked(finished.Host)
+	result.ExitCode, result.Duration, result.Err = finished.ExitCode, finished.Duration, finished.Err
+}
+
+// result returns what was collected for host
+func (c *collectingSink) result(host string) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.resultLocked(host)
+}
+
+// resultLocked returns the result of host, creating it; c.mu must be held
+func (c *collectingSink) resultLocked(host string) *Result {
+	result := c.results[host]
+	if result == nil {
+		result = &Result{Host: host}
+		c.results[host] = result
+	}
+	return result
+}
+
+// ServeDaemon serves the daemon API on a Unix socket at path, only accessible to the current user,
+// until ctx ends. All sessions are closed on return.
+func ServeDaemon(ctx context.Context, path string, d *Daemon) error {
+	defer d.Close()
+
+	// A socket left behind by a crashed daemon would make Listen fail
+	if conn, err := (&net.Dialer{}).DialContext(ctx, "unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	_ = os.Remove(path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "unix", path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	if len(d.Warm) > 0 {
+		info, failed, err := d.open(ctx, d.Warm, false)
+		for host, msg := range failed {
+			Log.Warn("Failed to warm connection", "host", host, "err", msg)
+		}
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		Log.Info("Keeping connections warm", "hosts", len(info.Hosts), "session", info.ID)
+	}
+
+	return serveOn(ctx, listener, d.Handler())
+}
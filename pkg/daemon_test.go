@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemon(t *testing.T) {
+	useFakeSSH(t)
+	SocketDir = t.TempDir()
+	defer func() { SocketDir = "" }()
+
+	path := filepath.Join(t.TempDir(), "d.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ServeDaemon(ctx, path, &Daemon{Groups: map[string][]string{"web": {"web1", "web2"}}}) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("daemon failed: %v", err)
+		}
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	request := func(method, url, body string) *http.Response {
+		t.Helper()
+		for range 100 { // Wait for the daemon to listen
+			req, _ := http.NewRequestWithContext(context.Background(), method, "http://gosh"+url, strings.NewReader(body))
+			resp, err := client.Do(req)
+			if err == nil {
+				return resp
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("daemon did not answer %s %s", method, url)
+		return nil
+	}
+
+	resp := request(http.MethodPost, "/sessions", `{"hosts": ["@web"]}`)
+	var created struct {
+		Session daemonSession `json:"session"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || len(created.Session.Hosts) != 2 {
+		t.Fatalf("expected a session with both hosts, got %d %+v", resp.StatusCode, created)
+	}
+
+	resp = request(http.MethodPost, "/sessions/"+created.Session.ID+"/run", `{"command": "echo hi {host}; test {host} = web1"}`)
+	var events []daemonEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var event daemonEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	resp.Body.Close()
+
+	if len(events) != 3 || events[0].Type != "line" || !strings.HasPrefix(events[0].Line, "hi web") {
+		t.Fatalf("expected two lines and a done event, got %+v", events)
+	}
+	results := events[2].Results
+	if events[2].Type != "done" || len(results) != 2 || results[0].Stdout != "hi web1\n" || results[0].ExitCode != 0 || results[1].ExitCode != 1 {
+		t.Errorf("unexpected results: %+v", events[2])
+	}
+
+	resp = request(http.MethodGet, "/sessions", "")
+	var sessions []daemonSession
+	_ = json.NewDecoder(resp.Body).Decode(&sessions)
+	resp.Body.Close()
+	if len(sessions) != 1 || sessions[0].Commands != 1 {
+		t.Errorf("expected one session with one command, got %+v", sessions)
+	}
+
+	resp = request(http.MethodDelete, "/sessions/"+created.Session.ID, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+	resp = request(http.MethodPost, "/sessions/"+created.Session.ID+"/run", `{"command": "true"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a closed session, got %d", resp.StatusCode)
+	}
+}
+
+func TestDaemonWarm(t *testing.T) {
+	useFakeSSH(t)
+	SocketDir = t.TempDir()
+	defer func() { SocketDir = "" }()
+
+	d := &Daemon{Groups: map[string][]string{"web": {"web1", "web2"}}, Warm: []string{"@web"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ServeDaemon(ctx, filepath.Join(t.TempDir(), "d.sock"), d) }()
+
+	var sessions int
+	for range 100 { // Wait for the warm session
+		d.mu.Lock()
+		sessions = len(d.sessions)
+		d.mu.Unlock()
+		if sessions > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("daemon failed: %v", err)
+	}
+	if sessions != 1 {
+		t.Errorf("expected a session for the warm hosts, got %d", sessions)
+	}
+}
+
+func TestDaemonSessionSockets(t *testing.T) {
+	useFakeSSH(t)
+	SocketDir = t.TempDir()
+	defer func() { SocketDir = "" }()
+
+	// Sessions with the same host don't share a master, so closing one leaves the other's alone
+	d := &Daemon{}
+	defer d.Close()
+	first, _, err := d.open(context.Background(), []string{"web1"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := d.open(context.Background(), []string{"web1"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstDir, secondDir := d.sessions[first.ID].cm.socketDir, d.sessions[second.ID].cm.socketDir
+	if firstDir == secondDir || filepath.Dir(firstDir) != SocketDir || filepath.Dir(secondDir) != SocketDir {
+		t.Fatalf("expected a socket directory per session below %s, got %s and %s", SocketDir, firstDir, secondDir)
+	}
+
+	d.sessions[first.ID].close()
+	if _, err := os.Stat(firstDir); !os.IsNotExist(err) {
+		t.Errorf("expected the closed session's socket directory to be removed: %v", err)
+	}
+	if _, err := os.Stat(secondDir); err != nil {
+		t.Errorf("expected the other session's socket directory to stay: %v", err)
+	}
+}
+
+func TestSharedSocket(t *testing.T) {
+	SocketDir = t.TempDir()
+	defer func() { SocketDir = "" }()
+
+	// A control master of another process listens on its socket
+	path := filepath.Join(SocketDir, "gosh-deploy@web1")
+	listener, err := (&net.ListenConfig{}).Listen(context.Background(), "unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if args := strings.Join(buildSSHArgs("web1", "true", "deploy"), " "); !strings.Contains(args, "-o ControlPath="+path+" -o ControlMaster=no") {
+		t.Errorf("expected the shared control socket to be used, got %q", args)
+	}
+	for _, user := range []string{"", "root"} {
+		if args := strings.Join(buildSSHArgs("web1", "true", user), " "); strings.Contains(args, "ControlPath") {
+			t.Errorf("expected no shared socket for user %q, got %q", user, args)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DiscoverySettings holds the service catalog endpoints used by @consul: and @etcd: selectors
+type DiscoverySettings struct {
+	Consul      string // Consul HTTP address, e.g. http://127.0.0.1:8500
+	ConsulToken string // Consul ACL token, empty for none
+	Etcd        string // etcd v3 HTTP gateway address, e.g. http://127.0.0.1:2379
+}
+
+// Discovery holds the catalog endpoints, set from the discovery file at startup
+var Discovery = DefaultDiscovery()
+
+// discoveryTimeout bounds a single catalog lookup
+const discoveryTimeout = 10 * time.Second
+
+// DefaultDiscoveryFile returns the default location of the discovery settings file
+func DefaultDiscoveryFile() string {
+	return filepath.Join(homeDir(), ".gosh", "discovery")
+}
+
+// DefaultDiscovery returns the endpoints used without a discovery file: the local agents, or the
+// addresses in $CONSUL_HTTP_ADDR, $CONSUL_HTTP_TOKEN and $ETCD_ENDPOINT as the official clients do
+func DefaultDiscovery() DiscoverySettings {
+	settings := DiscoverySettings{
+		Consul:      "http://127.0.0.1:8500",
+		ConsulToken: os.Getenv("CONSUL_HTTP_TOKEN"),
+		Etcd:        "http://127.0.0.1:2379",
+	}
+	if addr := os.Getenv("CONSUL_HTTP_ADDR"); addr != "" {
+		settings.Consul = addr
+	}
+	if addr := os.Getenv("ETCD_ENDPOINT"); addr != "" {
+		settings.Etcd = addr
+	}
+	return settings
+}
+
+// LoadDiscovery reads the discovery settings file. Each line has the form "key: value", supported keys
+// are "consul", "consul-token" and "etcd". Missing keys, or a missing file, keep the defaults.
+func LoadDiscovery(path string) (DiscoverySettings, error) {
+	settings := DefaultDiscovery()
+
+	file, err := os.Open(path) // #nosec G304 -- discovery file path is chosen by the local user
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("failed to open discovery file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "consul":
+			settings.Consul = value
+		case "consul-token":
+			settings.ConsulToken = value
+		case "etcd":
+			settings.Etcd = value
+		default:
+			return settings, fmt.Errorf("discovery file %s: unknown setting %q", path, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return settings, fmt.Errorf("failed to read discovery file %s: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+// discoverHosts resolves a catalog selector without the leading "@", e.g. "consul:web", "etcd:/services/web/"
+// or "k8s/namespace/app=web". found is false if the selector doesn't name a catalog.
+func discoverHosts(selector string) (hosts []string, found bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	if spec, ok := strings.CutPrefix(selector, "k8s/"); ok {
+		namespace, labels, _ := strings.Cut(spec, "/")
+		hosts, err = KubernetesPods(ctx, namespace, labels)
+	} else {
+		catalog, name, ok := strings.Cut(selector, ":")
+		switch {
+		case !ok || (catalog != "consul" && catalog != "etcd"):
+			return nil, false, nil
+		case name == "":
+			return nil, true, fmt.Errorf("@%s: needs a service name", catalog)
+		case catalog == "consul":
+			hosts, err = consulServiceHosts(ctx, Discovery, name)
+		default:
+			hosts, err = etcdPrefixHosts(ctx, Discovery, name)
+		}
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("@%s: %w", selector, err)
+	}
+	for _, host := range hosts {
+		if err := checkDiscoveredName(host); err != nil {
+			return nil, true, fmt.Errorf("@%s: %w", selector, err)
+		}
+	}
+	return hosts, true, nil
+}
+
+// checkDiscoveredName refuses a host name from a catalog or DNS record that ssh, scp or docker would take
+// for an option, like "-oProxyCommand=...", or that isn't a single word
+func checkDiscoveredName(name string) error {
+	if strings.HasPrefix(name, "-") || strings.IndexFunc(name, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return fmt.Errorf("refusing discovered host name %q", name)
+	}
+	return nil
+}
+
+// consulServiceHosts returns the address of every instance of service that passes its health checks
+func consulServiceHosts(ctx context.Context, settings DiscoverySettings, service string) ([]string, error) {
+	endpoint := strings.TrimSuffix(settings.Consul, "/") + "/v1/health/service/" + url.PathEscape(service) + "?passing=true"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ConsulToken != "" {
+		req.Header.Set("X-Consul-Token", settings.ConsulToken)
+	}
+
+	var entries []struct {
+		Node struct {
+			Node    string
+			Address string
+		}
+		Service struct {
+			Address string
+		}
+	}
+	if err := getCatalogJSON(req, &entries); err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// Services registered without an address use the address of their node
+		switch {
+		case entry.Service.Address != "":
+			hosts = append(hosts, entry.Service.Address)
+		case entry.Node.Address != "":
+			hosts = append(hosts, entry.Node.Address)
+		default:
+			hosts = append(hosts, entry.Node.Node)
+		}
+	}
+	return hosts, nil
+}
+
+// etcdPrefixHosts returns the values of all keys below prefix. Values are plain host names or addresses, or
+// endpoint records like {"Addr": "10.0.0.5:8080"} as written by etcd's naming package, whose port is dropped.
+// Instances register with a lease, so keys of dead instances expire and only live ones are returned.
+func etcdPrefixHosts(ctx context.Context, settings DiscoverySettings, prefix string) ([]string, error) {
+	// The range end of a prefix is the prefix with its last byte incremented
+	end := []byte(prefix)
+	end[len(end)-1]++
+	body, err := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := strings.TrimSuffix(settings.Etcd, "/") + "/v3/kv/range"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var response struct {
+		Kvs []struct {
+			Value []byte `json:"value"` // base64 in JSON, decoded by encoding/json
+		} `json:"kvs"`
+	}
+	if err := getCatalogJSON(req, &response); err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(response.Kvs))
+	for _, kv := range response.Kvs {
+		value := strings.TrimSpace(string(kv.Value))
+		var record struct{ Addr string }
+		if json.Unmarshal(kv.Value, &record) == nil && record.Addr != "" {
+			value = record.Addr
+			if host, _, err := net.SplitHostPort(value); err == nil {
+				value = host
+			}
+		}
+		if value != "" {
+			hosts = append(hosts, value)
+		}
+	}
+	return hosts, nil
+}
+
+// getCatalogJSON sends req and decodes the JSON response into v
+func getCatalogJSON(req *http.Request, v any) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unexpected response from %s: %w", req.URL.Host, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadDiscovery(t *testing.T) {
+	t.Setenv("CONSUL_HTTP_ADDR", "http://agent:8500")
+	path := filepath.Join(t.TempDir(), "discovery")
+	if err := os.WriteFile(path, []byte("# catalogs\netcd: http://etcd:2379\nconsul-token: secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := LoadDiscovery(path)
+	if err != nil {
+		t.Fatalf("LoadDiscovery failed: %v", err)
+	}
+	expected := DiscoverySettings{Consul: "http://agent:8500", ConsulToken: "secret", Etcd: "http://etcd:2379"}
+	if settings != expected {
+		t.Errorf("expected %+v, got %+v", expected, settings)
+	}
+
+	if err := os.WriteFile(path, []byte("zookeeper: zk:2181\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDiscovery(path); err == nil {
+		t.Error("expected an error for an unknown setting")
+	}
+}
+
+func TestDiscoverySelectors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/health/service/api":
+			if r.URL.Query().Get("passing") != "true" || r.Header.Get("X-Consul-Token") != "secret" {
+				http.Error(w, "expected passing instances and the token", http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`[
+				{"Node": {"Node": "n1", "Address": "10.0.0.1"}, "Service": {"Address": ""}},
+				{"Node": {"Node": "n2", "Address": "10.0.0.2"}, "Service": {"Address": "10.1.0.2"}}
+			]`))
+		case "/v1/health/service/missing":
+			_, _ = w.Write([]byte(`[]`))
+		case "/v1/health/service/evil":
+			_, _ = w.Write([]byte(`[{"Node": {"Node": "-oProxyCommand=touch /tmp/pwned"}}]`))
+		case "/v3/kv/range":
+			var req struct {
+				Key      []byte `json:"key"`
+				RangeEnd []byte `json:"range_end"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if string(req.Key) != "/web/" || string(req.RangeEnd) != "/web0" {
+				http.Error(w, "unexpected range", http.StatusBadRequest)
+				return
+			}
+			value := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+			_ = json.NewEncoder(w).Encode(map[string]any{"kvs": []map[string]string{
+				{"value": value("web1")},
+				{"value": value(`{"Op": 0, "Addr": "10.0.0.9:8080"}`)},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	old := Discovery
+	Discovery = DiscoverySettings{Consul: server.URL, ConsulToken: "secret", Etcd: server.URL + "/"}
+	defer func() { Discovery = old }()
+
+	groups := map[string][]string{"backends": {"@consul:api", "db1"}}
+	hosts, err := ResolveHosts([]string{"@backends", "-10.1.0.2", "@etcd:/web/"}, groups)
+	if err != nil {
+		t.Fatalf("ResolveHosts failed: %v", err)
+	}
+	if expected := []string{"10.0.0.1", "db1", "web1", "10.0.0.9"}; !slices.Equal(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+
+	if hosts, err := ResolveHosts([]string{"@consul:missing"}, nil); err != nil || len(hosts) != 0 {
+		t.Errorf("expected no hosts for a service without instances, got %v, %v", hosts, err)
+	}
+	if _, err := ResolveHosts([]string{"@consul:evil"}, nil); err == nil || !strings.Contains(err.Error(), "refusing discovered host name") {
+		t.Errorf("expected a name starting with - to be refused, got %v", err)
+	}
+	if _, err := ResolveHosts([]string{"@consul:"}, nil); err == nil {
+		t.Error("expected an error without a service name")
+	}
+	Discovery.Consul = server.URL + "/down"
+	if _, err := ResolveHosts([]string{"@consul:api"}, nil); err == nil {
+		t.Error("expected an error when the catalog fails")
+	}
+}
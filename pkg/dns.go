@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// ExpandDNS replaces every host name that resolves to several addresses by one host per address, labeled
+// "name[address]" so output stays attributable. Names starting with "_" are looked up as SRV records
+// (e.g. _ssh._tcp.example.com) and expand to their targets. Addresses, names with a single address and
+// names that don't resolve are kept as they are.
+func ExpandDNS(ctx context.Context, hosts []string) []string {
+	expanded := make([][]string, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Go(func() { expanded[i] = expandHostDNS(ctx, host) })
+	}
+	wg.Wait()
+
+	var result []string
+	seen := make(map[string]bool)
+	for _, labels := range expanded {
+		for _, label := range labels {
+			if !seen[label] {
+				seen[label] = true
+				result = append(result, label)
+			}
+		}
+	}
+	return result
+}
+
+// expandHostDNS returns the labels a single host expands to
+func expandHostDNS(ctx context.Context, host string) []string {
+	if net.ParseIP(host) != nil || strings.HasSuffix(host, "]") {
+		return []string{host}
+	}
+
+	var addresses []string
+	if strings.HasPrefix(host, "_") {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", host)
+		if err != nil {
+			return []string{host}
+		}
+		for _, record := range records {
+			if target := strings.TrimSuffix(record.Target, "."); checkDiscoveredName(target) == nil {
+				addresses = append(addresses, target)
+			}
+		}
+	} else {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil || len(ips) < 2 {
+			return []string{host}
+		}
+		for _, ip := range ips {
+			addresses = append(addresses, ip.IP.String())
+		}
+	}
+
+	labels := make([]string, len(addresses))
+	for i, address := range addresses {
+		labels[i] = host + "[" + address + "]"
+	}
+	return labels
+}
+
+// sshDestination returns the address ssh connects to for a host: the address of a "name[address]"
+// label from ExpandDNS, or the host itself
+func sshDestination(host string) string {
+	if name, address, found := strings.Cut(host, "["); found && name != "" && strings.HasSuffix(address, "]") {
+		return strings.TrimSuffix(address, "]")
+	}
+	return host
+}
+
+// ValidateHosts drops repeated hosts, comparing names case-insensitively as DNS does, and looks up the
+// names ssh would connect to in parallel. Hosts whose name doesn't exist are returned as unresolved, so typos
+// are reported up front instead of as connection failures. Hosts behind a ProxyJump or ProxyCommand and
+// lookups failing for other reasons, e.g. a timeout, are left for ssh to judge.
+func ValidateHosts(ctx context.Context, hosts []string) (valid, unresolved []string) {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, host := range hosts {
+		key := strings.ToLower(strings.TrimSuffix(host, "."))
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, host)
+		}
+	}
+
+	resolved := make([]bool, len(unique))
+	var wg sync.WaitGroup
+	for i, host := range unique {
+		wg.Go(func() { resolved[i] = resolvable(ctx, host) })
+	}
+	wg.Wait()
+
+	for i, host := range unique {
+		if resolved[i] {
+			valid = append(valid, host)
+		} else {
+			unresolved = append(unresolved, host)
+		}
+	}
+	return valid, unresolved
+}
+
+// resolvable reports whether the name ssh connects to for host exists
+func resolvable(ctx context.Context, host string) bool {
+	if backendOf(host) != BackendSSH {
+		return true // Container and pod names aren't DNS names
+	}
+	if net.ParseIP(sshDestination(host)) != nil {
+		return true
+	}
+	name, _, proxied := sshTarget(ctx, host)
+	if proxied || net.ParseIP(name) != nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ConnectTimeout)
+	defer cancel()
+	_, err := net.DefaultResolver.LookupHost(ctx, name)
+	var dnsErr *net.DNSError
+	return !errors.As(err, &dnsErr) || !dnsErr.IsNotFound
+}
+
+// sshTarget returns the host name and port ssh connects to for host, following ~/.ssh/config, and whether
+// the connection goes through a ProxyJump or ProxyCommand. Without a usable config it returns the host and 22.
+func sshTarget(ctx context.Context, host string) (name, port string, proxied bool) {
+	config, err := sshConfig(ctx, host)
+	if err != nil {
+		return sshDestination(host), "22", false
+	}
+	proxied = config["proxyjump"] != "none" || config["proxycommand"] != "none"
+	return config["hostname"], config["port"], proxied
+}
+
+// sshConfig returns the options ssh -G resolves for host from ~/.ssh/config and the extra options, keyed by
+// their lower case names like "hostname" and "port". Options given several times keep their first value.
+func sshConfig(ctx context.Context, host string) (map[string]string, error) {
+	acquireFDs()
+	output, err := exec.CommandContext(ctx, "ssh", append(append([]string{"-G"}, extraSSHOptions()...), "--", sshDestination(host))...).Output()
+	releaseFDs()
+	if err != nil {
+		return nil, err
+	}
+	config := map[string]string{"hostname": sshDestination(host), "port": "22", "proxyjump": "none", "proxycommand": "none"}
+	seen := make(map[string]bool)
+	for line := range strings.Lines(string(output)) {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if !seen[key] {
+			config[key] = value
+			seen[key] = true
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSSHDestination(t *testing.T) {
+	tests := map[string]string{
+		"web1":                         "web1",
+		"api.example.com[10.0.0.5]":    "10.0.0.5",
+		"api.example.com[2001:db8::1]": "2001:db8::1",
+		"_ssh._tcp.example.com[node1.example.com]": "node1.example.com",
+		"[::1]": "[::1]",
+	}
+	for host, expected := range tests {
+		if destination := sshDestination(host); destination != expected {
+			t.Errorf("sshDestination(%q) = %q, expected %q", host, destination, expected)
+		}
+	}
+}
+
+func TestExpandDNS(t *testing.T) {
+	// Addresses, labels and names that don't resolve are kept; duplicates are dropped
+	hosts := ExpandDNS(context.Background(), []string{"10.0.0.1", "web.invalid", "api[10.0.0.2]", "10.0.0.1"})
+	if expected := []string{"10.0.0.1", "web.invalid", "api[10.0.0.2]"}; !slices.Equal(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+}
+
+func TestValidateHosts(t *testing.T) {
+	valid, unresolved := ValidateHosts(context.Background(), []string{"localhost", "LOCALHOST", "nope.invalid", "10.0.0.1", "api[10.0.0.2]", "localhost."})
+	if expected := []string{"localhost", "10.0.0.1", "api[10.0.0.2]"}; !slices.Equal(valid, expected) {
+		t.Errorf("expected %v, got %v", expected, valid)
+	}
+	if !slices.Equal(unresolved, []string{"nope.invalid"}) {
+		t.Errorf("expected nope.invalid to be unresolved, got %v", unresolved)
+	}
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Download copies remotePath from all hosts in parallel to <localDir>/<host>/<name> and returns the failed
+// hosts as joined *HostError values
+func Download(ctx context.Context, hosts []string, remotePath, localDir, user string, noColor bool) error {
+	return downloadFile(ctx, hosts, remotePath, localDir, user, noColor)
+}
+
+// downloadFile copies remotePath from all hosts in parallel to <localDir>/<host>/<name>, so the copies of
+// different hosts don't overwrite each other, and returns the failed hosts as joined *HostError values
+func downloadFile(ctx context.Context, hosts []string, remotePath, localDir, user string, noColor bool) error {
+	if localDir == "" {
+		localDir = "."
+	}
+
+	maxHostLen := maxLen(hosts)
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+
+	for i, host := range hosts {
+		wg.Go(func() {
+			errs[i] = runDownload(ctx, host, remotePath, localDir, user, i, maxHostLen, noColor)
+		})
+	}
+
+	wg.Wait()
+	flushOutput()
+	return joinHostErrors(hosts, errs)
+}
+
+// runDownload copies a file from a single host using scp and prints the outcome
+func runDownload(ctx context.Context, host, remotePath, localDir, user string, idx, maxHostLen int, noColor bool) error {
+	prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
+	fail := func(err error, output []byte) error {
+		if output = bytes.TrimSpace(output); len(output) > 0 {
+			printOutput("%s: ❌ DOWNLOAD ERROR: %v\n%s: %s\n", prefix, err, prefix, output)
+		} else {
+			printOutput("%s: ❌ DOWNLOAD ERROR: %v\n", prefix, err)
+		}
+		return err
+	}
+
+	// Pods get a directory per namespace
+	parts := strings.Split(host, "/")
+	for i := range parts {
+		parts[i] = hostFileName(parts[i])
+	}
+	dir := filepath.Join(localDir, filepath.Join(parts...))
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fail(err, nil)
+	}
+	target := filepath.Join(dir, path.Base(strings.TrimSuffix(remotePath, "/")))
+
+	var output []byte
+	var err error
+	acquireFDs()
+	if backendOf(host) == BackendSSH {
+		args := append(scpArgs(user), "--", scpHost(host)+":"+remotePath, target)
+		output, err = exec.CommandContext(ctx, "scp", args...).CombinedOutput()
+		output = splitSSHDebug(prefix, output)
+		err = classifySSHFailure(host, output, err)
+	} else {
+		// Without scp the file is streamed out of the container, or read locally
+		output, err = copyFromHost(ctx, host, remotePath, target, user)
+	}
+	releaseFDs()
+	if err != nil {
+		return fail(err, output)
+	}
+
+	printOutput("%s: ✅ Download successful: %s\n", prefix, target)
+	return nil
+}
+
+// copyFromHost writes remotePath of a host of a non-ssh backend to target and returns the command's stderr
+func copyFromHost(ctx context.Context, host, remotePath, target, user string) ([]byte, error) {
+	file, err := os.Create(target) // #nosec G304 -- download target is chosen by the local user
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var stderr bytes.Buffer
+	cmd := hostCommand(ctx, host, "cat "+quoteRemotePath(remotePath), user, false)
+	cmd.Stdout = file
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		_ = os.Remove(target)
+		return stderr.Bytes(), err
+	}
+	return nil, nil
+}
+
+// transferArgs splits the arguments of :upload and :download into the source and an optional destination
+// directory, reporting false for anything else. A source naming an existing local file is taken as a whole,
+// so uploads keep working for file names with spaces.
+func transferArgs(args string, localSource bool) (source, destination string, ok bool) {
+	if localSource {
+		if _, err := os.Stat(args); err == nil {
+			return args, "", true
+		}
+		if i := strings.LastIndex(args, " "); i > 0 {
+			if _, err := os.Stat(strings.TrimSpace(args[:i])); err == nil {
+				return strings.TrimSpace(args[:i]), args[i+1:], true
+			}
+		}
+	}
+	fields := strings.Fields(args)
+	switch len(fields) {
+	case 1:
+		return fields[0], "", true
+	case 2:
+		return fields[0], fields[1], true
+	default:
+		return "", "", false
+	}
+}
@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTransferArgs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("my notes.txt", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args                string
+		local               bool
+		source, destination string
+		ok                  bool
+	}{
+		{"my notes.txt", true, "my notes.txt", "", true},
+		{"my notes.txt /tmp", true, "my notes.txt", "/tmp", true},
+		{"app.tar /opt/app", true, "app.tar", "/opt/app", true},
+		{"/var/log/syslog", false, "/var/log/syslog", "", true},
+		{"/var/log/syslog logs", false, "/var/log/syslog", "logs", true},
+		{"", false, "", "", false},
+		{"a b c", false, "", "", false},
+	}
+	for _, test := range tests {
+		source, destination, ok := transferArgs(test.args, test.local)
+		if source != test.source || destination != test.destination || ok != test.ok {
+			t.Errorf("transferArgs(%q) = %q, %q, %v", test.args, source, destination, ok)
+		}
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	LocalExec = true
+	defer func() { LocalExec = false }()
+	remote := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(remote, []byte("port=80\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	local := t.TempDir()
+
+	output := captureStdout(t, func() {
+		if err := downloadFile(context.Background(), []string{"localhost"}, remote, local, "", true); err != nil {
+			t.Errorf("downloadFile failed: %v", err)
+		}
+	})
+	content, err := os.ReadFile(filepath.Join(local, "localhost", "app.conf")) // #nosec G304 -- test file
+	if err != nil || string(content) != "port=80\n" {
+		t.Errorf("expected the file under the host's directory, got %q, %v (output %q)", content, err, output)
+	}
+
+	output = captureStdout(t, func() {
+		if err := downloadFile(context.Background(), []string{"localhost"}, remote+".missing", local, "", true); err == nil {
+			t.Error("expected a missing file to fail")
+		}
+	})
+	if !strings.Contains(output, "DOWNLOAD ERROR") {
+		t.Errorf("unexpected output: %q", output)
+	}
+	if _, err := os.Stat(filepath.Join(local, "localhost", "app.conf.missing")); err == nil {
+		t.Error("expected no file to be left behind")
+	}
+}
+
+func TestTransferCompletion(t *testing.T) {
+	// A fake ssh lists remote directories for compgen -d and files for compgen -f
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\ncase $last in\n" +
+		"*compgen\\ -d*) printf '/var/log/\\n/var/lib/\\n' ;;\n" +
+		"*compgen\\ -f*) printf '/var/log/\\n/var/lib/\\n/var/local.txt\\n' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("backups", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("app.tar", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := &SSHConnectionManager{connections: map[string]*SSHConnection{"web1": {host: "web1", shell: shellBash}}}
+	hosts := []string{"web1"}
+	tests := []struct {
+		line, word string
+		expected   []string
+	}{
+		{":download /var/l", "/var/l", []string{"og/", "ib/", "ocal.txt"}},
+		{":download /var/log/syslog ba", "ba", []string{"ckups/"}},
+		{":download /var/log/syslog ap", "ap", nil},
+		{":upload app.tar /var/l", "/var/l", []string{"og/", "ib/"}},
+		{":upload ap", "ap", []string{"p.tar"}},
+	}
+	for _, test := range tests {
+		if result := completerWithWord(test.line, test.word, hosts, cm); !slices.Equal(result, test.expected) {
+			t.Errorf("completerWithWord(%q) = %q, expected %q", test.line, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShowDuration prints each host's command wall time after its output and the slowest hosts after a command
+var ShowDuration bool
+
+// slowestHostCount is the number of hosts listed in the duration summary
+const slowestHostCount = 5
+
+// hostDurations collects the command wall time per host of one command run
+type hostDurations struct {
+	mu     sync.Mutex
+	byHost map[string]time.Duration
+}
+
+// durationsKey is the context key carrying the durations of a command run
+type durationsKey struct{}
+
+// withDurations records the wall time of commands started with ctx in the returned hostDurations
+func withDurations(ctx context.Context) (context.Context, *hostDurations) {
+	durations := &hostDurations{byHost: make(map[string]time.Duration)}
+	return context.WithValue(ctx, durationsKey{}, durations), durations
+}
+
+// recordDuration stores how long the command on host took if ctx collects durations
+func recordDuration(ctx context.Context, host string, duration time.Duration) {
+	durations, ok := ctx.Value(durationsKey{}).(*hostDurations)
+	if !ok {
+		return
+	}
+	durations.mu.Lock()
+	durations.byHost[host] = duration
+	durations.mu.Unlock()
+}
+
+// slowest returns up to n hosts with the longest wall time, slowest first
+func (d *hostDurations) slowest(n int) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hosts := make([]string, 0, len(d.byHost))
+	for host := range d.byHost {
+		hosts = append(hosts, host)
+	}
+	slices.SortFunc(hosts, func(a, b string) int {
+		return cmp.Or(cmp.Compare(d.byHost[b], d.byHost[a]), strings.Compare(a, b))
+	})
+	return hosts[:min(n, len(hosts))]
+}
+
+// printSlowest writes the slowest hosts, e.g. "🐢 Slowest: web7 12.31s · web3 4.02s", once the command completed
+// on more than one host
+func (d *hostDurations) printSlowest(w io.Writer) {
+	hosts := d.slowest(slowestHostCount)
+	if len(hosts) < 2 {
+		return
+	}
+	parts := make([]string, len(hosts))
+	for i, host := range hosts {
+		parts[i] = host + " " + formatSeconds(d.byHost[host])
+	}
+	fmt.Fprintf(w, "🐢 Slowest: %s\n", strings.Join(parts, " · "))
+}
+
+// formatSeconds formats a duration as seconds with two decimals, e.g. "1.42s"
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Env holds KEY=VALUE pairs exported into every remote command, in the order they were set
+var Env []string
+
+// parseEnv splits a KEY=VALUE assignment and validates the variable name
+func parseEnv(assignment string) (key, value string, err error) {
+	key, value, found := strings.Cut(assignment, "=")
+	if !found {
+		return "", "", fmt.Errorf("expected KEY=VALUE, got %q", assignment)
+	}
+	if !isEnvName(key) {
+		return "", "", fmt.Errorf("invalid variable name %q", key)
+	}
+	return key, value, nil
+}
+
+// isEnvName reports whether name is a valid shell variable name
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// SetEnv adds or replaces a variable in Env
+func SetEnv(assignment string) error {
+	key, _, err := parseEnv(assignment)
+	if err != nil {
+		return err
+	}
+	UnsetEnv(key)
+	Env = append(Env, assignment)
+	return nil
+}
+
+// UnsetEnv removes a variable from Env and reports whether it was set
+func UnsetEnv(key string) bool {
+	for i, assignment := range Env {
+		if name, _, _ := strings.Cut(assignment, "="); name == key {
+			Env = append(Env[:i], Env[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// envPrefix returns the exports that make env visible to the whole command line, including compound commands
+func envPrefix(env []string) string {
+	var b strings.Builder
+	for _, assignment := range env {
+		key, value, _ := strings.Cut(assignment, "=")
+		b.WriteString("export " + key + "=" + shellQuote(value) + "; ")
+	}
+	return b.String()
+}
+
+var (
+	// errShellExpansion marks words that need the remote shell to expand them and cannot be tracked locally
+	errShellExpansion = errors.New("contains shell expansions")
+	// errShellOperator marks lines that combine several commands or redirect output
+	errShellOperator = errors.New("contains shell operators")
+)
+
+// splitShellWords splits line into words, resolving single and double quotes. Words that need remote
+// expansion ($, backticks, globs, escapes) yield errShellExpansion, operators yield errShellOperator.
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	quote := rune(0)
+
+	for _, r := range line {
+		switch {
+		case quote == '\'' && r == '\'', quote == '"' && r == '"':
+			quote = 0
+		case quote == '\'':
+			word.WriteRune(r)
+		case quote == '"':
+			if strings.ContainsRune("$`\\", r) {
+				return nil, errShellExpansion
+			}
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case strings.ContainsRune(";&|<>()", r):
+			return nil, errShellOperator
+		case strings.ContainsRune("$`\\*?[]{}~#!", r):
+			return nil, errShellExpansion
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// trackEnvCommand applies a typed `export KEY=VALUE ...` or `unset KEY ...` to Env.
+// It reports false for other commands, including compound ones, which run remotely as usual.
+func trackEnvCommand(line string) (bool, error) {
+	if !strings.HasPrefix(line, "export ") && !strings.HasPrefix(line, "unset ") {
+		return false, nil
+	}
+	words, err := splitShellWords(line)
+	if errors.Is(err, errShellOperator) {
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("%w, use :env set KEY=VALUE with a literal value", err)
+	}
+
+	if words[0] == "unset" {
+		for _, key := range words[1:] {
+			UnsetEnv(key)
+		}
+		return true, nil
+	}
+
+	// Validate everything first so a bad assignment changes nothing
+	for _, assignment := range words[1:] {
+		if _, _, err := parseEnv(assignment); err != nil {
+			return true, err
+		}
+	}
+	for _, assignment := range words[1:] {
+		_ = SetEnv(assignment)
+	}
+	return true, nil
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	defer func() { Env = nil }()
+
+	for _, bad := range []string{"NOVALUE", "=x", "1ABC=x", "A-B=x"} {
+		if err := SetEnv(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+
+	if err := SetEnv("RELEASE=v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetEnv("MSG=it's a=b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetEnv("RELEASE=v2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(Env) != 2 || Env[1] != "RELEASE=v2" {
+		t.Errorf("expected RELEASE to be replaced, got %v", Env)
+	}
+
+	// Variables reach every part of a compound command
+	output, err := exec.CommandContext(context.Background(), "sh", "-c", prepareCommand(`echo "$MSG"; sh -c 'echo $RELEASE'`)).Output()
+	if err != nil || string(output) != "it's a=b\nv2\n" {
+		t.Errorf("unexpected output %q: %v", output, err)
+	}
+
+	if !UnsetEnv("MSG") || UnsetEnv("MSG") || len(Env) != 1 {
+		t.Errorf("unexpected unset result: %v", Env)
+	}
+}
+
+func TestTrackEnvCommand(t *testing.T) {
+	defer func() { Env = nil }()
+
+	tracked, err := trackEnvCommand(`export APP_ENV=staging MSG="hello world" QUOTE='it''s'`)
+	if !tracked || err != nil {
+		t.Fatalf("expected export to be tracked: %v", err)
+	}
+	want := []string{"APP_ENV=staging", "MSG=hello world", "QUOTE=its"}
+	if strings.Join(Env, "|") != strings.Join(want, "|") {
+		t.Errorf("unexpected env: %q", Env)
+	}
+
+	if tracked, _ := trackEnvCommand("unset MSG QUOTE"); !tracked || len(Env) != 1 {
+		t.Errorf("expected unset to be tracked, got %q", Env)
+	}
+
+	// Expansions can only be evaluated remotely
+	if tracked, err := trackEnvCommand("export PATH=$PATH:/opt/bin"); !tracked || err == nil {
+		t.Error("expected expansion to be rejected")
+	}
+	if tracked, err := trackEnvCommand("export 1BAD=x"); !tracked || err == nil {
+		t.Error("expected invalid name to be rejected")
+	}
+	if len(Env) != 1 {
+		t.Errorf("rejected exports must not change env, got %q", Env)
+	}
+
+	// Compound commands and other commands run as typed
+	for _, line := range []string{"export A=1 && ./run.sh", "exportfs -a", "ls"} {
+		if tracked, _ := trackEnvCommand(line); tracked {
+			t.Errorf("%q should not be tracked", line)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package pkg
+
+import "errors"
+
+// HostError is the failure of a command on one host. The host's output, including the failure,
+// has already been printed with its prefix when a printing function returns it.
+type HostError struct {
+	Host string
+	Err  error // Carries the remote exit status as an *exec.ExitError
+}
+
+// Error returns "host: err"
+func (e *HostError) Error() string {
+	return e.Host + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *HostError) Unwrap() error {
+	return e.Err
+}
+
+// joinHostErrors combines the non-nil errors of errs, indexed like hosts, into one error of *HostError values
+func joinHostErrors(hosts []string, errs []error) error {
+	var joined []error
+	for i, err := range errs {
+		if err != nil {
+			joined = append(joined, &HostError{Host: hosts[i], Err: err})
+		}
+	}
+	return errors.Join(joined...)
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import "time"
+
+// Stream identifies which output stream of a remote command a line came from
+type Stream string
+
+const (
+	// StreamStdout is the remote command's standard output
+	StreamStdout Stream = "stdout"
+	// StreamStderr is the remote command's standard error
+	StreamStderr Stream = "stderr"
+)
+
+// Events lets programs embedding pkg observe connection and command progress to render their own UI.
+// Callbacks are invoked concurrently from per-host goroutines and must be safe for concurrent use.
+type Events struct {
+	// OnHostConnected is called once per host when establishing its persistent connection finished; err is nil on success.
+	// When set, the built-in connection progress bar is not printed.
+	OnHostConnected func(host string, err error)
+	// OnHostLine is called for every line of remote output
+	OnHostLine func(host string, stream Stream, line string)
+	// OnHostDone is called when a command finished on a host; err carries the exit status
+	OnHostDone func(host string, err error, duration time.Duration)
+}
+
+// Hooks holds the registered event callbacks; nil callbacks are skipped
+var Hooks Events
+
+// hostConnected notifies OnHostConnected, if registered
+func (e Events) hostConnected(host string, err error) {
+	if e.OnHostConnected != nil {
+		e.OnHostConnected(host, err)
+	}
+}
+
+// hostLine notifies OnHostLine, if registered
+func (e Events) hostLine(host string, stream Stream, line string) {
+	if e.OnHostLine != nil {
+		e.OnHostLine(host, stream, line)
+	}
+}
+
+// hostDone notifies OnHostDone, if registered
+func (e Events) hostDone(host string, err error, duration time.Duration) {
+	if e.OnHostDone != nil {
+		e.OnHostDone(host, err, duration)
+	}
+}
@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHooksStreamCommand(t *testing.T) {
+	var mu sync.Mutex
+	lines := make(map[Stream][]string)
+	var doneErr error
+	doneCalls := 0
+
+	Hooks = Events{
+		OnHostLine: func(host string, stream Stream, line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if host == "web1" {
+				lines[stream] = append(lines[stream], line)
+			}
+		},
+		OnHostDone: func(_ string, err error, _ time.Duration) {
+			doneErr = err
+			doneCalls++
+		},
+	}
+	defer func() { Hooks = Events{} }()
+
+	captureStdout(t, func() {
+		cmd := exec.CommandContext(context.Background(), "sh", "-c", "echo one; echo two >&2; exit 2")
+		_ = streamCommand(context.Background(), cmd, "web1", "web1", "")
+	})
+
+	if len(lines[StreamStdout]) != 1 || lines[StreamStdout][0] != "one" {
+		t.Errorf("unexpected stdout lines: %v", lines[StreamStdout])
+	}
+	if len(lines[StreamStderr]) != 1 || lines[StreamStderr][0] != "two" {
+		t.Errorf("unexpected stderr lines: %v", lines[StreamStderr])
+	}
+	if doneCalls != 1 || doneErr == nil {
+		t.Errorf("expected one OnHostDone call with an error, got %d calls, err %v", doneCalls, doneErr)
+	}
+}
@@ -0,0 +1,196 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// GatherFacts gathers the facts of all hosts once an interactive session is connected, like :facts
+var GatherFacts bool
+
+// factNames are the facts gathered per host, in the order :facts shows them
+var factNames = []string{"os", "kernel", "arch", "uptime", "memory", "ips"}
+
+// factsCommand prints the facts of a host as name=value lines in one round trip. Linux reports uptime and
+// memory through /proc; elsewhere they stay empty.
+const factsCommand = `echo "os=$( (. /etc/os-release && echo "$PRETTY_NAME") 2>/dev/null || uname -s)"; ` +
+	`echo "kernel=$(uname -r)"; ` +
+	`echo "arch=$(uname -m)"; ` +
+	`echo "uptime=$(cut -d' ' -f1 /proc/uptime 2>/dev/null)"; ` +
+	`echo "memory=$(awk '/^MemTotal:/ {print $2}' /proc/meminfo 2>/dev/null)"; ` +
+	`echo "ips=$(hostname -I 2>/dev/null || ip -o addr show scope global 2>/dev/null | awk '{sub("/.*", "", $4); print $4}')"`
+
+// hostFacts maps fact names to their values on one host
+type hostFacts map[string]string
+
+func init() {
+	paletteSources = append(paletteSources, func() []commandInfo {
+		placeholders := make([]commandInfo, len(factNames))
+		for i, name := range factNames {
+			placeholders[i] = commandInfo{"{facts." + name + "}", "", "Placeholder: the " + name + " fact of the host, see :facts"}
+		}
+		return placeholders
+	})
+}
+
+// parseFacts reads the output of factsCommand, making uptime and memory readable
+func parseFacts(output string) hostFacts {
+	facts := make(hostFacts, len(factNames))
+	for line := range strings.Lines(output) {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || !slices.Contains(factNames, name) {
+			continue
+		}
+		value = strings.Join(strings.Fields(value), " ") // hostname -I ends with a space, ip prints a line per address
+		switch name {
+		case "uptime":
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				value = formatUptime(time.Duration(seconds) * time.Second)
+			}
+		case "memory":
+			if kib, err := strconv.ParseFloat(value, 64); err == nil {
+				value = fmt.Sprintf("%.1f GiB", kib/(1<<20))
+			}
+		}
+		facts[name] = value
+	}
+	return facts
+}
+
+// formatUptime returns an uptime in days, hours and minutes, e.g. "12d 3h 4m"
+func formatUptime(d time.Duration) string {
+	days, hours, minutes := int(d.Hours())/24, int(d.Hours())%24, int(d.Minutes())%60
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+// gatherFacts gathers the facts of hosts in parallel and caches them for the session. With refresh unset,
+// hosts whose facts are cached already are skipped. It returns the hosts that failed.
+func (cm *SSHConnectionManager) gatherFacts(ctx context.Context, hosts []string, refresh bool) map[string]error {
+	if !refresh {
+		hosts = slices.DeleteFunc(slices.Clone(hosts), func(host string) bool { return cm.factsOf(host) != nil })
+	}
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Go(func() {
+			output, err := cm.sessionCommand(ctx, host, factsCommand).Output()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			facts := parseFacts(string(output))
+			cm.mu.Lock()
+			cm.facts[host] = facts
+			cm.mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	failed := make(map[string]error)
+	for i, host := range hosts {
+		if errs[i] != nil {
+			failed[host] = errs[i]
+		}
+	}
+	return failed
+}
+
+// factsOf returns the cached facts of a host, nil if they were not gathered
+func (cm *SSHConnectionManager) factsOf(host string) hostFacts {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.facts[host]
+}
+
+// dropFacts forgets the facts of a host that left the session
+func (cm *SSHConnectionManager) dropFacts(host string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	delete(cm.facts, host)
+}
+
+// factPlaceholder matches {facts.<name>} in commands
+var factPlaceholder = regexp.MustCompile(`\{facts\.([^{}]*)\}`)
+
+// usesFacts reports whether command contains fact placeholders
+func usesFacts(command string) bool {
+	return strings.Contains(command, "{facts.")
+}
+
+// expandFacts replaces {facts.<name>} in command with the cached facts of host, each quoted as a single
+// shell word since hosts report them and they may contain anything. Unknown names and facts the host didn't
+// report are errors, rather than running the command with a blank.
+func (cm *SSHConnectionManager) expandFacts(command, host string) (string, error) {
+	if !usesFacts(command) {
+		return command, nil
+	}
+	facts := cm.factsOf(host)
+	var errs []error
+	expanded := factPlaceholder.ReplaceAllStringFunc(command, func(placeholder string) string {
+		name := factPlaceholder.FindStringSubmatch(placeholder)[1]
+		switch value := facts[name]; {
+		case !slices.Contains(factNames, name):
+			errs = append(errs, fmt.Errorf("unknown fact %s, expected one of %s", placeholder, strings.Join(factNames, ", ")))
+		case facts == nil:
+			errs = append(errs, errors.New("no facts were gathered, see :facts"))
+		case value == "":
+			errs = append(errs, fmt.Errorf("the %s fact is unknown", name))
+		default:
+			return shellQuote(value)
+		}
+		return placeholder
+	})
+	return expanded, errors.Join(errs...)
+}
+
+// printFacts shows the cached facts of hosts as a table, "-" where a fact is unknown
+func (cm *SSHConnectionManager) printFacts(hosts []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\t"+strings.ToUpper(strings.Join(factNames, "\t")))
+	for _, host := range hosts {
+		facts := cm.factsOf(host)
+		if facts == nil {
+			continue
+		}
+		row := []string{host}
+		for _, name := range factNames {
+			row = append(row, cmp.Or(facts[name], "-"))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	_ = w.Flush()
+}
+
+// facts handles ":facts [refresh]": it gathers the facts of the targeted hosts that are not cached yet,
+// or all of them again with refresh, and shows them
+func (s *session) facts(args string) {
+	refresh := strings.TrimSpace(args) == "refresh"
+	if !refresh && strings.TrimSpace(args) != "" {
+		fmt.Println("📋 Usage: :facts [refresh]")
+		return
+	}
+	hosts := s.targetHosts()
+	failed := s.connManager.gatherFacts(s.context(), hosts, refresh)
+	s.connManager.printFacts(hosts)
+
+	maxHostLen := maxLen(s.hosts)
+	for _, host := range hosts {
+		if err, ok := failed[host]; ok {
+			prefix := formatHostPrefix(host, slices.Index(s.hosts, host), maxHostLen, s.noColor)
+			fmt.Printf("%s: ❌ Failed to gather facts: %v\n", prefix, err)
+		}
+	}
+}
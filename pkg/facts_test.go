@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseFacts(t *testing.T) {
+	output := "os=Debian GNU/Linux 12 (bookworm)\nkernel=6.1.0-18-amd64\narch=x86_64\nuptime=273906.52\n" +
+		"memory=16303428\nips=10.0.0.5 fd00::5 \nshell=bash\n"
+	expected := hostFacts{
+		"os":     "Debian GNU/Linux 12 (bookworm)",
+		"kernel": "6.1.0-18-amd64",
+		"arch":   "x86_64",
+		"uptime": "3d 4h 5m",
+		"memory": "15.5 GiB",
+		"ips":    "10.0.0.5 fd00::5",
+	}
+	facts := parseFacts(output)
+	if len(facts) != len(expected) {
+		t.Errorf("expected %d facts, got %q", len(expected), facts)
+	}
+	for name, value := range expected {
+		if facts[name] != value {
+			t.Errorf("expected %s=%q, got %q", name, value, facts[name])
+		}
+	}
+
+	if facts := parseFacts("uptime=\nmemory=\n"); facts["uptime"] != "" || facts["memory"] != "" {
+		t.Errorf("expected missing facts to stay empty, got %q", facts)
+	}
+}
+
+func TestExpandFacts(t *testing.T) {
+	cm := NewSSHConnectionManager("")
+	cm.facts["web1"] = hostFacts{"os": "Ubuntu 24.04", "arch": "aarch64", "uptime": ""}
+	cm.facts["web2"] = hostFacts{"os": "Debian GNU/Linux 12 (bookworm)", "kernel": "6.1'; reboot; '"}
+
+	if command, err := cm.expandFacts("echo {host} {facts.os} on {facts.arch}", "web1"); err != nil || command != "echo {host} 'Ubuntu 24.04' on 'aarch64'" {
+		t.Errorf("expected the facts to be expanded, got %q (%v)", command, err)
+	}
+
+	// Values are single words to the shell, whatever they contain
+	command, err := cm.expandFacts("echo {facts.os} / {facts.kernel}", "web2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.CommandContext(context.Background(), "sh", "-c", command).Output(); err != nil || string(output) != "Debian GNU/Linux 12 (bookworm) / 6.1'; reboot; '\n" {
+		t.Errorf("expected the facts as given, got %q (%v)", output, err)
+	}
+	if command, err := cm.expandFacts("echo ${HOME}", "web9"); err != nil || command != "echo ${HOME}" {
+		t.Errorf("expected commands without facts to stay unchanged, got %q (%v)", command, err)
+	}
+
+	tests := []struct {
+		command, host, expected string
+	}{
+		{"echo {facts.distro}", "web1", "unknown fact {facts.distro}"},
+		{"echo {facts.uptime}", "web1", "the uptime fact is unknown"},
+		{"echo {facts.os}", "web9", "no facts were gathered"},
+	}
+	for _, test := range tests {
+		if _, err := cm.expandFacts(test.command, test.host); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s on %s: expected %q, got %v", test.command, test.host, test.expected, err)
+		}
+	}
+}
+
+func TestSessionFacts(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("uptime and memory are gathered from /proc")
+	}
+	LocalExec = true
+	defer func() { LocalExec = false }()
+
+	cm := NewSSHConnectionManager("")
+	sess := &session{ctx: context.Background(), connManager: cm, hosts: []string{"localhost"}, noColor: true}
+	output := captureStdout(t, func() { sess.facts("") })
+	if !strings.Contains(output, "HOST") || !strings.Contains(output, "localhost") || strings.Contains(output, "Failed") {
+		t.Fatalf("expected a table of the facts of localhost, got %q", output)
+	}
+	facts := cm.factsOf("localhost")
+	if facts["arch"] == "" || facts["kernel"] == "" || !strings.HasSuffix(facts["memory"], " GiB") {
+		t.Errorf("expected arch, kernel and memory to be gathered, got %q", facts)
+	}
+
+	// Commands use the cached facts
+	output = captureStdout(t, func() {
+		executeCommandStreaming(context.Background(), cm, sess.hosts, nil, "echo arch={facts.arch}", nil, true)
+		flushOutput()
+	})
+	if !strings.Contains(output, "arch="+facts["arch"]) {
+		t.Errorf("expected the arch fact in the output, got %q", output)
+	}
+
+	if output := captureStdout(t, func() { sess.facts(" later") }); !strings.Contains(output, "Usage: :facts") {
+		t.Errorf("expected the usage, got %q", output)
+	}
+	captureStdout(t, func() { sess.removeHost("localhost") })
+	if cm.factsOf("localhost") != nil {
+		t.Error("expected the facts of a removed host to be dropped")
+	}
+}
@@ -0,0 +1,47 @@
+package pkg
+
+// fdsPerHost estimates the file descriptors gosh holds per concurrently running ssh/scp process (pipes and /dev/null)
+const fdsPerHost = 6
+
+// fdReserve is kept free for stdin/stdout, the history file, logs and other bookkeeping
+const fdReserve = 64
+
+// fdSemaphore bounds concurrently running ssh/scp processes; nil means unlimited
+var fdSemaphore chan struct{}
+
+// Parallelism caps how many ssh/scp processes run at a time, 0 leaves it to the open file limit
+var Parallelism int
+
+// InitFDBudget raises the open file limit as far as allowed and, if it still cannot accommodate
+// all hosts at once, limits how many ssh processes run concurrently and prints a warning.
+// Parallelism lowers the limit further.
+func InitFDBudget(hostCount int) {
+	fdSemaphore = nil
+	budget := hostCount
+	if limit := raiseFDLimit(); limit != 0 { // 0 is an unknown limit on this platform
+		budget = max(1, (limit-fdReserve)/fdsPerHost)
+		if hostCount > budget && (Parallelism == 0 || Parallelism > budget) {
+			Log.Warn("Hosts exceed the open file limit, limiting how many run at a time", "hosts", hostCount, "limit", limit, "parallel", budget)
+		}
+	}
+	if Parallelism > 0 {
+		budget = min(budget, Parallelism)
+	}
+	if hostCount > budget {
+		fdSemaphore = make(chan struct{}, budget)
+	}
+}
+
+// acquireFDs waits until another ssh process fits into the file descriptor budget
+func acquireFDs() {
+	if fdSemaphore != nil {
+		fdSemaphore <- struct{}{}
+	}
+}
+
+// releaseFDs returns a slot acquired by acquireFDs
+func releaseFDs() {
+	if fdSemaphore != nil {
+		<-fdSemaphore
+	}
+}
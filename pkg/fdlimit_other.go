@@ -0,0 +1,8 @@
+//go:build !unix
+
+package pkg
+
+// raiseFDLimit is a no-op on platforms without RLIMIT_NOFILE
+func raiseFDLimit() int {
+	return 0
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFDSemaphoreBoundsConcurrency(t *testing.T) {
+	fdSemaphore = make(chan struct{}, 3)
+	defer func() { fdSemaphore = nil }()
+
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			acquireFDs()
+			defer releaseFDs()
+
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			running.Add(-1)
+		})
+	}
+	wg.Wait()
+
+	if peak.Load() > 3 {
+		t.Errorf("expected at most 3 concurrent holders, got %d", peak.Load())
+	}
+}
+
+func TestInitFDBudget(t *testing.T) {
+	defer func() { fdSemaphore = nil }()
+
+	InitFDBudget(1)
+	if fdSemaphore != nil {
+		t.Error("a single host must not be throttled")
+	}
+
+	limit := raiseFDLimit()
+	if limit == 0 {
+		t.Skip("open file limit unknown on this platform")
+	}
+	InitFDBudget(limit) // More hosts than descriptors can never fit
+	if fdSemaphore == nil || cap(fdSemaphore) != (limit-fdReserve)/fdsPerHost {
+		t.Errorf("expected throttling to the budget, got %v", fdSemaphore)
+	}
+}
+
+func TestParallelismLimitsProcesses(t *testing.T) {
+	Parallelism = 4
+	defer func() { Parallelism, fdSemaphore = 0, nil }()
+
+	InitFDBudget(4)
+	if fdSemaphore != nil {
+		t.Error("hosts within the parallelism must not be throttled")
+	}
+	InitFDBudget(10)
+	if fdSemaphore == nil || cap(fdSemaphore) != 4 {
+		t.Errorf("expected at most 4 processes at a time, got %v", fdSemaphore)
+	}
+}
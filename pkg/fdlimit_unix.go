@@ -0,0 +1,23 @@
+//go:build unix
+
+package pkg
+
+import "syscall"
+
+// raiseFDLimit raises the soft RLIMIT_NOFILE to the hard limit and returns the resulting soft limit, 0 if unknown
+func raiseFDLimit() int {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0
+	}
+
+	if rlimit.Cur < rlimit.Max {
+		raised := rlimit
+		raised.Cur = rlimit.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised); err == nil {
+			rlimit = raised
+		}
+	}
+
+	return int(min(rlimit.Cur, 1<<20)) // #nosec G115 -- capped to fit in int
+}
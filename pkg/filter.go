@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// filterSeparator starts a local output filter in interactive commands, e.g. "dmesg :| grep -i error"
+const filterSeparator = ":|"
+
+// lineFilter selects which lines of host output are displayed
+type lineFilter struct {
+	re     *regexp.Regexp
+	invert bool // Show the lines that don't match, like grep -v
+}
+
+// outputFilter filters displayed host output; nil shows everything. Logs and hooks still get every line.
+var outputFilter *lineFilter
+
+// match reports whether line passes the filter
+func (f *lineFilter) match(line []byte) bool {
+	return f == nil || f.re.Match(line) != f.invert
+}
+
+// SetOutputFilter shows only host output lines matching the regular expression pattern
+func SetOutputFilter(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	outputFilter = &lineFilter{re: re}
+	return nil
+}
+
+// parseGrepFilter builds a filter from grep-style arguments: [-i] [-v] [-F] [-E] pattern.
+// The pattern is the rest of the line, optionally quoted, so it may contain spaces.
+func parseGrepFilter(args string) (*lineFilter, error) {
+	ignoreCase, invert, literal := false, false, false
+	args = strings.TrimSpace(args)
+	for strings.HasPrefix(args, "-") {
+		flags, rest, _ := strings.Cut(args, " ")
+		for _, flag := range flags[1:] {
+			switch flag {
+			case 'i':
+				ignoreCase = true
+			case 'v':
+				invert = true
+			case 'F':
+				literal = true
+			case 'E':
+			default:
+				return nil, fmt.Errorf("unsupported grep option -%c, use -i, -v, -F or -E", flag)
+			}
+		}
+		args = strings.TrimSpace(rest)
+	}
+
+	pattern := args
+	if len(pattern) >= 2 && (pattern[0] == '\'' || pattern[0] == '"') && pattern[len(pattern)-1] == pattern[0] {
+		pattern = pattern[1 : len(pattern)-1]
+	}
+	if pattern == "" {
+		return nil, errors.New("grep needs a pattern")
+	}
+	if literal {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return &lineFilter{re: re, invert: invert}, nil
+}
+
+// cutOutputFilter splits "command :| grep pattern" into the command and its filter.
+// Lines without a trailing ":| grep ..." are returned unchanged with a nil filter.
+func cutOutputFilter(line string) (string, *lineFilter, error) {
+	i := strings.LastIndex(line, filterSeparator)
+	if i < 0 {
+		return line, nil, nil
+	}
+	args, isGrep := strings.CutPrefix(strings.TrimSpace(line[i+len(filterSeparator):]), "grep ")
+	if !isGrep {
+		return line, nil, nil
+	}
+
+	filter, err := parseGrepFilter(args)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(line[:i]), filter, nil
+}
+
+// withOutputFilter runs a command with the ":| grep" filter it ends in, if any, instead of the session's filter
+func (s *session) withOutputFilter(line string, run func(command string)) {
+	command, filter, err := cutOutputFilter(line)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	if filter != nil {
+		previous := outputFilter
+		outputFilter = filter
+		defer func() { outputFilter = previous }()
+	}
+	run(command)
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCutOutputFilter(t *testing.T) {
+	tests := []struct {
+		line, command string
+		matches       []string
+		rejects       []string
+		wantErr       bool
+	}{
+		{"dmesg :| grep -i error", "dmesg", []string{"I/O ERROR on sda"}, []string{"all good"}, false},
+		{`journalctl :| grep -v "GET /health"`, "journalctl", []string{"POST /login"}, []string{"GET /health 200"}, false},
+		{"cat app.log :| grep -F a.b*", "cat app.log", []string{"x a.b* y"}, []string{"aXbb"}, false},
+		{"ps aux :| grep -iv 'sshd|bash'", "ps aux", []string{"nginx"}, []string{"SSHD: root"}, false},
+		{"echo a:|b", "echo a:|b", nil, nil, false},
+		{"uptime", "uptime", nil, nil, false},
+		{"dmesg :| grep -x error", "", nil, nil, true},
+		{"dmesg :| grep '('", "", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		command, filter, err := cutOutputFilter(tt.line)
+		if (err != nil) != tt.wantErr || command != tt.command {
+			t.Errorf("cutOutputFilter(%q) = %q, %v", tt.line, command, err)
+			continue
+		}
+		for _, line := range tt.matches {
+			if !filter.match([]byte(line)) {
+				t.Errorf("%q: expected %q to be shown", tt.line, line)
+			}
+		}
+		for _, line := range tt.rejects {
+			if filter.match([]byte(line)) {
+				t.Errorf("%q: expected %q to be hidden", tt.line, line)
+			}
+		}
+	}
+}
+
+func TestOutputFilter(t *testing.T) {
+	useFakeSSH(t)
+	if err := SetOutputFilter("^keep"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { outputFilter = nil }()
+
+	output := captureStdout(t, func() {
+		_ = ExecuteCommand(context.Background(), []string{"web1"}, "printf 'keep 1\\ndrop 2\\nkeep 3\\n'", "", true)
+	})
+	if !strings.Contains(output, "keep 1") || !strings.Contains(output, "keep 3") || strings.Contains(output, "drop") {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	if err := SetOutputFilter("("); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
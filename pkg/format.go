@@ -1,30 +1,187 @@
 package pkg
 
-import "fmt"
-
-// Color codes for host prefixes (ANSI code fragments; full sequence built dynamically)
-var colors = []string{
-	"31m",       // Red
-	"32m",       // Green
-	"33m",       // Yellow
-	"34m",       // Blue
-	"35m",       // Magenta
-	"36m",       // Cyan
-	"37m",       // White
-	"91m",       // Bright Red
-	"92m",       // Bright Green
-	"93m",       // Bright Yellow
-	"94m",       // Bright Blue
-	"95m",       // Bright Magenta
-	"96m",       // Bright Cyan
-	"97m",       // Bright White
-	"38;5;208m", // Orange (256-color)
-	"38;5;201m", // Pink (256-color)
-	"38;5;120m", // Light Green (256-color)
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// Color codes for host prefixes (ANSI code fragments; full sequence built dynamically).
+// The active palette is derived from the selected theme, see SetTheme.
+var colors = themes["default"]
+
+// themes holds the built-in color themes. Entries are either ANSI code fragments
+// or "#rrggbb" truecolor values that are downgraded on terminals without truecolor.
+var themes = map[string][]string{
+	"default": {
+		"31m",       // Red
+		"32m",       // Green
+		"33m",       // Yellow
+		"34m",       // Blue
+		"35m",       // Magenta
+		"36m",       // Cyan
+		"37m",       // White
+		"91m",       // Bright Red
+		"92m",       // Bright Green
+		"93m",       // Bright Yellow
+		"94m",       // Bright Blue
+		"95m",       // Bright Magenta
+		"96m",       // Bright Cyan
+		"97m",       // Bright White
+		"38;5;208m", // Orange (256-color)
+		"38;5;201m", // Pink (256-color)
+		"38;5;120m", // Light Green (256-color)
+	},
+	"solarized": {
+		"#b58900", // Yellow
+		"#cb4b16", // Orange
+		"#dc322f", // Red
+		"#d33682", // Magenta
+		"#6c71c4", // Violet
+		"#268bd2", // Blue
+		"#2aa198", // Cyan
+		"#859900", // Green
+	},
+	"high-contrast": {
+		"#ffffff", // White
+		"#ffff00", // Yellow
+		"#00ffff", // Cyan
+		"#ff00ff", // Magenta
+		"#00ff00", // Green
+		"#ff8000", // Orange
+		"#ff4040", // Red
+		"#80a0ff", // Blue
+	},
+}
+
+// SetTheme selects the color palette by built-in theme name or path to a theme file.
+// A theme file lists one color per line, either "#rrggbb" or an ANSI code fragment such as "38;5;208m".
+func SetTheme(name string) error {
+	entries, ok := themes[name]
+	if !ok {
+		var err error
+		entries, err = loadThemeFile(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	truecolor := supportsTruecolor()
+	palette := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		code, err := colorCode(entry, truecolor)
+		if err != nil {
+			return fmt.Errorf("theme %s: %w", name, err)
+		}
+		palette = append(palette, code)
+	}
+	if len(palette) == 0 {
+		return fmt.Errorf("theme %s: no colors defined", name)
+	}
+
+	colors = palette
+	return nil
+}
+
+// loadThemeFile reads color entries from a theme file; lines starting with "#" that are not hex colors are comments
+func loadThemeFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- theme path is chosen by the local user
+	if err != nil {
+		return nil, fmt.Errorf("unknown theme %q: %w", path, err)
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || (strings.HasPrefix(line, "#") && !isHexColor(line)) {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, nil
+}
+
+// AutoNoColor reports whether colors should be disabled because NO_COLOR is set or stdout is not a terminal
+func AutoNoColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return true
+	}
+	return !isTerminal(int(os.Stdout.Fd())) // #nosec G115 -- file descriptors fit in int
+}
+
+// isTerminal reports whether a file descriptor is a terminal; tests replace it
+var isTerminal = readline.IsTerminal
+
+// supportsTruecolor reports whether the terminal advertises 24-bit color support
+func supportsTruecolor() bool {
+	colorTerm := strings.ToLower(os.Getenv("COLORTERM"))
+	return colorTerm == "truecolor" || colorTerm == "24bit"
+}
+
+// isHexColor reports whether s has the form #rrggbb
+func isHexColor(s string) bool {
+	if len(s) != 7 || s[0] != '#' {
+		return false
+	}
+	_, err := strconv.ParseUint(s[1:], 16, 32)
+	return err == nil
+}
+
+// colorCode converts a theme entry into an ANSI code fragment, downgrading truecolor to the 256-color palette if needed
+func colorCode(entry string, truecolor bool) (string, error) {
+	if !strings.HasPrefix(entry, "#") {
+		if !strings.HasSuffix(entry, "m") {
+			return "", fmt.Errorf("invalid color %q", entry)
+		}
+		return entry, nil
+	}
+	if !isHexColor(entry) {
+		return "", fmt.Errorf("invalid color %q", entry)
+	}
+
+	value, _ := strconv.ParseUint(entry[1:], 16, 32)
+	r, g, b := int(value>>16&0xff), int(value>>8&0xff), int(value&0xff)
+	if truecolor {
+		return fmt.Sprintf("38;2;%d;%d;%dm", r, g, b), nil
+	}
+
+	return fmt.Sprintf("38;5;%dm", rgbTo256(r, g, b)), nil
+}
+
+// rgbTo256 maps an RGB color to the nearest entry of the xterm 6x6x6 color cube
+func rgbTo256(r, g, b int) int {
+	level := func(v int) int {
+		if v < 48 {
+			return 0
+		}
+		if v < 115 {
+			return 1
+		}
+		return (v - 35) / 40
+	}
+	return 16 + 36*level(r) + 6*level(g) + level(b)
 }
 
 const reset = "\033[0m"
 
+// HashColors derives host colors from a hash of the hostname instead of the host's position
+var HashColors bool
+
+// colorIndex returns the palette index for a host
+func colorIndex(host string, idx int) int {
+	if !HashColors {
+		return idx % len(colors)
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(host))
+	return int(h.Sum32() % uint32(len(colors))) // #nosec G115 -- palette length is small and positive
+}
+
 // formatHostPrefix creates a colored/formatted host prefix
 func formatHostPrefix(host string, idx, maxLen int, noColor bool) string {
 	padded := fmt.Sprintf("%-*s", maxLen, host)
@@ -32,7 +189,7 @@ func formatHostPrefix(host string, idx, maxLen int, noColor bool) string {
 		return padded
 	}
 
-	code := colors[idx%len(colors)]
+	code := colors[colorIndex(host, idx)]
 
 	return "\033[1;" + code + padded + reset
 }
@@ -0,0 +1,289 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LocalForwards and RemoteForwards are opened on all hosts once an interactive session is connected, like
+// :forward -L and :forward -R
+var LocalForwards, RemoteForwards []string
+
+// portForward is a tunnel over the control master of a host
+type portForward struct {
+	host string
+	flag string // -L listens on this machine, -R on the host, -D is a SOCKS proxy on this machine
+	spec string // As ssh takes it, e.g. "9090:localhost:9090" or "1080"
+}
+
+// String returns the forward as ssh options, e.g. "-L 9090:localhost:9090"
+func (f portForward) String() string {
+	return f.flag + " " + f.spec
+}
+
+// ValidateForward checks that spec is a TCP forward as ssh takes it, [bind:]port:host:hostport
+func ValidateForward(spec string) error {
+	_, err := forwardSpecs("-R", spec, 1)
+	return err
+}
+
+// forwardSpecs returns the spec of a forward for each of n hosts. A local port can only listen for one of
+// them, so local forwards count the port up per host: 9090:localhost:80 listens on 9090, 9091 and so on.
+// Remote forwards listen on each host and keep the spec.
+func forwardSpecs(flag, spec string, n int) ([]string, error) {
+	parts := splitForward(spec)
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, fmt.Errorf("invalid forward %q, expected [bind:]port:host:hostport", spec)
+	}
+	listen := len(parts) - 3 // Index of the listening port, after the optional bind address
+	port, err := strconv.Atoi(parts[listen])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %q in forward %q", parts[listen], spec)
+	}
+	if target, err := strconv.Atoi(parts[listen+2]); err != nil || target <= 0 || target > 65535 || parts[listen+1] == "" {
+		return nil, fmt.Errorf("invalid target %q in forward %q", strings.Join(parts[listen+1:], ":"), spec)
+	}
+
+	specs := make([]string, n)
+	for i := range specs {
+		if flag == "-R" || port == 0 { // Port 0 lets the system pick a free one
+			specs[i] = spec
+			continue
+		}
+		if port+i > 65535 {
+			return nil, fmt.Errorf("forward %q runs out of ports for %d hosts", spec, n)
+		}
+		parts[listen] = strconv.Itoa(port + i)
+		specs[i] = strings.Join(parts, ":")
+	}
+	return specs, nil
+}
+
+// splitForward splits a forward at the colons outside of brackets, which enclose IPv6 addresses
+func splitForward(spec string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range spec {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, spec[start:])
+}
+
+// muxForward asks the control master of a host to open ("forward") or close ("cancel") a tunnel
+func (cm *SSHConnectionManager) muxForward(ctx context.Context, operation string, f portForward) error {
+	if backendOf(f.host) != BackendSSH {
+		return fmt.Errorf("%s is reached via %s, forwarding needs ssh", f.host, backendOf(f.host))
+	}
+	if cm.isDirect(f.host) {
+		return fmt.Errorf("%s has no persistent connection to forward over", f.host)
+	}
+	// #nosec G204 - host parameter is controlled by our connection manager, not user input
+	cmd := exec.CommandContext(ctx, "ssh", "-S", cm.getSocketPath(f.host), "-O", operation, f.flag, f.spec, "--", sshDestination(f.host))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if message := bytes.TrimSpace(output); len(message) > 0 {
+			return fmt.Errorf("%s", message)
+		}
+		return err
+	}
+	return nil
+}
+
+// openForward opens a tunnel over the control master of its host and keeps it open across reconnects. An
+// allow list rules tunnels out, as they reach beyond the permitted commands.
+func (cm *SSHConnectionManager) openForward(ctx context.Context, f portForward) error {
+	if Restricted() {
+		return fmt.Errorf("%w: tunnels are not possible with an allow list", ErrRestricted)
+	}
+	cm.mu.Lock()
+	open := slices.Contains(cm.forwards, f)
+	cm.mu.Unlock()
+	if open {
+		return fmt.Errorf("%s is already open", f)
+	}
+	if err := cm.muxForward(ctx, "forward", f); err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.forwards = append(cm.forwards, f)
+	return nil
+}
+
+// closeForward closes a tunnel; it is forgotten even if its control master is gone already
+func (cm *SSHConnectionManager) closeForward(ctx context.Context, f portForward) error {
+	cm.mu.Lock()
+	cm.forwards = slices.DeleteFunc(cm.forwards, func(open portForward) bool { return open == f })
+	cm.mu.Unlock()
+	return cm.muxForward(ctx, "cancel", f)
+}
+
+// openForwards returns the open tunnels in the order they were opened
+func (cm *SSHConnectionManager) openForwards() []portForward {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return slices.Clone(cm.forwards)
+}
+
+// reopenForwards opens the tunnels of host again over a new control master
+func (cm *SSHConnectionManager) reopenForwards(ctx context.Context, host string) {
+	for _, f := range cm.openForwards() {
+		if f.host != host {
+			continue
+		}
+		if err := cm.muxForward(ctx, "forward", f); err != nil {
+			Log.Warn("Failed to re-open forward", "host", host, "forward", f.String(), "err", err)
+		}
+	}
+}
+
+// dropForwards forgets the tunnels of a host that left the session
+func (cm *SSHConnectionManager) dropForwards(host string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.forwards = slices.DeleteFunc(cm.forwards, func(f portForward) bool { return f.host == host })
+}
+
+// hasForwards reports whether tunnels of host are open; the caller holds cm.mu
+func (cm *SSHConnectionManager) hasForwards(host string) bool {
+	return slices.ContainsFunc(cm.forwards, func(f portForward) bool { return f.host == host })
+}
+
+// forward handles ":forward [-L|-R] <spec> [hosts]": it opens a tunnel on the targeted hosts, or on those
+// matching hosts. -L, the default, listens on this machine, -R on the hosts.
+func (s *session) forward(args string) {
+	fields := strings.Fields(args)
+	flag := "-L"
+	if len(fields) > 0 && (fields[0] == "-L" || fields[0] == "-R") {
+		flag, fields = fields[0], fields[1:]
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		fmt.Println("🔀 Usage: :forward [-L|-R] [bind:]port:host:hostport [hosts]")
+		return
+	}
+
+	targets := s.targetHosts()
+	if len(fields) == 2 {
+		matched := matchHosts(s.hosts, fields[1])
+		targets = slices.DeleteFunc(slices.Clone(s.hosts), func(host string) bool { return !matched[host] })
+		if len(targets) == 0 {
+			fmt.Printf("⚠️  No connected hosts match %q\n", fields[1])
+			return
+		}
+	}
+	specs, err := forwardSpecs(flag, fields[0], len(targets))
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, host := range targets {
+		wg.Go(func() {
+			errs[i] = s.connManager.openForward(s.context(), portForward{host: host, flag: flag, spec: specs[i]})
+		})
+	}
+	wg.Wait()
+
+	maxHostLen := maxLen(s.hosts)
+	for i, host := range targets {
+		prefix := formatHostPrefix(host, slices.Index(s.hosts, host), maxHostLen, s.noColor)
+		if errs[i] != nil {
+			fmt.Printf("%s: ❌ %v\n", prefix, errs[i])
+		} else {
+			fmt.Printf("%s: 🔀 %s %s\n", prefix, flag, specs[i])
+		}
+	}
+}
+
+// socks handles ":socks [bind:]<port> <host>": it opens a SOCKS proxy on this machine whose connections
+// leave from host, e.g. to reach internal dashboards with a browser
+func (s *session) socks(args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		fmt.Println("🧦 Usage: :socks [bind:]<port> <host>")
+		return
+	}
+	spec, host := fields[0], fields[1]
+	if !slices.Contains(s.hosts, host) {
+		fmt.Printf("⚠️  %s is not connected\n", host)
+		return
+	}
+	parts := splitForward(spec)
+	if port, err := strconv.Atoi(parts[len(parts)-1]); len(parts) > 2 || err != nil || port <= 0 || port > 65535 {
+		fmt.Printf("❌ Error: invalid SOCKS port %q, expected [bind:]port\n", spec)
+		return
+	}
+
+	if err := s.connManager.openForward(s.context(), portForward{host: host, flag: "-D", spec: spec}); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	listen := spec
+	if len(parts) == 1 {
+		listen = "localhost:" + spec
+	}
+	fmt.Printf("🧦 SOCKS proxy on %s through %s, e.g. curl --socks5-hostname %s http://...\n", listen, host, listen)
+}
+
+// listForwards handles ":forwards", numbering the open tunnels for :unforward
+func (s *session) listForwards() {
+	forwards := s.connManager.openForwards()
+	if len(forwards) == 0 {
+		fmt.Println("🔀 No forwards are open")
+		return
+	}
+	fmt.Printf("🔀 Open forwards (%d):\n", len(forwards))
+	for i, f := range forwards {
+		fmt.Printf("  %d. %s %s\n", i+1, f.host, f)
+	}
+}
+
+// unforward handles ":unforward <n>... | all", closing tunnels by their number in :forwards
+func (s *session) unforward(args string) {
+	forwards := s.connManager.openForwards()
+	var closing []portForward
+	for _, arg := range strings.Fields(args) {
+		if arg == "all" {
+			closing = forwards
+			break
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(forwards) {
+			fmt.Printf("❌ Error: no forward number %s, see :forwards\n", arg)
+			return
+		}
+		closing = append(closing, forwards[n-1])
+	}
+	if len(closing) == 0 {
+		fmt.Println("🔀 Usage: :unforward <n>... | all")
+		return
+	}
+
+	var errs []error
+	for _, f := range closing {
+		if err := s.connManager.closeForward(s.context(), f); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", f.host, f, err))
+		}
+	}
+	printError(errors.Join(errs...))
+	fmt.Printf("🔀 Closed %d forward(s)\n", len(closing)-len(errs))
+}
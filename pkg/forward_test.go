@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestForwardSpecs(t *testing.T) {
+	tests := []struct {
+		flag, spec string
+		expected   []string
+	}{
+		{"-L", "9090:localhost:9090", []string{"9090:localhost:9090", "9091:localhost:9090", "9092:localhost:9090"}},
+		{"-L", "127.0.0.1:8080:db:5432", []string{"127.0.0.1:8080:db:5432", "127.0.0.1:8081:db:5432", "127.0.0.1:8082:db:5432"}},
+		{"-L", "[::1]:8080:[fd00::5]:80", []string{"[::1]:8080:[fd00::5]:80", "[::1]:8081:[fd00::5]:80", "[::1]:8082:[fd00::5]:80"}},
+		{"-L", "0:localhost:80", []string{"0:localhost:80", "0:localhost:80", "0:localhost:80"}},
+		{"-R", "8080:localhost:80", []string{"8080:localhost:80", "8080:localhost:80", "8080:localhost:80"}},
+	}
+	for _, test := range tests {
+		specs, err := forwardSpecs(test.flag, test.spec, 3)
+		if err != nil || !slices.Equal(specs, test.expected) {
+			t.Errorf("%s %s: expected %q, got %q (%v)", test.flag, test.spec, test.expected, specs, err)
+		}
+	}
+
+	for _, spec := range []string{"9090", "9090:localhost", "http:localhost:80", "9090:localhost:http", "9090::80", "70000:localhost:80"} {
+		if err := ValidateForward(spec); err == nil {
+			t.Errorf("expected %q to be invalid", spec)
+		}
+	}
+	if _, err := forwardSpecs("-L", "65535:localhost:80", 2); err == nil {
+		t.Error("expected to run out of ports")
+	}
+}
+
+// freePort returns a local TCP port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+	lc := &net.ListenConfig{}
+	listener, err := lc.Listen(t.Context(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// listening reports whether something accepts connections on a local port
+func listening(t *testing.T, port int) bool {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(t.Context(), "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err == nil {
+		conn.Close()
+	}
+	return err == nil
+}
+
+func TestSessionForwards(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	server := startFakeSSHServer(t, "127.0.0.1:0", "")
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+
+	SocketDir, SSHOptions = t.TempDir(), []string{"Port=" + port}
+	StrictHostKeyChecking, KnownHostsFile = "no", "/dev/null"
+	defer func() { SocketDir, SSHOptions, StrictHostKeyChecking, KnownHostsFile = "", nil, "", "" }()
+
+	cm := NewSSHConnectionManager("")
+	defer cm.closeAllConnections()
+	captureStdout(t, func() {
+		if err := cm.establishConnection(context.Background(), "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	sess := &session{ctx: context.Background(), connManager: cm, hosts: []string{"127.0.0.1"}, noColor: true}
+
+	local := freePort(t)
+	spec := strconv.Itoa(local) + ":localhost:80"
+	output := captureStdout(t, func() { sess.forward(spec) })
+	if !strings.Contains(output, "🔀 -L "+spec) || !listening(t, local) {
+		t.Fatalf("expected the forward to listen on %d, got %q", local, output)
+	}
+	if output := captureStdout(t, func() { sess.forward("-L " + spec + " 127.*") }); !strings.Contains(output, "already open") {
+		t.Errorf("expected the forward to be refused twice, got %q", output)
+	}
+	if output := captureStdout(t, sess.listForwards); !strings.Contains(output, "1. 127.0.0.1 -L "+spec) {
+		t.Errorf("expected the forward to be listed, got %q", output)
+	}
+
+	// A new control master gets the forward again
+	captureStdout(t, func() {
+		if err := cm.reconnect(context.Background(), "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !listening(t, local) {
+		t.Error("expected the forward to be re-opened after reconnecting")
+	}
+
+	if output := captureStdout(t, func() { sess.unforward(" 2") }); !strings.Contains(output, "no forward number 2") {
+		t.Errorf("expected an unknown number to be refused, got %q", output)
+	}
+	if output := captureStdout(t, func() { sess.unforward(" 1") }); !strings.Contains(output, "Closed 1 forward(s)") {
+		t.Errorf("expected the forward to be closed, got %q", output)
+	}
+	if listening(t, local) || len(cm.openForwards()) != 0 {
+		t.Error("expected the forward to be gone")
+	}
+
+	socks := freePort(t)
+	if output := captureStdout(t, func() { sess.socks(" 1080 web9") }); !strings.Contains(output, "web9 is not connected") {
+		t.Errorf("expected an unknown host to be refused, got %q", output)
+	}
+	if output := captureStdout(t, func() { sess.socks(" socks 127.0.0.1") }); !strings.Contains(output, "invalid SOCKS port") {
+		t.Errorf("expected an invalid port to be refused, got %q", output)
+	}
+	output = captureStdout(t, func() { sess.socks(" " + strconv.Itoa(socks) + " 127.0.0.1") })
+	if !strings.Contains(output, "SOCKS proxy on localhost:"+strconv.Itoa(socks)) || !listening(t, socks) {
+		t.Errorf("expected a SOCKS proxy on %d, got %q", socks, output)
+	}
+	if output := captureStdout(t, sess.listForwards); !strings.Contains(output, "1. 127.0.0.1 -D "+strconv.Itoa(socks)) {
+		t.Errorf("expected the proxy to be listed, got %q", output)
+	}
+	captureStdout(t, func() { sess.unforward(" all") })
+	if listening(t, socks) {
+		t.Error("expected the proxy to be closed")
+	}
+}
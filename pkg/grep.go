@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GrepOptions controls how Grep searches remote files
+type GrepOptions struct {
+	MaxCount         int  // Maximum matching lines reported per file, 0 for unlimited
+	FilesWithMatches bool // Only report the names of files that contain a match
+	IgnoreCase       bool // Match case-insensitively
+}
+
+// grepResult holds the outcome of a grep on a single host
+type grepResult struct {
+	output string
+	err    error
+}
+
+// buildGrepCommand returns the remote grep invocation. Files are left unquoted so the remote shell expands globs.
+func buildGrepCommand(pattern string, files []string, opts GrepOptions) string {
+	args := []string{"grep", "-H", "-s"}
+	if opts.FilesWithMatches {
+		args = append(args, "-l")
+	} else if opts.MaxCount > 0 {
+		args = append(args, "-m", strconv.Itoa(opts.MaxCount))
+	}
+	if opts.IgnoreCase {
+		args = append(args, "-i")
+	}
+	args = append(args, "-e", shellQuote(pattern), "--")
+	args = append(args, files...)
+
+	return strings.Join(args, " ")
+}
+
+// Grep searches files on all hosts in parallel and prints the matches grouped by host.
+// Hosts where grep failed, other than finding nothing, are returned as joined *HostError values.
+func Grep(ctx context.Context, hosts []string, pattern string, files []string, user string, noColor bool, opts GrepOptions) error {
+	command := buildGrepCommand(pattern, files, opts)
+	if err := checkCommand(command); err != nil { // File arguments are globs and go unquoted
+		return err
+	}
+	results := make([]grepResult, len(hosts))
+
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Go(func() {
+			cmd := hostCommand(ctx, host, command, user, false)
+			acquireFDs()
+			stdout, stderr, err := runCmdWithSeparateOutput(cmd)
+			releaseFDs()
+			if err != nil && stderr != "" {
+				err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+			}
+			results[i] = grepResult{output: stdout, err: err}
+		})
+	}
+	wg.Wait()
+
+	maxHostLen := maxLen(hosts)
+	matchingHosts := 0
+	errs := make([]error, len(hosts))
+	for i, host := range hosts {
+		prefix := formatHostPrefix(host, i, maxHostLen, noColor)
+		result := results[i]
+
+		// grep exits with 1 when nothing matched, which is not an error
+		var exitErr *exec.ExitError
+		if result.err != nil && (!errors.As(result.err, &exitErr) || exitErr.ExitCode() != 1) {
+			fmt.Printf("%s: ERROR: %v\n", prefix, result.err)
+			errs[i] = result.err
+			continue
+		}
+
+		output := strings.TrimRight(result.output, "\n")
+		if output == "" {
+			continue
+		}
+
+		matchingHosts++
+		for _, line := range strings.Split(output, "\n") {
+			logHostLine(host, line)
+			fmt.Printf("%s: %s\n", prefix, line)
+		}
+	}
+
+	fmt.Printf("🔎 %d/%d host(s) have matches\n", matchingHosts, len(hosts))
+	return joinHostErrors(hosts, errs)
+}
@@ -0,0 +1,26 @@
+package pkg
+
+import "testing"
+
+func TestBuildGrepCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		files    []string
+		opts     GrepOptions
+		expected string
+	}{
+		{"defaults", "ERROR", []string{"/var/log/app/*.log"}, GrepOptions{MaxCount: 20}, "grep -H -s -m 20 -e 'ERROR' -- /var/log/app/*.log"},
+		{"files with matches", "ERROR", []string{"a.log", "b.log"}, GrepOptions{MaxCount: 20, FilesWithMatches: true}, "grep -H -s -l -e 'ERROR' -- a.log b.log"},
+		{"ignore case unlimited", "timeout", []string{"x.log"}, GrepOptions{IgnoreCase: true}, "grep -H -s -i -e 'timeout' -- x.log"},
+		{"quoted pattern", "it's", []string{"x.log"}, GrepOptions{}, `grep -H -s -e 'it'\''s' -- x.log`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := buildGrepCommand(test.pattern, test.files, test.opts); result != test.expected {
+				t.Errorf("buildGrepCommand() = %q, expected %q", result, test.expected)
+			}
+		})
+	}
+}
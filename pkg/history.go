@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// historyLimit is the number of commands kept in the history file, readline's default
+const historyLimit = 500
+
+// historyListSize is the number of entries :history shows by default
+const historyListSize = 20
+
+// historyFile returns the location of the interactive command history
+func historyFile() string {
+	return filepath.Join(homeDir(), ".gosh_history")
+}
+
+// commandHistory holds the commands of the interactive session and earlier ones from the history file,
+// oldest first and without duplicates: a repeated command moves to the end
+type commandHistory struct {
+	entries []string
+}
+
+// loadHistory reads the history file, dropping duplicates and entries beyond historyLimit.
+// The file is compacted when anything was dropped, so readline loads the deduplicated list.
+func loadHistory(path string) *commandHistory {
+	h := &commandHistory{}
+	file, err := os.Open(path) // #nosec G304 -- history path is derived from the local user's home directory
+	if err != nil {
+		return h
+	}
+	defer file.Close()
+
+	total := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			total++
+			h.add(line)
+		}
+	}
+
+	if total > len(h.entries) {
+		_ = os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0o600)
+	}
+	return h
+}
+
+// add appends command, removing an earlier copy of it
+func (h *commandHistory) add(command string) {
+	h.entries = slices.DeleteFunc(h.entries, func(entry string) bool { return entry == command })
+	h.entries = append(h.entries, command)
+	if len(h.entries) > historyLimit {
+		h.entries = h.entries[len(h.entries)-historyLimit:]
+	}
+}
+
+// isHistoryReference reports whether line starts with a history reference like !!, !3, !-2 or !git
+// rather than a shell negation such as "! grep -q x file"
+func isHistoryReference(line string) bool {
+	return len(line) > 1 && line[0] == '!' && !strings.ContainsRune(" \t=(", rune(line[1]))
+}
+
+// expand resolves a history reference at the start of line: !! is the last command, !N the entry
+// numbered N in :history, !-N the Nth last and !prefix the most recent command starting with prefix.
+// Text after the reference is appended to the command.
+func (h *commandHistory) expand(line string) (string, error) {
+	word, rest, _ := strings.Cut(line, " ")
+	event := word[1:]
+
+	index := -1
+	if event == "!" {
+		index = len(h.entries) - 1
+	} else if n, err := strconv.Atoi(event); err == nil {
+		index = n - 1
+		if n < 0 {
+			index = len(h.entries) + n
+		}
+	} else {
+		for i := len(h.entries) - 1; i >= 0; i-- {
+			if strings.HasPrefix(h.entries[i], event) {
+				index = i
+				break
+			}
+		}
+	}
+	if index < 0 || index >= len(h.entries) {
+		return "", errors.New(word + ": event not found")
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" {
+		return h.entries[index] + " " + rest, nil
+	}
+	return h.entries[index], nil
+}
+
+// completions returns the single-line entries that continue prefix, most recent first
+func (h *commandHistory) completions(prefix string) []string {
+	var completions []string
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		entry := h.entries[i]
+		if len(entry) > len(prefix) && strings.HasPrefix(entry, prefix) && !strings.Contains(entry, "\n") {
+			completions = append(completions, entry)
+		}
+	}
+	return completions
+}
+
+// fuzzyMatch returns the entry or alias name that best matches query as a fuzzy subsequence, preferring
+// aliases and recent entries on ties, or "" if nothing matches
+func (h *commandHistory) fuzzyMatch(query string, aliases []string) string {
+	best, bestScore := "", -1
+	consider := func(candidate string) {
+		if score := fuzzyScore(query, candidate); score > bestScore && candidate != query {
+			best, bestScore = candidate, score
+		}
+	}
+	for _, name := range aliases {
+		consider(name)
+	}
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if !strings.Contains(h.entries[i], "\n") {
+			consider(h.entries[i])
+		}
+	}
+	return best
+}
+
+// show lists history entries with their numbers: the last historyListSize by default,
+// the last N for a number, or those containing the given text
+func (h *commandHistory) show(arg string) {
+	first, filter := max(len(h.entries)-historyListSize, 0), ""
+	if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+		first = max(len(h.entries)-n, 0)
+	} else if arg != "" {
+		first, filter = 0, arg
+	}
+
+	width := len(strconv.Itoa(len(h.entries)))
+	shown := 0
+	for i := first; i < len(h.entries); i++ {
+		if filter != "" && !strings.Contains(h.entries[i], filter) {
+			continue
+		}
+		// Continuation lines of heredocs line up under the command
+		entry := strings.ReplaceAll(h.entries[i], "\n", "\n"+strings.Repeat(" ", width+4))
+		fmt.Printf("  %*d  %s\n", width, i+1, entry)
+		shown++
+	}
+	if shown == 0 {
+		fmt.Println("📜 No matching history entries")
+	}
+}
+
+// recordHistory adds a command to the session history. Single-line commands are also saved to the
+// history file through readline so they can be recalled with the arrow keys and Ctrl+R.
+func (s *session) recordHistory(command string) {
+	s.history.add(command)
+	if s.rl != nil && !strings.Contains(command, "\n") {
+		_ = s.rl.SaveHistory(command)
+	}
+}
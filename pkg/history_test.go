@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func TestLoadHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("uptime\ndf -h\n\nuptime\nls\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := loadHistory(path)
+	if strings.Join(h.entries, ",") != "df -h,uptime,ls" {
+		t.Errorf("unexpected entries: %q", h.entries)
+	}
+
+	// The file is compacted so readline loads the same list
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "df -h\nuptime\nls\n" {
+		t.Errorf("unexpected history file %q: %v", content, err)
+	}
+
+	if h := loadHistory(filepath.Join(t.TempDir(), "missing")); len(h.entries) != 0 {
+		t.Errorf("expected an empty history, got %q", h.entries)
+	}
+}
+
+func TestHistoryExpand(t *testing.T) {
+	h := &commandHistory{}
+	for _, command := range []string{"uptime", "git status", "df -h", "git log"} {
+		h.add(command)
+	}
+
+	tests := []struct {
+		line, want string
+		wantErr    bool
+	}{
+		{"!!", "git log", false},
+		{"!1", "uptime", false},
+		{"!-2", "df -h", false},
+		{"!git", "git log", false},
+		{"!up", "uptime", false},
+		{"!3 /tmp", "df -h /tmp", false},
+		{"!9", "", true},
+		{"!0", "", true},
+		{"!nope", "", true},
+	}
+	for _, tt := range tests {
+		got, err := h.expand(tt.line)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v", tt.line, got, err)
+		}
+	}
+
+	for line, want := range map[string]bool{"!!": true, "!3": true, "! grep -q x f": false, "!= x": false, "!": false, "ls": false} {
+		if got := isHistoryReference(line); got != want {
+			t.Errorf("isHistoryReference(%q) = %v", line, got)
+		}
+	}
+}
+
+func TestHistoryShow(t *testing.T) {
+	h := &commandHistory{}
+	for _, command := range []string{"uptime", "git status", "cat <<EOF\nx\nEOF", "uptime"} {
+		h.add(command)
+	}
+
+	output := captureStdout(t, func() { h.show("") })
+	want := "  1  git status\n  2  cat <<EOF\n     x\n     EOF\n  3  uptime\n"
+	if output != want {
+		t.Errorf("got %q, want %q", output, want)
+	}
+
+	if output := captureStdout(t, func() { h.show("1") }); output != "  3  uptime\n" {
+		t.Errorf("unexpected output for a count: %q", output)
+	}
+	if output := captureStdout(t, func() { h.show("git") }); output != "  1  git status\n" {
+		t.Errorf("unexpected output for a search: %q", output)
+	}
+	if output := captureStdout(t, func() { h.show("nope") }); !strings.Contains(output, "No matching") {
+		t.Errorf("unexpected output for no match: %q", output)
+	}
+}
+
+func TestHistoryCompletion(t *testing.T) {
+	Aliases = map[string]string{"deploy-web": "sudo /opt/deploy.sh web"}
+	defer func() { Aliases = map[string]string{} }()
+	completer := &customCompleter{
+		history: &commandHistory{entries: []string{"systemctl status sshd", "df -h", "systemctl status nginx"}},
+		connMgr: &SSHConnectionManager{},
+	}
+
+	// Matching history entries come first, most recent first
+	completions, _ := completer.Do([]rune("systemctl st"), 12)
+	if len(completions) < 2 || string(completions[0]) != "atus nginx" || string(completions[1]) != "atus sshd" {
+		t.Errorf("unexpected completions %q", completions)
+	}
+	if line, _, ok := completer.OnChange([]rune("systemctl st"), 12, readline.CharTab); ok {
+		t.Errorf("expected no replacement with prefix completions, got %q", string(line))
+	}
+
+	// Without completions Tab replaces the line with the best fuzzy match
+	for query, expected := range map[string]string{"dfh": "df -h", "dpw": "deploy-web", "stsshd": "systemctl status sshd"} {
+		if completions, _ := completer.Do([]rune(query), len(query)); len(completions) != 0 {
+			t.Errorf("expected no completions for %q, got %q", query, completions)
+		}
+		line, pos, ok := completer.OnChange([]rune(query), len(query), readline.CharTab)
+		if !ok || string(line) != expected || pos != len(expected) {
+			t.Errorf("expected %q to become %q, got %q (%v)", query, expected, string(line), ok)
+		}
+	}
+
+	// Other keys drop the fuzzy match
+	completer.Do([]rune("dfh"), 3)
+	if _, _, ok := completer.OnChange([]rune("dfhx"), 4, 'x'); ok {
+		t.Error("expected typing to leave the line alone")
+	}
+	if _, _, ok := completer.OnChange([]rune("dfhx"), 4, readline.CharTab); ok {
+		t.Error("expected the dropped fuzzy match not to be applied")
+	}
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// StrictHostKeyChecking is passed to ssh as its StrictHostKeyChecking option: yes refuses hosts whose key
+// isn't known, accept-new pins the key of new hosts and refuses changed ones, no accepts any key. Empty
+// leaves the choice to ssh_config, or accept-new with a Profile.
+var StrictHostKeyChecking string
+
+// HostKeyPolicies are the values StrictHostKeyChecking accepts
+var HostKeyPolicies = []string{"yes", "no", "accept-new"}
+
+// KnownHostsFile replaces the known_hosts file ssh checks host keys against, including that of a Profile
+var KnownHostsFile string
+
+// SetStrictHostKeyChecking validates and sets the host key policy
+func SetStrictHostKeyChecking(policy string) error {
+	if policy != "" && !slices.Contains(HostKeyPolicies, policy) {
+		return fmt.Errorf("unknown host key policy %q, expected %s", policy, strings.Join(HostKeyPolicies, ", "))
+	}
+	StrictHostKeyChecking = policy
+	return nil
+}
+
+// hostKeyOptions returns the ssh options for the host key policy and known_hosts file in effect
+func hostKeyOptions() []string {
+	knownHosts, policy := expandHome(KnownHostsFile), StrictHostKeyChecking
+	if Profile != "" {
+		knownHosts = cmp.Or(knownHosts, knownHostsFile(Profile))
+		policy = cmp.Or(policy, "accept-new") // Pin keys on first use, reject changes afterwards
+	}
+
+	var args []string
+	if knownHosts != "" {
+		_ = os.MkdirAll(filepath.Dir(knownHosts), 0o700) // ssh reports a missing directory itself
+		args = append(args, "-o", "UserKnownHostsFile="+knownHosts)
+	}
+	if policy != "" {
+		args = append(args, "-o", "StrictHostKeyChecking="+policy)
+	}
+	return args
+}
+
+// scannedKeys are the current host keys of a host, ready to be added to a known_hosts file
+type scannedKeys struct {
+	name         string   // Name ssh looks the keys up by, e.g. "web1" or "[web1]:2222"
+	file         string   // known_hosts file ssh checks the keys against
+	lines        []byte   // known_hosts lines of the keys
+	fingerprints []string // Key type and SHA256 fingerprint of each key
+}
+
+// scanHostKeys fetches the host keys of host with ssh-keyscan from the address ssh connects to. They are
+// listed under the name and in the known_hosts file ssh uses for host, following ~/.ssh/config.
+func scanHostKeys(ctx context.Context, host string) (*scannedKeys, error) {
+	config, err := sshConfig(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the ssh config of %s: %w", host, err)
+	}
+	if config["proxyjump"] != "none" || config["proxycommand"] != "none" {
+		return nil, fmt.Errorf("%s is reached through a proxy, ssh-keyscan can't fetch its keys", host)
+	}
+	file, _, _ := strings.Cut(config["userknownhostsfile"], " ") // ssh adds new keys to the first file
+	if file == "" {
+		return nil, fmt.Errorf("no known_hosts file is configured for %s", host)
+	}
+
+	acquireFDs()
+	output, err := exec.CommandContext(ctx, "ssh-keyscan", "-T", sshSeconds(ConnectTimeout), "-p", config["port"], config["hostname"]).Output()
+	releaseFDs()
+	if err != nil {
+		return nil, fmt.Errorf("ssh-keyscan failed for %s: %w", host, err)
+	}
+
+	name := cmp.Or(config["hostkeyalias"], config["hostname"])
+	if config["port"] != "22" {
+		name = "[" + name + "]:" + config["port"]
+	}
+	keys, err := parseScannedKeys(output, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", host, err)
+	}
+	keys.file = expandHome(file)
+	return keys, nil
+}
+
+// parseScannedKeys turns the output of ssh-keyscan into known_hosts lines for name
+func parseScannedKeys(output []byte, name string) (*scannedKeys, error) {
+	keys := &scannedKeys{name: name}
+	for line := range bytes.Lines(output) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		_, key, found := bytes.Cut(line, []byte(" "))
+		if !found {
+			continue
+		}
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unreadable host key: %w", err)
+		}
+		keys.lines = fmt.Appendf(keys.lines, "%s %s\n", name, key)
+		keys.fingerprints = append(keys.fingerprints, publicKey.Type()+" "+ssh.FingerprintSHA256(publicKey))
+	}
+	if len(keys.lines) == 0 {
+		return nil, errors.New("no host keys received")
+	}
+	return keys, nil
+}
+
+// trust replaces the keys known for the host with the scanned ones
+func (k *scannedKeys) trust(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(k.file), 0o700); err != nil {
+		return err
+	}
+	if _, err := os.Stat(k.file); err == nil {
+		// ssh-keygen also removes hashed entries and keeps a backup in known_hosts.old
+		removal := exec.CommandContext(ctx, "ssh-keygen", "-R", k.name, "-f", k.file) // #nosec G204 -- name comes from ssh -G
+		if output, err := removal.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove the old keys of %s: %w: %s", k.name, err, bytes.TrimSpace(output))
+		}
+	}
+
+	file, err := os.OpenFile(k.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 -- known_hosts file from ssh -G
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(k.lines); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// trustHost shows the current host keys of host and, once confirmed, replaces the keys known for it, e.g.
+// after a reinstall or for a host never connected to before. The host is then connected or reconnected.
+func (s *session) trustHost(host string) {
+	if host == "" {
+		fmt.Println("🔑 Usage: :trust <host>")
+		return
+	}
+	if backendOf(host) != BackendSSH {
+		fmt.Printf("⚠️  %s isn't reached over ssh and has no host key\n", host)
+		return
+	}
+
+	keys, err := scanHostKeys(s.context(), host)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	fmt.Printf("🔑 Host keys of %s:\n", keys.name)
+	for _, fingerprint := range keys.fingerprints {
+		fmt.Printf("  %s\n", fingerprint)
+	}
+	answer, err := s.readAnswer(fmt.Sprintf("❓ Trust them in %s? [y/N]: ", keys.file))
+	if err != nil || !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		fmt.Println("🛑 Not trusted, nothing was changed")
+		return
+	}
+	if err := keys.trust(s.context()); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ Trusted %d key(s) of %s\n", len(keys.fingerprints), keys.name)
+
+	if slices.Contains(s.hosts, host) {
+		s.reconnectHosts(host)
+	} else {
+		s.addHost(host)
+	}
+}
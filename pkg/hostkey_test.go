@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHostKeyOptions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer func() { StrictHostKeyChecking, KnownHostsFile, Profile = "", "", "" }()
+
+	if args := hostKeyOptions(); len(args) != 0 {
+		t.Errorf("expected ssh_config to decide by default, got %q", args)
+	}
+	if err := SetStrictHostKeyChecking("maybe"); err == nil {
+		t.Error("expected an unknown policy to fail")
+	}
+
+	if err := SetStrictHostKeyChecking("yes"); err != nil {
+		t.Fatal(err)
+	}
+	KnownHostsFile = "~/fleet/known_hosts"
+	expected := []string{"-o", "UserKnownHostsFile=" + filepath.Join(os.Getenv("HOME"), "fleet", "known_hosts"), "-o", "StrictHostKeyChecking=yes"}
+	if args := hostKeyOptions(); !slices.Equal(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+
+	// The flags take precedence over the defaults of a profile
+	Profile = "lab"
+	if args := hostKeyOptions(); !slices.Equal(args, expected) {
+		t.Errorf("expected the flags to override the profile, got %q", args)
+	}
+	StrictHostKeyChecking, KnownHostsFile = "", ""
+	expected = []string{"-o", "UserKnownHostsFile=" + knownHostsFile("lab"), "-o", "StrictHostKeyChecking=accept-new"}
+	if args := hostKeyOptions(); !slices.Equal(args, expected) {
+		t.Errorf("expected the profile defaults %q, got %q", expected, args)
+	}
+}
+
+func TestParseScannedKeys(t *testing.T) {
+	publicKey, err := os.ReadFile("test_keys/test_server_key.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.Join(strings.Fields(string(publicKey))[:2], " ")
+	output := "# web1:2222 SSH-2.0-OpenSSH_9.6\nweb1 " + key + "\n"
+
+	keys, err := parseScannedKeys([]byte(output), "[web1]:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(keys.lines) != "[web1]:2222 "+key+"\n" {
+		t.Errorf("expected the key under the known_hosts name, got %q", keys.lines)
+	}
+	if len(keys.fingerprints) != 1 || !strings.HasPrefix(keys.fingerprints[0], "ssh-rsa SHA256:") {
+		t.Errorf("expected the fingerprint of the key, got %q", keys.fingerprints)
+	}
+
+	if _, err := parseScannedKeys([]byte("# web1:22 SSH-2.0-OpenSSH_9.6\n"), "web1"); err == nil {
+		t.Error("expected an error without keys")
+	}
+}
+
+func TestScanAndTrustHostKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	listener := startFakeSSHServer(t, "127.0.0.1:0", "")
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+	SSHOptions = []string{"Port=" + port}
+	defer func() { KnownHostsFile, SSHOptions = "", nil }()
+
+	keys, err := scanHostKeys(t.Context(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.name != "[127.0.0.1]:"+port || keys.file != KnownHostsFile {
+		t.Errorf("expected the keys of [127.0.0.1]:%s for %s, got %s for %s", port, KnownHostsFile, keys.name, keys.file)
+	}
+
+	// A stale key is replaced, keys of other hosts are kept
+	stale := keys.name + " ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGtQUDR8xgh7Zy4bqLEzJyUGSl7FpMmuzPOBwUyTWx5v\n"
+	other := "web1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGtQUDR8xgh7Zy4bqLEzJyUGSl7FpMmuzPOBwUyTWx5v\n"
+	if err := os.WriteFile(KnownHostsFile, []byte(stale+other), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.trust(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(KnownHostsFile)
+	if strings.Contains(string(content), stale) || !strings.Contains(string(content), other) || !strings.HasSuffix(string(content), string(keys.lines)) {
+		t.Errorf("expected the stale key to be replaced, got %q", content)
+	}
+
+	// ssh finds the trusted keys where it looks for them
+	if output, err := exec.CommandContext(t.Context(), "ssh-keygen", "-F", keys.name, "-f", KnownHostsFile).Output(); err != nil || len(output) == 0 {
+		t.Errorf("expected ssh-keygen to find the trusted key: %v", err)
+	}
+}
@@ -1,12 +1,18 @@
 package pkg
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"path"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/chzyer/readline"
 )
@@ -15,23 +21,25 @@ import (
 var Verbose bool
 
 // InteractiveMode starts an interactive session
-func InteractiveMode(hosts []string, user string, noColor bool, verbose bool) {
+func InteractiveMode(ctx context.Context, hosts []string, user string, noColor bool, verbose bool) error {
 	// Set the global verbose flag to support changes during the session
 	Verbose = verbose
+	applyLogLevel()
 
-	if Verbose {
-		fmt.Printf("🔍 Testing connections to %d host(s)...\n", len(hosts))
-		fmt.Println("💡 Type 'exit' or 'quit' to exit, 'help' for help")
+	if banner := CurrentProfile.bannerLine(len(hosts), noColor); banner != "" {
+		fmt.Println(banner)
 	}
 
+	Log.Info("Testing connections", "hosts", len(hosts))
+	Log.Info("Type :exit or :quit to exit, :help for help")
+
 	// Create SSH connection manager for persistent connections
 	connManager := NewSSHConnectionManager(user)
 	defer connManager.closeAllConnections() // Ensure cleanup on exit
+	defer closeHostLogs()
 
-	if Verbose {
-		fmt.Printf("Socket directory: %s\n", connManager.socketDir)
-	}
-	// Establish connections to all hosts in parallel with progress bar
+	Log.Debug("Using socket directory", "dir", connManager.socketDir)
+	// Establish connections to all hosts in parallel with a progress line
 	type connectionResult struct {
 		host  string
 		error error
@@ -43,170 +51,902 @@ func InteractiveMode(hosts []string, user string, noColor bool, verbose bool) {
 	// Start connections in parallel
 	for _, host := range hosts {
 		wg.Go(func() {
-			err := connManager.establishConnection(host)
+			err := connManager.establishConnection(ctx, host)
 			resultChan <- connectionResult{host: host, error: err}
 		})
 	}
 
-	// Close channel when all connections complete
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	// Process results and update progress
+	// Process results and update progress; the line is also redrawn in between to keep the ETA current
 	var connectedHosts []string
-	var failedConnections []string
-	completed := 0
+	connectErrs := make([]error, len(hosts)) // Indexed like hosts
+	failures := 0
+	progress := newConnectProgress(hosts)
+	ticker := time.NewTicker(progressRefresh)
+	defer ticker.Stop()
 
-	for result := range resultChan {
-		completed++
-		if result.error != nil {
-			failedConnections = append(failedConnections, fmt.Sprintf("%s: %v", result.host, result.error))
-		} else {
-			connectedHosts = append(connectedHosts, result.host)
-		}
+	for completed := 0; completed < len(hosts); {
+		select {
+		case result := <-resultChan:
+			completed++
+			if result.error != nil {
+				connectErrs[slices.Index(hosts, result.host)] = result.error
+				failures++
+			} else {
+				connectedHosts = append(connectedHosts, result.host)
+			}
+			progress.done(result.host, result.error, time.Now())
 
-		// Show progress bar
-		printProgressBar(completed, len(hosts), 20)
+			// Embedders render their own progress, otherwise show the progress line
+			if Hooks.OnHostConnected != nil {
+				Hooks.hostConnected(result.host, result.error)
+			} else {
+				progress.print(time.Now())
+			}
+		case <-ticker.C:
+			if Hooks.OnHostConnected == nil {
+				progress.print(time.Now())
+			}
+		}
 	}
+	wg.Wait()
 
-	// Show any connection failures
-	if len(failedConnections) > 0 {
-		fmt.Printf("⚠️  Failed to establish persistent connections to %d host(s):\n", len(failedConnections))
-		for _, failure := range failedConnections {
-			fmt.Printf("  • %s\n", failure)
+	// Show any connection failures; the ones ssh explained are grouped by cause with a hint
+	if failures > 0 {
+		fmt.Printf("⚠️  Failed to establish persistent connections to %d host(s):\n", failures)
+		for i, err := range connectErrs {
+			var connErr *ConnectionError
+			if err != nil && !errors.As(err, &connErr) {
+				fmt.Printf("  • %s: %v\n", hosts[i], err)
+			}
 		}
+		writeFailureSummary(os.Stdout, hosts, connectErrs)
 		fmt.Println()
 	}
 
+	// Keep the command line order rather than the order connections completed in
+	connectedHosts = slices.DeleteFunc(slices.Clone(hosts), func(host string) bool { return !slices.Contains(connectedHosts, host) })
+
+	// The same machine reachable under several names would run every command twice
+	unique, duplicates := findDuplicateHosts(connectedHosts, connManager.machineIDs(connectedHosts))
+	for _, host := range connectedHosts {
+		first, duplicate := duplicates[host]
+		if !duplicate {
+			continue
+		}
+		if KeepDuplicates {
+			fmt.Printf("⚠️  %s and %s are the same machine, commands will run on it twice\n", host, first)
+			continue
+		}
+		fmt.Printf("⚠️  %s and %s are the same machine, using %s only\n", host, first, first)
+		connManager.disconnect(host)
+	}
+	if !KeepDuplicates {
+		connectedHosts = unique
+	}
+
 	// Check if we have any working connections
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if len(connectedHosts) == 0 {
-		fmt.Println("❌ Error: No hosts are reachable. Exiting.")
-		return
+		return errors.New("no hosts are reachable")
 	}
 
-	if Verbose {
-		fmt.Printf("🚀 Interactive mode - connected to %d/%d host(s)\n", len(connectedHosts), len(hosts))
-	}
+	Log.Info("Interactive mode", "connected", len(connectedHosts), "hosts", len(hosts))
 
-	// Create readline instance
-	prompt := fmt.Sprintf("🖥️ [%d]> ", len(connectedHosts))
-	config := &readline.Config{
-		Prompt: prompt,
-		AutoComplete: &customCompleter{
+	sess := &session{
+		ctx:         ctx,
+		connManager: connManager,
+		hosts:       connectedHosts,
+		user:        user,
+		noColor:     noColor,
+		when:        When,
+		completer: &customCompleter{
 			hosts:   connectedHosts,
 			noColor: noColor,
 			connMgr: connManager,
 		},
-		HistoryFile: os.Getenv("HOME") + "/.gosh_history",
+	}
+
+	// Tunnels of --forward-L and --forward-R
+	for _, spec := range LocalForwards {
+		sess.forward("-L " + spec)
+	}
+	for _, spec := range RemoteForwards {
+		sess.forward("-R " + spec)
+	}
+
+	if GatherFacts {
+		sess.facts("")
+	}
+
+	// Create readline instance
+	sess.history = loadHistory(historyFile())
+	sess.completer.history = sess.history
+	sess.keys = newTerminalInput(os.Stdin)
+	config := &readline.Config{
+		Stdin:        io.NopCloser(sess.keys),
+		Prompt:       sess.prompt(),
+		AutoComplete: sess.completer,
+		Listener:     sess.completer,
+		HistoryFile:  historyFile(),
+		// Commands are saved by recordHistory, after history references are expanded
+		DisableAutoSaveHistory: true,
 	}
 
 	rl, err := readline.NewEx(config)
 	if err != nil {
-		fmt.Printf("⚠️  Failed to initialize readline: %v\n", err)
-		return
+		return fmt.Errorf("failed to initialize readline: %w", err)
 	}
 	defer rl.Close()
+	sess.rl = rl
+	sess.completer.out = rl.Stdout()
+
+	// Cancelling ctx ends a pending Readline like Ctrl+D
+	stopClose := context.AfterFunc(ctx, func() { _ = rl.Close() })
+	defer stopClose()
+
+	// Re-establish control masters that die during long sessions
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	defer stopMonitor()
+	connManager.startHealthMonitor(monitorCtx, healthCheckInterval, rl.Stdout())
 
 	for {
-		line, err := rl.Readline()
-		if err != nil { // EOF or Ctrl+D
-			return
+		line, err := sess.readCommand()
+		if err != nil { // EOF, Ctrl+D or ctx cancelled
+			return ctx.Err()
 		}
 
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
+		if isHistoryReference(line) {
+			if line, err = sess.history.expand(line); err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				continue
+			}
+			fmt.Println(line)
+		}
+		sess.recordHistory(line)
 
 		switch {
 		case line == ":exit" || line == ":quit":
-			return
+			return nil
 		case line == ":help":
 			showHelp()
+		case line == ":?" || strings.HasPrefix(line, ":? "):
+			showPalette(strings.TrimSpace(strings.TrimPrefix(line, ":?")))
 		case line == ":hosts":
-			fmt.Printf("🖥️ Connected hosts (%d):\n", len(connectedHosts))
-			for _, host := range connectedHosts {
-				fmt.Printf("  • %s\n", host)
-			}
-		case strings.HasPrefix(line, ":upload "):
-			filepath := strings.TrimSpace(strings.TrimPrefix(line, ":upload"))
-			if filepath == "" {
-				fmt.Println("📁 Usage: :upload <filepath>")
+			fmt.Printf("🖥️ Connected hosts (%d):\n", len(sess.hosts))
+			for _, host := range sess.hosts {
+				marker := ""
+				if sess.selected != nil && sess.selected[host] {
+					marker = " (selected)"
+				}
+				fmt.Printf("  • %s%s\n", host, marker)
+			}
+		case line == ":upload" || strings.HasPrefix(line, ":upload "):
+			file, dir, ok := transferArgs(strings.TrimSpace(strings.TrimPrefix(line, ":upload")), true)
+			if !ok {
+				fmt.Println("📁 Usage: :upload <file> [remote-dir]")
+				continue
+			}
+			printError(uploadFile(ctx, sess.targetHosts(), file, dir, user, noColor))
+		case line == ":download" || strings.HasPrefix(line, ":download "):
+			remotePath, dir, ok := transferArgs(strings.TrimSpace(strings.TrimPrefix(line, ":download")), false)
+			if !ok {
+				fmt.Println("📁 Usage: :download <remote-path> [local-dir]")
 				continue
 			}
-			uploadFile(connectedHosts, filepath, user, noColor)
+			printError(downloadFile(ctx, sess.targetHosts(), remotePath, dir, user, noColor))
 		case line == ":verbose":
 			Verbose = !Verbose
+			applyLogLevel()
 			status := "disabled"
 			if Verbose {
 				status = "enabled"
 			}
 			fmt.Printf("🔍 Verbose mode %s\n", status)
+		case strings.HasPrefix(line, ":setenv "):
+			editEnv("set " + strings.TrimSpace(strings.TrimPrefix(line, ":setenv")))
+		case line == ":env" || strings.HasPrefix(line, ":env "):
+			editEnv(strings.TrimSpace(strings.TrimPrefix(line, ":env")))
+		case line == ":stdin" || strings.HasPrefix(line, ":stdin "):
+			sess.toggleStdin(strings.TrimSpace(strings.TrimPrefix(line, ":stdin")))
+		case line == ":kill" || strings.HasPrefix(line, ":kill "):
+			fmt.Println("⚠️  No command is running")
+		case line == ":tty" || strings.HasPrefix(line, ":tty "):
+			toggleTTY(strings.TrimSpace(strings.TrimPrefix(line, ":tty")))
+		case line == ":confirm" || strings.HasPrefix(line, ":confirm "):
+			toggleConfirmEach(strings.TrimSpace(strings.TrimPrefix(line, ":confirm")))
+		case line == ":sudo" || strings.HasPrefix(line, ":sudo "):
+			sess.toggleSudo(strings.TrimSpace(strings.TrimPrefix(line, ":sudo")))
+		case line == ":reconnect" || strings.HasPrefix(line, ":reconnect "):
+			sess.reconnectHosts(strings.TrimSpace(strings.TrimPrefix(line, ":reconnect")))
+		case line == ":forward" || strings.HasPrefix(line, ":forward "):
+			sess.forward(strings.TrimPrefix(line, ":forward"))
+		case line == ":socks" || strings.HasPrefix(line, ":socks "):
+			sess.socks(strings.TrimPrefix(line, ":socks"))
+		case line == ":forwards":
+			sess.listForwards()
+		case line == ":unforward" || strings.HasPrefix(line, ":unforward "):
+			sess.unforward(strings.TrimPrefix(line, ":unforward"))
+		case line == ":when" || strings.HasPrefix(line, ":when "):
+			sess.setWhen(strings.TrimPrefix(line, ":when"))
+		case line == ":same" || strings.HasPrefix(line, ":same "):
+			sess.same(strings.TrimPrefix(line, ":same"))
+		case line == ":tag" || strings.HasPrefix(line, ":tag "):
+			sess.editTags(strings.TrimPrefix(line, ":tag"), false)
+		case line == ":untag" || strings.HasPrefix(line, ":untag "):
+			sess.editTags(strings.TrimPrefix(line, ":untag"), true)
+		case line == ":facts" || strings.HasPrefix(line, ":facts "):
+			sess.facts(strings.TrimPrefix(line, ":facts"))
+		case line == ":trust" || strings.HasPrefix(line, ":trust "):
+			sess.trustHost(strings.TrimSpace(strings.TrimPrefix(line, ":trust")))
+		case line == ":status":
+			sess.connManager.printStatus(sess.hosts)
+		case line == ":last":
+			if sess.last == nil {
+				fmt.Println("📋 No command has completed yet")
+				continue
+			}
+			sess.last.show(noColor)
+		case line == ":refresh-completions":
+			sess.connManager.refreshCompletions()
+			fmt.Println("🔄 Command completions will be fetched again")
+		case line == ":retry":
+			sess.retry()
+		case strings.HasPrefix(line, ":add "):
+			for _, host := range strings.Fields(strings.TrimPrefix(line, ":add")) {
+				sess.addHost(host)
+			}
+		case strings.HasPrefix(line, ":remove "):
+			for _, host := range strings.Fields(strings.TrimPrefix(line, ":remove")) {
+				sess.removeHost(host)
+			}
+		case strings.HasPrefix(line, ":port "):
+			args := strings.Fields(strings.TrimPrefix(line, ":port"))
+			if len(args) != 2 {
+				fmt.Println("🔌 Usage: :port <port> <host>")
+				continue
+			}
+			command, err := buildPortCheckCommand(args[1], args[0])
+			if err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				continue
+			}
+			sess.runCommand(command, sess.selected)
+		case line == ":select" || strings.HasPrefix(line, ":select "):
+			sess.selectHosts(strings.TrimSpace(strings.TrimPrefix(line, ":select")))
+		case strings.HasPrefix(line, ":on "):
+			pattern, command, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, ":on")), " ")
+			command = strings.TrimSpace(command)
+			if command == "" {
+				fmt.Println("🎯 Usage: :on <host,pattern,...> <command>")
+				continue
+			}
+			targets := matchHosts(sess.hosts, pattern)
+			if len(targets) == 0 {
+				fmt.Printf("⚠️  No connected hosts match %q\n", pattern)
+				continue
+			}
+			sess.withOutput(expandAlias(command), func(command string) { sess.runCommand(command, targets) })
+		case line == ":script" || strings.HasPrefix(line, ":script "):
+			sess.runScript(strings.TrimPrefix(line, ":script"))
+		case line == ":tail" || strings.HasPrefix(line, ":tail "):
+			sess.withOutput(strings.TrimSpace(strings.TrimPrefix(line, ":tail")), sess.tail)
+		case line == ":watch" || strings.HasPrefix(line, ":watch "):
+			sess.withOutput(strings.TrimPrefix(line, ":watch"), sess.watch)
+		case line == ":history" || strings.HasPrefix(line, ":history "):
+			sess.history.show(strings.TrimSpace(strings.TrimPrefix(line, ":history")))
+		case line == ":alias" || strings.HasPrefix(line, ":alias "):
+			editAliases(strings.TrimSpace(strings.TrimPrefix(line, ":alias")), false)
+		case line == ":unalias" || strings.HasPrefix(line, ":unalias "):
+			editAliases(strings.TrimSpace(strings.TrimPrefix(line, ":unalias")), true)
+		case line == ":cd" || strings.HasPrefix(line, ":cd "):
+			sess.changeDir(strings.TrimSpace(strings.TrimPrefix(line, ":cd")))
 		default:
-			// All commands use streaming output - simple and real-time!
-			// Create a cancellable context for interrupt handling
-			ctx, cancel := context.WithCancel(context.Background())
+			line = expandAlias(line)
+			if dir, ok := plainCd(line); ok {
+				sess.changeDir(dir)
+				continue
+			}
+			if tracked, err := trackEnvCommand(line); tracked {
+				if err != nil {
+					fmt.Printf("❌ Error: %v\n", err)
+				}
+				continue
+			}
+			sess.withOutput(line, func(command string) { sess.runCommand(command, sess.selected) })
+		}
+	}
+}
+
+// session holds the state of an interactive session
+type session struct {
+	ctx         context.Context // Ends the session; nil means it never ends
+	connManager *SSHConnectionManager
+	hosts       []string        // Connected hosts in display order
+	selected    map[string]bool // Hosts restricted by :select, nil targets all hosts
+	user        string
+	noColor     bool
+	completer   *customCompleter
+	rl          *readline.Instance
+	keys        *terminalInput // readline's input, used to end a pending Readline
+	stdin       bool           // Forward lines typed while a command runs to the remote processes
+	job         string         // Token of the running command, empty when idle
+	jobHosts    []string       // Hosts the running command was started on
+	history     *commandHistory
+	last        *lastResult // Outcome of the last command, nil if none completed
+	when        string      // Guard command set by :when, commands only run where it succeeds
+}
+
+// context returns the context commands of the session run under
+func (s *session) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// printError prints err unless it only reports hosts whose failure was already shown with their output
+func printError(err error) {
+	var hostErr *HostError
+	if err == nil || errors.As(err, &hostErr) {
+		return
+	}
+	fmt.Printf("❌ Error: %v\n", err)
+}
+
+// setHosts replaces the connected host list and refreshes everything derived from it
+func (s *session) setHosts(hosts []string) {
+	s.hosts = hosts
+	if s.completer != nil {
+		s.completer.hosts = hosts
+	}
+	if s.rl != nil {
+		s.rl.SetPrompt(s.prompt())
+	}
+}
+
+// addHost establishes a persistent connection to a new host and adds it to the session
+func (s *session) addHost(host string) {
+	if slices.Contains(s.hosts, host) {
+		fmt.Printf("⚠️  %s is already connected\n", host)
+		return
+	}
+
+	if err := s.connManager.establishConnection(s.context(), host); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	// A new host is part of an active selection so it isn't silently skipped
+	if s.selected != nil {
+		s.selected[host] = true
+	}
+	s.setHosts(append(slices.Clone(s.hosts), host))
+	prefix := formatHostPrefix(host, len(s.hosts)-1, len(host), s.noColor)
+	fmt.Printf("%s: ✅ Connected (%d host(s) total)\n", prefix, len(s.hosts))
+}
+
+// reconnectHosts re-establishes the persistent connections of the hosts matching pattern; "" or "all" reconnects every host
+func (s *session) reconnectHosts(pattern string) {
+	targets := s.hosts
+	if pattern != "" && pattern != "all" {
+		matched := matchHosts(s.hosts, pattern)
+		targets = slices.DeleteFunc(slices.Clone(s.hosts), func(host string) bool { return !matched[host] })
+	}
+	if len(targets) == 0 {
+		fmt.Printf("⚠️  No connected hosts match %q\n", pattern)
+		return
+	}
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, host := range targets {
+		wg.Go(func() {
+			errs[i] = s.connManager.reconnect(s.context(), host)
+		})
+	}
+	wg.Wait()
+
+	maxHostLen := maxLen(s.hosts)
+	for i, host := range targets {
+		prefix := formatHostPrefix(host, slices.Index(s.hosts, host), maxHostLen, s.noColor)
+		if errs[i] != nil {
+			fmt.Printf("%s: ❌ %v\n", prefix, errs[i])
+		} else {
+			fmt.Printf("%s: ↻ reconnected\n", prefix)
+		}
+	}
+}
+
+// removeHost closes the persistent connection to a host and removes it from the session
+func (s *session) removeHost(host string) {
+	idx := slices.Index(s.hosts, host)
+	if idx < 0 {
+		fmt.Printf("⚠️  %s is not connected\n", host)
+		return
+	}
 
-			// Set up signal handling for Ctrl+C
-			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, os.Interrupt)
+	s.connManager.disconnect(host)
+	s.connManager.setWorkDir(host, "")
+	s.connManager.dropForwards(host)
+	s.connManager.dropFacts(host)
+	delete(s.selected, host)
+	emptied := s.selected != nil && len(s.selected) == 0
+	if emptied {
+		s.selected = nil // An empty selection would target no host at all, not every host
+	}
+	s.setHosts(slices.Delete(slices.Clone(s.hosts), idx, idx+1))
+	fmt.Printf("🔌 Disconnected %s (%d host(s) left)\n", host, len(s.hosts))
+	if emptied {
+		fmt.Printf("🎯 No selected host left, selected all %d host(s)\n", len(s.hosts))
+	}
+}
 
-			// Goroutine to handle interrupt signal
-			go func() {
-				<-sigChan
-				fmt.Println("\n🛑 Command interrupted by user")
-				cancel()
-			}()
+// prompt returns the prompt for the current host selection. The result of the last command replaces the
+// host count while it ran on exactly the targeted hosts, e.g. "🖥️ [18✓ 2✗]>".
+func (s *session) prompt() string {
+	result := ""
+	if s.last != nil && slices.Equal(s.last.hosts, s.targetHosts()) {
+		result = s.last.summary()
+	}
+	switch {
+	case s.selected == nil && result == "":
+		return buildPrompt(len(s.hosts), s.noColor)
+	case s.selected == nil:
+		return CurrentProfile.colorize("🖥️ ["+result+"]>", s.noColor) + " "
+	case result == "":
+		return CurrentProfile.colorize(fmt.Sprintf("🖥️ [%d/%d]>", len(s.selected), len(s.hosts)), s.noColor) + " "
+	default:
+		return CurrentProfile.colorize(fmt.Sprintf("🖥️ [%d/%d %s]>", len(s.selected), len(s.hosts), result), s.noColor) + " "
+	}
+}
 
-			// Execute command with interruptible context
-			executeCommandStreaming(ctx, connManager, connectedHosts, line, noColor)
+// targetHosts returns the hosts subsequent commands run on, in display order
+func (s *session) targetHosts() []string {
+	if s.selected == nil {
+		return s.hosts
+	}
+	var targets []string
+	for _, host := range s.hosts {
+		if s.selected[host] {
+			targets = append(targets, host)
+		}
+	}
+	return targets
+}
+
+// selectHosts restricts subsequent commands to the hosts matching pattern; "all" resets the selection
+func (s *session) selectHosts(pattern string) {
+	switch pattern {
+	case "":
+		fmt.Println("🎯 Usage: :select <host,pattern,...> | :select all")
+		return
+	case "all":
+		s.selected = nil
+		fmt.Printf("🎯 Selected all %d host(s)\n", len(s.hosts))
+	default:
+		targets := matchHosts(s.hosts, pattern)
+		if len(targets) == 0 {
+			fmt.Printf("⚠️  No connected hosts match %q\n", pattern)
+			return
+		}
+		s.selected = targets
+		fmt.Printf("🎯 Selected %d/%d host(s)\n", len(targets), len(s.hosts))
+	}
+
+	if s.rl != nil {
+		s.rl.SetPrompt(s.prompt())
+	}
+}
+
+// toggleStdin turns forwarding of typed lines to running commands on or off
+func (s *session) toggleStdin(mode string) {
+	switch mode {
+	case "on":
+		s.stdin = true
+		fmt.Println("⌨️  Lines typed while a command runs are sent to all hosts (Ctrl+D sends EOF, Ctrl+C interrupts)")
+		if Sudo {
+			fmt.Println("⚠️  Not while sudo mode is on, the sudo password uses stdin")
+		}
+	case "off":
+		s.stdin = false
+		fmt.Println("⌨️  Stdin forwarding disabled")
+	default:
+		fmt.Println("⌨️  Usage: :stdin on|off")
+	}
+}
 
-			// Clean up
+// monitorInput reads lines from the terminal while a command runs. ":kill [host]" terminates the command,
+// other lines become the command's input with :stdin on; input is nil otherwise.
+// The terminal is in raw mode meanwhile, so Ctrl+C arrives as a key rather than a signal and calls interrupt.
+// stop ends monitoring and must be called before the prompt is used again.
+func (s *session) monitorInput(interrupt func()) (input io.Reader, stop func()) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	// The sudo password owns stdin
+	if s.stdin && !Sudo {
+		input = pr
+	}
+
+	// stop injects Ctrl+C to end the pending Readline unless the reader already left after Ctrl+D;
+	// once stopping is set the reader stays until it has consumed that key
+	var mu sync.Mutex
+	stopping, exited := false, false
+
+	s.rl.HistoryDisable() // Answers to remote prompts may be secrets
+	s.rl.SetPrompt("")
+	go func() {
+		defer close(done)
+		for {
+			line, err := s.rl.Readline()
+			mu.Lock()
+			isStopping := stopping
+			mu.Unlock()
+
+			switch {
+			case errors.Is(err, readline.ErrInterrupt) && isStopping:
+				return
+			case errors.Is(err, readline.ErrInterrupt):
+				interrupt()
+			case err != nil: // Ctrl+D ends the input, or the terminal is gone
+				_ = pw.Close()
+				mu.Lock()
+				exited = !stopping || s.keys.isClosed()
+				mu.Unlock()
+				if exited {
+					return
+				}
+			case strings.TrimSpace(line) == ":kill" || strings.HasPrefix(strings.TrimSpace(line), ":kill "):
+				s.killJob(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ":kill")))
+			case input != nil:
+				_, _ = io.WriteString(pw, line+"\n")
+			default:
+				fmt.Println("⏳ A command is running: :kill [host] terminates it, :stdin on forwards input")
+			}
+		}
+	}()
+
+	return input, func() {
+		_ = pw.Close()
+		mu.Lock()
+		stopping = true
+		inject := !exited
+		mu.Unlock()
+		if inject && s.keys.inject([]byte{readline.CharInterrupt}) {
+			<-done
+		}
+		s.rl.HistoryEnable()
+		s.rl.SetPrompt(s.prompt())
+	}
+}
+
+// toggleSudo turns sudo mode on or off, asking for the password the first time it is enabled
+func (s *session) toggleSudo(mode string) {
+	switch mode {
+	case "on":
+		if sudoPassword == nil {
+			password, err := s.rl.ReadPassword(sudoPrompt)
+			if err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				return
+			}
+			if err := setSudoPassword(password); err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				return
+			}
+		}
+		Sudo = true
+		fmt.Println("🔑 Sudo mode enabled")
+	case "off":
+		Sudo = false
+		fmt.Println("🔑 Sudo mode disabled")
+	default:
+		fmt.Println("🔑 Usage: :sudo on|off")
+	}
+}
+
+// runCommand executes a command on the target hosts with Ctrl+C interrupt handling; nil targets all hosts
+func (s *session) runCommand(command string, targets map[string]bool) {
+	if !s.confirm(command, len(s.targetHostsOf(targets))) {
+		return
+	}
+	s.run(command, targets, nil)
+}
+
+// runScript streams a local script into an interpreter on the targeted hosts
+func (s *session) runScript(args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		fmt.Println("📜 Usage: :script <file> [args...]")
+		return
+	}
+	if Sudo {
+		fmt.Println("⚠️  :script needs stdin, which sudo mode uses for the password")
+		return
+	}
+
+	command, content, err := ScriptCommand(fields[0], fields[1:])
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	if !s.confirm(string(content), len(s.targetHosts())) {
+		return
+	}
+	if !s.run(command, s.selected, content) {
+		s.last.input = content
+	}
+}
+
+// run executes a command on the targeted hosts and reports whether it was interrupted. input is sent to
+// every host; when it is nil, typed lines are forwarded instead with :stdin on. With ConfirmEach the
+// user is asked before each host.
+func (s *session) run(command string, targets map[string]bool, input []byte) (interrupted bool) {
+	// All commands use streaming output - simple and real-time!
+	// Create a cancellable context for interrupt handling
+	ctx, cancel := context.WithCancel(s.context())
+	s.job = newJobToken()
+	s.jobHosts = s.targetHostsOf(targets)
+	ctx = withJob(ctx, s.job)
+
+	// Ctrl+C also terminates the remote processes, which keep running without a terminal otherwise.
+	// Cancelling first keeps their exit status from being reported as a failure.
+	var once sync.Once
+	interrupt := func() {
+		once.Do(func() {
+			interrupted = true
+			fmt.Println("\n🛑 Command interrupted by user")
 			cancel()
-			signal.Stop(sigChan)
+			s.killJob("")
+		})
+	}
+
+	// Set up signal handling for Ctrl+C
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+
+	// Goroutine to handle interrupt signal
+	go func() {
+		select {
+		case <-sigChan:
+			interrupt()
+		case <-ctx.Done():
 		}
+	}()
+
+	// Execute command with interruptible context
+	var durations *hostDurations
+	if ShowDuration {
+		ctx, durations = withDurations(ctx)
+	}
+
+	// Hosts where the guard of :when fails are skipped, the ones it couldn't run on count as failed
+	var skipped []string
+	var guardErrs []error
+	if s.when != "" {
+		var pass map[string]bool
+		pass, skipped, guardErrs = s.checkGuard(ctx, targets)
+		s.jobHosts = s.targetHostsOf(targetsWithout(s.hosts, targets, skipped))
+		targets = pass
 	}
+	execute := func(targets map[string]bool) []error {
+		// Watch the terminal for :kill, Ctrl+C and input to forward
+		var stdin io.Reader
+		stopMonitor := func() {}
+		if s.rl != nil && s.keys != nil {
+			stdin, stopMonitor = s.monitorInput(interrupt)
+		}
+		defer stopMonitor()
+		if input != nil {
+			stdin = bytes.NewReader(input)
+		}
+		return executeCommandStreaming(ctx, s.connManager, s.hosts, targets, command, stdin, s.noColor)
+	}
+	var errs []error
+	if ConfirmEach {
+		errs = s.confirmEach(ctx, targets, execute)
+	} else {
+		errs = execute(targets)
+	}
+	if guardErrs != nil {
+		errs = mergeGuardErrors(errs, guardErrs)
+		flushOutput()
+		printSkipped(os.Stdout, s.when, skipped)
+	}
+
+	// Clean up
+	cancel()
+	signal.Stop(sigChan)
+	once.Do(func() {}) // Waits for an interrupt in progress, later ones have nothing left to stop
+
+	// Interrupted commands have no meaningful result
+	s.last = nil
+	if !interrupted {
+		s.last = newLastResult(command, slices.Clone(s.hosts), s.jobHosts, errs)
+		if durations != nil {
+			durations.printSlowest(os.Stdout)
+		}
+	}
+	if s.rl != nil {
+		s.rl.SetPrompt(s.prompt())
+	}
+	s.job, s.jobHosts = "", nil
+	return interrupted
 }
 
-// showHelp displays help information
-func showHelp() {
-	fmt.Println("📚 Commands:")
-	fmt.Println("  :help            - Show this help")
-	fmt.Println("  :upload <file>   - Upload file to all hosts (current directory)")
-	fmt.Println("  :exit/:quit      - Exit interactive mode")
-	fmt.Println("  :hosts       	- List connected hosts")
-	fmt.Println("  :verbose         - Toggle verbose output mode")
-	fmt.Println("  <command>        - Execute command on all connected hosts")
-	fmt.Println()
-	fmt.Println("💡 Examples:")
-	fmt.Println("  date            - Show date/time on all connected hosts")
-	fmt.Println("  uptime          - Show uptime on all connected hosts")
-	fmt.Println("  ls -la          - List files on all connected hosts")
-	fmt.Println("  :upload script.sh - Upload script.sh to all connected hosts")
+// changeDir changes the working directory of the targeted hosts for subsequent commands
+func (s *session) changeDir(dir string) {
+	hosts := s.targetHosts()
+	if len(hosts) == 0 {
+		return
+	}
+	dirs := make([]string, len(hosts))
+	errs := make([]error, len(hosts))
+
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Go(func() {
+			dirs[i], errs[i] = s.connManager.changeDir(host, dir)
+		})
+	}
+	wg.Wait()
+
+	// Most of the time every host ends up in the same place
+	maxHostLen := maxLen(s.hosts)
+	uniform := true
+	for i, host := range hosts {
+		if errs[i] != nil {
+			fmt.Printf("%s: ❌ %v\n", formatHostPrefix(host, slices.Index(s.hosts, host), maxHostLen, s.noColor), errs[i])
+			uniform = false
+		} else if dirs[i] != dirs[0] {
+			uniform = false
+		}
+	}
+	if uniform {
+		fmt.Printf("📂 %s\n", dirs[0])
+		return
+	}
+	for i, host := range hosts {
+		if errs[i] == nil {
+			fmt.Printf("%s: 📂 %s\n", formatHostPrefix(host, slices.Index(s.hosts, host), maxHostLen, s.noColor), dirs[i])
+		}
+	}
+}
+
+// targetHostsOf returns the hosts in targets in display order, all hosts for nil
+func (s *session) targetHostsOf(targets map[string]bool) []string {
+	if targets == nil {
+		return s.hosts
+	}
+	var hosts []string
+	for _, host := range s.hosts {
+		if targets[host] {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
 }
 
-// printProgressBar displays a simple text-based progress bar
-func printProgressBar(current, total int, width int) {
-	if total == 0 {
+// killJob terminates the running command on the hosts matching pattern, or on all of its hosts for ""
+func (s *session) killJob(pattern string) {
+	if s.job == "" {
+		fmt.Println("⚠️  No command is running")
 		return
 	}
 
-	percentage := float64(current) / float64(total)
-	filled := int(float64(width) * percentage)
+	hosts := s.jobHosts
+	if pattern != "" {
+		matched := matchHosts(hosts, pattern)
+		if len(matched) == 0 {
+			fmt.Printf("⚠️  The running command has no hosts matching %q\n", pattern)
+			return
+		}
+		hosts = s.targetHostsOf(matched)
+	}
 
-	bar := ""
-	for i := range width {
-		if i < filled {
-			bar += "█"
-		} else {
-			bar += "░"
+	// Positions come from the full host list so colors match the command's output
+	maxHostLen := maxLen(s.hosts)
+	command := killJobCommand(s.job)
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		if s.connManager.shellOf(host) == shellRestricted {
+			prefix := formatHostPrefix(host, slices.Index(s.hosts, host), maxHostLen, s.noColor)
+			fmt.Printf("%s: ⚠️  :kill is not supported in a restricted shell\n", prefix)
+			continue
+		}
+		wg.Go(func() {
+			s.connManager.runSSHStreaming(context.Background(), host, command, nil, slices.Index(s.hosts, host), maxHostLen, s.noColor)
+		})
+	}
+	wg.Wait()
+}
+
+// matchHosts returns the hosts matching a comma-separated list of names, glob patterns or tags like
+// @role=web, see HostTags
+func matchHosts(hosts []string, pattern string) map[string]bool {
+	matched := make(map[string]bool)
+	for _, item := range strings.Split(pattern, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		tag, byTag := strings.CutPrefix(item, "@")
+		for _, host := range hosts {
+			ok := byTag && hasTag(host, tag)
+			if !byTag {
+				globbed, _ := path.Match(item, host)
+				ok = globbed || item == host
+			}
+			if ok {
+				matched[host] = true
+			}
 		}
 	}
+	return matched
+}
 
-	fmt.Printf("\r[%s] %d/%d (%.1f%%)", bar, current, total, percentage*100)
-	if current == total {
-		fmt.Println() // New line when complete
+// toggleTTY turns pseudo-terminal allocation for remote commands on or off
+func toggleTTY(mode string) {
+	switch mode {
+	case "on":
+		TTY = true
+		fmt.Println("🖵 Commands run with a pseudo-terminal (stderr is merged into stdout)")
+	case "off":
+		TTY = false
+		fmt.Println("🖵 Commands run without a pseudo-terminal")
+	default:
+		fmt.Println("🖵 Usage: :tty on|off")
 	}
 }
+
+// editEnv lists, sets or unsets the variables exported into remote commands.
+// Everything after "set" is a single assignment, so values may contain spaces.
+func editEnv(args string) {
+	action, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	switch {
+	case action == "":
+		if len(Env) == 0 {
+			fmt.Println("🌱 No variables set")
+		}
+		for _, assignment := range Env {
+			fmt.Printf("  %s\n", assignment)
+		}
+	case action == "set" && rest != "":
+		if err := SetEnv(rest); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+		}
+	case action == "unset" && rest != "":
+		for _, key := range strings.Fields(rest) {
+			if !UnsetEnv(key) {
+				fmt.Printf("⚠️  %s is not set\n", key)
+			}
+		}
+	default:
+		fmt.Println("🌱 Usage: :env [set KEY=VALUE | unset KEY ...]")
+	}
+}
+
+// buildPrompt returns the interactive prompt, colored by the current profile
+func buildPrompt(hostCount int, noColor bool) string {
+	return CurrentProfile.colorize(fmt.Sprintf("🖥️ [%d]>", hostCount), noColor) + " "
+}
+
+// showHelp displays help information
+func showHelp() {
+	fmt.Println("📚 Commands:")
+	printCommands(append(slices.Clone(builtinCommands), commandInfo{"<command>", "", "Execute command on all connected hosts"}))
+	fmt.Println("  Type :? <query> to search commands, aliases and keybindings")
+	fmt.Println()
+	fmt.Println("💡 Examples:")
+	fmt.Println("  date            - Show date/time on all connected hosts")
+	fmt.Println("  uptime          - Show uptime on all connected hosts")
+	fmt.Println("  ls -la          - List files on all connected hosts")
+	fmt.Println("  :upload script.sh - Upload script.sh to all connected hosts")
+}
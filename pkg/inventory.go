@@ -0,0 +1,235 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultGroupsFile returns the default location of the host groups file
+func DefaultGroupsFile() string {
+	return filepath.Join(homeDir(), ".gosh_groups")
+}
+
+// LoadGroups reads host group definitions from a file.
+// Each non-empty line has the form "name: host1 host2 @othergroup"; lines starting with # are comments.
+// A missing file yields an empty set of groups.
+func LoadGroups(path string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+
+	file, err := os.Open(path) // #nosec G304 -- groups file path is chosen by the local user
+	if errors.Is(err, os.ErrNotExist) {
+		return groups, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open groups file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, members, found := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"name: host ...\"", path, lineNo)
+		}
+		groups[name] = append(groups[name], strings.Fields(members)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read groups file %s: %w", path, err)
+	}
+
+	return groups, nil
+}
+
+// ResolveHosts evaluates host selectors from left to right and returns the resulting host list.
+// A selector is a host name, "@group" or a catalog lookup ("@consul:service", "@etcd:/key/prefix/", "@k8s/namespace/labels"),
+// optionally prefixed with "+" (add) or "-"/"!" (remove).
+// For example "@prod-web -@canary +db7" selects all prod-web hosts except canaries, plus db7.
+func ResolveHosts(selectors []string, groups map[string][]string) ([]string, error) {
+	var hosts []string
+	selected := make(map[string]bool)
+
+	for _, selector := range selectors {
+		remove := false
+		switch {
+		case strings.HasPrefix(selector, "+"):
+			selector = selector[1:]
+		case strings.HasPrefix(selector, "-"), strings.HasPrefix(selector, "!"):
+			selector = selector[1:]
+			remove = true
+		}
+		if selector == "" {
+			continue
+		}
+
+		members, err := expandSelector(selector, groups, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, host := range members {
+			switch {
+			case remove:
+				delete(selected, host)
+			case !selected[host]:
+				selected[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	// Drop removed hosts while keeping the original order
+	result := make([]string, 0, len(selected))
+	for _, host := range hosts {
+		if selected[host] {
+			result = append(result, host)
+			delete(selected, host) // a host removed and re-added appears only once
+		}
+	}
+
+	return result, nil
+}
+
+// expandSelector expands a single host, @group or catalog selector into host names
+func expandSelector(selector string, groups map[string][]string, visiting map[string]bool) ([]string, error) {
+	if !strings.HasPrefix(selector, "@") {
+		return []string{selector}, nil
+	}
+
+	name := selector[1:]
+	if hosts, found, err := discoverHosts(name); found {
+		return hosts, err
+	}
+	members, ok := groups[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown host group %q", name)
+	}
+	if visiting[name] {
+		return nil, fmt.Errorf("host group %q references itself", name)
+	}
+
+	if visiting == nil {
+		visiting = make(map[string]bool)
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	var hosts []string
+	for _, member := range members {
+		expanded, err := expandSelector(member, groups, visiting)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+
+	return hosts, nil
+}
+
+// FilterHosts keeps the hosts matching any limit pattern (all hosts without limits) and drops those matching
+// any exclude pattern. Patterns are globs like "web[0-9]*", or regular expressions between slashes like "/^db-(eu|us)/".
+func FilterHosts(hosts, limit, exclude []string) ([]string, error) {
+	limitMatch, err := hostMatcher(limit)
+	if err != nil {
+		return nil, fmt.Errorf("--limit: %w", err)
+	}
+	excludeMatch, err := hostMatcher(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("--exclude: %w", err)
+	}
+
+	var kept []string
+	for _, host := range hosts {
+		if (len(limit) == 0 || limitMatch(host)) && !excludeMatch(host) {
+			kept = append(kept, host)
+		}
+	}
+	return kept, nil
+}
+
+// hostMatcher compiles glob and /regex/ patterns into a function reporting whether a host matches any of them
+func hostMatcher(patterns []string) (func(host string) bool, error) {
+	var globs []string
+	var regexps []*regexp.Regexp
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, "/"); ok && len(expr) > 0 && strings.HasSuffix(expr, "/") {
+			re, err := regexp.Compile(strings.TrimSuffix(expr, "/"))
+			if err != nil {
+				return nil, err
+			}
+			regexps = append(regexps, re)
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		globs = append(globs, pattern)
+	}
+
+	return func(host string) bool {
+		for _, glob := range globs {
+			if ok, _ := path.Match(glob, host); ok {
+				return true
+			}
+		}
+		return slices.ContainsFunc(regexps, func(re *regexp.Regexp) bool { return re.MatchString(host) })
+	}, nil
+}
+
+// SampleHosts picks n random hosts, keeping their order, or all hosts for n <= 0. With shuffle the
+// result is in random order, so rolling operations don't always start with the same hosts.
+func SampleHosts(hosts []string, n int, shuffle bool) []string {
+	result := slices.Clone(hosts)
+	if n > 0 && n < len(result) {
+		// Drop random hosts instead of picking some, which keeps the picked ones in order
+		for len(result) > n {
+			i := rand.IntN(len(result)) // #nosec G404 -- host sampling needs no cryptographic randomness
+			result = slices.Delete(result, i, i+1)
+		}
+	}
+	if shuffle {
+		rand.Shuffle(len(result), func(i, j int) { result[i], result[j] = result[j], result[i] })
+	}
+	return result
+}
+
+// KeepDuplicates keeps hosts that turn out to be the same machine instead of merging them
+var KeepDuplicates bool
+
+// findDuplicateHosts groups hosts by machine identifier. It returns the hosts to keep (the first name of
+// each machine, in order) and maps every duplicate name to the name it was merged into.
+func findDuplicateHosts(hosts []string, machineIDs map[string]string) (unique []string, duplicates map[string]string) {
+	duplicates = make(map[string]string)
+	firstByID := make(map[string]string)
+
+	for _, host := range hosts {
+		id := machineIDs[host]
+		if id == "" {
+			unique = append(unique, host)
+			continue
+		}
+		if first, seen := firstByID[id]; seen {
+			duplicates[host] = first
+			continue
+		}
+		firstByID[id] = host
+		unique = append(unique, host)
+	}
+
+	return unique, duplicates
+}
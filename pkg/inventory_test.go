@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadGroups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups")
+	content := "# fleet\nprod-web: web1 web2 web3\ncanary: web3\n\nprod: @prod-web db7\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := LoadGroups(path)
+	if err != nil {
+		t.Fatalf("LoadGroups failed: %v", err)
+	}
+	if !slices.Equal(groups["prod-web"], []string{"web1", "web2", "web3"}) {
+		t.Errorf("unexpected prod-web group: %v", groups["prod-web"])
+	}
+
+	// A missing file is not an error
+	groups, err = LoadGroups(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(groups) != 0 {
+		t.Errorf("expected empty groups for missing file, got %v, %v", groups, err)
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	groups := map[string][]string{
+		"prod-web": {"web1", "web2", "web3"},
+		"canary":   {"web3"},
+		"prod":     {"@prod-web", "db7"},
+		"loop":     {"@loop"},
+	}
+
+	tests := []struct {
+		name      string
+		selectors []string
+		expected  []string
+		wantErr   bool
+	}{
+		{"plain hosts", []string{"a", "b", "a"}, []string{"a", "b"}, false},
+		{"group minus group plus host", []string{"@prod-web", "!@canary", "+db7"}, []string{"web1", "web2", "db7"}, false},
+		{"dash removal", []string{"@prod", "-web1"}, []string{"web2", "web3", "db7"}, false},
+		{"re-add after removal", []string{"@prod-web", "-@canary", "web3"}, []string{"web1", "web2", "web3"}, false},
+		{"unknown group", []string{"@nope"}, nil, true},
+		{"self reference", []string{"@loop"}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := ResolveHosts(test.selectors, groups)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", hosts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(hosts, test.expected) {
+				t.Errorf("ResolveHosts(%v) = %v, expected %v", test.selectors, hosts, test.expected)
+			}
+		})
+	}
+}
+
+func TestFindDuplicateHosts(t *testing.T) {
+	hosts := []string{"web1", "web1.example.com", "10.0.0.5", "db1", "legacy"}
+	ids := map[string]string{
+		"web1":             "abc",
+		"web1.example.com": "abc",
+		"10.0.0.5":         "abc",
+		"db1":              "def",
+	}
+
+	unique, duplicates := findDuplicateHosts(hosts, ids)
+	if !slices.Equal(unique, []string{"web1", "db1", "legacy"}) {
+		t.Errorf("unexpected unique hosts: %v", unique)
+	}
+	if len(duplicates) != 2 || duplicates["web1.example.com"] != "web1" || duplicates["10.0.0.5"] != "web1" {
+		t.Errorf("unexpected duplicates: %v", duplicates)
+	}
+}
+
+func TestFilterHosts(t *testing.T) {
+	hosts := []string{"web1", "web2", "web10", "db1", "db-eu1"}
+	tests := []struct {
+		name           string
+		limit, exclude []string
+		expected       []string
+	}{
+		{"no filters", nil, nil, hosts},
+		{"limit glob", []string{"web[0-9]"}, nil, []string{"web1", "web2"}},
+		{"exclude glob", nil, []string{"db*"}, []string{"web1", "web2", "web10"}},
+		{"limit and exclude", []string{"web*", "db1"}, []string{"/0$/"}, []string{"web1", "web2", "db1"}},
+		{"regex", []string{"/^db-(eu|us)/"}, nil, []string{"db-eu1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filtered, err := FilterHosts(hosts, test.limit, test.exclude)
+			if err != nil || !slices.Equal(filtered, test.expected) {
+				t.Errorf("got %v, %v, expected %v", filtered, err, test.expected)
+			}
+		})
+	}
+
+	if _, err := FilterHosts(hosts, []string{"/(/"}, nil); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+	if _, err := FilterHosts(hosts, nil, []string{"web["}); err == nil {
+		t.Error("expected an error for an invalid glob")
+	}
+}
+
+func TestSampleHosts(t *testing.T) {
+	hosts := []string{"h1", "h2", "h3", "h4", "h5", "h6"}
+
+	sample := SampleHosts(hosts, 3, false)
+	if len(sample) != 3 || !slices.IsSortedFunc(sample, func(a, b string) int { return slices.Index(hosts, a) - slices.Index(hosts, b) }) {
+		t.Errorf("expected 3 hosts in their original order, got %v", sample)
+	}
+	if sample := SampleHosts(hosts, 10, false); !slices.Equal(sample, hosts) {
+		t.Errorf("expected all hosts when sampling more than there are, got %v", sample)
+	}
+
+	shuffled := SampleHosts(hosts, 0, true)
+	slices.Sort(shuffled)
+	if !slices.Equal(shuffled, hosts) {
+		t.Errorf("expected shuffling to keep every host, got %v", shuffled)
+	}
+}
@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// jobKey is the context key carrying the job token of a running interactive command
+type jobKey struct{}
+
+// withJob marks commands started with ctx as part of the job identified by token
+func withJob(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, jobKey{}, token)
+}
+
+// jobFrom returns the job token carried by ctx, empty if there is none
+func jobFrom(ctx context.Context) string {
+	token, _ := ctx.Value(jobKey{}).(string)
+	return token
+}
+
+// newJobToken returns a random identifier for a command run
+func newJobToken() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobPIDFile returns the remote file recording the process group of a job, expanded by the remote shell
+func jobPIDFile(token string) string {
+	return `"${TMPDIR:-/tmp}/gosh-` + token + `.pid"`
+}
+
+// trackJob records the remote shell's PID, which sshd makes a process group leader, so killJobCommand
+// can terminate the command with everything it started
+func trackJob(command, token string) string {
+	file := jobPIDFile(token)
+	return "echo $$ > " + file + "; " + command + "\nrc=$?; rm -f " + file + "; exit $rc"
+}
+
+// killJobCommand returns a remote command that terminates the process group of a tracked job
+func killJobCommand(token string) string {
+	file := jobPIDFile(token)
+	return "if [ -f " + file + " ] && kill -TERM -$(cat " + file + ") 2>/dev/null; then echo '🛑 killed'; " +
+		"else echo 'not running'; fi; rm -f " + file
+}
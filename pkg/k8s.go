@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// AllNodes selects every node of the cluster when passed as label selector to KubernetesNodes
+const AllNodes = "*"
+
+// kubeNodeList is the part of "kubectl get nodes -o json" gosh needs
+type kubeNodeList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Addresses []struct {
+				Type    string `json:"type"`
+				Address string `json:"address"`
+			} `json:"addresses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// KubernetesNodes lists the nodes matching a label selector (AllNodes for all) in the current kubeconfig
+// context and returns one address of the given type per node, e.g. InternalIP, ExternalIP or Hostname.
+// Nodes without such an address are targeted by name. It uses kubectl, so authentication plugins and
+// $KUBECONFIG work as for kubectl itself.
+func KubernetesNodes(ctx context.Context, selector, addressType string) ([]string, error) {
+	args := []string{"get", "nodes", "-o", "json"}
+	if selector != AllNodes && selector != "" {
+		args = append(args, "-l", selector)
+	}
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	stdout, stderr, err := runCmdWithSeparateOutput(cmd)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("kubectl is not installed")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kubectl get nodes: %s", strings.TrimSpace(stderr))
+	}
+	return parseNodeAddresses([]byte(stdout), addressType)
+}
+
+// parseNodeAddresses picks one address of addressType per node from a kubectl node list
+func parseNodeAddresses(data []byte, addressType string) ([]string, error) {
+	var list kubeNodeList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unexpected kubectl output: %w", err)
+	}
+
+	hosts := make([]string, 0, len(list.Items))
+	for _, node := range list.Items {
+		host := node.Metadata.Name
+		for _, address := range node.Status.Addresses {
+			if strings.EqualFold(address.Type, addressType) {
+				host = address.Address
+				break
+			}
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// kubePodList is the part of "kubectl get pods -o json" gosh needs
+type kubePodList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+// KubernetesPods lists the running pods matching a label selector (all pods for "") in namespace, or in
+// the namespace of the current kubeconfig context for "". Pods of an explicit namespace are returned as
+// "namespace/pod", the form the kubectl backend accepts.
+func KubernetesPods(ctx context.Context, namespace, selector string) ([]string, error) {
+	args := []string{"get", "pods", "-o", "json", "--field-selector=status.phase=Running"}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	if selector != "" {
+		args = append(args, "-l", selector)
+	}
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	stdout, stderr, err := runCmdWithSeparateOutput(cmd)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("kubectl is not installed")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kubectl get pods: %s", strings.TrimSpace(stderr))
+	}
+
+	var list kubePodList
+	if err := json.Unmarshal([]byte(stdout), &list); err != nil {
+		return nil, fmt.Errorf("unexpected kubectl output: %w", err)
+	}
+	pods := make([]string, 0, len(list.Items))
+	for _, pod := range list.Items {
+		if namespace != "" {
+			pods = append(pods, pod.Metadata.Namespace+"/"+pod.Metadata.Name)
+		} else {
+			pods = append(pods, pod.Metadata.Name)
+		}
+	}
+	return pods, nil
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const kubeNodesJSON = `{"items": [
+	{"metadata": {"name": "node-a"}, "status": {"addresses": [{"type": "InternalIP", "address": "10.0.0.1"}, {"type": "Hostname", "address": "node-a"}]}},
+	{"metadata": {"name": "node-b"}, "status": {"addresses": [{"type": "Hostname", "address": "node-b.local"}]}}
+]}`
+
+func TestParseNodeAddresses(t *testing.T) {
+	hosts, err := parseNodeAddresses([]byte(kubeNodesJSON), "InternalIP")
+	if err != nil || !slices.Equal(hosts, []string{"10.0.0.1", "node-b"}) {
+		t.Errorf("got %q, %v", hosts, err)
+	}
+	hosts, _ = parseNodeAddresses([]byte(kubeNodesJSON), "hostname")
+	if !slices.Equal(hosts, []string{"node-a", "node-b.local"}) {
+		t.Errorf("got %q", hosts)
+	}
+	if _, err := parseNodeAddresses([]byte("error"), "InternalIP"); err == nil {
+		t.Error("expected an error for invalid output")
+	}
+}
+
+func TestKubernetesNodes(t *testing.T) {
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\ncat <<'EOF'\n" + kubeNodesJSON + "\nEOF\n"
+	if err := os.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	hosts, err := KubernetesNodes(context.Background(), "role=worker", "InternalIP")
+	if err != nil || len(hosts) != 2 {
+		t.Fatalf("got %q, %v", hosts, err)
+	}
+	if got, _ := os.ReadFile(args); string(got) != "get nodes -o json -l role=worker\n" {
+		t.Errorf("unexpected kubectl arguments: %q", got)
+	}
+
+	_, _ = KubernetesNodes(context.Background(), AllNodes, "InternalIP")
+	if got, _ := os.ReadFile(args); string(got) != "get nodes -o json\n" {
+		t.Errorf("expected no selector for all nodes, got %q", got)
+	}
+}
+
+func TestKubernetesPods(t *testing.T) {
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	pods := `{"items": [{"metadata": {"name": "api-1", "namespace": "shop"}}, {"metadata": {"name": "api-2", "namespace": "shop"}}]}`
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\ncat <<'EOF'\n" + pods + "\nEOF\n"
+	if err := os.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	hosts, err := ResolveHosts([]string{"@k8s/shop/app.kubernetes.io/name=api", "-shop/api-2"}, nil)
+	if err != nil || !slices.Equal(hosts, []string{"shop/api-1"}) {
+		t.Fatalf("got %q, %v", hosts, err)
+	}
+	if got, _ := os.ReadFile(args); string(got) != "get pods -o json --field-selector=status.phase=Running -n shop -l app.kubernetes.io/name=api\n" {
+		t.Errorf("unexpected kubectl arguments: %q", got)
+	}
+
+	// Without a namespace the current one is used and pods are named without it
+	hosts, _ = KubernetesPods(context.Background(), "", "")
+	if !slices.Equal(hosts, []string{"api-1", "api-2"}) {
+		t.Errorf("got %q", hosts)
+	}
+
+	if args := kubectlExecArgs("shop/api-1", "uptime", false); !slices.Equal(args, []string{"exec", "-i", "-n", "shop", "api-1", "--", "sh", "-c", "uptime"}) {
+		t.Errorf("unexpected exec arguments: %q", args)
+	}
+}
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// lastResult is the outcome of the last command of an interactive session, shown in the prompt and by :last
+type lastResult struct {
+	command string
+	all     []string         // Connected hosts when the command ran, for stable prefix colors
+	hosts   []string         // Hosts the command ran on, in display order
+	failed  map[string]error // Errors of the hosts the command failed on
+	input   []byte           // Sent to the stdin of every host, nil if none
+}
+
+// newLastResult records the outcome of command on hosts from errs, which is indexed like all
+func newLastResult(command string, all, hosts []string, errs []error) *lastResult {
+	result := &lastResult{command: command, all: all, hosts: hosts, failed: make(map[string]error)}
+	for i, err := range errs {
+		if err != nil && slices.Contains(hosts, all[i]) {
+			result.failed[all[i]] = err
+		}
+	}
+	return result
+}
+
+// summary returns the succeeded and failed host counts, e.g. "18✓ 2✗"
+func (r *lastResult) summary() string {
+	var parts []string
+	if succeeded := len(r.hosts) - len(r.failed); succeeded > 0 {
+		parts = append(parts, fmt.Sprintf("%d✓", succeeded))
+	}
+	if len(r.failed) > 0 {
+		parts = append(parts, fmt.Sprintf("%d✗", len(r.failed)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// show prints the summary of the last command and why it failed on which hosts
+func (r *lastResult) show(noColor bool) {
+	fmt.Printf("📋 %s: %s\n", r.command, r.summary())
+	if len(r.failed) == 0 {
+		fmt.Printf("✅ Succeeded on all %d host(s)\n", len(r.hosts))
+		return
+	}
+
+	failed := r.failedHosts()
+	fmt.Printf("❌ Failed on %d host(s):\n", len(failed))
+	maxHostLen := maxLen(failed)
+	for _, host := range failed {
+		err := r.failed[host]
+		reason := err.Error()
+		var connErr *ConnectionError
+		if errors.As(err, &connErr) {
+			reason += "\n    💡 " + connErr.Hint()
+		} else if code := exitCode(err); code > 0 {
+			reason = fmt.Sprintf("exit code %d", code)
+		}
+		fmt.Printf("  %s: %s\n", formatHostPrefix(host, slices.Index(r.all, host), maxHostLen, noColor), reason)
+	}
+}
+
+// failedHosts returns the hosts the command failed on, in display order
+func (r *lastResult) failedHosts() []string {
+	var failed []string
+	for _, host := range r.hosts {
+		if r.failed[host] != nil {
+			failed = append(failed, host)
+		}
+	}
+	return failed
+}
+
+// merge takes over the outcome of retry, a re-run of the command on some of the hosts
+func (r *lastResult) merge(retry *lastResult) {
+	for _, host := range retry.hosts {
+		if err := retry.failed[host]; err != nil {
+			r.failed[host] = err
+		} else {
+			delete(r.failed, host)
+		}
+	}
+}
+
+// retry re-runs the last command on the hosts it failed on that are still connected, and merges the outcome
+// into the last result so hosts that already succeeded are left alone
+func (s *session) retry() {
+	last := s.last
+	if last == nil {
+		fmt.Println("🔁 No command has completed yet")
+		return
+	}
+	targets := make(map[string]bool)
+	for _, host := range last.failedHosts() {
+		if slices.Contains(s.hosts, host) {
+			targets[host] = true
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Println("✅ Nothing to retry, the last command failed on no connected host")
+		return
+	}
+
+	fmt.Printf("🔁 Retrying %s on %d host(s)\n", last.command, len(targets))
+	if s.run(last.command, targets, last.input) {
+		return
+	}
+	last.merge(s.last)
+	s.last = last
+	if s.rl != nil {
+		s.rl.SetPrompt(s.prompt())
+	}
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLastResult(t *testing.T) {
+	useFakeSSH(t)
+	sess := &session{connManager: NewSSHConnectionManager(""), hosts: []string{"web1", "web2", "web3"}, noColor: true}
+	if prompt := sess.prompt(); prompt != "🖥️ [3]> " {
+		t.Errorf("unexpected prompt before any command: %q", prompt)
+	}
+
+	captureStdout(t, func() { sess.runCommand("test {host} != web2", nil) })
+	if prompt := sess.prompt(); prompt != "🖥️ [2✓ 1✗]> " {
+		t.Errorf("unexpected prompt: %q", prompt)
+	}
+	output := captureStdout(t, func() { sess.last.show(true) })
+	if !strings.Contains(output, "2✓ 1✗") || !strings.Contains(output, "web2: exit code 1") || strings.Contains(output, "web1:") {
+		t.Errorf("expected only web2 to be listed as failed, got %q", output)
+	}
+
+	// The result only stands for the hosts it ran on
+	captureStdout(t, func() { sess.runCommand("true", map[string]bool{"web1": true}) })
+	if prompt := sess.prompt(); prompt != "🖥️ [3]> " {
+		t.Errorf("expected the host count for a result of other hosts, got %q", prompt)
+	}
+	sess.selected = map[string]bool{"web1": true}
+	if prompt := sess.prompt(); prompt != "🖥️ [1/3 1✓]> " {
+		t.Errorf("unexpected prompt with a selection: %q", prompt)
+	}
+	output = captureStdout(t, func() { sess.last.show(true) })
+	if !strings.Contains(output, "Succeeded on all 1 host(s)") {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	useFakeSSH(t)
+	dir := t.TempDir()
+	sess := &session{connManager: NewSSHConnectionManager(""), hosts: []string{"web1", "web2", "web3"}, noColor: true}
+
+	// web2 fails until the marker exists; every run is logged
+	command := "echo {host} >> " + dir + "/runs; test {host} != web2 || test -e " + dir + "/ok"
+	captureStdout(t, func() { sess.runCommand(command, nil) })
+	if err := os.WriteFile(filepath.Join(dir, "ok"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureStdout(t, func() { sess.retry() })
+	if !strings.Contains(output, "Retrying") || !strings.Contains(output, "on 1 host(s)") {
+		t.Errorf("unexpected output: %q", output)
+	}
+	runs, err := os.ReadFile(filepath.Join(dir, "runs")) // #nosec G304 -- test file
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(runs), "web2") != 2 || strings.Count(string(runs), "web1") != 1 {
+		t.Errorf("expected only web2 to run again, got runs %q", runs)
+	}
+	if prompt := sess.prompt(); prompt != "🖥️ [3✓]> " {
+		t.Errorf("expected the retry to be merged into the result, got %q", prompt)
+	}
+
+	output = captureStdout(t, func() { sess.retry() })
+	if !strings.Contains(output, "Nothing to retry") {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log receives gosh's own diagnostics such as warnings and connection details, never the output of hosts.
+// It writes to stderr so stdout only carries what the hosts print; SetLogging picks the level and format.
+var Log = slog.New(newConsoleHandler(os.Stderr, logLevel))
+
+// logLevel is the minimum level Log writes: the configured one, or info while Verbose is on
+var logLevel = new(slog.LevelVar)
+
+// configuredLevel is the level chosen with SetLogging
+var configuredLevel = slog.LevelWarn
+
+func init() {
+	logLevel.Set(configuredLevel)
+}
+
+// LogLevels and LogFormats are the values SetLogging accepts
+var (
+	LogLevels  = []string{"debug", "info", "warn"}
+	LogFormats = []string{"text", "json"}
+)
+
+// SetLogging makes Log write records of level (debug, info or warn) and above in format, text for people
+// or json for log collectors
+func SetLogging(level, format string) error {
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = newConsoleHandler(os.Stderr, logLevel)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s", format, strings.Join(LogFormats, " or "))
+	}
+	switch level {
+	case "debug":
+		configuredLevel = slog.LevelDebug
+	case "info":
+		configuredLevel = slog.LevelInfo
+	case "warn":
+		configuredLevel = slog.LevelWarn
+	default:
+		return fmt.Errorf("unknown log level %q, expected %s", level, strings.Join(LogLevels, ", "))
+	}
+	Log = slog.New(handler)
+	applyLogLevel()
+	return nil
+}
+
+// applyLogLevel lowers the configured level to info while Verbose is on
+func applyLogLevel() {
+	level := configuredLevel
+	if Verbose {
+		level = min(level, slog.LevelInfo)
+	}
+	logLevel.Set(level)
+}
+
+// consoleHandler writes records for people: an emoji for the level, the message, then the attributes
+// as key=value
+type consoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	attrs  string // Attributes added with WithAttrs, already formatted
+	prefix string // Groups opened with WithGroup, e.g. "ssh."
+}
+
+// newConsoleHandler returns a handler writing records of level and above to w
+func newConsoleHandler(w io.Writer, level slog.Leveler) *consoleHandler {
+	return &consoleHandler{mu: new(sync.Mutex), w: w, level: level}
+}
+
+// Enabled reports whether records of level are written
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes a record as one line
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var b strings.Builder
+	switch {
+	case record.Level >= slog.LevelError:
+		b.WriteString("❌ ")
+	case record.Level >= slog.LevelWarn:
+		b.WriteString("⚠️  ")
+	case record.Level >= slog.LevelInfo:
+		b.WriteString("ℹ️  ")
+	default:
+		b.WriteString("🐞 ")
+	}
+	b.WriteString(record.Message)
+	b.WriteString(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		writeLogAttr(&b, h.prefix, attr)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// WithAttrs returns a handler adding attrs to every record
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, attr := range attrs {
+		writeLogAttr(&b, h.prefix, attr)
+	}
+	clone := *h
+	clone.attrs += b.String()
+	return &clone
+}
+
+// WithGroup returns a handler qualifying the keys of later attributes with name
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.prefix += name + "."
+	return &clone
+}
+
+// writeLogAttr appends " key=value", quoting values with blanks or quotes and flattening groups
+func writeLogAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, attr := range value.Group() {
+			writeLogAttr(b, prefix, attr)
+		}
+		return
+	}
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	text := value.String()
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		text = strconv.Quote(text)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, attr.Key, text)
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func TestConsoleHandler(t *testing.T) {
+	var out bytes.Buffer
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelInfo)
+	logger := slog.New(newConsoleHandler(&out, level))
+
+	logger.Debug("hidden")
+	logger.Info("Connected", "hosts", 3)
+	logger.With("host", "web1").WithGroup("ssh").Warn("Reconnect failed", "err", errors.New("exit status 255"), slog.Group("retry", "in", "2s"))
+	logger.Error("Broken", "path", "", "note", `say "hi"`)
+
+	expected := "ℹ️  Connected hosts=3\n" +
+		"⚠️  Reconnect failed host=web1 ssh.err=\"exit status 255\" ssh.retry.in=2s\n" +
+		"❌ Broken path=\"\" note=\"say \\\"hi\\\"\"\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestSetLogging(t *testing.T) {
+	defer func(logger *slog.Logger) {
+		Log = logger
+		Verbose = false
+		configuredLevel = slog.LevelWarn
+		applyLogLevel()
+	}(Log)
+
+	if err := SetLogging("debug", "json"); err != nil {
+		t.Fatal(err)
+	}
+	if !Log.Enabled(t.Context(), slog.LevelDebug) {
+		t.Error("expected debug records to be written")
+	}
+	if _, ok := Log.Handler().(*slog.JSONHandler); !ok {
+		t.Errorf("expected a JSON handler, got %T", Log.Handler())
+	}
+
+	if err := SetLogging("warn", "text"); err != nil {
+		t.Fatal(err)
+	}
+	if Log.Enabled(t.Context(), slog.LevelInfo) {
+		t.Error("expected info records to be dropped at warn")
+	}
+	Verbose = true
+	applyLogLevel()
+	if !Log.Enabled(t.Context(), slog.LevelInfo) || Log.Enabled(t.Context(), slog.LevelDebug) {
+		t.Error("expected verbose mode to write info records")
+	}
+
+	if err := SetLogging("trace", "text"); err == nil {
+		t.Error("expected an unknown level to fail")
+	}
+	if err := SetLogging("info", "xml"); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
@@ -5,8 +5,10 @@ package pkg
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
 	"os/exec"
@@ -16,6 +18,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/chzyer/readline"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -100,6 +103,122 @@ func TestFormatHostColorCycling(t *testing.T) {
 	}
 }
 
+func TestFormatHostHashColors(t *testing.T) {
+	HashColors = true
+	defer func() { HashColors = false }()
+
+	// The same host must get the same color regardless of its position
+	first := formatHostPrefix("web3", 0, 10, false)
+	second := formatHostPrefix("web3", 5, 10, false)
+	if first != second {
+		t.Errorf("hash colors should not depend on index: %q != %q", first, second)
+	}
+
+	if idx := colorIndex("web3", 7); idx < 0 || idx >= len(colors) {
+		t.Errorf("colorIndex out of range: %d", idx)
+	}
+}
+
+func TestAutoNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	if !AutoNoColor() {
+		t.Error("NO_COLOR should disable colors")
+	}
+
+	defer func() { isTerminal = readline.IsTerminal }()
+	t.Setenv("NO_COLOR", "")
+	isTerminal = func(int) bool { return false }
+	if !AutoNoColor() {
+		t.Error("non-terminal stdout should disable colors")
+	}
+	isTerminal = func(int) bool { return true }
+	if AutoNoColor() {
+		t.Error("a terminal should keep colors")
+	}
+}
+
+func TestSetTheme(t *testing.T) {
+	defer func() { colors = themes["default"] }()
+
+	t.Setenv("COLORTERM", "truecolor")
+	if err := SetTheme("solarized"); err != nil {
+		t.Fatalf("SetTheme failed: %v", err)
+	}
+	if colors[0] != "38;2;181;137;0m" {
+		t.Errorf("expected truecolor code, got %q", colors[0])
+	}
+
+	// Without truecolor support hex colors are downgraded to the 256-color palette
+	t.Setenv("COLORTERM", "")
+	if err := SetTheme("solarized"); err != nil {
+		t.Fatalf("SetTheme failed: %v", err)
+	}
+	if !strings.HasPrefix(colors[0], "38;5;") {
+		t.Errorf("expected 256-color code, got %q", colors[0])
+	}
+
+	path := filepath.Join(t.TempDir(), "theme")
+	if err := os.WriteFile(path, []byte("# my theme\n#ff0000\n32m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTheme(path); err != nil {
+		t.Fatalf("SetTheme from file failed: %v", err)
+	}
+	if len(colors) != 2 || colors[1] != "32m" {
+		t.Errorf("unexpected palette from file: %v", colors)
+	}
+
+	if err := SetTheme("does-not-exist"); err == nil {
+		t.Error("expected error for unknown theme")
+	}
+}
+
+// captureStdout runs fn and returns everything it printed to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	oldStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	os.Stdout = w
+
+	fn()
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	output, _ := io.ReadAll(r)
+	return string(output)
+}
+
+func TestStreamCommandOutputModes(t *testing.T) {
+	defer func() { Output = OutputAll }()
+
+	script := "echo out; echo err >&2; exit $0"
+	run := func(mode OutputMode, exitCode string) string {
+		Output = mode
+		return captureStdout(t, func() {
+			cmd := exec.CommandContext(context.Background(), "sh", "-c", script, exitCode)
+			_ = streamCommand(context.Background(), cmd, "host", "host", script)
+		})
+	}
+
+	if output := run(OutputAll, "0"); !strings.Contains(output, "host: out") || !strings.Contains(output, "host: err") {
+		t.Errorf("expected all output, got %q", output)
+	}
+	if output := run(OutputQuiet, "0"); strings.Contains(output, "host: out") || !strings.Contains(output, "host: err") {
+		t.Errorf("quiet mode should only show stderr, got %q", output)
+	}
+	if output := run(OutputOnlyFailures, "0"); output != "" {
+		t.Errorf("only-failures should hide successful hosts, got %q", output)
+	}
+	if output := run(OutputOnlyFailures, "3"); !strings.Contains(output, "host: out") || !strings.Contains(output, "exit status 3") {
+		t.Errorf("only-failures should show failed hosts, got %q", output)
+	}
+}
+
 // startFakeSSHServer starts a fake SSH server for testing purposes
 func startFakeSSHServer(t *testing.T, addr string, response string) net.Listener {
 	t.Helper()
@@ -338,11 +457,39 @@ func TestExecuteCommand(t *testing.T) {
 		t.Run(test.name, func(_ *testing.T) {
 			// This test verifies the function doesn't panic and completes
 			// Actual SSH execution is tested in integration tests
-			ExecuteCommand(test.hosts, test.command, test.user, test.noColor)
+			_ = ExecuteCommand(context.Background(), test.hosts, test.command, test.user, test.noColor)
 		})
 	}
 }
 
+func TestExecuteCommandErrors(t *testing.T) {
+	useFakeSSH(t)
+
+	var err error
+	captureStdout(t, func() {
+		err = ExecuteCommand(context.Background(), []string{"web1", "web2"}, "test {host} = web1", "", true)
+	})
+	var hostErr *HostError
+	if !errors.As(err, &hostErr) || hostErr.Host != "web2" {
+		t.Fatalf("expected a host error for web2, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "web2: ") {
+		t.Errorf("unexpected error message: %q", err)
+	}
+
+	captureStdout(t, func() { err = ExecuteCommand(context.Background(), []string{"web1"}, "true", "", true) })
+	if err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	captureStdout(t, func() { err = ExecuteCommand(ctx, []string{"web1"}, "true", "", true) })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled context, got %v", err)
+	}
+}
+
 func TestUploadFile(t *testing.T) {
 	// Create a temporary test file
 	tempFile := "/tmp/gosh_test_file.txt"
@@ -359,18 +506,21 @@ func TestUploadFile(t *testing.T) {
 		filepath string
 		user     string
 		noColor  bool
+		wantErr  bool
 	}{
-		{"existing file", []string{"localhost"}, tempFile, "", true},
-		{"nonexistent file", []string{"localhost"}, "/nonexistent/file.txt", "", true},
-		{"multiple hosts", []string{"host1", "host2"}, tempFile, "testuser", false},
-		{"empty hosts", []string{}, tempFile, "", true},
+		{"existing file", []string{"localhost"}, tempFile, "", true, false},
+		{"nonexistent file", []string{"localhost"}, "/nonexistent/file.txt", "", true, true},
+		{"multiple hosts", []string{"host1", "host2"}, tempFile, "testuser", false, false},
+		{"empty hosts", []string{}, tempFile, "", true, false},
 	}
 
 	for _, test := range tests {
-		t.Run(test.name, func(_ *testing.T) {
-			// This test verifies the function doesn't panic and handles file existence
-			// Actual SCP execution is tested in integration tests
-			uploadFile(test.hosts, test.filepath, test.user, test.noColor)
+		t.Run(test.name, func(t *testing.T) {
+			// Only the missing local file is a guaranteed error, actual SCP execution is tested in integration tests
+			err := uploadFile(context.Background(), test.hosts, test.filepath, "", test.user, test.noColor)
+			if test.wantErr && err == nil {
+				t.Error("expected an error")
+			}
 		})
 	}
 }
@@ -460,6 +610,29 @@ func TestSSHCommandConstruction(t *testing.T) {
 	}
 }
 
+func TestExtraSSHOptionsProfile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	Profile = ""
+	if args := extraSSHOptions(); len(args) != 0 {
+		t.Errorf("expected no extra options without profile, got %v", args)
+	}
+
+	Profile = "lab"
+	defer func() { Profile = "" }()
+
+	args := strings.Join(extraSSHOptions(), " ")
+	if !strings.Contains(args, "UserKnownHostsFile="+knownHostsFile("lab")) {
+		t.Errorf("expected profile known_hosts file in %q", args)
+	}
+	if knownHostsFile("lab") == knownHostsFile("prod") {
+		t.Error("profiles must use separate known_hosts files")
+	}
+	if _, err := os.Stat(filepath.Dir(knownHostsFile("lab"))); err != nil {
+		t.Errorf("known_hosts directory not created: %v", err)
+	}
+}
+
 func TestSCPCommandConstruction(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -826,6 +999,9 @@ func TestSSHConnectionManager(t *testing.T) {
 			// Test socket path generation
 			socketPath := cm.getSocketPath("testhost")
 			expectedPath := filepath.Join(cm.socketDir, "gosh-testhost")
+			if test.user != "" {
+				expectedPath = filepath.Join(cm.socketDir, "gosh-"+test.user+"@testhost")
+			}
 			if socketPath != expectedPath {
 				t.Errorf("Expected socket path %q, got %q", expectedPath, socketPath)
 			}
@@ -1043,61 +1219,865 @@ func TestGetLocalFileCompletionsExtended(t *testing.T) {
 	}
 }
 
-func TestPrintProgressBar(t *testing.T) {
+func TestMatchHosts(t *testing.T) {
+	hosts := []string{"web1", "web2", "web3", "db1"}
+
 	tests := []struct {
-		name     string
-		current  int
-		total    int
-		width    int
-		expected string // partial expected output
+		pattern  string
+		expected []string
 	}{
-		{"zero progress", 0, 10, 5, "[░░░░░] 0/10"},
-		{"half progress", 5, 10, 5, "[██░░░] 5/10"},
-		{"full progress", 10, 10, 5, "[█████] 10/10"},
-		{"zero total", 0, 0, 5, ""}, // Should not print anything
-		{"single item", 1, 1, 3, "[███] 1/1"},
+		{"web1,web3", []string{"web1", "web3"}},
+		{"web*", []string{"web1", "web2", "web3"}},
+		{"db*, web2", []string{"db1", "web2"}},
+		{"cache*", nil},
 	}
 
 	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			// Capture stdout
-			oldStdout := os.Stdout
-			r, w, _ := os.Pipe()
-			os.Stdout = w
+		matched := matchHosts(hosts, test.pattern)
+		if len(matched) != len(test.expected) {
+			t.Errorf("matchHosts(%q) = %v, expected %v", test.pattern, matched, test.expected)
+		}
+		for _, host := range test.expected {
+			if !matched[host] {
+				t.Errorf("matchHosts(%q) missing %s", test.pattern, host)
+			}
+		}
+	}
+}
 
-			printProgressBar(test.current, test.total, test.width)
+func TestSessionSelectHosts(t *testing.T) {
+	sess := &session{hosts: []string{"web1", "web2", "db1"}, noColor: true}
 
-			// Restore stdout
-			w.Close()
-			os.Stdout = oldStdout
+	captureStdout(t, func() { sess.selectHosts("web*") })
+	if targets := sess.targetHosts(); len(targets) != 2 || targets[0] != "web1" || targets[1] != "web2" {
+		t.Errorf("unexpected targets after select: %v", targets)
+	}
+	if prompt := sess.prompt(); !strings.Contains(prompt, "[2/3]") {
+		t.Errorf("prompt should show selection, got %q", prompt)
+	}
 
-			// Read captured output
-			output, _ := io.ReadAll(r)
-			outputStr := string(output)
+	// An empty match keeps the previous selection
+	captureStdout(t, func() { sess.selectHosts("nope") })
+	if len(sess.targetHosts()) != 2 {
+		t.Error("selection should be unchanged after a pattern without matches")
+	}
 
-			if test.total == 0 {
-				if outputStr != "" {
-					t.Errorf("Expected no output for zero total, got %q", outputStr)
-				}
-				return
-			}
+	captureStdout(t, func() { sess.selectHosts("all") })
+	if len(sess.targetHosts()) != 3 {
+		t.Errorf("select all should reset the selection, got %v", sess.targetHosts())
+	}
+}
 
-			// Check that the output contains expected elements
-			if !strings.Contains(outputStr, test.expected) {
-				t.Errorf("Expected output to contain %q, got %q", test.expected, outputStr)
-			}
+func TestSessionRemoveHost(t *testing.T) {
+	sess := &session{
+		connManager: NewSSHConnectionManager(""),
+		hosts:       []string{"web1", "web2"},
+		selected:    map[string]bool{"web2": true},
+		noColor:     true,
+		completer:   &customCompleter{hosts: []string{"web1", "web2"}},
+	}
 
-			// Check for progress bar characters
-			if !strings.Contains(outputStr, "[") || !strings.Contains(outputStr, "]") {
-				t.Errorf("Expected output to contain progress bar brackets, got %q", outputStr)
-			}
+	output := captureStdout(t, func() {
+		sess.removeHost("web2")
+		sess.removeHost("web9")
+		sess.addHost("web1")
+	})
 
-			// For completed progress, should have newline
-			if test.current == test.total && test.total > 0 {
-				if !strings.HasSuffix(outputStr, "\n") {
-					t.Errorf("Expected output to end with newline for completed progress, got %q", outputStr)
-				}
-			}
-		})
+	if len(sess.hosts) != 1 || sess.hosts[0] != "web1" || len(sess.completer.hosts) != 1 {
+		t.Errorf("unexpected hosts after remove: %v / %v", sess.hosts, sess.completer.hosts)
+	}
+	if sess.selected != nil || len(sess.targetHosts()) != 1 {
+		t.Errorf("removing the last selected host should select all hosts, got %v", sess.selected)
+	}
+	if !strings.Contains(output, "selected all 1 host(s)") || !strings.Contains(output, "web9 is not connected") || !strings.Contains(output, "web1 is already connected") {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestBuildPortCheckCommand(t *testing.T) {
+	command, err := buildPortCheckCommand("dbhost.internal", "5432")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(command, "/dev/tcp/dbhost.internal/5432") || !strings.Contains(command, "nc -z dbhost.internal 5432") {
+		t.Errorf("unexpected command: %q", command)
+	}
+
+	// The probe runs locally here: a closed port must be reported as closed
+	lc := &net.ListenConfig{}
+	listener, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := fmt.Sprint(listener.Addr().(*net.TCPAddr).Port)
+	command, _ = buildPortCheckCommand("127.0.0.1", port)
+	output, _ := exec.CommandContext(context.Background(), "sh", "-c", command).Output()
+	if strings.TrimSpace(string(output)) != "127.0.0.1:"+port+" open" {
+		t.Errorf("expected open port, got %q", output)
+	}
+	listener.Close()
+
+	// Without timeout and nc on the host the check can't tell whether the port is closed
+	cmd := exec.CommandContext(context.Background(), "/bin/sh", "-c", command)
+	cmd.Env = []string{"PATH=" + t.TempDir()}
+	output, _ = cmd.Output()
+	if strings.TrimSpace(string(output)) != "127.0.0.1:"+port+" error: timeout or nc not available on host" {
+		t.Errorf("expected an error without timeout, got %q", output)
+	}
+
+	for _, args := range [][2]string{{"db", "0"}, {"db", "http"}, {"db;rm -rf /", "22"}, {"", "22"}} {
+		if _, err := buildPortCheckCommand(args[0], args[1]); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	cm := NewSSHConnectionManager("")
+	cm.connections["web1"] = &SSHConnection{
+		host:        "web1",
+		socketPath:  cm.getSocketPath("web1"),
+		connectedAt: time.Now().Add(-time.Minute),
+		lastRun:     1500 * time.Millisecond,
+		shell:       shellBash,
+	}
+
+	output := captureStdout(t, func() { cm.printStatus([]string{"web1", "web2"}) })
+	rows := strings.Split(strings.TrimSpace(output), "\n")
+	if len(rows) != 3 {
+		t.Fatalf("expected header and two rows, got %q", output)
+	}
+
+	if strings.Join(strings.Fields(rows[0]), " ") != "HOST STATE SHELL AGE LAST COMMAND" {
+		t.Errorf("unexpected table header: %q", rows[0])
+	}
+	// No control master is running, so both sockets are stale
+	if strings.Join(strings.Fields(rows[1]), " ") != "web1 stale bash 1m0s 1.5s" {
+		t.Errorf("unexpected web1 row: %q", rows[1])
+	}
+	if strings.Join(strings.Fields(rows[2]), " ") != "web2 stale unknown - -" {
+		t.Errorf("unexpected web2 row: %q", rows[2])
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHealthMonitorDetectsDeadConnection(t *testing.T) {
+	cm := NewSSHConnectionManager("")
+	host := "gosh-monitor-test.invalid"
+	cm.connections[host] = &SSHConnection{host: host, socketPath: cm.getSocketPath(host), connectedAt: time.Now()}
+
+	var out syncBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := cm.startHealthMonitor(ctx, 10*time.Millisecond, &out)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "connection lost") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if !strings.Contains(out.String(), host+" connection lost") {
+		t.Errorf("expected connection lost notice, got %q", out.String())
+	}
+	if strings.Contains(out.String(), "reconnected") {
+		t.Errorf("unresolvable host must not reconnect, got %q", out.String())
+	}
+
+	// The host stays in the session, marked down, so the monitor retries it
+	cm.mu.Lock()
+	conn := cm.connections[host]
+	cm.mu.Unlock()
+	if conn == nil {
+		t.Fatal("expected a failed reconnect to keep the host")
+	}
+	if output := captureStdout(t, func() { cm.printStatus([]string{host}) }); !strings.Contains(output, host+"  down") {
+		t.Errorf("expected the host to be listed as down, got %q", output)
+	}
+}
+
+func TestReconnectInPlace(t *testing.T) {
+	useFakeSSH(t)
+	cm := NewSSHConnectionManager("")
+	conn := &SSHConnection{host: "web1", socketPath: cm.getSocketPath("web1"), active: 1, down: true}
+	cm.connections["web1"] = conn
+
+	if err := cm.reconnect(context.Background(), "web1"); err != nil {
+		t.Fatalf("reconnect failed: %v", err)
+	}
+	if cm.connections["web1"] != conn || conn.down || conn.active != 1 || conn.connectedAt.IsZero() {
+		t.Errorf("expected the connection to be re-established in place, got %+v", cm.connections["web1"])
+	}
+
+	// Hosts removed from the session are not brought back
+	if err := cm.reconnect(context.Background(), "web2"); !errors.Is(err, errNotConnected) || cm.connections["web2"] != nil {
+		t.Errorf("expected a removed host to stay removed, got %v", err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	useFakeSSH(t)
+	IdleTimeout = time.Minute
+	defer func() { IdleTimeout = 0 }()
+
+	cm := NewSSHConnectionManager("")
+	cm.connections["web1"] = &SSHConnection{host: "web1", socketPath: cm.getSocketPath("web1"), lastUsed: time.Now().Add(-time.Hour)}
+	cm.connections["web2"] = &SSHConnection{host: "web2", socketPath: cm.getSocketPath("web2"), lastUsed: time.Now()}
+
+	if !cm.suspendIfIdle("web1") || cm.suspendIfIdle("web2") {
+		t.Fatal("expected only the unused connection to be suspended")
+	}
+	output := captureStdout(t, func() { cm.printStatus([]string{"web1"}) })
+	if !strings.Contains(output, "web1  idle") {
+		t.Errorf("expected web1 to be listed as idle, got %q", output)
+	}
+
+	// The next command re-establishes the connection, which isn't suspended while in use
+	cm.use(context.Background(), "web1")
+	cm.connections["web1"].lastUsed = time.Now().Add(-time.Hour)
+	if cm.connections["web1"].idle || cm.suspendIfIdle("web1") {
+		t.Error("expected the connection in use to be re-established and kept")
+	}
+	cm.release("web1")
+	if !cm.suspendIfIdle("web1") {
+		t.Error("expected the connection to be suspended once the command finished")
+	}
+}
+
+func TestIdleConnectionReestablishedOnce(t *testing.T) {
+	dir := t.TempDir()
+	masters := filepath.Join(dir, "masters")
+	script := "#!/bin/sh\ncase \" $* \" in *\" -M \"*) echo >> " + masters + ";; esac\nfor last; do :; done\nexec sh -c \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cm := NewSSHConnectionManager("")
+	cm.connections["web1"] = &SSHConnection{host: "web1", socketPath: cm.getSocketPath("web1"), idle: true}
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Go(func() { cm.use(context.Background(), "web1") })
+	}
+	wg.Wait()
+
+	started, _ := os.ReadFile(masters)
+	if strings.Count(string(started), "\n") != 1 {
+		t.Errorf("expected concurrent commands to re-establish the connection once, got %d masters", strings.Count(string(started), "\n"))
+	}
+	if conn := cm.connections["web1"]; conn.idle || conn.active != 5 {
+		t.Errorf("expected an active connection used 5 times, got %+v", conn)
+	}
+}
+
+func TestSharedMasterLeftOpen(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$*\" >> " + calls + "\ncase \" $* \" in *\" -O check \"*) exit 0;; esac\nfor last; do :; done\nexec sh -c \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// Another gosh process, like the daemon, keeps a master listening on the host's socket
+	cm := NewSSHConnectionManager("")
+	IdleTimeout = time.Nanosecond
+	defer func() { IdleTimeout = 0 }()
+	socketPath := cm.getSocketPath("web1")
+	listener, err := (&net.ListenConfig{}).Listen(context.Background(), "unix", socketPath)
+	if err != nil {
+		t.Skipf("unix sockets not available: %v", err)
+	}
+	defer listener.Close()
+
+	if err := cm.establishConnection(context.Background(), "web1"); err != nil {
+		t.Fatal(err)
+	}
+	if !cm.connections["web1"].shared || cm.suspendIfIdle("web1") {
+		t.Errorf("expected the live master to be used without suspending it, got %+v", cm.connections["web1"])
+	}
+	cm.closeAllConnections()
+
+	log, _ := os.ReadFile(calls)
+	if strings.Contains(string(log), "-M") || strings.Contains(string(log), "-O exit") {
+		t.Errorf("expected the master of the other process to be neither started nor closed, got %q", log)
+	}
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Errorf("expected the socket of the other process to stay: %v", err)
+	}
+}
+
+func TestConnectWarnsOnStderr(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\ncase \"$last\" in *BASH_VERSION*) echo 'Command not allowed'; exit 1;; esac\nexec sh -c \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func(logger *slog.Logger) { Log = logger }(Log)
+	var logged bytes.Buffer
+	Log = slog.New(newConsoleHandler(&logged, new(slog.LevelVar)))
+
+	// Stdout is kept for host output, also for one-shot runs and the daemon
+	cm := NewSSHConnectionManager("")
+	output := captureStdout(t, func() {
+		if err := cm.establishConnection(context.Background(), "web1"); err != nil {
+			t.Error(err)
+		}
+	})
+	if output != "" || !strings.Contains(logged.String(), "Restricted shell detected") || !strings.Contains(logged.String(), "host=web1") {
+		t.Errorf("expected the warning on the log only, got %q and %q", output, logged.String())
+	}
+}
+
+func TestSessionReconnectHosts(t *testing.T) {
+	sess := &session{
+		connManager: NewSSHConnectionManager(""),
+		hosts:       []string{"gosh-a.invalid", "gosh-b.invalid"},
+		noColor:     true,
+	}
+
+	output := captureStdout(t, func() { sess.reconnectHosts("gosh-a*") })
+	if !strings.Contains(output, "gosh-a.invalid: ❌") || strings.Contains(output, "gosh-b.invalid") {
+		t.Errorf("expected only gosh-a to be reconnected, got %q", output)
+	}
+
+	output = captureStdout(t, func() { sess.reconnectHosts("nope") })
+	if !strings.Contains(output, "No connected hosts match") {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestSSHSecondsAndSocketDir(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		5 * time.Second:         "5",
+		10 * time.Minute:        "600",
+		1500 * time.Millisecond: "2",
+		0:                       "1",
+	} {
+		if result := sshSeconds(d); result != expected {
+			t.Errorf("sshSeconds(%s) = %s, expected %s", d, result, expected)
+		}
+	}
+
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	if cm := NewSSHConnectionManager(""); cm.socketDir != filepath.Join(runtimeDir, "gosh") {
+		t.Errorf("expected socket dir below XDG_RUNTIME_DIR, got %s", cm.socketDir)
+	}
+
+	SocketDir = filepath.Join(t.TempDir(), "sockets")
+	defer func() { SocketDir = "" }()
+	if cm := NewSSHConnectionManager(""); cm.socketDir != SocketDir {
+		t.Errorf("expected --socket-dir override, got %s", cm.socketDir)
+	}
+}
+
+func TestParseShellProbe(t *testing.T) {
+	tests := []struct {
+		output   string
+		err      error
+		expected shellCapability
+	}{
+		{"bash\n", nil, shellBash},
+		{"sh+bash\n", nil, shellBashAvailable},
+		{"sh\n", nil, shellPOSIX},
+		{"% Invalid input detected\n", nil, shellRestricted},
+		{"", fmt.Errorf("exit status 1"), shellRestricted},
+	}
+
+	for _, test := range tests {
+		if result := parseShellProbe(test.output, test.err); result != test.expected {
+			t.Errorf("parseShellProbe(%q, %v) = %s, expected %s", test.output, test.err, result, test.expected)
+		}
+	}
+
+	// The probe itself must work in a POSIX shell
+	output, err := exec.CommandContext(context.Background(), "sh", "-c", shellProbeCommand).Output()
+	if capability := parseShellProbe(string(output), err); capability == shellRestricted {
+		t.Errorf("probe failed locally: %q, %v", output, err)
+	}
+}
+
+func TestCleanupStaleSockets(t *testing.T) {
+	SocketDir = t.TempDir()
+	defer func() { SocketDir = "" }()
+
+	// Leave a socket behind without a master process, like a crashed session would
+	stale := filepath.Join(SocketDir, "gosh-gosh-stale.invalid")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: stale, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Failed to create socket: %v", err)
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+
+	// Live sockets of other sessions are kept, whichever login user they belong to
+	live := filepath.Join(SocketDir, "gosh-deploy@web1")
+	master, err := net.ListenUnix("unix", &net.UnixAddr{Name: live, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Failed to create socket: %v", err)
+	}
+	defer master.Close()
+
+	// Unrelated files are never touched
+	other := filepath.Join(SocketDir, "other-file")
+	if err := os.WriteFile(other, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed := CleanupSockets(); removed != 1 {
+		t.Errorf("expected 1 removed socket, got %d", removed)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("stale socket should be removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("unrelated file should be kept")
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Error("live socket should be kept")
+	}
+}
+
+func TestSSHOptionsPassthrough(t *testing.T) {
+	SSHOptions = []string{"StrictHostKeyChecking=accept-new", "Ciphers=aes256-gcm@openssh.com"}
+	defer func() { SSHOptions = nil }()
+
+	args := strings.Join(buildSSHArgs("web1", "uptime", ""), " ")
+	if !strings.Contains(args, "-o StrictHostKeyChecking=accept-new -o Ciphers=aes256-gcm@openssh.com") {
+		t.Errorf("expected user options in ssh args, got %q", args)
+	}
+	if !strings.HasSuffix(args, "web1 uptime") {
+		t.Errorf("host and command must come last, got %q", args)
+	}
+}
+
+func TestIdentityFiles(t *testing.T) {
+	t.Setenv("HOME", "/home/tester")
+	IdentityFiles = []string{"~/.ssh/prod_ed25519", "/etc/keys/deploy"}
+	defer func() { IdentityFiles = nil }()
+
+	args := strings.Join(extraSSHOptions(), " ")
+	if args != "-i /home/tester/.ssh/prod_ed25519 -i /etc/keys/deploy" {
+		t.Errorf("unexpected identity args: %q", args)
+	}
+}
+
+func TestNoEcho(t *testing.T) {
+	NoEcho = true
+	defer func() { NoEcho = false }()
+
+	if command := prepareCommand("uptime"); command != noEchoPrefix+"uptime" {
+		t.Errorf("unexpected prepared command: %q", command)
+	}
+	if !strings.Contains(strings.Join(extraSSHOptions(), " "), "LogLevel=ERROR") {
+		t.Error("expected banners to be suppressed")
+	}
+
+	// A shell echoing the command back must not show up in the output
+	output := captureStdout(t, func() {
+		cmd := exec.CommandContext(context.Background(), "sh", "-c", "echo 'echo hi'; echo hi")
+		_ = streamCommand(context.Background(), cmd, "web1", "web1", "echo hi")
+	})
+	if output != "web1: hi\n" {
+		t.Errorf("expected echoed command to be dropped, got %q", output)
+	}
+}
+
+func TestScheduleCommand(t *testing.T) {
+	at := time.Now().Add(300 * time.Millisecond)
+	command := scheduleCommand("echo fired", at)
+
+	start := time.Now()
+	output, err := exec.CommandContext(context.Background(), "sh", "-c", command).Output()
+	if err != nil {
+		t.Fatalf("scheduled command failed: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "fired" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("command ran too early, after %s", elapsed)
+	}
+
+	// A time in the past runs immediately
+	past := scheduleCommand("true", time.Now().Add(-time.Hour))
+	start = time.Now()
+	if err := exec.CommandContext(context.Background(), "sh", "-c", past).Run(); err != nil || time.Since(start) > time.Second {
+		t.Errorf("past schedule should run immediately: %v after %s", err, time.Since(start))
+	}
+}
+
+func TestSudo(t *testing.T) {
+	Sudo = true
+	sudoPassword = []byte("secret")
+	defer func() { Sudo, sudoPassword = false, nil }()
+
+	if command := prepareCommand("whoami"); command != wrapSudo("whoami", "", true) {
+		t.Errorf("unexpected prepared command: %q", command)
+	}
+
+	// A fake sudo checks the password on stdin, then runs the command
+	dir := t.TempDir()
+	fakeSudo := "#!/bin/sh\nread -r pw; [ \"$pw\" = secret ] || exit 1\n" +
+		"while [ \"$1\" != -- ]; do [ \"$1\" = -u ] && export SUDO_USER_ARG=\"$2\"; shift; done; shift; exec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudo), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// The command itself must not see the password on its stdin
+	output := captureStdout(t, func() {
+		cmd := exec.CommandContext(context.Background(), "sh", "-c", wrapSudo("cat; echo done", "", true))
+		if err := streamCommand(context.Background(), cmd, "web1", "web1", "cat; echo done"); err != nil {
+			t.Errorf("sudo command failed: %v", err)
+		}
+	})
+	if output != "web1: done\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestBecomeUser(t *testing.T) {
+	BecomeUser = "deploy"
+	defer func() { BecomeUser = "" }()
+
+	command := prepareCommand("echo it's $SUDO_USER_ARG")
+	if !strings.HasPrefix(command, "sudo -n -u 'deploy' -- sh -c ") {
+		t.Errorf("unexpected prepared command: %q", command)
+	}
+
+	// Quoting survives the extra shell level
+	dir := t.TempDir()
+	fakeSudo := "#!/bin/sh\nwhile [ \"$1\" != -- ]; do [ \"$1\" = -u ] && export SUDO_USER_ARG=\"$2\"; shift; done; shift; exec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudo), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	output, err := exec.CommandContext(context.Background(), "sh", "-c", wrapSudo(`echo "it's" $SUDO_USER_ARG`, "deploy", false)).Output()
+	if err != nil || string(output) != "it's deploy\n" {
+		t.Errorf("unexpected output %q: %v", output, err)
+	}
+
+	// Composes with the --sudo password
+	Sudo = true
+	defer func() { Sudo = false }()
+	if command := prepareCommand("id"); !strings.HasPrefix(command, "sudo -S -p '' -u 'deploy' -- sh -c ") {
+		t.Errorf("unexpected prepared command: %q", command)
+	}
+}
+
+func TestSessionMonitorInput(t *testing.T) {
+	keys, typed := io.Pipe()
+	input := newTerminalInput(keys)
+	rl, err := readline.NewEx(&readline.Config{
+		Stdin:          io.NopCloser(input),
+		Stdout:         io.Discard,
+		FuncIsTerminal: func() bool { return true },
+		FuncMakeRaw:    func() error { return nil },
+		FuncExitRaw:    func() error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	sess := &session{hosts: []string{"web1"}, noColor: true, rl: rl, keys: input, stdin: true}
+
+	interrupted := make(chan struct{})
+	forwarded, stop := sess.monitorInput(func() { close(interrupted) })
+
+	go func() { _, _ = io.WriteString(typed, "yes\n") }()
+	line := make([]byte, 4)
+	if _, err := io.ReadFull(forwarded, line); err != nil || string(line) != "yes\n" {
+		t.Fatalf("expected typed line to be forwarded, got %q: %v", line, err)
+	}
+
+	// Ctrl+C interrupts the command instead of being forwarded
+	go func() { _, _ = typed.Write([]byte{readline.CharInterrupt}) }()
+	select {
+	case <-interrupted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Ctrl+C to interrupt")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop did not end forwarding")
+	}
+	if _, err := forwarded.Read(line); err != io.EOF {
+		t.Errorf("expected EOF after stop, got %v", err)
+	}
+}
+
+func TestTTY(t *testing.T) {
+	if ttyArgs() != nil {
+		t.Error("no pseudo-terminal expected by default")
+	}
+
+	TTY = true
+	defer func() { TTY = false }()
+	if args := strings.Join(ttyArgs(), " "); !strings.Contains(args, "-tt") {
+		t.Errorf("expected -tt, got %q", args)
+	}
+
+	// Terminal line endings are stripped
+	output := captureStdout(t, func() {
+		cmd := exec.CommandContext(context.Background(), "printf", `a\r\nb\r\n`)
+		_ = streamCommand(context.Background(), cmd, "web1", "web1", "")
+	})
+	if output != "web1: a\nweb1: b\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestKillJob(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid not available")
+	}
+	token := newJobToken()
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	// Like sshd, setsid makes the shell a process group leader
+	cmd := exec.CommandContext(context.Background(), "setsid", "sh", "-c", trackJob("sleep 30 & sleep 30", token))
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cmd.Process.Kill() })
+
+	// Wait until the pid has been written completely, a loaded machine may take a while to start the job
+	pidFile := filepath.Join(dir, "gosh-"+token+".pid")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if pid, err := os.ReadFile(pidFile); err == nil && strings.HasSuffix(string(pid), "\n") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the job never wrote its pid file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	output, err := exec.CommandContext(context.Background(), "sh", "-c", killJobCommand(token)).Output()
+	if err != nil || !strings.Contains(string(output), "killed") {
+		t.Fatalf("unexpected kill output %q: %v", output, err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Error("expected the job to be terminated")
+	}
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Error("expected the pid file to be removed")
+	}
+
+	output, _ = exec.CommandContext(context.Background(), "sh", "-c", killJobCommand(token)).Output()
+	if strings.TrimSpace(string(output)) != "not running" {
+		t.Errorf("unexpected output for a finished job: %q", output)
+	}
+
+	// Finished jobs clean up after themselves and keep their exit status
+	err = exec.CommandContext(context.Background(), "sh", "-c", trackJob("exit 3", token)).Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+	err = exec.CommandContext(context.Background(), "sh", "-c", trackJob("false", token)).Run()
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("expected exit status 1, got %v", err)
+	}
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Error("expected the pid file to be removed after the job finished")
+	}
+
+	ctx := withJob(context.Background(), token)
+	if jobFrom(ctx) != token || jobFrom(context.Background()) != "" {
+		t.Error("unexpected job token from context")
+	}
+}
+
+func TestRestrictedShellRunsCommandAsTyped(t *testing.T) {
+	useFakeSSH(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	sess := &session{ctx: context.Background(), connManager: NewSSHConnectionManager(""), hosts: []string{"web1"}, noColor: true, job: newJobToken(), jobHosts: []string{"web1"}}
+	sess.connManager.connections["web1"] = &SSHConnection{host: "web1", shell: shellRestricted}
+	sess.connManager.setWorkDir("web1", "/nonexistent")
+
+	// Neither the cd of :cd nor the pid file of :kill reach the host
+	ctx := withJob(context.Background(), sess.job)
+	output := captureStdout(t, func() {
+		_ = sess.connManager.runSSHStreaming(ctx, "web1", `ls "$TMPDIR"/*.pid 2>/dev/null; echo done`, nil, 0, 4, true)
+		flushOutput()
+	})
+	if output != "web1: done\n" {
+		t.Errorf("expected the command to run as typed, got %q", output)
+	}
+	if output := captureStdout(t, func() { sess.killJob("") }); !strings.Contains(output, "not supported in a restricted shell") {
+		t.Errorf("expected :kill to be unsupported, got %q", output)
+	}
+}
+
+// useFakeSSH puts an ssh on PATH that runs the remote command locally, ignoring all options
+func useFakeSSH(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\nexec sh -c \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestChangeDir(t *testing.T) {
+	useFakeSSH(t)
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "var", "log"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	sess := &session{connManager: NewSSHConnectionManager(""), hosts: []string{"web1", "web2"}, noColor: true}
+	output := captureStdout(t, func() { sess.changeDir(root + "/var") })
+	if !strings.Contains(output, "📂 "+root+"/var") {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	// Relative paths resolve against the tracked directory
+	captureStdout(t, func() { sess.changeDir("log") })
+	if dir := sess.connManager.workDir("web2"); dir != root+"/var/log" {
+		t.Errorf("expected %s/var/log, got %q", root, dir)
+	}
+
+	// Failures keep the previous directory
+	output = captureStdout(t, func() { sess.changeDir("missing") })
+	if !strings.Contains(output, "web1: ❌") || sess.connManager.workDir("web1") != root+"/var/log" {
+		t.Errorf("unexpected result for a missing directory: %q", output)
+	}
+
+	// Commands start in the tracked directory
+	output = captureStdout(t, func() { sess.runCommand("pwd", map[string]bool{"web1": true}) })
+	if output != "web1: "+root+"/var/log\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	captureStdout(t, func() { sess.changeDir("") })
+	if sess.connManager.workDir("web1") != "" {
+		t.Error("cd without a directory should return to the login directory")
+	}
+}
+
+func TestPlainCd(t *testing.T) {
+	tests := []struct {
+		line, dir string
+		ok        bool
+	}{
+		{"cd /var/log", "/var/log", true},
+		{"cd", "", true},
+		{"cd ~/src", "~/src", true},
+		{"cd /tmp && ls", "", false},
+		{"cd $(mktemp -d)", "", false},
+		{"cdrecord", "", false},
+	}
+	for _, tt := range tests {
+		if dir, ok := plainCd(tt.line); dir != tt.dir || ok != tt.ok {
+			t.Errorf("plainCd(%q) = %q, %v", tt.line, dir, ok)
+		}
+	}
+
+	if got := quoteRemotePath("~/it's"); got != `"$HOME"/'it'\''s'` {
+		t.Errorf("unexpected quoting: %s", got)
+	}
+}
+
+func TestShell(t *testing.T) {
+	defer func() { Shell = "" }()
+
+	if err := SetShell("fish"); err == nil {
+		t.Error("expected unsupported shell to be rejected")
+	}
+	if err := SetShell("sh"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The command reaches the selected shell unchanged
+	command := prepareCommand(`echo "it's" *.none`)
+	if !strings.HasPrefix(command, "sh -lc ") {
+		t.Errorf("unexpected prepared command: %q", command)
+	}
+	output, err := exec.CommandContext(context.Background(), "sh", "-c", command).Output()
+	if err != nil || string(output) != "it's *.none\n" {
+		t.Errorf("unexpected output %q: %v", output, err)
+	}
+}
+
+func TestDirectFallback(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	server := startFakeSSHServer(t, "127.0.0.1:0", "fallback works\n")
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+
+	SSHOptions = []string{"Port=" + port}
+	StrictHostKeyChecking, KnownHostsFile = "no", "/dev/null"
+	defer func() { SSHOptions, StrictHostKeyChecking, KnownHostsFile, DirectFallback = nil, "", "", false }()
+
+	// The control socket can't be created, so no master can be established
+	cm := NewSSHConnectionManager("")
+	cm.socketDir = filepath.Join(t.TempDir(), "missing")
+	defer cm.closeAllConnections()
+	captureStdout(t, func() {
+		if err := cm.establishConnection(context.Background(), "127.0.0.1"); err == nil {
+			t.Fatal("expected the master to fail without the fallback")
+		}
+	})
+
+	DirectFallback = true
+	defer func(logger *slog.Logger) { Log = logger }(Log)
+	var logged bytes.Buffer
+	Log = slog.New(newConsoleHandler(&logged, new(slog.LevelVar)))
+	if err := cm.establishConnection(context.Background(), "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if !cm.isDirect("127.0.0.1") || !strings.Contains(logged.String(), "connecting anew for every command") {
+		t.Fatalf("expected the host to be reached directly, got %q", logged.String())
+	}
+	if !cm.checkConnection("127.0.0.1") || cm.suspendIfIdle("127.0.0.1") {
+		t.Error("expected a direct host to count as connected and never be suspended")
+	}
+
+	output := captureStdout(t, func() {
+		if err := cm.runSSHStreaming(context.Background(), "127.0.0.1", "echo", nil, 0, 9, true); err != nil {
+			t.Error(err)
+		}
+	})
+	if !strings.Contains(output, "fallback works") {
+		t.Errorf("expected the command to run over a new connection, got %q", output)
+	}
+	if err := cm.openForward(context.Background(), portForward{host: "127.0.0.1", flag: "-D", spec: "1080"}); err == nil || !strings.Contains(err.Error(), "no persistent connection") {
+		t.Errorf("expected forwards to be refused, got %v", err)
+	}
+	if output := captureStdout(t, func() { cm.printStatus([]string{"127.0.0.1"}) }); !strings.Contains(output, "direct") {
+		t.Errorf("expected :status to show the host as direct, got %q", output)
 	}
 }
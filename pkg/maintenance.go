@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaintenanceEntry marks a host as excluded from all selections until a point in time
+type MaintenanceEntry struct {
+	Host  string
+	Until time.Time // Zero means until removed explicitly
+}
+
+// active reports whether the entry still applies at the given time
+func (e MaintenanceEntry) active(now time.Time) bool {
+	return e.Until.IsZero() || now.Before(e.Until)
+}
+
+// DefaultMaintenanceFile returns the default location of the maintenance list
+func DefaultMaintenanceFile() string {
+	return filepath.Join(homeDir(), ".gosh", "maintenance")
+}
+
+// LoadMaintenance reads the maintenance list; each line holds a host and an optional RFC 3339 expiry.
+// Expired entries are dropped and a missing file yields an empty list.
+func LoadMaintenance(path string) ([]MaintenanceEntry, error) {
+	file, err := os.Open(path) // #nosec G304 -- maintenance file path is chosen by the local user
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open maintenance list %s: %w", path, err)
+	}
+	defer file.Close()
+
+	now := time.Now()
+	var entries []MaintenanceEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		entry := MaintenanceEntry{Host: fields[0]}
+		if len(fields) > 1 {
+			entry.Until, err = time.Parse(time.RFC3339, fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("maintenance list %s: invalid expiry for %s: %w", path, entry.Host, err)
+			}
+		}
+		if entry.active(now) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read maintenance list %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// SaveMaintenance writes the maintenance list
+func SaveMaintenance(path string, entries []MaintenanceEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	for _, entry := range entries {
+		sb.WriteString(entry.Host)
+		if !entry.Until.IsZero() {
+			sb.WriteString(" " + entry.Until.Format(time.RFC3339))
+		}
+		sb.WriteString("\n")
+	}
+
+	return os.WriteFile(path, []byte(sb.String()), 0o600)
+}
+
+// AddMaintenance puts hosts into maintenance for the given duration, 0 meaning until removed
+func AddMaintenance(path string, hosts []string, duration time.Duration) error {
+	entries, err := LoadMaintenance(path)
+	if err != nil {
+		return err
+	}
+
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration).Truncate(time.Second)
+	}
+	for _, host := range hosts {
+		entries = slices.DeleteFunc(entries, func(e MaintenanceEntry) bool { return e.Host == host })
+		entries = append(entries, MaintenanceEntry{Host: host, Until: until})
+	}
+
+	return SaveMaintenance(path, entries)
+}
+
+// RemoveMaintenance takes hosts out of maintenance
+func RemoveMaintenance(path string, hosts []string) error {
+	entries, err := LoadMaintenance(path)
+	if err != nil {
+		return err
+	}
+
+	entries = slices.DeleteFunc(entries, func(e MaintenanceEntry) bool { return slices.Contains(hosts, e.Host) })
+	return SaveMaintenance(path, entries)
+}
+
+// ExcludeMaintenance splits hosts into those to run on and those skipped because they are in maintenance
+func ExcludeMaintenance(hosts []string, entries []MaintenanceEntry) (kept, skipped []string) {
+	now := time.Now()
+	for _, host := range hosts {
+		if slices.ContainsFunc(entries, func(e MaintenanceEntry) bool { return e.Host == host && e.active(now) }) {
+			skipped = append(skipped, host)
+		} else {
+			kept = append(kept, host)
+		}
+	}
+	return kept, skipped
+}
+
+// PrintMaintenance lists the hosts currently in maintenance
+func PrintMaintenance(entries []MaintenanceEntry) {
+	if len(entries) == 0 {
+		fmt.Println("🔧 No hosts in maintenance")
+		return
+	}
+
+	fmt.Printf("🔧 Hosts in maintenance (%d):\n", len(entries))
+	for _, entry := range entries {
+		if entry.Until.IsZero() {
+			fmt.Printf("  • %s\n", entry.Host)
+		} else {
+			fmt.Printf("  • %s (until %s)\n", entry.Host, entry.Until.Local().Format(time.DateTime))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance")
+
+	if err := AddMaintenance(path, []string{"web3"}, 0); err != nil {
+		t.Fatalf("AddMaintenance failed: %v", err)
+	}
+	if err := AddMaintenance(path, []string{"web4", "web5"}, 2*time.Hour); err != nil {
+		t.Fatalf("AddMaintenance failed: %v", err)
+	}
+
+	entries, err := LoadMaintenance(path)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %v, %v", entries, err)
+	}
+
+	kept, skipped := ExcludeMaintenance([]string{"web1", "web3", "web4"}, entries)
+	if !slices.Equal(kept, []string{"web1"}) || !slices.Equal(skipped, []string{"web3", "web4"}) {
+		t.Errorf("unexpected split: kept %v, skipped %v", kept, skipped)
+	}
+
+	if err := RemoveMaintenance(path, []string{"web3", "web5"}); err != nil {
+		t.Fatalf("RemoveMaintenance failed: %v", err)
+	}
+	entries, _ = LoadMaintenance(path)
+	if len(entries) != 1 || entries[0].Host != "web4" {
+		t.Errorf("unexpected entries after remove: %v", entries)
+	}
+}
+
+func TestLoadMaintenanceDropsExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance")
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	if err := os.WriteFile(path, []byte("# broken disks\nold "+past+"\nforever\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := LoadMaintenance(path)
+	if err != nil {
+		t.Fatalf("LoadMaintenance failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Host != "forever" || !entries[0].Until.IsZero() {
+		t.Errorf("expected only the indefinite entry, got %v", entries)
+	}
+}
@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connectBuckets are the upper bounds in seconds of the connection latency histogram
+var connectBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// hostCounters are the per-host counters
+type hostCounters struct {
+	ok, failed   int64 // Finished commands by outcome
+	connectFails int64 // Failed connection attempts
+}
+
+// fleetMetrics counts what gosh did since the process started, exported in the Prometheus text format
+type fleetMetrics struct {
+	mu          sync.Mutex
+	hosts       map[string]*hostCounters
+	connectHist []int64 // Cumulative counts per connectBuckets entry, +Inf is connectN
+	connectSum  float64
+	connectN    int64
+
+	stdoutBytes, stderrBytes atomic.Int64
+}
+
+// metrics is the process-wide instrumentation of connections and commands
+var metrics = &fleetMetrics{}
+
+// host returns the counters of host, creating them; m.mu must be held
+func (m *fleetMetrics) host(host string) *hostCounters {
+	if m.hosts == nil {
+		m.hosts = make(map[string]*hostCounters)
+	}
+	counters := m.hosts[host]
+	if counters == nil {
+		counters = &hostCounters{}
+		m.hosts[host] = counters
+	}
+	return counters
+}
+
+// commandDone counts a command that finished on host
+func (m *fleetMetrics) commandDone(host string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.host(host).failed++
+	} else {
+		m.host(host).ok++
+	}
+}
+
+// connected records how long establishing a connection to host took, and whether it failed
+func (m *fleetMetrics) connected(host string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.host(host).connectFails++
+		return
+	}
+	if m.connectHist == nil {
+		m.connectHist = make([]int64, len(connectBuckets))
+	}
+	seconds := duration.Seconds()
+	for i, bound := range connectBuckets {
+		if seconds <= bound {
+			m.connectHist[i]++
+		}
+	}
+	m.connectSum += seconds
+	m.connectN++
+}
+
+// received counts n bytes of remote output on stream
+func (m *fleetMetrics) received(stream Stream, n int) {
+	if stream == StreamStderr {
+		m.stderrBytes.Add(int64(n))
+	} else {
+		m.stdoutBytes.Add(int64(n))
+	}
+}
+
+// writeTo writes all metrics in the Prometheus text exposition format
+func (m *fleetMetrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hosts := make([]string, 0, len(m.hosts))
+	for host := range m.hosts {
+		hosts = append(hosts, host)
+	}
+	slices.Sort(hosts)
+
+	var ok, failed int64
+	for _, counters := range m.hosts {
+		ok += counters.ok
+		failed += counters.failed
+	}
+
+	fmt.Fprintf(w, "# HELP gosh_commands_total Commands finished on hosts.\n# TYPE gosh_commands_total counter\n")
+	fmt.Fprintf(w, "gosh_commands_total{status=\"ok\"} %d\ngosh_commands_total{status=\"failed\"} %d\n", ok, failed)
+
+	fmt.Fprintf(w, "# HELP gosh_host_failures_total Commands that failed per host.\n# TYPE gosh_host_failures_total counter\n")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "gosh_host_failures_total{host=%s} %d\n", promLabel(host), m.hosts[host].failed)
+	}
+
+	fmt.Fprintf(w, "# HELP gosh_connection_failures_total Failed persistent connection attempts per host.\n# TYPE gosh_connection_failures_total counter\n")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "gosh_connection_failures_total{host=%s} %d\n", promLabel(host), m.hosts[host].connectFails)
+	}
+
+	fmt.Fprintf(w, "# HELP gosh_connection_establish_seconds Time to establish persistent connections.\n# TYPE gosh_connection_establish_seconds histogram\n")
+	for i, bound := range connectBuckets {
+		fmt.Fprintf(w, "gosh_connection_establish_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.bucket(i))
+	}
+	fmt.Fprintf(w, "gosh_connection_establish_seconds_bucket{le=\"+Inf\"} %d\n", m.connectN)
+	fmt.Fprintf(w, "gosh_connection_establish_seconds_sum %g\ngosh_connection_establish_seconds_count %d\n", m.connectSum, m.connectN)
+
+	fmt.Fprintf(w, "# HELP gosh_output_bytes_total Bytes of remote output received.\n# TYPE gosh_output_bytes_total counter\n")
+	fmt.Fprintf(w, "gosh_output_bytes_total{stream=\"stdout\"} %d\ngosh_output_bytes_total{stream=\"stderr\"} %d\n",
+		m.stdoutBytes.Load(), m.stderrBytes.Load())
+}
+
+// bucket returns the cumulative count of bucket i; m.mu must be held
+func (m *fleetMetrics) bucket(i int) int64 {
+	if m.connectHist == nil {
+		return 0
+	}
+	return m.connectHist[i]
+}
+
+// promLabel quotes a label value
+func promLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// MetricsHandler serves the metrics of this process for Prometheus
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.writeTo(w)
+	})
+}
+
+// ServeMetrics serves only /metrics on addr until ctx ends, for scraping a daemon that listens on a Unix socket
+func ServeMetrics(ctx context.Context, addr string) error {
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", MetricsHandler())
+	return serveOn(ctx, listener, mux)
+}
@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := &fleetMetrics{}
+	m.commandDone("web1", nil)
+	m.commandDone("web2", errors.New("exit status 1"))
+	m.commandDone("web2", nil)
+	m.connected("web1", 300*time.Millisecond, nil)
+	m.connected("web2", 0, errors.New("timeout"))
+	m.received(StreamStdout, 10)
+	m.received(StreamStderr, 4)
+
+	var out strings.Builder
+	m.writeTo(&out)
+	for _, want := range []string{
+		`gosh_commands_total{status="ok"} 2`,
+		`gosh_commands_total{status="failed"} 1`,
+		`gosh_host_failures_total{host="web2"} 1`,
+		`gosh_connection_failures_total{host="web2"} 1`,
+		`gosh_connection_establish_seconds_bucket{le="0.25"} 0`,
+		`gosh_connection_establish_seconds_bucket{le="0.5"} 1`,
+		`gosh_connection_establish_seconds_bucket{le="+Inf"} 1`,
+		`gosh_connection_establish_seconds_count 1`,
+		`gosh_output_bytes_total{stream="stdout"} 10`,
+		`gosh_output_bytes_total{stream="stderr"} 4`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+
+	if got := promLabel(`a"b\c`); got != `"a\"b\\c"` {
+		t.Errorf("unexpected label: %s", got)
+	}
+}
+
+func TestMetricsStreamCommand(t *testing.T) {
+	saved := metrics
+	metrics = &fleetMetrics{}
+	defer func() { metrics = saved }()
+
+	captureStdout(t, func() {
+		cmd := exec.CommandContext(context.Background(), "sh", "-c", "echo hello; exit 1")
+		_ = streamCommand(context.Background(), cmd, "web1", "web1", "")
+	})
+	if metrics.hosts["web1"].failed != 1 || metrics.stdoutBytes.Load() != 6 {
+		t.Errorf("expected one failure and 6 bytes, got %+v and %d", metrics.hosts["web1"], metrics.stdoutBytes.Load())
+	}
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// CompletionHosts is the number of hosts Tab completion asks, starting with the first one; 0 asks all hosts
+var CompletionHosts = 1
+
+// CompletionIntersect only offers remote completions that exist on every host that answered
+var CompletionIntersect bool
+
+// remoteCompletions completes word on the first CompletionHosts hosts in parallel and merges their
+// completions in the order they were found. Completions missing on some of the hosts that answered are
+// returned in partial with how many hosts have them, e.g. "2/5"; with CompletionIntersect they are dropped.
+func remoteCompletions(word string, hosts []string, connMgr *SSHConnectionManager) (completions []string, partial map[string]string) {
+	if len(hosts) == 0 || connMgr == nil {
+		return []string{}, nil
+	}
+	return mergeCompletions(hosts, func(host string) ([]string, bool) { return hostCompletions(word, host, connMgr) })
+}
+
+// remotePathCompletions completes word as a path on the hosts like remoteCompletions, directories with a
+// trailing "/". With dirsOnly only directories are offered.
+func remotePathCompletions(word string, hosts []string, connMgr *SSHConnectionManager, dirsOnly bool) ([]string, map[string]string) {
+	if len(hosts) == 0 || connMgr == nil {
+		return []string{}, nil
+	}
+	compgenCmd := "compgen -f -- " + shellQuote(word) + ` | while IFS= read -r f; do if [ -d "$f" ]; then echo "$f/"; else echo "$f"; fi; done`
+	if dirsOnly {
+		compgenCmd = "compgen -d -- " + shellQuote(word) + ` | while IFS= read -r f; do echo "$f/"; done`
+	}
+	return mergeCompletions(hosts, func(host string) ([]string, bool) {
+		shell := connMgr.shellOf(host)
+		if shell == shellPOSIX || shell == shellRestricted {
+			return []string{}, false
+		}
+		return connMgr.lookupCompletions(host, shell, compgenCmd)
+	})
+}
+
+// mergeCompletions asks the first CompletionHosts hosts in parallel with lookup, which reports false for
+// hosts that didn't answer, and merges the results as described for remoteCompletions
+func mergeCompletions(hosts []string, lookup func(host string) ([]string, bool)) (completions []string, partial map[string]string) {
+	targets := hosts
+	if CompletionHosts > 0 && CompletionHosts < len(hosts) {
+		targets = hosts[:CompletionHosts]
+	}
+
+	results := make([][]string, len(targets))
+	answered := make([]bool, len(targets))
+	var wg sync.WaitGroup
+	for i, host := range targets {
+		wg.Go(func() { results[i], answered[i] = lookup(host) })
+	}
+	wg.Wait()
+
+	// Hosts that didn't answer in time don't count, or every completion would look partial
+	counts := make(map[string]int)
+	var merged []string
+	total := 0
+	for i, result := range results {
+		if !answered[i] {
+			continue
+		}
+		total++
+		for _, completion := range result {
+			if counts[completion] == 0 {
+				merged = append(merged, completion)
+			}
+			counts[completion]++
+		}
+	}
+
+	partial = make(map[string]string)
+	for _, completion := range merged {
+		if counts[completion] < total {
+			if CompletionIntersect {
+				continue
+			}
+			partial[completion] = fmt.Sprintf("%d/%d", counts[completion], total)
+		}
+		completions = append(completions, completion)
+	}
+	return completions, partial
+}
+
+// printPartialCompletions notes which of the offered suffixes of word complete to something that exists on
+// some hosts only, e.g. "💡 On some hosts only: nginx (2/5)". readline can't mark candidates themselves.
+func printPartialCompletions(out io.Writer, word string, suffixes []string, partial map[string]string) {
+	var notes []string
+	for _, suffix := range suffixes {
+		if hosts, ok := partial[word+suffix]; ok {
+			notes = append(notes, word+suffix+" ("+hosts+")")
+		}
+	}
+	if len(notes) > 0 {
+		fmt.Fprintf(out, "💡 On some hosts only: %s\n", strings.Join(notes, ", "))
+	}
+}
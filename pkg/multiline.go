@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// continuationPrompt is shown while a command continues over several lines, like the shell's PS2
+const continuationPrompt = "> "
+
+// heredoc is a pending here-document whose body is still being read
+type heredoc struct {
+	delimiter string
+	stripTabs bool // <<- ignores leading tabs before the delimiter
+}
+
+// commandBuffer collects the lines of one command entered across several lines: lines ending in a
+// backslash continue on the next one, and here-documents (<<EOF ... EOF) run until their delimiter
+type commandBuffer struct {
+	text      strings.Builder
+	heredocs  []heredoc
+	continued bool // The last line ended in a backslash
+}
+
+// add appends a typed line and reports whether the command is complete
+func (b *commandBuffer) add(line string) bool {
+	if len(b.heredocs) > 0 {
+		b.text.WriteString("\n" + line)
+		doc := b.heredocs[0]
+		if doc.stripTabs {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == doc.delimiter {
+			b.heredocs = b.heredocs[1:]
+		}
+		return len(b.heredocs) == 0
+	}
+
+	if b.text.Len() > 0 && !b.continued {
+		b.text.WriteString("\n")
+	}
+	docs, continued := scanCommandLine(line)
+	if continued {
+		line = line[:len(line)-1] // The shell drops backslash-newline, so the parts join directly
+	}
+	b.text.WriteString(line)
+	b.heredocs = append(b.heredocs, docs...)
+	b.continued = continued
+	return !continued && len(b.heredocs) == 0
+}
+
+// String returns the command entered so far
+func (b *commandBuffer) String() string {
+	return b.text.String()
+}
+
+// scanCommandLine finds the here-documents started on a command line and reports whether the line
+// ends in an unquoted backslash. Quotes and comments are honored, here-strings (<<<) are not heredocs.
+func scanCommandLine(line string) (docs []heredoc, continued bool) {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			if i == len(line)-1 {
+				return docs, true
+			}
+			i++ // Skip the escaped character
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return docs, false
+		case c == '<' && strings.HasPrefix(line[i:], "<<<"):
+			i += 2
+		case c == '<' && strings.HasPrefix(line[i:], "<<"):
+			i += 2
+			doc := heredoc{}
+			if i < len(line) && line[i] == '-' {
+				doc.stripTabs = true
+				i++
+			}
+			doc.delimiter, i = heredocDelimiter(line, i)
+			if doc.delimiter != "" {
+				docs = append(docs, doc)
+			}
+			i-- // The loop advances past the delimiter's last character
+		}
+	}
+	return docs, false
+}
+
+// heredocDelimiter reads the delimiter word starting at i, removing its quotes, and returns the index after it
+func heredocDelimiter(line string, i int) (string, int) {
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+
+	var word strings.Builder
+	quote := byte(0)
+	for ; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+		case strings.IndexByte(" \t;&|<>()", c) >= 0:
+			return word.String(), i
+		default:
+			word.WriteByte(c)
+		}
+	}
+	return word.String(), i
+}
+
+// readCommand reads one command, prompting for continuation lines until it is complete.
+// Ctrl+C on a continuation line discards the command; end of input submits what was entered so far.
+func (s *session) readCommand() (string, error) {
+	line, err := s.rl.Readline()
+	if err != nil {
+		return "", err
+	}
+
+	var buf commandBuffer
+	if buf.add(line) {
+		return buf.String(), nil
+	}
+
+	s.rl.SetPrompt(continuationPrompt)
+	defer s.rl.SetPrompt(s.prompt())
+
+	for {
+		line, err := s.rl.Readline()
+		switch {
+		case errors.Is(err, readline.ErrInterrupt):
+			return "", nil
+		case errors.Is(err, io.EOF):
+			return buf.String(), nil
+		case err != nil:
+			return "", err
+		}
+		if buf.add(line) {
+			return buf.String(), nil
+		}
+	}
+}
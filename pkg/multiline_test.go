@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"io"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func TestCommandBuffer(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"single line", []string{"uptime"}, "uptime"},
+		{"backslash continuation", []string{`apt-get install \`, `  curl`}, "apt-get install   curl"},
+		{"escaped backslash", []string{`echo a\\`}, `echo a\\`},
+		{"quoted backslash", []string{`echo 'a\'`}, `echo 'a\'`},
+		{"heredoc", []string{"cat <<EOF > /tmp/x", "a $HOME", "EOF"}, "cat <<EOF > /tmp/x\na $HOME\nEOF"},
+		{"quoted delimiter", []string{"cat <<'END'", "EOF", "END"}, "cat <<'END'\nEOF\nEND"},
+		{"tab stripping", []string{"cat <<-EOF", "\tx", "\tEOF"}, "cat <<-EOF\n\tx\n\tEOF"},
+		{"two heredocs", []string{"cat <<A <<B", "1", "A", "2", "B"}, "cat <<A <<B\n1\nA\n2\nB"},
+		{"continuation into heredoc", []string{`cat \`, "<<EOF", "x", "EOF"}, "cat <<EOF\nx\nEOF"},
+		{"here-string", []string{"cat <<< EOF"}, "cat <<< EOF"},
+		{"quoted operator", []string{`echo "<<EOF"`}, `echo "<<EOF"`},
+		{"comment", []string{"echo hi # <<EOF"}, "echo hi # <<EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf commandBuffer
+			for i, line := range tt.lines {
+				if complete := buf.add(line); complete != (i == len(tt.lines)-1) {
+					t.Fatalf("line %d (%q): complete = %v", i, line, complete)
+				}
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	keys, typed := io.Pipe()
+	rl, err := readline.NewEx(&readline.Config{
+		Stdin:          keys,
+		Stdout:         io.Discard,
+		FuncIsTerminal: func() bool { return true },
+		FuncMakeRaw:    func() error { return nil },
+		FuncExitRaw:    func() error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	sess := &session{hosts: []string{"web1"}, noColor: true, rl: rl}
+
+	go func() {
+		_, _ = io.WriteString(typed, "cat <<EOF\nline\nEOF\n")
+		_, _ = io.WriteString(typed, "echo a \\\n")
+		_, _ = typed.Write([]byte{readline.CharInterrupt})
+		_, _ = io.WriteString(typed, "uptime\n")
+	}()
+
+	if command, err := sess.readCommand(); err != nil || command != "cat <<EOF\nline\nEOF" {
+		t.Errorf("unexpected heredoc command %q: %v", command, err)
+	}
+	// Ctrl+C on a continuation line discards the command
+	if command, err := sess.readCommand(); err != nil || command != "" {
+		t.Errorf("expected interrupted command to be discarded, got %q: %v", command, err)
+	}
+	if command, err := sess.readCommand(); err != nil || command != "uptime" {
+		t.Errorf("unexpected command %q: %v", command, err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package pkg
+
+// controlMasterSupported reports whether ssh can share a connection through a control socket. The OpenSSH
+// client of Windows has no ControlMaster, so every command opens a connection of its own.
+const controlMasterSupported = false
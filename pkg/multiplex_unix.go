@@ -0,0 +1,7 @@
+//go:build unix
+
+package pkg
+
+// controlMasterSupported reports whether ssh can share a connection through a control socket, which OpenSSH
+// only offers on Unix
+const controlMasterSupported = true
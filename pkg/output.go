@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// outputBatchSize is the number of bytes collected before the writer goroutine writes to stdout
+const outputBatchSize = 64 << 10
+
+// maxQueuedOutput caps the bytes waiting for the writer goroutine. Producers block beyond it, which in turn
+// stops reading from ssh, so a slow terminal throttles the remote commands instead of growing memory.
+const maxQueuedOutput = 4 << 20
+
+// maxLineLength caps a single line of remote output; longer lines are truncated instead of stalling the stream
+const maxLineLength = 64 << 10
+
+// truncatedMarker ends lines cut at maxLineLength
+const truncatedMarker = " …[truncated]"
+
+// linePool recycles the buffers used to format prefixed output lines
+var linePool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// outputRequest is either a formatted line or, with done set, a flush barrier
+type outputRequest struct {
+	buf  *bytes.Buffer
+	done chan struct{}
+}
+
+var (
+	outputOnce sync.Once
+	outputCh   chan outputRequest
+
+	queueMu     sync.Mutex
+	queueFreed  = sync.NewCond(&queueMu)
+	queuedBytes int // Bytes handed to the writer goroutine but not yet written
+)
+
+// reserveOutput waits until n more bytes fit into the queue. A single oversized request is let through
+// when the queue is empty so it cannot block forever.
+func reserveOutput(n int) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	for queuedBytes > 0 && queuedBytes+n > maxQueuedOutput {
+		queueFreed.Wait()
+	}
+	queuedBytes += n
+}
+
+// releaseOutput frees n written bytes and wakes blocked producers
+func releaseOutput(n int) {
+	queueMu.Lock()
+	queuedBytes -= n
+	queueMu.Unlock()
+	queueFreed.Broadcast()
+}
+
+// startOutputWriter starts the single goroutine that owns writes of host output to stdout
+func startOutputWriter() {
+	outputOnce.Do(func() {
+		outputCh = make(chan outputRequest, 4096)
+		go outputLoop()
+	})
+}
+
+// outputLoop batches lines and writes them whenever the queue runs empty, the batch is full or a flush is requested
+func outputLoop() {
+	batch := make([]byte, 0, outputBatchSize)
+	for req := range outputCh {
+		if req.buf != nil {
+			batch = append(batch, req.buf.Bytes()...)
+			req.buf.Reset()
+			linePool.Put(req.buf)
+		}
+
+		if req.done != nil || len(outputCh) == 0 || len(batch) >= outputBatchSize {
+			_, _ = os.Stdout.Write(batch)
+			releaseOutput(len(batch))
+			batch = batch[:0]
+		}
+		if req.done != nil {
+			close(req.done)
+		}
+	}
+}
+
+// writeHostLine queues "prefix: line" for output without allocating per line
+func writeHostLine(prefix string, line []byte) {
+	buf := linePool.Get().(*bytes.Buffer)
+	buf.Grow(len(prefix) + len(line) + 3)
+	buf.WriteString(prefix)
+	buf.WriteString(": ")
+	buf.Write(line)
+	buf.WriteByte('\n')
+	queueOutput(buf)
+}
+
+// printOutput queues formatted text for output. Everything printed in one call is written together,
+// so concurrent callers never split each other's lines.
+func printOutput(format string, args ...any) {
+	buf := linePool.Get().(*bytes.Buffer)
+	fmt.Fprintf(buf, format, args...)
+	queueOutput(buf)
+}
+
+// queueOutput hands buf to the writer goroutine, waiting while the queue is full
+func queueOutput(buf *bytes.Buffer) {
+	startOutputWriter()
+	reserveOutput(buf.Len())
+	outputCh <- outputRequest{buf: buf}
+}
+
+// flushOutput blocks until all queued lines have been written to stdout
+func flushOutput() {
+	startOutputWriter()
+
+	done := make(chan struct{})
+	outputCh <- outputRequest{done: done}
+	<-done
+}
+
+// scanCappedLines splits like bufio.ScanLines, but cuts lines longer than limit and skips the rest of them,
+// so a runaway line (a minified file, a binary blob) neither aborts the stream nor grows the buffer
+func scanCappedLines(limit int) bufio.SplitFunc {
+	skipping := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		line, _, found := bytes.Cut(data, []byte("\n"))
+		switch {
+		case found && skipping:
+			skipping = false
+			return len(line) + 1, nil, nil
+		case found && len(line) > limit:
+			return len(line) + 1, append(bytes.Clone(line[:limit]), truncatedMarker...), nil
+		case found:
+			return len(line) + 1, bytes.TrimSuffix(line, []byte("\r")), nil
+		case skipping && len(data) > 0:
+			return len(data), nil, nil
+		case len(data) > limit:
+			skipping = true
+			return len(data), append(bytes.Clone(data[:limit]), truncatedMarker...), nil
+		case atEOF && len(data) > 0:
+			return len(data), bytes.TrimSuffix(data, []byte("\r")), nil
+		}
+		return 0, nil, nil
+	}
+}
@@ -0,0 +1,165 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fan-out used by the output benchmarks: 500 hosts × 10k lines each
+const (
+	benchHosts = 500
+	benchLines = 10000
+)
+
+// redirectStdoutToDevNull points stdout at /dev/null for the duration of a benchmark
+func redirectStdoutToDevNull(b *testing.B) {
+	b.Helper()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatalf("Failed to open %s: %v", os.DevNull, err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout = devNull
+	b.Cleanup(func() {
+		os.Stdout = oldStdout
+		devNull.Close()
+	})
+}
+
+// benchmarkFanOut emits benchLines lines from each of benchHosts concurrent producers
+func benchmarkFanOut(b *testing.B, write func(prefix string, line []byte)) {
+	b.Helper()
+	redirectStdoutToDevNull(b)
+
+	line := []byte(strings.Repeat("x", 80))
+	prefixes := make([]string, benchHosts)
+	for i := range prefixes {
+		prefixes[i] = formatHostPrefix(fmt.Sprintf("host%03d", i), i, 7, false)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		var wg sync.WaitGroup
+		for _, prefix := range prefixes {
+			wg.Go(func() {
+				for range benchLines {
+					write(prefix, line)
+				}
+			})
+		}
+		wg.Wait()
+		flushOutput()
+	}
+	b.ReportMetric(float64(benchHosts*benchLines*b.N)/b.Elapsed().Seconds(), "lines/s")
+}
+
+// BenchmarkOutputPrintf measures the previous per-line fmt.Printf output path for comparison
+func BenchmarkOutputPrintf(b *testing.B) {
+	benchmarkFanOut(b, func(prefix string, line []byte) {
+		fmt.Printf("%s: %s\n", prefix, line)
+	})
+}
+
+// BenchmarkOutputPooledWriter measures the pooled single-writer output path
+func BenchmarkOutputPooledWriter(b *testing.B) {
+	benchmarkFanOut(b, writeHostLine)
+}
+
+func TestWriteHostLineOrdering(t *testing.T) {
+	output := captureStdout(t, func() {
+		for i := range 1000 {
+			writeHostLine("web1", fmt.Appendf(nil, "line %d", i))
+		}
+		flushOutput()
+	})
+
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != 1000 {
+		t.Fatalf("expected 1000 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if line != fmt.Sprintf("web1: line %d", i) {
+			t.Fatalf("line %d out of order: %q", i, line)
+		}
+	}
+}
+
+func TestPrintOutputKeepsCallsTogether(t *testing.T) {
+	output := captureStdout(t, func() {
+		var wg sync.WaitGroup
+		for i := range 50 {
+			wg.Go(func() {
+				printOutput("host%d: first\nhost%d: second\n", i, i)
+			})
+		}
+		wg.Wait()
+		flushOutput()
+	})
+
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("expected 100 lines, got %d", len(lines))
+	}
+	for i := 0; i < len(lines); i += 2 {
+		host, _, _ := strings.Cut(lines[i], ":")
+		if lines[i+1] != host+": second" {
+			t.Fatalf("lines of %s were split: %q", host, lines[i:i+2])
+		}
+	}
+}
+
+func TestReserveOutputBlocksWhenFull(t *testing.T) {
+	reserveOutput(maxQueuedOutput)
+	reserved := make(chan struct{})
+	go func() {
+		reserveOutput(1)
+		close(reserved)
+	}()
+
+	select {
+	case <-reserved:
+		t.Fatal("expected the producer to wait for a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	releaseOutput(maxQueuedOutput)
+	select {
+	case <-reserved:
+	case <-time.After(time.Second):
+		t.Fatal("expected the producer to continue after the queue drained")
+	}
+	releaseOutput(1)
+
+	// An oversized request passes an empty queue
+	reserveOutput(2 * maxQueuedOutput)
+	releaseOutput(2 * maxQueuedOutput)
+}
+
+func TestScanCappedLines(t *testing.T) {
+	long := strings.Repeat("x", 20)
+	input := "short\r\n" + long + "\nexact-10ch\n" + long + long + "\nlast"
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	scanner.Buffer(make([]byte, 4), 12)
+	scanner.Split(scanCappedLines(10))
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cut := strings.Repeat("x", 10) + truncatedMarker
+	want := []string{"short", cut, "exact-10ch", cut, "last"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
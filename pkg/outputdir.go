@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OutputDir is the directory receiving one log file per host; empty disables per-host logs
+var OutputDir string
+
+// OutputMaxSize rotates a host log once it would grow beyond this many bytes; 0 disables rotation
+var OutputMaxSize int64
+
+// OutputKeep is the number of rotated, gzip-compressed logs kept per host
+var OutputKeep = 5
+
+// hostLog is an append-only log file for a single host with size-based rotation
+type hostLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+var (
+	hostLogsMu sync.Mutex
+	hostLogs   = make(map[string]*hostLog)
+)
+
+// ParseSize parses a byte size such as "512", "64K", "10M" or "1G"
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// hostFileName returns host as a file name: slashes, e.g. of namespace/pod, become underscores, and on
+// Windows so do the characters its file names can't hold, e.g. the colon of docker:web
+func hostFileName(host string) string {
+	invalid := "/"
+	if runtime.GOOS == "windows" {
+		invalid = `/\:*?"<>|`
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(invalid, r) {
+			return '_'
+		}
+		return r
+	}, host)
+}
+
+// logHostLine appends a line of host output to the host's log file, if per-host logs are enabled
+func logHostLine(host, line string) {
+	if OutputDir == "" {
+		return
+	}
+
+	hostLogsMu.Lock()
+	log, ok := hostLogs[host]
+	if !ok {
+		log = &hostLog{path: filepath.Join(OutputDir, hostFileName(host)+".log")}
+		hostLogs[host] = log
+	}
+	hostLogsMu.Unlock()
+
+	if err := log.writeLine(line); err != nil {
+		Log.Warn("Failed to write host log", "host", host, "err", err)
+	}
+}
+
+// closeHostLogs closes all open per-host log files
+func closeHostLogs() {
+	hostLogsMu.Lock()
+	defer hostLogsMu.Unlock()
+
+	for host, log := range hostLogs {
+		log.mu.Lock()
+		if log.file != nil {
+			_ = log.file.Close()
+		}
+		log.mu.Unlock()
+		delete(hostLogs, host)
+	}
+}
+
+// writeLine appends a line, rotating the file first if it would exceed OutputMaxSize
+func (l *hostLog) writeLine(line string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	data := line + "\n"
+	if OutputMaxSize > 0 && l.size > 0 && l.size+int64(len(data)) > OutputMaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.WriteString(data)
+	l.size += int64(n)
+	return err
+}
+
+// open opens the log file for appending and records its current size
+func (l *hostLog) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o750); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640) // #nosec G304 -- path is built from the output directory chosen by the user
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate compresses the current log to <path>.1.gz, shifting older archives and dropping those beyond OutputKeep
+func (l *hostLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if OutputKeep > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d.gz", l.path, OutputKeep))
+		for i := OutputKeep - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d.gz", l.path, i), fmt.Sprintf("%s.%d.gz", l.path, i+1))
+		}
+		if err := gzipFile(l.path, l.path+".1.gz"); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// gzipFile writes a gzip-compressed copy of src to dst
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src) // #nosec G304 -- src is a host log inside the output directory
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640) // #nosec G304 -- dst is a host log inside the output directory
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"64K", 64 << 10, false},
+		{"10m", 10 << 20, false},
+		{"1G", 1 << 30, false},
+		{"abc", 0, true},
+		{"-5", 0, true},
+	}
+
+	for _, test := range tests {
+		size, err := ParseSize(test.input)
+		if (err != nil) != test.wantErr || size != test.expected {
+			t.Errorf("ParseSize(%q) = %d, %v; expected %d", test.input, size, err, test.expected)
+		}
+	}
+}
+
+func TestHostLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	OutputDir, OutputMaxSize, OutputKeep = dir, 20, 2
+	defer func() {
+		closeHostLogs()
+		OutputDir, OutputMaxSize, OutputKeep = "", 0, 5
+	}()
+
+	// Each line is 10 bytes, so every second line triggers a rotation
+	for _, line := range []string{"line-0001", "line-0002", "line-0003", "line-0004", "line-0005", "line-0006", "line-0007"} {
+		logHostLine("web1", line)
+	}
+	closeHostLogs()
+
+	current, err := os.ReadFile(filepath.Join(dir, "web1.log"))
+	if err != nil {
+		t.Fatalf("missing current log: %v", err)
+	}
+	if string(current) != "line-0007\n" {
+		t.Errorf("unexpected current log: %q", current)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "web1.log.1.gz"))
+	if err != nil {
+		t.Fatalf("missing rotated log: %v", err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("rotated log is not gzip: %v", err)
+	}
+	rotated, _ := io.ReadAll(zr)
+	if !strings.Contains(string(rotated), "line-0005") {
+		t.Errorf("unexpected rotated content: %q", rotated)
+	}
+
+	// Only OutputKeep archives are retained
+	if _, err := os.Stat(filepath.Join(dir, "web1.log.3.gz")); !os.IsNotExist(err) {
+		t.Error("expected archives beyond OutputKeep to be removed")
+	}
+}
+
+func TestHostFileName(t *testing.T) {
+	if name := hostFileName("prod/web-0"); name != "prod_web-0" {
+		t.Errorf("expected slashes to become underscores, got %q", name)
+	}
+	expected := "docker:web"
+	if runtime.GOOS == "windows" {
+		expected = "docker_web"
+	}
+	if name := hostFileName("docker:web"); name != expected {
+		t.Errorf("expected %q, got %q", expected, name)
+	}
+}
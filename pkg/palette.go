@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// commandInfo describes an interactive command for help, completion and the command palette
+type commandInfo struct {
+	name        string // Command word including the leading colon, e.g. ":upload"
+	usage       string // Arguments shown after the name
+	description string
+}
+
+// builtinCommands lists all internal interactive commands in help order
+var builtinCommands = []commandInfo{
+	{":help", "", "Show this help"},
+	{":?", "[query]", "Search commands, aliases and keybindings"},
+	{":upload", "<file> [dir]", "Upload file to all hosts (home directory or dir)"},
+	{":download", "<path> [dir]", "Download a file from all hosts to dir/<host>/ (current directory)"},
+	{":exit", "", "Exit interactive mode (also :quit)"},
+	{":quit", "", "Exit interactive mode"},
+	{":hosts", "", "List connected hosts"},
+	{":verbose", "", "Toggle verbose output mode"},
+	{":status", "", "Show connection health, shell, age and last command duration per host"},
+	{":last", "", "Show on which hosts the last command failed and why"},
+	{":retry", "", "Re-run the last command on the hosts it failed on"},
+	{":reconnect", "[host|all]", "Re-establish persistent connections, e.g. after a reboot"},
+	{":add", "<host>", "Connect to an additional host"},
+	{":remove", "<host>", "Disconnect a host"},
+	{":forward", "[-L|-R] <spec> [hosts]", "Open a tunnel over the connections, e.g. :forward 9090:localhost:9090 web1 (-L listens here, -R on the hosts)"},
+	{":socks", "<port> <host>", "Open a SOCKS proxy on this machine whose connections leave from host"},
+	{":forwards", "", "List open tunnels and SOCKS proxies"},
+	{":unforward", "<n>...|all", "Close tunnels and SOCKS proxies by their number in :forwards"},
+	{":trust", "<host>", "Show the current host keys of a host, trust them once confirmed and connect"},
+	{":facts", "[refresh]", "Gather OS, kernel, arch, uptime, memory and IPs of the targeted hosts once and show them; commands can use {facts.os} etc."},
+	{":port", "<port> <host>", "Check from every host whether host:port accepts TCP connections"},
+	{":on", "<hosts> <cmd>", "Run a command on matching hosts only (e.g. :on web1,db* uptime or :on @role=web uptime)"},
+	{":tag", "[hosts key=value...]", "List the tags of the hosts, or tag matching hosts for @key=value targeting, saved to ~/.gosh/tags"},
+	{":untag", "<hosts> <key>...", "Remove tags from matching hosts"},
+	{":same", "<cmd>", "Run a command and report whether all hosts produced the same output and exit status, or the outliers"},
+	{":select", "<hosts>", "Restrict subsequent commands to matching hosts (:select all to reset)"},
+	{":script", "<file> [args]", "Run a local script on the targeted hosts by streaming it to the interpreter"},
+	{":cd", "[dir]", "Change the working directory of subsequent commands (plain cd works too)"},
+	{":env", "[set K=V|unset K]", "List, set or unset variables exported into every command"},
+	{":kill", "[hosts]", "While a command runs: terminate it with its remote process group, on all or matching hosts"},
+	{":stdin", "on|off", "Send lines typed while a command runs to all hosts, e.g. to answer prompts"},
+	{":tty", "on|off", "Run commands with a pseudo-terminal for pagers, top and sudo"},
+	{":setenv", "KEY=VALUE", "Set a variable for every subsequent command (typed export/unset are tracked too)"},
+	{":sudo", "on|off", "Run subsequent commands through sudo, asking for the password once"},
+	{":when", "[guard|off]", "Only run subsequent commands on hosts where the guard succeeds, e.g. :when test -f /etc/redhat-release"},
+	{":confirm", "on|off", "Ask y/n/all/quit before running each command on each host"},
+	{":tail", "[-n N] <file>...", "Follow log files on all hosts (tail -F, survives rotation) until Ctrl+C"},
+	{":watch", "<interval> <cmd>", "Re-run a command every interval (e.g. :watch 5s df -h) until Ctrl+C"},
+	{":history", "[N|text]", "List recent commands with their numbers; run one again with !N, !! or !prefix"},
+	{":alias", "[name='cmd']", "List aliases or define one, saved to ~/.gosh/aliases"},
+	{":unalias", "<name>", "Remove an alias"},
+	{":refresh-completions", "", "Fetch remote command names for Tab completion again (cached for 5 minutes)"},
+}
+
+// keybindings lists the line editor shortcuts shown in the command palette
+var keybindings = []commandInfo{
+	{"Tab", "", "Complete history, aliases, commands, remote paths and :upload/:download files; fuzzy-match history when nothing completes"},
+	{"Ctrl+R", "", "Search command history"},
+	{"Ctrl+C", "", "Interrupt the running command"},
+	{"Ctrl+D", "", "Exit interactive mode"},
+}
+
+// paletteSources returns extra palette entries such as user-defined aliases; features register them here
+var paletteSources []func() []commandInfo
+
+// commandNames returns the names of all internal commands for completion
+func commandNames() []string {
+	names := make([]string, 0, len(builtinCommands))
+	for _, cmd := range builtinCommands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+// paletteEntries returns all commands, extra entries and keybindings
+func paletteEntries() []commandInfo {
+	entries := slices.Clone(builtinCommands)
+	for _, source := range paletteSources {
+		entries = append(entries, source()...)
+	}
+	return append(entries, keybindings...)
+}
+
+// fuzzyScore rates how well query matches text as a case-insensitive subsequence; -1 means no match.
+// Consecutive characters and matches at word starts score higher.
+func fuzzyScore(query, text string) int {
+	query = strings.ToLower(query)
+	text = strings.ToLower(text)
+	if query == "" {
+		return 0
+	}
+
+	score, qi, streak := 0, 0, 0
+	queryRunes := []rune(query)
+	prev := ' '
+	for _, r := range text {
+		if qi < len(queryRunes) && r == queryRunes[qi] {
+			qi++
+			streak++
+			score += streak
+			if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+				score += 3 // Start of a word
+			}
+		} else {
+			streak = 0
+		}
+		prev = r
+	}
+
+	if qi < len(queryRunes) {
+		return -1
+	}
+	return score
+}
+
+// searchPalette returns the entries matching query, best matches first
+func searchPalette(query string) []commandInfo {
+	type scored struct {
+		entry commandInfo
+		score int
+	}
+
+	var matches []scored
+	for _, entry := range paletteEntries() {
+		// Names weigh more than descriptions
+		score := max(2*fuzzyScore(query, entry.name), fuzzyScore(query, entry.description))
+		if score >= 0 {
+			matches = append(matches, scored{entry, score})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b scored) int { return b.score - a.score })
+
+	result := make([]commandInfo, len(matches))
+	for i, match := range matches {
+		result[i] = match.entry
+	}
+	return result
+}
+
+// printCommands prints entries as an aligned list
+func printCommands(entries []commandInfo) {
+	width := 0
+	for _, entry := range entries {
+		width = max(width, len(strings.TrimSpace(entry.name+" "+entry.usage)))
+	}
+	for _, entry := range entries {
+		fmt.Printf("  %-*s  - %s\n", width, strings.TrimSpace(entry.name+" "+entry.usage), entry.description)
+	}
+}
+
+// showPalette prints the commands matching query, or everything when query is empty
+func showPalette(query string) {
+	matches := searchPalette(query)
+	if len(matches) == 0 {
+		fmt.Printf("🔎 Nothing matches %q\n", query)
+		return
+	}
+	fmt.Printf("🔎 %d match(es):\n", len(matches))
+	printCommands(matches)
+}
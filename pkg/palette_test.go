@@ -0,0 +1,49 @@
+package pkg
+
+import "testing"
+
+func TestFuzzyScore(t *testing.T) {
+	if fuzzyScore("rcn", ":reconnect") < 0 {
+		t.Error("expected subsequence to match")
+	}
+	if fuzzyScore("xyz", ":reconnect") != -1 {
+		t.Error("expected no match")
+	}
+	if fuzzyScore("", ":hosts") != 0 {
+		t.Error("expected empty query to match everything")
+	}
+	if fuzzyScore("up", ":upload") <= fuzzyScore("up", ":status update") {
+		t.Error("expected consecutive match at word start to score higher")
+	}
+}
+
+func TestSearchPalette(t *testing.T) {
+	matches := searchPalette("upl")
+	if len(matches) == 0 || matches[0].name != ":upload" {
+		t.Fatalf("expected :upload first, got %+v", matches)
+	}
+
+	// Keybindings are searchable by description
+	found := false
+	for _, entry := range searchPalette("history") {
+		if entry.name == "Ctrl+R" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected Ctrl+R for \"history\"")
+	}
+
+	// Extra sources contribute entries
+	paletteSources = append(paletteSources, func() []commandInfo {
+		return []commandInfo{{"deploy", "", "alias for ./deploy.sh"}}
+	})
+	t.Cleanup(func() { paletteSources = paletteSources[:len(paletteSources)-1] })
+	if matches := searchPalette("deploy"); len(matches) == 0 || matches[0].name != "deploy" {
+		t.Errorf("expected alias entry, got %+v", matches)
+	}
+
+	if len(searchPalette("")) != len(paletteEntries()) {
+		t.Error("expected empty query to list all entries")
+	}
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Preflight dials the ssh port of every host in parallel and splits the hosts into reachable and dead ones,
+// so dead hosts are known before any ssh handshake. Hosts behind a ProxyJump or ProxyCommand, and hosts of
+// other backends, count as reachable.
+func Preflight(ctx context.Context, hosts []string) (reachable, dead []string) {
+	alive := make([]bool, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Go(func() { alive[i] = portOpen(ctx, host) })
+	}
+	wg.Wait()
+
+	for i, host := range hosts {
+		if alive[i] {
+			reachable = append(reachable, host)
+		} else {
+			dead = append(dead, host)
+		}
+	}
+	return reachable, dead
+}
+
+// portOpen reports whether the ssh port of host accepts TCP connections within ConnectTimeout
+func portOpen(ctx context.Context, host string) bool {
+	if backendOf(host) != BackendSSH {
+		return true
+	}
+	name, port, proxied := sshTarget(ctx, host)
+	if proxied {
+		return true
+	}
+
+	acquireFDs()
+	defer releaseFDs()
+	dialer := &net.Dialer{Timeout: ConnectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(name, port))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	listener, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	_, openPort, _ := net.SplitHostPort(listener.Addr().String())
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+
+	// A fake ssh -G maps the hosts to the ports like an ~/.ssh/config would
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\ncase $last in\n" +
+		"up) printf 'hostname 127.0.0.1\\nport " + openPort + "\\n' ;;\n" +
+		"down) printf 'hostname 127.0.0.1\\nport " + closedPort + "\\n' ;;\n" +
+		"behind-bastion) printf 'hostname 10.9.9.9\\nproxyjump bastion\\n' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	reachable, dead := Preflight(context.Background(), []string{"up", "down", "behind-bastion"})
+	if !slices.Equal(reachable, []string{"up", "behind-bastion"}) || !slices.Equal(dead, []string{"down"}) {
+		t.Errorf("expected only down to be dead, got reachable %v and dead %v", reachable, dead)
+	}
+}
@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProfileSettings holds per-profile settings: presentation cues that help tell environments apart and credentials
+type ProfileSettings struct {
+	Banner     string   // Text shown when a session starts, e.g. "PRODUCTION"
+	Color      string   // Prompt and banner color: a color name, "#rrggbb" or an ANSI code fragment
+	Identities []string // Private keys used for hosts of this profile
+}
+
+// CurrentProfile holds the settings of the selected profile
+var CurrentProfile ProfileSettings
+
+// namedColors maps color names usable in profile files to ANSI code fragments
+var namedColors = map[string]string{
+	"red":     "31m",
+	"green":   "32m",
+	"yellow":  "33m",
+	"blue":    "34m",
+	"magenta": "35m",
+	"cyan":    "36m",
+	"white":   "37m",
+}
+
+// profileFile returns the settings file of a profile
+func profileFile(profile string) string {
+	return filepath.Join(homeDir(), ".gosh", "profiles", profile)
+}
+
+// LoadProfile reads the settings file of a profile. Each line has the form "key: value",
+// supported keys are "banner", "color" and "identity" (repeatable). A missing file yields empty settings.
+func LoadProfile(profile string) (ProfileSettings, error) {
+	var settings ProfileSettings
+	path := profileFile(profile)
+
+	file, err := os.Open(path) // #nosec G304 -- profile path is derived from the local user's home directory
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("failed to open profile %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "banner":
+			settings.Banner = value
+		case "color":
+			if _, err := profileColorCode(value); err != nil {
+				return settings, fmt.Errorf("profile %s: %w", profile, err)
+			}
+			settings.Color = value
+		case "identity":
+			settings.Identities = append(settings.Identities, value)
+		default:
+			return settings, fmt.Errorf("profile %s: unknown setting %q", profile, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return settings, fmt.Errorf("failed to read profile %s: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+// ProfileConfigKeys are the config file settings that set the profile presentation rather than a flag
+var ProfileConfigKeys = []string{"banner", "color"}
+
+// Configure applies the banner and color of config file settings, which take precedence over the profile file
+func (p *ProfileSettings) Configure(settings map[string][]string) error {
+	if values := settings["banner"]; len(values) > 0 {
+		p.Banner = values[len(values)-1]
+	}
+	if values := settings["color"]; len(values) > 0 {
+		color := values[len(values)-1]
+		if _, err := profileColorCode(color); err != nil {
+			return fmt.Errorf("config file: color: %w", err)
+		}
+		p.Color = color
+	}
+	return nil
+}
+
+// profileColorCode converts a profile color setting into an ANSI code fragment
+func profileColorCode(color string) (string, error) {
+	if code, ok := namedColors[strings.ToLower(color)]; ok {
+		return code, nil
+	}
+	return colorCode(color, supportsTruecolor())
+}
+
+// colorize wraps text in the profile color, leaving it unchanged without a color or with colors disabled
+func (p ProfileSettings) colorize(text string, noColor bool) string {
+	if p.Color == "" || noColor {
+		return text
+	}
+	code, err := profileColorCode(p.Color)
+	if err != nil {
+		return text
+	}
+	return "\033[1;" + code + text + reset
+}
+
+// bannerLine returns the session banner, e.g. "PRODUCTION (142 hosts)", or "" if the profile has no banner
+func (p ProfileSettings) bannerLine(hostCount int, noColor bool) string {
+	if p.Banner == "" {
+		return ""
+	}
+	return p.colorize(fmt.Sprintf("%s (%d hosts)", p.Banner, hostCount), noColor)
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadProfile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// Unknown profiles have no settings
+	settings, err := LoadProfile("lab")
+	if err != nil || settings.Banner != "" || settings.Color != "" || len(settings.Identities) != 0 {
+		t.Fatalf("expected empty settings, got %+v, %v", settings, err)
+	}
+
+	path := profileFile("prod")
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("banner: PRODUCTION\ncolor: red\nidentity: ~/.ssh/prod_ed25519\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err = LoadProfile("prod")
+	if err != nil {
+		t.Fatalf("LoadProfile failed: %v", err)
+	}
+	if settings.Banner != "PRODUCTION" || settings.Color != "red" || len(settings.Identities) != 1 {
+		t.Errorf("unexpected settings: %+v", settings)
+	}
+
+	banner := settings.bannerLine(142, false)
+	if !strings.Contains(banner, "PRODUCTION (142 hosts)") || !strings.HasPrefix(banner, "\033[1;31m") {
+		t.Errorf("unexpected banner: %q", banner)
+	}
+	if plain := settings.bannerLine(142, true); plain != "PRODUCTION (142 hosts)" {
+		t.Errorf("unexpected plain banner: %q", plain)
+	}
+
+	if err := os.WriteFile(path, []byte("color: not-a-color\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadProfile("prod"); err == nil {
+		t.Error("expected error for invalid color")
+	}
+}
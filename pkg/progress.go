@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// etaWindow is the number of recent connections the ETA is based on, so it follows changes in pace
+const etaWindow = 20
+
+// maxConnectingNames caps the pending hosts named in the progress line
+const maxConnectingNames = 3
+
+// progressRefresh is how often the progress line is redrawn while no connection completes
+const progressRefresh = 250 * time.Millisecond
+
+// connectProgress tracks connection establishment and renders it on a single line updated in place
+type connectProgress struct {
+	hosts     []string
+	pending   map[string]bool
+	connected int
+	failed    int
+	recent    []time.Time // Completion times of the last etaWindow connections
+}
+
+// newConnectProgress starts tracking connections to hosts
+func newConnectProgress(hosts []string) *connectProgress {
+	pending := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		pending[host] = true
+	}
+	return &connectProgress{hosts: hosts, pending: pending}
+}
+
+// done records that the connection to host completed at now, failed if err is set
+func (p *connectProgress) done(host string, err error, now time.Time) {
+	delete(p.pending, host)
+	if err != nil {
+		p.failed++
+	} else {
+		p.connected++
+	}
+	p.recent = append(p.recent, now)
+	if len(p.recent) > etaWindow {
+		p.recent = p.recent[1:]
+	}
+}
+
+// eta estimates when the pending connections complete from the pace of the recent ones
+func (p *connectProgress) eta(now time.Time) (time.Duration, bool) {
+	if len(p.recent) < 2 || len(p.pending) == 0 {
+		return 0, false
+	}
+	perHost := p.recent[len(p.recent)-1].Sub(p.recent[0]) / time.Duration(len(p.recent)-1)
+	// Time already spent waiting since the last completion counts towards the next one
+	remaining := perHost*time.Duration(len(p.pending)) - now.Sub(p.recent[len(p.recent)-1])
+	return max(remaining, 0), true
+}
+
+// line renders the progress, e.g. "[████░░░░] 40/100 ✅ 38 ❌ 2 ⏳ 60 · ETA 12s · web17, web18, web19 +57"
+func (p *connectProgress) line(now time.Time) string {
+	total := len(p.hosts)
+	completed := p.connected + p.failed
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %d/%d ✅ %d ❌ %d", progressBar(completed, total, 20), completed, total, p.connected, p.failed)
+	if len(p.pending) == 0 {
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, " ⏳ %d", len(p.pending))
+	if eta, ok := p.eta(now); ok {
+		fmt.Fprintf(&b, " · ETA %s", eta.Round(time.Second))
+	}
+
+	var names []string
+	for _, host := range p.hosts {
+		if p.pending[host] && len(names) < maxConnectingNames {
+			names = append(names, host)
+		}
+	}
+	b.WriteString(" · " + strings.Join(names, ", "))
+	if more := len(p.pending) - len(names); more > 0 {
+		fmt.Fprintf(&b, " +%d", more)
+	}
+	return b.String()
+}
+
+// print redraws the progress line, ending it once all connections completed
+func (p *connectProgress) print(now time.Time) {
+	fmt.Printf("\r%s\033[K", p.line(now))
+	if len(p.pending) == 0 {
+		fmt.Println()
+	}
+}
+
+// progressBar renders a text progress bar of the given width, e.g. "[██░░░]"
+func progressBar(current, total, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = width * current / total
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
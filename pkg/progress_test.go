@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		current, total, width int
+		expected              string
+	}{
+		{0, 10, 5, "[░░░░░]"},
+		{5, 10, 5, "[██░░░]"},
+		{10, 10, 5, "[█████]"},
+		{0, 0, 3, "[░░░]"},
+	}
+	for _, test := range tests {
+		if bar := progressBar(test.current, test.total, test.width); bar != test.expected {
+			t.Errorf("progressBar(%d, %d, %d) = %q, expected %q", test.current, test.total, test.width, bar, test.expected)
+		}
+	}
+}
+
+func TestConnectProgress(t *testing.T) {
+	start := time.Now()
+	progress := newConnectProgress([]string{"web1", "web2", "web3", "web4", "web5", "web6"})
+
+	if line := progress.line(start); !strings.Contains(line, "0/6 ✅ 0 ❌ 0 ⏳ 6 · web1, web2, web3 +3") || strings.Contains(line, "ETA") {
+		t.Errorf("unexpected initial line %q", line)
+	}
+
+	// Two connections a second apart leave four pending at one second each
+	progress.done("web2", nil, start)
+	progress.done("web1", errors.New("refused"), start.Add(time.Second))
+	line := progress.line(start.Add(time.Second))
+	if !strings.Contains(line, "2/6 ✅ 1 ❌ 1 ⏳ 4 · ETA 4s · web3, web4, web5 +1") {
+		t.Errorf("unexpected line %q", line)
+	}
+
+	for _, host := range []string{"web3", "web4", "web5", "web6"} {
+		progress.done(host, nil, start.Add(2*time.Second))
+	}
+	if line := progress.line(start.Add(2 * time.Second)); !strings.HasSuffix(line, "6/6 ✅ 5 ❌ 1") {
+		t.Errorf("unexpected final line %q", line)
+	}
+	output := captureStdout(t, func() { progress.print(start) })
+	if !strings.HasPrefix(output, "\r") || !strings.HasSuffix(output, "\n") {
+		t.Errorf("expected the final line to be redrawn and ended, got %q", output)
+	}
+}
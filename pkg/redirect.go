@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// RedirectRaw writes output redirected with !> without host prefixes
+var RedirectRaw bool
+
+// outputRedirect receives the stdout lines of all hosts for "command !> file"
+type outputRedirect struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	lines int
+}
+
+// redirect is the destination of host stdout while a redirected command runs, nil for the terminal
+var redirect *outputRedirect
+
+// openRedirect creates or truncates path, or appends to it
+func openRedirect(path string, appendMode bool) (*outputRedirect, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0o600) // #nosec G304 -- redirect target is chosen by the local user
+	if err != nil {
+		return nil, err
+	}
+	return &outputRedirect{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// writeLine writes "host: line", or the bare line with RedirectRaw
+func (r *outputRedirect) writeLine(host string, line []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !RedirectRaw {
+		_, _ = r.w.WriteString(host + ": ")
+	}
+	_, _ = r.w.Write(line)
+	_ = r.w.WriteByte('\n')
+	r.lines++
+}
+
+// close flushes and closes the file and returns the number of lines written
+func (r *outputRedirect) close() (int, error) {
+	err := r.w.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return r.lines, err
+}
+
+// cutRedirect splits "command !> file" or "command !>> file" into the command, the local file and
+// whether to append. Lines without a redirect are returned unchanged with an empty path.
+func cutRedirect(line string) (command, path string, appendMode bool, err error) {
+	i := strings.LastIndex(line, "!>")
+	if i < 0 {
+		return line, "", false, nil
+	}
+
+	path, appendMode = strings.CutPrefix(line[i+2:], ">")
+	path = strings.TrimSpace(path)
+	if len(path) >= 2 && (path[0] == '\'' || path[0] == '"') && path[len(path)-1] == path[0] {
+		path = path[1 : len(path)-1]
+	}
+	command = strings.TrimSpace(line[:i])
+	switch {
+	case path == "":
+		return "", "", false, errors.New("missing file after !>")
+	case command == "":
+		return "", "", false, errors.New("missing command before !>")
+	}
+
+	return command, expandHome(path), appendMode, nil
+}
+
+// withOutput runs a command with the output options it ends in: a "!> file" redirect and a ":| grep" filter
+func (s *session) withOutput(line string, run func(command string)) {
+	command, path, appendMode, err := cutRedirect(line)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	if path == "" {
+		s.withOutputFilter(command, run)
+		return
+	}
+
+	r, err := openRedirect(path, appendMode)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	redirect = r
+	s.withOutputFilter(command, run)
+	redirect = nil
+
+	lines, err := r.close()
+	if err != nil {
+		fmt.Printf("❌ Error: %s: %v\n", path, err)
+		return
+	}
+	fmt.Printf("📝 Wrote %d line(s) to %s\n", lines, path)
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCutRedirect(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	tests := []struct {
+		line, command, path string
+		appendMode, wantErr bool
+	}{
+		{"uptime !> results.txt", "uptime", "results.txt", false, false},
+		{"df -h !>> 'disk usage.txt'", "df -h", "disk usage.txt", true, false},
+		{"uptime !>~/out.txt", "uptime", "/home/me/out.txt", false, false},
+		{"dmesg :| grep -i err !> errors.txt", "dmesg :| grep -i err", "errors.txt", false, false},
+		{"uptime", "uptime", "", false, false},
+		{"uptime !>", "", "", false, true},
+		{"!> out.txt", "", "", false, true},
+	}
+	for _, tt := range tests {
+		command, path, appendMode, err := cutRedirect(tt.line)
+		if (err != nil) != tt.wantErr || command != tt.command || path != tt.path || appendMode != tt.appendMode {
+			t.Errorf("cutRedirect(%q) = %q, %q, %v, %v", tt.line, command, path, appendMode, err)
+		}
+	}
+}
+
+func TestRedirectOutput(t *testing.T) {
+	useFakeSSH(t)
+	path := filepath.Join(t.TempDir(), "results.txt")
+	sess := &session{connManager: NewSSHConnectionManager(""), hosts: []string{"web1", "web2"}, noColor: true}
+	run := func(line string) string {
+		return captureStdout(t, func() {
+			sess.withOutput(line, func(command string) { sess.runCommand(command, map[string]bool{"web1": true}) })
+		})
+	}
+
+	output := run("echo out; echo err >&2 !> " + path)
+	if strings.Contains(output, "out") || !strings.Contains(output, "err") || !strings.Contains(output, "Wrote 1 line(s)") {
+		t.Errorf("expected stdout in the file and stderr on the terminal, got %q", output)
+	}
+
+	RedirectRaw = true
+	defer func() { RedirectRaw = false }()
+	run("printf 'a\\nb\\n' :| grep a !>> " + path)
+
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "web1: out\na\n" {
+		t.Errorf("unexpected file content %q: %v", content, err)
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	for path, expected := range map[string]string{
+		"~/logs/out.txt": filepath.Join(home, "logs", "out.txt"),
+		"~" + string(filepath.Separator) + "out.txt": filepath.Join(home, "out.txt"),
+		"/var/log/out.txt":                           "/var/log/out.txt",
+		"~other/out.txt":                             "~other/out.txt",
+	} {
+		if got := expandHome(path); got != expected {
+			t.Errorf("expandHome(%q) = %q, expected %q", path, got, expected)
+		}
+	}
+}
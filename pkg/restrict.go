@@ -0,0 +1,265 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrRestricted is wrapped by the errors of commands refused by the allow or deny list
+var ErrRestricted = errors.New("command not permitted")
+
+// commandPattern is a line of an allow or deny list, a command with * and ? wildcards
+type commandPattern struct {
+	text string
+	re   *regexp.Regexp
+}
+
+var (
+	// allowList restricts commands to the ones matching a pattern when it is not nil
+	allowList []commandPattern
+	// denyList refuses commands matching one of its patterns
+	denyList []commandPattern
+
+	// shellWords may precede the command of a segment without changing which one runs
+	shellWords = []string{"!", "(", ")", "{", "}", "if", "then", "elif", "else", "while", "until", "do"}
+	// commandWrappers run the command following their options; the value lists their options taking a value
+	commandWrappers = map[string]string{"command": "", "exec": "a", "env": "uCS", "nohup": "", "sudo": "CDghprtTUu", "time": "fo"}
+	// assignmentPattern matches a variable assignment preceding a command, like LANG=C
+	assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+// LoadAllowList restricts every command run on hosts to the patterns of the file at path, e.g. for a shared
+// account that may only run diagnostics. Each simple command of a command line has to match one of them, and
+// command substitution and output redirection are refused since their effect can't be vetted.
+func LoadAllowList(path string) error {
+	patterns, err := ReadCommandList(path)
+	if err != nil {
+		return err
+	}
+	allowList = compileCommandPatterns(patterns)
+	return nil
+}
+
+// LoadDenyList refuses commands of which any simple command matches one of the patterns of the file at path.
+// Patterns are also matched against the command without grouping, wrappers like sudo or env and the
+// directory of the program, so "rm *" refuses "(sudo /bin/rm -rf x)". This is a guard rail against
+// mistakes: commands hidden in e.g. "sh -c" strings or scripts still run.
+func LoadDenyList(path string) error {
+	patterns, err := ReadCommandList(path)
+	if err != nil {
+		return err
+	}
+	denyList = append(denyList, compileCommandPatterns(patterns)...)
+	return nil
+}
+
+// ReadCommandList reads the patterns of an allow or deny list file, one per line, skipping blank lines and
+// # comments. In patterns * matches any text and ? a single character; a trailing " *" also matches no
+// arguments at all, so "systemctl status *" permits "systemctl status" as well.
+func ReadCommandList(path string) ([]string, error) {
+	file, err := os.Open(path) // #nosec G304 -- list path is chosen by the local user
+	if err != nil {
+		return nil, fmt.Errorf("failed to open command list: %w", err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read command list %s: %w", path, err)
+	}
+	return patterns, nil
+}
+
+// compileCommandPatterns compiles a list of wildcard patterns; the result is not nil, so an empty allow list
+// refuses every command
+func compileCommandPatterns(patterns []string) []commandPattern {
+	compiled := make([]commandPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled = append(compiled, compileCommandPattern(pattern))
+	}
+	return compiled
+}
+
+// compileCommandPattern turns a wildcard pattern into a regular expression matching whole commands
+func compileCommandPattern(text string) commandPattern {
+	text = strings.Join(strings.Fields(text), " ")
+	body, anyArgs := strings.CutSuffix(text, " *")
+	expr := regexp.QuoteMeta(body)
+	expr = strings.NewReplacer(`\*`, `.*`, `\?`, `.`).Replace(expr)
+	if anyArgs {
+		expr += `( .*)?`
+	}
+	return commandPattern{text: text, re: regexp.MustCompile(`^` + expr + `$`)}
+}
+
+// matchCommandPattern returns the first pattern matching command, or nil
+func matchCommandPattern(patterns []commandPattern, command string) *commandPattern {
+	for i := range patterns {
+		if patterns[i].re.MatchString(command) {
+			return &patterns[i]
+		}
+	}
+	return nil
+}
+
+// checkCommand returns an error wrapping ErrRestricted when the allow or deny list refuses command
+func checkCommand(command string) error {
+	return checkCommandWith(allowList, denyList, command)
+}
+
+// checkCommandWith checks command against the given lists; a nil allow list permits every command
+func checkCommandWith(allowList, denyList []commandPattern, command string) error {
+	if allowList == nil && len(denyList) == 0 {
+		return nil
+	}
+	segments, unsafe := shellSegments(command)
+	for _, segment := range segments {
+		pattern := matchCommandPattern(denyList, segment)
+		if pattern == nil {
+			pattern = matchCommandPattern(denyList, unwrapCommand(segment))
+		}
+		if pattern != nil {
+			return fmt.Errorf("%w: %q is denied by %q", ErrRestricted, segment, pattern.text)
+		}
+	}
+	if allowList == nil {
+		return nil
+	}
+	if unsafe != "" {
+		return fmt.Errorf("%w: %s can't be checked against the allow list", ErrRestricted, unsafe)
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("%w: empty command", ErrRestricted)
+	}
+	for _, segment := range segments {
+		if matchCommandPattern(allowList, segment) == nil {
+			return fmt.Errorf("%w: %q is not in the allow list", ErrRestricted, segment)
+		}
+	}
+	return nil
+}
+
+// unwrapCommand returns the command a segment runs: without grouping and negation, preceding reserved
+// words and variable assignments, and wrappers like sudo or env with their options. The program is given
+// by its base name without quotes, so "(sudo -u root /sbin/reboot)" becomes "reboot".
+func unwrapCommand(segment string) string {
+	words := strings.Fields(strings.TrimRight(strings.TrimLeft(segment, "({! "), ") "))
+	for len(words) > 0 {
+		word := strings.NewReplacer(`\`, "", `'`, "", `"`, "").Replace(words[0])
+		words = words[1:]
+		options, wrapper := commandWrappers[word]
+		switch {
+		case slices.Contains(shellWords, word) || assignmentPattern.MatchString(word):
+		case wrapper:
+			for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+				option := words[0]
+				words = words[1:]
+				if option == "--" {
+					break
+				}
+				if len(option) == 2 && strings.Contains(options, option[1:]) && len(words) > 0 {
+					words = words[1:]
+				}
+			}
+		default:
+			return strings.Join(append([]string{path.Base(word)}, words...), " ")
+		}
+	}
+	return ""
+}
+
+// Restricted reports whether an allow list limits the commands run on hosts, which rules out interactive
+// shells and uploads
+func Restricted() bool {
+	return allowList != nil
+}
+
+// shellSegments splits a command line into its simple commands at unquoted ;, &, | and line breaks, with
+// runs of blanks collapsed. unsafe names the first command substitution, process substitution, output
+// redirection, ANSI-C quoting ($'...', whose escapes this doesn't follow) or parenthesis attached to a word,
+// like the zsh glob qualifier in *(e:reboot:), it contains, or is empty; duplicating descriptors like 2>&1
+// and writing to /dev/null are fine.
+func shellSegments(command string) (segments []string, unsafe string) {
+	var segment strings.Builder
+	flush := func() {
+		if text := strings.Join(strings.Fields(segment.String()), " "); text != "" {
+			segments = append(segments, text)
+		}
+		segment.Reset()
+	}
+	flag := func(what string) {
+		if unsafe == "" {
+			unsafe = what
+		}
+	}
+
+	quote := byte(0)
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		rest := command[i+1:]
+		switch {
+		case c == '\\' && quote != '\'' && i+1 < len(command):
+			segment.WriteString(command[i : i+2])
+			i++
+			continue
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '`' || c == '$' && strings.HasPrefix(rest, "("):
+			flag("command substitution")
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '$' && strings.HasPrefix(rest, "'"):
+			flag("ANSI-C quoting")
+		case c == '(' && i > 0 && !strings.ContainsRune(" \t\n;&|(", rune(command[i-1])):
+			flag("glob qualifier")
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '&' && strings.HasPrefix(rest, ">"):
+			// &> redirects both streams, checked at the >
+		case c == ';' || c == '&' || c == '|' || c == '\n':
+			flush()
+			continue
+		case c == '<' && strings.HasPrefix(rest, "("):
+			flag("process substitution")
+		case c == '>':
+			target := strings.TrimLeft(strings.TrimPrefix(rest, ">"), " \t")
+			switch {
+			case len(target) >= 2 && target[0] == '&' && (target[1] >= '0' && target[1] <= '9' || target[1] == '-'):
+				// Duplicating a descriptor; the & is not a separator
+				segment.WriteString(command[i : len(command)-len(target)+2])
+				i = len(command) - len(target) + 1
+				continue
+			case isDevNull(target):
+			default:
+				flag("output redirection")
+			}
+		}
+		segment.WriteByte(c)
+	}
+	flush()
+	return segments, unsafe
+}
+
+// isDevNull reports whether a redirection target starts with the word /dev/null
+func isDevNull(target string) bool {
+	rest, ok := strings.CutPrefix(target, "/dev/null")
+	return ok && (rest == "" || strings.ContainsRune(" \t\n;&|)", rune(rest[0])))
+}
@@ -0,0 +1,175 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestShellSegments(t *testing.T) {
+	tests := []struct {
+		command  string
+		segments []string
+		unsafe   string
+	}{
+		{"uptime", []string{"uptime"}, ""},
+		{"cat  /etc/hosts | grep   web && uptime; df -h &", []string{"cat /etc/hosts", "grep web", "uptime", "df -h"}, ""},
+		{"echo 'a; b' \"c | d\" e\\;f", []string{"echo 'a; b' \"c | d\" e\\;f"}, ""},
+		{"journalctl -u nginx 2>&1 | tail -n 50", []string{"journalctl -u nginx 2>&1", "tail -n 50"}, ""},
+		{"grep -r x /etc 2>/dev/null", []string{"grep -r x /etc 2>/dev/null"}, ""},
+		{"ls &>/dev/null", []string{"ls &>/dev/null"}, ""},
+		{"cat /etc/hosts > /tmp/copy", []string{"cat /etc/hosts > /tmp/copy"}, "output redirection"},
+		{"cat x >>/tmp/log", []string{"cat x >>/tmp/log"}, "output redirection"},
+		{"echo $(rm -rf /)", []string{"echo $(rm -rf /)"}, "command substitution"},
+		{"echo \"`reboot`\"", []string{"echo \"`reboot`\""}, "command substitution"},
+		{"echo '$(literal)'", []string{"echo '$(literal)'"}, ""},
+		{"diff <(ls a) b", []string{"diff <(ls a) b"}, "process substitution"},
+		{"uptime\nreboot", []string{"uptime", "reboot"}, ""},
+		{"(uptime) && { df -h; }", []string{"(uptime)", "{ df -h", "}"}, ""},
+		{"cat $'\\'' ; touch /tmp/pwned ; # '", []string{"cat $'\\'' ; touch /tmp/pwned ; # '"}, "ANSI-C quoting"},
+		{"cat *(e:'reboot':)", []string{"cat *(e:'reboot':)"}, "glob qualifier"},
+		{"cat =(reboot)", []string{"cat =(reboot)"}, "glob qualifier"},
+	}
+	for _, test := range tests {
+		segments, unsafe := shellSegments(test.command)
+		if !slices.Equal(segments, test.segments) || unsafe != test.unsafe {
+			t.Errorf("shellSegments(%q) = %q, %q, expected %q, %q", test.command, segments, unsafe, test.segments, test.unsafe)
+		}
+	}
+}
+
+func TestUnwrapCommand(t *testing.T) {
+	tests := map[string]string{
+		"rm -rf x":                        "rm -rf x",
+		"(rm -rf x)":                      "rm -rf x",
+		"{ rm -rf x":                      "rm -rf x",
+		"}":                               "",
+		"! /bin/rm -rf x":                 "rm -rf x",
+		"command rm -rf x":                "rm -rf x",
+		"sudo reboot":                     "reboot",
+		"sudo -u root -i /sbin/reboot":    "reboot",
+		"env -i LANG=C nohup time reboot": "reboot",
+		"exec -a name \\rm x":             "rm x",
+		"if 'rm' -rf x":                   "rm -rf x",
+	}
+	for segment, expected := range tests {
+		if command := unwrapCommand(segment); command != expected {
+			t.Errorf("unwrapCommand(%q) = %q, expected %q", segment, command, expected)
+		}
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	defer func() { allowList, denyList = nil, nil }()
+
+	dir := t.TempDir()
+	allow := filepath.Join(dir, "allow")
+	deny := filepath.Join(dir, "deny")
+	if err := os.WriteFile(allow, []byte("# Diagnostics only\ncat *\ngrep *\nsystemctl status *\nuptime\n\ntail -n ? *\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(deny, []byte("cat /etc/shadow*\nrm -rf *\nreboot\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkCommand("rm -rf /"); err != nil {
+		t.Errorf("expected everything to run without lists, got %v", err)
+	}
+	if err := LoadDenyList(deny); err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range []string{"(rm -rf x)", "{ rm -rf x; }", "/bin/rm -rf x", "command rm -rf x", "sudo reboot", "uptime && sudo -u root /sbin/reboot"} {
+		if err := checkCommand(command); !errors.Is(err, ErrRestricted) {
+			t.Errorf("expected %q to be denied, got %v", command, err)
+		}
+	}
+	if err := LoadAllowList(allow); err != nil {
+		t.Fatal(err)
+	}
+	if !Restricted() {
+		t.Error("expected an allow list to restrict commands")
+	}
+
+	for _, command := range []string{"uptime", "systemctl status", "systemctl  status nginx", "cat /var/log/syslog | grep -i error", "tail -n 5 /var/log/messages"} {
+		if err := checkCommand(command); err != nil {
+			t.Errorf("expected %q to be allowed, got %v", command, err)
+		}
+	}
+	for _, command := range []string{"systemctl restart nginx", "uptime; reboot", "cat x > /etc/motd", "cat $(which reboot)", `cat $'\'' ; touch /tmp/pwned ; # '`, "cat *(e:'reboot':)", "uptimex", "tail -n 50 x", "cat /etc/shadow", ""} {
+		if err := checkCommand(command); !errors.Is(err, ErrRestricted) {
+			t.Errorf("expected %q to be refused, got %v", command, err)
+		}
+	}
+
+	cm := &SSHConnectionManager{}
+	if err := cm.openForward(context.Background(), portForward{host: "web1", flag: "-D", spec: "1080"}); !errors.Is(err, ErrRestricted) {
+		t.Errorf("expected tunnels to be refused with an allow list, got %v", err)
+	}
+
+	if err := LoadAllowList(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected a missing list to fail")
+	}
+}
+
+func TestKillJobWithAllowList(t *testing.T) {
+	useFakeSSH(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	defer func() { allowList = nil }()
+	allowList = []commandPattern{compileCommandPattern("uptime")}
+
+	// The kill command is gosh's own, the allow list doesn't apply to it
+	sess := &session{ctx: context.Background(), connManager: NewSSHConnectionManager(""), hosts: []string{"web1"}, noColor: true, job: newJobToken(), jobHosts: []string{"web1"}}
+	output := captureStdout(t, func() {
+		sess.killJob("")
+		flushOutput()
+	})
+	if output != "web1: not running\n" {
+		t.Errorf("expected the kill command to run, got %q", output)
+	}
+}
+
+func TestRestrictedCommandsDontRun(t *testing.T) {
+	defer func() { allowList = nil }()
+	allowList = []commandPattern{compileCommandPattern("uptime")}
+
+	sink := &recordingSink{}
+	Sink = sink
+	defer func() { Sink = nil }()
+
+	marker := filepath.Join(t.TempDir(), "ran")
+	if err := runSSHStreaming(context.Background(), "web1", "touch "+marker, "", nil, 0, 4, true); !errors.Is(err, ErrRestricted) {
+		t.Errorf("expected the command to be refused, got %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected the refused command not to run")
+	}
+	errs := executeCommandStreaming(context.Background(), NewSSHConnectionManager(""), []string{"web1"}, nil, "touch "+marker, nil, true)
+	if !errors.Is(errs[0], ErrRestricted) {
+		t.Errorf("expected the session command to be refused, got %v", errs[0])
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected the refused command not to run")
+	}
+	if len(sink.results) != 2 || !errors.Is(sink.results[0].Err, ErrRestricted) || !errors.Is(sink.results[1].Err, ErrRestricted) {
+		t.Errorf("expected the refusals as the host's results, got %+v", sink.results)
+	}
+
+	results, _ := NewRunner([]string{"web1"}, WithAllowList("uptime")).Run(context.Background(), "reboot")
+	if !errors.Is(results[0].Err, ErrRestricted) {
+		t.Errorf("expected the runner to refuse the command, got %+v", results[0])
+	}
+	if err := uploadFile(context.Background(), []string{"web1"}, marker, "", "", true); !errors.Is(err, ErrRestricted) {
+		t.Errorf("expected uploads to be refused, got %v", err)
+	}
+
+	allowList = append(allowList, compileCommandPattern("grep *"))
+	if err := Bench(context.Background(), []string{"web1"}, "reboot", "", 1); !errors.Is(err, ErrRestricted) {
+		t.Errorf("expected bench to refuse the command, got %v", err)
+	}
+	if err := Grep(context.Background(), []string{"web1"}, "x", []string{"/x; reboot"}, "", true, GrepOptions{}); !errors.Is(err, ErrRestricted) {
+		t.Errorf("expected grep to refuse the injected command, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// FailurePolicy decides how a runbook continues after a step failed on some hosts
+type FailurePolicy int
+
+const (
+	// FailStop stops the runbook after the failed step
+	FailStop FailurePolicy = iota
+	// FailContinue runs the remaining steps on all hosts
+	FailContinue
+	// FailDropHosts runs the remaining steps only on hosts that have not failed
+	FailDropHosts
+)
+
+// ParseFailurePolicy converts "stop", "continue" or "drop-hosts" into a FailurePolicy
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch s {
+	case "stop":
+		return FailStop, nil
+	case "continue":
+		return FailContinue, nil
+	case "drop-hosts":
+		return FailDropHosts, nil
+	default:
+		return FailStop, fmt.Errorf("unknown failure policy %q, use stop, continue or drop-hosts", s)
+	}
+}
+
+// ReadCommands reads one command per line, skipping blank lines and # comments
+func ReadCommands(r io.Reader) ([]string, error) {
+	var commands []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	return commands, nil
+}
+
+// RunCommands executes commands one after another on all hosts, each step waiting for every host to finish.
+// After a step fails on some hosts the policy decides whether to go on. It returns ctx's error when
+// interrupted, otherwise the failures of all steps as joined *HostError values.
+func RunCommands(ctx context.Context, hosts, commands []string, user string, noColor bool, policy FailurePolicy) error {
+	defer closeHostLogs()
+
+	if banner := CurrentProfile.bannerLine(len(hosts), noColor); banner != "" {
+		fmt.Fprintln(os.Stderr, banner)
+	}
+
+	// Hosts stay in the full list so their colors don't change when others are dropped
+	active := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		active[host] = true
+	}
+
+	var failures []error
+	for step, command := range commands {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Fprintf(os.Stderr, "▶ [%d/%d] %s\n", step+1, len(commands), command)
+
+		errs := runOnHostsConfirmed(ctx, hosts, active, command, user, nil, noColor)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var failed []string
+		for i, err := range errs {
+			if err != nil {
+				failed = append(failed, hosts[i])
+			}
+		}
+		if len(failed) == 0 {
+			continue
+		}
+
+		failures = append(failures, joinHostErrors(hosts, errs))
+		fmt.Fprintf(os.Stderr, "❌ Step %d failed on %d host(s): %s\n", step+1, len(failed), strings.Join(failed, ", "))
+		writeFailureSummary(os.Stderr, hosts, errs)
+		switch policy {
+		case FailStop:
+			if step+1 < len(commands) {
+				fmt.Fprintf(os.Stderr, "🛑 Stopping, %d step(s) not run\n", len(commands)-step-1)
+			}
+			return errors.Join(failures...)
+		case FailDropHosts:
+			for _, host := range failed {
+				delete(active, host)
+			}
+			if len(active) == 0 {
+				fmt.Fprintln(os.Stderr, "🛑 No hosts left")
+				return errors.Join(failures...)
+			}
+		case FailContinue:
+		}
+	}
+
+	return errors.Join(failures...)
+}
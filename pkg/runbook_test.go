@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadCommands(t *testing.T) {
+	input := "# prepare\nuptime\n\n  df -h  \n# done\n"
+	commands, err := ReadCommands(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 2 || commands[0] != "uptime" || commands[1] != "df -h" {
+		t.Errorf("unexpected commands: %q", commands)
+	}
+}
+
+func TestParseFailurePolicy(t *testing.T) {
+	for input, want := range map[string]FailurePolicy{"stop": FailStop, "continue": FailContinue, "drop-hosts": FailDropHosts} {
+		if got, err := ParseFailurePolicy(input); err != nil || got != want {
+			t.Errorf("ParseFailurePolicy(%q) = %v, %v", input, got, err)
+		}
+	}
+	if _, err := ParseFailurePolicy("retry"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	useFakeSSH(t)
+	hosts := []string{"web1", "web2"}
+	commands := []string{"echo first-{host}", `test {host} != web2`, "echo last-{host}"}
+
+	tests := []struct {
+		policy  FailurePolicy
+		want    []string
+		notWant []string
+	}{
+		{FailStop, []string{"first-web1", "first-web2"}, []string{"last-web1", "last-web2"}},
+		{FailContinue, []string{"last-web1", "last-web2"}, nil},
+		{FailDropHosts, []string{"last-web1"}, []string{"last-web2"}},
+	}
+	for _, tt := range tests {
+		var err error
+		output := captureStdout(t, func() { err = RunCommands(context.Background(), hosts, commands, "", true, tt.policy) })
+		var hostErr *HostError
+		if !errors.As(err, &hostErr) || hostErr.Host != "web2" {
+			t.Errorf("policy %d: expected web2 to fail, got %v", tt.policy, err)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(output, s) {
+				t.Errorf("policy %d: expected %q in %q", tt.policy, s, output)
+			}
+		}
+		for _, s := range tt.notWant {
+			if strings.Contains(output, s) {
+				t.Errorf("policy %d: unexpected %q in %q", tt.policy, s, output)
+			}
+		}
+	}
+
+	output := captureStdout(t, func() {
+		if err := RunCommands(context.Background(), hosts, []string{"true", "echo ok"}, "", true, FailStop); err != nil {
+			t.Errorf("expected success, got %v", err)
+		}
+	})
+	if strings.Count(output, "ok") != 2 {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
@@ -0,0 +1,307 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a command on one host
+type Result struct {
+	Host     string
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int           // Remote exit status; 255 usually means ssh itself failed, -1 that the command never exited
+	Duration time.Duration // Time from starting ssh until it exited
+	Err      error         // Non-nil when the command failed, carrying the exit status
+}
+
+// OK reports whether the command exited with status 0
+func (r Result) OK() bool {
+	return r.Err == nil
+}
+
+// Runner runs commands on a fixed set of hosts in parallel and collects their output, without printing
+// anything. It uses the system ssh binary like the gosh CLI and is safe for concurrent use. It is configured
+// by its options only, the settings of the gosh command line don't apply to it.
+type Runner struct {
+	hosts          []string
+	user           string
+	concurrency    int
+	timeout        time.Duration
+	connectTimeout time.Duration
+	sshOptions     []string
+	backend        string
+	localExec      bool
+	settings       commandSettings
+	sudoPassword   []byte
+	allowList      []commandPattern
+	denyList       []commandPattern
+	metrics        bool
+	stdin          []byte
+	onLine         func(host string, stream Stream, line string)
+}
+
+// Option configures a Runner
+type Option func(*Runner)
+
+// WithUser logs in as user instead of the ssh default
+func WithUser(user string) Option {
+	return func(r *Runner) { r.user = user }
+}
+
+// WithConcurrency runs the command on at most n hosts at a time; 0 runs on all hosts at once
+func WithConcurrency(n int) Option {
+	return func(r *Runner) { r.concurrency = n }
+}
+
+// WithTimeout limits how long the command may run on each host
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Runner) { r.timeout = timeout }
+}
+
+// WithSSHOptions passes extra "-o" options to ssh, e.g. "StrictHostKeyChecking=accept-new"
+func WithSSHOptions(options ...string) Option {
+	return func(r *Runner) {
+		for _, option := range options {
+			r.sshOptions = append(r.sshOptions, "-o", option)
+		}
+	}
+}
+
+// WithConnectTimeout bounds how long connecting to each host may take, 5 seconds by default
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(r *Runner) { r.connectTimeout = timeout }
+}
+
+// WithBackend selects the transport by name, BackendSSH by default
+func WithBackend(backend string) Option {
+	return func(r *Runner) { r.backend = backend }
+}
+
+// WithLocalExec runs commands for localhost targets directly instead of over the backend
+func WithLocalExec() Option {
+	return func(r *Runner) { r.localExec = true }
+}
+
+// WithEnv exports "VAR=value" assignments to the command on every host
+func WithEnv(assignments ...string) Option {
+	return func(r *Runner) { r.settings.env = append(r.settings.env, assignments...) }
+}
+
+// WithShell runs commands in a login shell, one of sh, bash or zsh, instead of the user's default shell
+func WithShell(shell string) Option {
+	return func(r *Runner) { r.settings.shell = shell }
+}
+
+// WithSudo runs commands through sudo, which reads password from stdin. The command's own stdin is
+// detached, so WithStdin has no effect.
+func WithSudo(password []byte) Option {
+	return func(r *Runner) { r.settings.sudo, r.sudoPassword = true, password }
+}
+
+// WithBecomeUser runs commands as user via sudo, which must not need a password unless WithSudo is given
+func WithBecomeUser(user string) Option {
+	return func(r *Runner) { r.settings.becomeUser = user }
+}
+
+// WithNoEcho turns off terminal echo and keeps connection banners out of the output
+func WithNoEcho() Option {
+	return func(r *Runner) { r.settings.noEcho = true }
+}
+
+// WithAllowList refuses commands unless each of their simple commands matches one of the patterns, as in
+// the files of --allow-list; see ReadCommandList
+func WithAllowList(patterns ...string) Option {
+	return func(r *Runner) {
+		if r.allowList == nil {
+			r.allowList = []commandPattern{} // An empty allow list refuses every command
+		}
+		r.allowList = append(r.allowList, compileCommandPatterns(patterns)...)
+	}
+}
+
+// WithDenyList refuses commands of which any simple command matches one of the patterns, as in the files of
+// --deny-list; see ReadCommandList
+func WithDenyList(patterns ...string) Option {
+	return func(r *Runner) { r.denyList = append(r.denyList, compileCommandPatterns(patterns)...) }
+}
+
+// WithMetrics counts the commands and output in the metrics served by ServeMetrics
+func WithMetrics() Option {
+	return func(r *Runner) { r.metrics = true }
+}
+
+// WithStdin sends input to the command on every host
+func WithStdin(input []byte) Option {
+	return func(r *Runner) { r.stdin = input }
+}
+
+// WithLineHandler calls fn for every complete line of output as it arrives, in addition to collecting it.
+// fn is called concurrently from per-host goroutines.
+func WithLineHandler(fn func(host string, stream Stream, line string)) Option {
+	return func(r *Runner) { r.onLine = fn }
+}
+
+// NewRunner returns a Runner for hosts. Commands may use the {host}, {shorthost} and {index} placeholders.
+func NewRunner(hosts []string, opts ...Option) *Runner {
+	r := &Runner{hosts: hosts, backend: BackendSSH, connectTimeout: defaultConnectTimeout}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run executes command on all hosts and returns one Result per host, in host order. Failures on single
+// hosts are reported in their Result; the error is only set when ctx ended before all hosts finished.
+func (r *Runner) Run(ctx context.Context, command string) ([]Result, error) {
+	if command == "" {
+		return nil, errors.New("empty command")
+	}
+	if err := checkBackend(r.backend); err != nil {
+		return nil, err
+	}
+	if err := checkShell(r.settings.shell); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(r.hosts))
+	var limit chan struct{}
+	if r.concurrency > 0 {
+		limit = make(chan struct{}, r.concurrency)
+	}
+
+	var wg sync.WaitGroup
+	for i, host := range r.hosts {
+		wg.Go(func() {
+			if limit != nil {
+				select {
+				case limit <- struct{}{}:
+					defer func() { <-limit }()
+				case <-ctx.Done():
+					results[i] = Result{Host: host, ExitCode: -1, Err: ctx.Err()}
+					return
+				}
+			}
+			results[i] = r.runHost(ctx, host, expandHostTemplate(command, host, i))
+		})
+	}
+	wg.Wait()
+
+	return results, ctx.Err()
+}
+
+// runHost runs command on a single host over a new connection
+func (r *Runner) runHost(ctx context.Context, host, command string) Result {
+	if err := checkCommandWith(r.allowList, r.denyList, command); err != nil {
+		return Result{Host: host, ExitCode: -1, Err: err}
+	}
+	acquireFDs()
+	defer releaseFDs()
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	backend := backendFor(r.backend, r.localExec, host)
+	cmd := r.hostCommand(ctx, backend, host, r.settings.prepare(command))
+	if input := r.input(); input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	var lines []*lineWriter
+	if r.onLine != nil {
+		out := &lineWriter{emit: func(line string) { r.onLine(host, StreamStdout, line) }}
+		errOut := &lineWriter{emit: func(line string) { r.onLine(host, StreamStderr, line) }}
+		cmd.Stdout, cmd.Stderr = io.MultiWriter(&stdout, out), io.MultiWriter(&stderr, errOut)
+		lines = append(lines, out, errOut)
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	if backend == BackendSSH {
+		err = classifyFailure(stderr.Bytes(), err)
+	}
+	for _, w := range lines {
+		w.flush()
+	}
+	if r.metrics {
+		metrics.commandDone(host, err)
+		metrics.received(StreamStdout, stdout.Len())
+		metrics.received(StreamStderr, stderr.Len())
+	}
+
+	return Result{
+		Host:     host,
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		ExitCode: exitCode(err),
+		Duration: time.Since(start),
+		Err:      err,
+	}
+}
+
+// hostCommand returns the command that runs command on host over a new connection with backend
+func (r *Runner) hostCommand(ctx context.Context, backend, host, command string) *exec.Cmd {
+	if backend != BackendSSH {
+		return backendCommand(ctx, backend, host, command, r.user, false)
+	}
+
+	args := []string{"-o", "ConnectTimeout=" + sshSeconds(r.connectTimeout), "-o", "BatchMode=yes"}
+	args = append(args, r.sshOptions...)
+	if r.settings.noEcho {
+		args = append(args, "-o", "LogLevel=ERROR")
+	}
+	if r.user != "" {
+		args = append(args, "-l", r.user)
+	}
+	if socket := sharedSocket(host, r.user); socket != "" {
+		args = append(args, "-o", "ControlPath="+socket, "-o", "ControlMaster=no")
+	}
+	return exec.CommandContext(ctx, "ssh", append(args, "--", sshDestination(host), command)...)
+}
+
+// input returns the data written to the command's stdin: the sudo password with WithSudo, which detaches
+// the command's own stdin, or the input of WithStdin
+func (r *Runner) input() []byte {
+	if r.settings.sudo {
+		return passwordLine(r.sudoPassword)
+	}
+	return r.stdin
+}
+
+// lineWriter splits written output into lines for a callback
+type lineWriter struct {
+	buf  []byte
+	emit func(line string)
+}
+
+// Write emits every complete line in p and keeps the rest for the next write
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		line, rest, found := bytes.Cut(w.buf, []byte("\n"))
+		if !found {
+			break
+		}
+		w.emit(string(bytes.TrimSuffix(line, []byte("\r"))))
+		w.buf = rest
+	}
+	return len(p), nil
+}
+
+// flush emits an unterminated last line
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunner(t *testing.T) {
+	useFakeSSH(t)
+
+	var mu sync.Mutex
+	var lines []string
+	runner := NewRunner([]string{"web1", "web2"},
+		WithStdin([]byte("input\n")),
+		WithLineHandler(func(host string, stream Stream, line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, host+" "+string(stream)+" "+line)
+		}))
+
+	results, err := runner.Run(context.Background(), `echo {host}; cat; echo oops >&2; test {host} = web1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Host != "web1" || results[1].Host != "web2" {
+		t.Fatalf("expected results in host order, got %+v", results)
+	}
+
+	web1, web2 := results[0], results[1]
+	if !web1.OK() || web1.ExitCode != 0 || string(web1.Stdout) != "web1\ninput\n" || string(web1.Stderr) != "oops\n" {
+		t.Errorf("unexpected result for web1: %+v", web1)
+	}
+	if web2.OK() || web2.ExitCode != 1 || web2.Duration <= 0 {
+		t.Errorf("expected web2 to fail with exit code 1, got %+v", web2)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, want := range []string{"web1 stdout web1", "web2 stdout input", "web2 stderr oops"} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("expected line %q, got %q", want, lines)
+		}
+	}
+}
+
+func TestRunnerSSHOptions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\necho \"$@\"\n"), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	results, err := NewRunner([]string{"web1"}, WithSSHOptions("StrictHostKeyChecking=accept-new")).Run(context.Background(), "uptime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := string(results[0].Stdout); !strings.Contains(args, "-o StrictHostKeyChecking=accept-new -- web1 uptime") {
+		t.Errorf("expected the options before the destination, got %q", args)
+	}
+}
+
+func TestRunnerOptions(t *testing.T) {
+	useFakeSSH(t)
+
+	// Settings of the command line don't leak into a Runner
+	Env, Shell, denyList = []string{"GLOBAL=1"}, "zsh", []commandPattern{compileCommandPattern("echo *")}
+	defer func() { Env, Shell, denyList = nil, "", nil }()
+
+	results, err := NewRunner([]string{"web1"}).Run(context.Background(), `echo "$GLOBAL"`)
+	if err != nil || !results[0].OK() || string(results[0].Stdout) != "\n" {
+		t.Errorf("expected the command to run as given, got %+v: %v", results[0], err)
+	}
+
+	runner := NewRunner([]string{"web1"}, WithEnv("GREETING=hello world"), WithShell("sh"), WithDenyList("reboot *"))
+	results, err = runner.Run(context.Background(), `echo "$GREETING"; reboot now`)
+	if err != nil || !errors.Is(results[0].Err, ErrRestricted) {
+		t.Errorf("expected the deny list to refuse the command, got %+v: %v", results[0], err)
+	}
+	results, err = runner.Run(context.Background(), `echo "$GREETING" from $0`)
+	if err != nil || string(results[0].Stdout) != "hello world from sh\n" {
+		t.Errorf("expected the command in sh with the variable, got %+v: %v", results[0], err)
+	}
+
+	if _, err := NewRunner([]string{"web1"}, WithBackend("telnet")).Run(context.Background(), "true"); err == nil {
+		t.Error("expected an unknown backend to fail")
+	}
+	if _, err := NewRunner([]string{"web1"}, WithShell("fish")).Run(context.Background(), "true"); err == nil {
+		t.Error("expected an unsupported shell to fail")
+	}
+	results, _ = NewRunner([]string{"web1"}, WithAllowList()).Run(context.Background(), "true")
+	if !errors.Is(results[0].Err, ErrRestricted) {
+		t.Errorf("expected an empty allow list to refuse every command, got %+v", results[0])
+	}
+}
+
+func TestRunnerClassifiesFailures(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho 'ssh: Could not resolve hostname web1: Name or service not known' >&2\nexit 255\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o700); err != nil { // #nosec G306 -- test script must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	results, err := NewRunner([]string{"web1"}).Run(context.Background(), "uptime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var connErr *ConnectionError
+	if !errors.As(results[0].Err, &connErr) || connErr.Class != FailureDNS || results[0].ExitCode != 255 {
+		t.Errorf("expected a DNS failure from the stderr of ssh, got %v (exit %d)", results[0].Err, results[0].ExitCode)
+	}
+}
+
+func TestRunnerTimeoutAndCancel(t *testing.T) {
+	useFakeSSH(t)
+
+	results, err := NewRunner([]string{"web1"}, WithTimeout(50*time.Millisecond)).Run(context.Background(), "exec sleep 5")
+	if err != nil || results[0].OK() || results[0].Duration > 4*time.Second {
+		t.Errorf("expected the timeout to stop the command, got %+v: %v", results[0], err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = NewRunner([]string{"web1", "web2"}, WithConcurrency(1)).Run(ctx, "true")
+	if !errors.Is(err, context.Canceled) || results[0].OK() || results[1].OK() {
+		t.Errorf("expected a cancelled run, got %+v: %v", results, err)
+	}
+
+	if _, err := NewRunner([]string{"web1"}).Run(context.Background(), ""); err == nil {
+		t.Error("expected an error for an empty command")
+	}
+}
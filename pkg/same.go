@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// sameGroup is a set of hosts whose command produced the same output and exit status
+type sameGroup struct {
+	value string
+	hosts []string
+}
+
+// sameValue returns what :same compares of a host's result: its output, stdout before stderr, and how the
+// command ended unless it succeeded. err is the host's error in case the command never started, e.g. since
+// the guard of :when couldn't run.
+func sameValue(result Result, err error) string {
+	if result.Err == nil && err != nil {
+		result.Err, result.ExitCode = err, exitCode(err)
+	}
+	value := strings.TrimRight(string(result.Stdout)+string(result.Stderr), "\n")
+	var status string
+	switch {
+	case result.Err == nil:
+		return cmp.Or(value, "(no output)")
+	case result.ExitCode > 0 && result.ExitCode != 255:
+		status = fmt.Sprintf("exit %d", result.ExitCode)
+	default:
+		status = fmt.Sprintf("error: %v", result.Err)
+	}
+	if value == "" {
+		return status
+	}
+	return status + ": " + value
+}
+
+// groupSame groups hosts by their values, largest group first; groups of the same size keep the order of
+// their first host
+func groupSame(hosts []string, values map[string]string) []sameGroup {
+	var groups []sameGroup
+	for _, host := range hosts {
+		i := slices.IndexFunc(groups, func(g sameGroup) bool { return g.value == values[host] })
+		if i < 0 {
+			groups = append(groups, sameGroup{value: values[host]})
+			i = len(groups) - 1
+		}
+		groups[i].hosts = append(groups[i].hosts, host)
+	}
+	slices.SortStableFunc(groups, func(a, b sameGroup) int { return len(b.hosts) - len(a.hosts) })
+	return groups
+}
+
+// printSame prints "CONSISTENT (n hosts)" for a single group, otherwise the value of the majority and the
+// outliers with theirs; values of several lines continue indented
+func printSame(groups []sameGroup, hosts int) {
+	if len(groups) == 1 {
+		fmt.Printf("✅ CONSISTENT (%d hosts)\n", hosts)
+		return
+	}
+	indent := func(value string) string { return strings.ReplaceAll(value, "\n", "\n    ") }
+	fmt.Printf("❌ INCONSISTENT (%d hosts, %d outlier(s))\n", hosts, hosts-len(groups[0].hosts))
+	fmt.Printf("  majority (%d hosts): %s\n", len(groups[0].hosts), indent(groups[0].value))
+	for _, group := range groups[1:] {
+		fmt.Printf("  %s: %s\n", strings.Join(group.hosts, ", "), indent(group.value))
+	}
+}
+
+// same handles ":same <command>": it runs the command on the targeted hosts without showing their output
+// and reports whether all of them produced the same output and exit status, e.g. to verify a rollout
+func (s *session) same(command string) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		fmt.Println("🟰 Usage: :same <command>")
+		return
+	}
+	command = expandAlias(command)
+	if !s.confirm(command, len(s.targetHosts())) {
+		return
+	}
+
+	// The output is collected instead of printed; Ctrl+C, :kill and :when work as for other commands
+	sink := &collectingSink{results: make(map[string]*Result), onLine: func(string, Stream, string) {}}
+	ctx := s.ctx
+	s.ctx = withSink(s.context(), sink)
+	interrupted := s.run(command, s.selected, nil)
+	s.ctx = ctx
+	if interrupted || s.last == nil || len(s.last.hosts) == 0 {
+		return
+	}
+
+	values := make(map[string]string, len(s.last.hosts))
+	for _, host := range s.last.hosts {
+		values[host] = sameValue(sink.result(host), s.last.failed[host])
+	}
+	printSame(groupSame(s.last.hosts, values), len(s.last.hosts))
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGroupSame(t *testing.T) {
+	exit1 := exec.CommandContext(context.Background(), "sh", "-c", "exit 1").Run()
+	values := map[string]string{
+		"web1": sameValue(Result{Stdout: []byte("nginx 1.24.0\n")}, nil),
+		"web2": sameValue(Result{Stdout: []byte("nginx 1.24.0\n")}, nil),
+		"web3": sameValue(Result{Stdout: []byte("nginx 1.22.1\n")}, nil),
+		"web4": sameValue(Result{Stderr: []byte("not found\n"), ExitCode: 1, Err: exit1}, nil),
+		"web5": sameValue(Result{}, errors.New("guard: connection refused")),
+		"web6": sameValue(Result{}, nil),
+	}
+	expected := map[string]string{
+		"web1": "nginx 1.24.0",
+		"web4": "exit 1: not found",
+		"web5": "error: guard: connection refused",
+		"web6": "(no output)",
+	}
+	for host, value := range expected {
+		if values[host] != value {
+			t.Errorf("%s: expected %q, got %q", host, value, values[host])
+		}
+	}
+
+	groups := groupSame([]string{"web3", "web1", "web2", "web4"}, values)
+	if len(groups) != 3 || groups[0].value != "nginx 1.24.0" || strings.Join(groups[0].hosts, ",") != "web1,web2" || groups[1].hosts[0] != "web3" {
+		t.Errorf("expected the majority first, then the outliers in order, got %+v", groups)
+	}
+
+	output := captureStdout(t, func() { printSame(groups, 4) })
+	for _, line := range []string{"INCONSISTENT (4 hosts, 2 outlier(s))", "majority (2 hosts): nginx 1.24.0", "web3: nginx 1.22.1", "web4: exit 1: not found"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected %q in %q", line, output)
+		}
+	}
+	if output := captureStdout(t, func() { printSame(groups[:1], 2) }); output != "✅ CONSISTENT (2 hosts)\n" {
+		t.Errorf("expected just the consistent line, got %q", output)
+	}
+}
+
+func TestSessionSame(t *testing.T) {
+	LocalExec = true
+	defer func() { LocalExec = false }()
+	sess := &session{ctx: context.Background(), connManager: NewSSHConnectionManager(""), hosts: []string{"localhost", "127.0.0.1"}, noColor: true}
+
+	if output := captureStdout(t, func() { sess.same(" echo same") }); output != "✅ CONSISTENT (2 hosts)\n" {
+		t.Errorf("expected only the consistent line, got %q", output)
+	}
+	if sess.ctx != context.Background() {
+		t.Error("expected the session context to be restored")
+	}
+
+	output := captureStdout(t, func() { sess.same(" echo {host}; test {index} = 0") })
+	if !strings.Contains(output, "INCONSISTENT (2 hosts, 1 outlier(s))") || !strings.Contains(output, "127.0.0.1: exit 1: 127.0.0.1") {
+		t.Errorf("expected 127.0.0.1 to be the outlier, got %q", output)
+	}
+	if output := captureStdout(t, func() { sess.same("") }); !strings.Contains(output, "Usage: :same") {
+		t.Errorf("expected the usage, got %q", output)
+	}
+}
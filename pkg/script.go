@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// shellInterpreters read a script from stdin with -s; other interpreters take "-" as the script file
+var shellInterpreters = []string{"sh", "bash", "dash", "ksh", "zsh"}
+
+// scriptInterpreter returns the interpreter named by the script's shebang line, e.g. "bash -e" for
+// "#!/bin/bash -e" or "python3" for "#!/usr/bin/env python3". Paths are reduced to the program name
+// so the remote PATH decides. Scripts without a shebang run with Shell, or sh.
+func scriptInterpreter(content []byte) string {
+	line, _, _ := bytes.Cut(content, []byte("\n"))
+	shebang, found := strings.CutPrefix(strings.TrimSpace(string(line)), "#!")
+	fields := strings.Fields(shebang)
+	if !found || len(fields) == 0 {
+		if Shell != "" {
+			return Shell
+		}
+		return "sh"
+	}
+
+	if path.Base(fields[0]) == "env" {
+		fields = fields[1:]
+		if len(fields) > 0 && fields[0] == "-S" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return "sh"
+		}
+	}
+	fields[0] = path.Base(fields[0])
+	return strings.Join(fields, " ")
+}
+
+// ScriptCommand reads a local script and returns the remote command that runs it from stdin with args,
+// together with the script to send as the command's input
+func ScriptCommand(file string, args []string) (string, []byte, error) {
+	content, err := os.ReadFile(file) // #nosec G304 -- script path is chosen by the local user
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read script: %w", err)
+	}
+
+	interpreter := scriptInterpreter(content)
+	command := interpreter + " -"
+	if slices.Contains(shellInterpreters, strings.Fields(interpreter)[0]) {
+		command = interpreter + " -s --"
+	}
+	for _, arg := range args {
+		command += " " + shellQuote(arg)
+	}
+
+	return command, content, nil
+}
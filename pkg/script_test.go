@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptInterpreter(t *testing.T) {
+	tests := []struct {
+		script, want string
+	}{
+		{"#!/bin/bash -e\necho hi\n", "bash -e"},
+		{"#!/usr/bin/env python3\nprint(1)\n", "python3"},
+		{"#!/usr/bin/env -S perl -w\n", "perl -w"},
+		{"echo no shebang\n", "sh"},
+		{"", "sh"},
+	}
+	for _, tt := range tests {
+		if got := scriptInterpreter([]byte(tt.script)); got != tt.want {
+			t.Errorf("scriptInterpreter(%q) = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "deploy.sh")
+	script := "#!/bin/sh\necho \"deploying $1 to $2\"\n"
+	if err := os.WriteFile(file, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	command, content, err := ScriptCommand(file, []string{"v1.2", "it's prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command != `sh -s -- 'v1.2' 'it'\''s prod'` {
+		t.Errorf("unexpected command: %s", command)
+	}
+
+	cmd := exec.CommandContext(context.Background(), "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(content)
+	output, err := cmd.Output()
+	if err != nil || string(output) != "deploying v1.2 to it's prod\n" {
+		t.Errorf("unexpected output %q: %v", output, err)
+	}
+
+	if _, _, err := ScriptCommand(filepath.Join(t.TempDir(), "missing.sh"), nil); err == nil {
+		t.Error("expected an error for a missing script")
+	}
+}
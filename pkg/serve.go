@@ -0,0 +1,311 @@
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed serve.html
+var dashboardPage []byte
+
+// serveShutdownTimeout is how long running requests get to finish when the server stops
+const serveShutdownTimeout = 10 * time.Second
+
+// Server exposes the fan-out over HTTP: a small web page, a JSON API and a Server-Sent Events stream.
+// Every run is written to the audit log. Requests must address the server by the address it listens on and
+// come from its own page, so that other sites can't reach it through the browser.
+type Server struct {
+	Groups          map[string][]string // Host groups usable as @group selectors
+	User            string              // ssh login user, empty for the ssh default
+	Token           string              // Required as "Authorization: Bearer <token>"; without one the API refuses every request
+	MaintenanceFile string              // Hosts listed here are skipped, empty to ignore maintenance
+	Audit           io.Writer           // Receives one line per run, nil to disable
+	Options         []Option            // Applied to every Runner, e.g. WithTimeout
+
+	listen  string // Address given to Serve, whose host name requests may use
+	auditMu sync.Mutex
+}
+
+// runRequest is the body of POST /api/run and POST /api/stream
+type runRequest struct {
+	Hosts   []string `json:"hosts"` // Host selectors as on the command line, e.g. "@prod-web", "-db1"
+	Command string   `json:"command"`
+}
+
+// hostResult is the JSON form of a Result
+type hostResult struct {
+	Host       string `json:"host"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// newHostResult converts a Result for the JSON API
+func newHostResult(r Result) hostResult {
+	result := hostResult{
+		Host:       r.Host,
+		Stdout:     string(r.Stdout),
+		Stderr:     string(r.Stderr),
+		ExitCode:   r.ExitCode,
+		DurationMS: r.Duration.Milliseconds(),
+	}
+	if r.Err != nil {
+		result.Error = r.Err.Error()
+	}
+	return result
+}
+
+// Handler returns the HTTP routes of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(dashboardPage)
+	})
+	mux.HandleFunc("GET /api/groups", s.authorized(s.handleGroups))
+	mux.HandleFunc("POST /api/run", s.authorized(s.handleRun))
+	mux.HandleFunc("POST /api/stream", s.authorized(s.handleStream))
+	mux.Handle("GET /metrics", s.authorized(MetricsHandler().ServeHTTP))
+	return s.sameOrigin(mux)
+}
+
+// authorized rejects requests without the token; without a configured token it rejects every request
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// sameOrigin rejects requests for another host than the server, as sent after DNS rebinding, and requests
+// a browser sends on behalf of another site
+func (s *Server) sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.servesHost(r) {
+			http.Error(w, "unknown host "+r.Host, http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+				http.Error(w, "cross-origin request from "+origin, http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// servesHost reports whether the Host of r is the address the request arrived on, the host name the server
+// was started with, or localhost for a loopback address; the port has to match in any case
+func (s *Server) servesHost(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	localIP, port, err := net.SplitHostPort(local.String())
+	if err != nil {
+		return false
+	}
+	host, hostPort, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, hostPort = r.Host, "80"
+	}
+	listenHost, _, _ := net.SplitHostPort(s.listen)
+
+	switch {
+	case hostPort != port:
+		return false
+	case strings.EqualFold(host, "localhost"):
+		return net.ParseIP(localIP).IsLoopback()
+	case listenHost != "" && net.ParseIP(listenHost) == nil && strings.EqualFold(host, listenHost):
+		return true
+	default:
+		return net.ParseIP(host).Equal(net.ParseIP(localIP))
+	}
+}
+
+// handleGroups lists the configured host groups
+func (s *Server) handleGroups(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.Groups)
+}
+
+// handleRun runs a command and responds with all results once every host finished
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	req, hosts, ok := s.decodeRun(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := s.runner(hosts).Run(r.Context(), req.Command)
+	s.audit(r, hosts, req.Command, results, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := make([]hostResult, len(results))
+	for i, result := range results {
+		response[i] = newHostResult(result)
+	}
+	writeJSON(w, http.StatusOK, map[string][]hostResult{"results": response})
+}
+
+// handleStream runs a command and streams its output as Server-Sent Events: a "line" event per output
+// line and one "done" event with all results. The request is the same as for handleRun.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	req, hosts, ok := s.decodeRun(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var mu sync.Mutex
+	send := func(event string, data any) {
+		payload, _ := json.Marshal(data)
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		flusher.Flush()
+	}
+
+	runner := s.runner(hosts, WithLineHandler(func(host string, stream Stream, line string) {
+		send("line", map[string]string{"host": host, "stream": string(stream), "line": line})
+	}))
+	results, err := runner.Run(r.Context(), req.Command)
+	s.audit(r, hosts, req.Command, results, err)
+	if err != nil {
+		return // The client went away
+	}
+
+	response := make([]hostResult, len(results))
+	for i, result := range results {
+		response[i] = newHostResult(result)
+	}
+	send("done", map[string][]hostResult{"results": response})
+}
+
+// decodeRun reads and resolves the run request in the body of r, responding with the error if it fails
+func (s *Server) decodeRun(w http.ResponseWriter, r *http.Request) (runRequest, []string, bool) {
+	var req runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return req, nil, false
+	}
+	hosts, err := s.resolve(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, nil, false
+	}
+	return req, hosts, true
+}
+
+// runner returns a Runner for hosts with the server's user and options, followed by extra
+func (s *Server) runner(hosts []string, extra ...Option) *Runner {
+	options := append([]Option{WithUser(s.User), WithMetrics()}, s.Options...) // Counted for /metrics
+	return NewRunner(hosts, append(options, extra...)...)
+}
+
+// resolve validates a run request and expands its selectors, skipping hosts in maintenance
+func (s *Server) resolve(req runRequest) ([]string, error) {
+	if strings.TrimSpace(req.Command) == "" {
+		return nil, errors.New("missing command")
+	}
+	hosts, err := ResolveHosts(req.Hosts, s.Groups)
+	if err != nil {
+		return nil, err
+	}
+	if s.MaintenanceFile != "" {
+		entries, err := LoadMaintenance(s.MaintenanceFile)
+		if err != nil {
+			return nil, err
+		}
+		hosts, _ = ExcludeMaintenance(hosts, entries)
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("host selectors matched no hosts")
+	}
+	return hosts, nil
+}
+
+// audit records who ran what where, and how it went
+func (s *Server) audit(r *http.Request, hosts []string, command string, results []Result, err error) {
+	if s.Audit == nil {
+		return
+	}
+	failed := 0
+	for _, result := range results {
+		if !result.OK() {
+			failed++
+		}
+	}
+	outcome := fmt.Sprintf("%d/%d failed", failed, len(hosts))
+	if err != nil {
+		outcome = "aborted: " + err.Error()
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	_, _ = fmt.Fprintf(s.Audit, "%s %s hosts=%s command=%q %s\n",
+		time.Now().Format(time.RFC3339), r.RemoteAddr, strings.Join(hosts, ","), command, outcome)
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Serve listens on addr and serves s until ctx ends, then waits for running requests to finish
+func Serve(ctx context.Context, addr string, s *Server) error {
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listen = addr
+	return serveOn(ctx, listener, s.Handler())
+}
+
+// serveOn serves handler on listener until ctx ends
+func serveOn(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	shutdown := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(shutdown)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	})
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		stop()
+		return err
+	}
+	<-shutdown
+	return nil
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// httpGet returns the body of a GET request
+func httpGet(t *testing.T, url string) string {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestServerRun(t *testing.T) {
+	useFakeSSH(t)
+	var audit bytes.Buffer
+	server := httptest.NewServer((&Server{
+		Groups: map[string][]string{"web": {"web1", "web2"}},
+		Token:  "secret",
+		Audit:  &audit,
+	}).Handler())
+	defer server.Close()
+
+	post := func(token, body string) *http.Response {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL+"/api/run", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post("wrong", `{"hosts": ["@web"], "command": "true"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong token, got %d", resp.StatusCode)
+	}
+
+	resp = post("secret", `{"hosts": ["@web", "-web1"], "command": ""}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an empty command, got %d", resp.StatusCode)
+	}
+
+	failures := func() int64 {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return metrics.host("web2").failed
+	}
+	before := failures()
+	resp = post("secret", `{"hosts": ["@web"], "command": "echo hi {host}; test {host} = web1"}`)
+	defer resp.Body.Close()
+	var body struct {
+		Results []hostResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Results) != 2 || body.Results[0].Stdout != "hi web1\n" || body.Results[0].ExitCode != 0 || body.Results[1].ExitCode != 1 {
+		t.Errorf("unexpected results: %+v", body.Results)
+	}
+	if !strings.Contains(audit.String(), `hosts=web1,web2 command="echo hi {host}; test {host} = web1" 1/2 failed`) {
+		t.Errorf("unexpected audit log: %q", audit.String())
+	}
+	if failures() != before+1 {
+		t.Error("expected the failed run to be counted in the metrics")
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	useFakeSSH(t)
+	server := httptest.NewServer((&Server{Token: "secret"}).Handler())
+	defer server.Close()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL+"/api/stream",
+		strings.NewReader(`{"hosts": ["web1", "web2"], "command": "echo out {host}; echo err >&2"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	stream := string(body)
+	for _, want := range []string{
+		"event: line\ndata: {\"host\":\"web2\",\"line\":\"out web2\",\"stream\":\"stdout\"}",
+		"\"stream\":\"stderr\"",
+		"event: done\ndata: {\"results\":[{\"host\":\"web1\"",
+	} {
+		if !strings.Contains(stream, want) {
+			t.Errorf("expected %q in %q", want, stream)
+		}
+	}
+}
+
+func TestServerRefusesForeignRequests(t *testing.T) {
+	server := httptest.NewServer((&Server{Token: "secret"}).Handler())
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+
+	status := func(method, path, host, origin, token string) int {
+		req, _ := http.NewRequestWithContext(context.Background(), method, server.URL+path, strings.NewReader(`{"hosts": ["web1"], "command": "true"}`))
+		req.Host, req.Header["Origin"] = host, []string{origin}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name, method, path, host, origin, token string
+		expected                                int
+	}{
+		{"own page", http.MethodGet, "/", "", "", "", http.StatusOK},
+		{"localhost", http.MethodGet, "/api/groups", "localhost" + port, "http://localhost" + port, "secret", http.StatusOK},
+		{"rebound host", http.MethodGet, "/", "evil.example" + port, "", "", http.StatusForbidden},
+		{"other port", http.MethodGet, "/api/groups", "127.0.0.1:1", "", "secret", http.StatusForbidden},
+		{"other origin", http.MethodGet, "/api/groups", "", "http://evil.example", "secret", http.StatusForbidden},
+		{"no token", http.MethodGet, "/api/groups", "", "", "", http.StatusUnauthorized},
+		{"stream by GET", http.MethodGet, "/api/stream", "", "", "secret", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		if got := status(test.method, test.path, test.host, test.origin, test.token); got != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, got)
+		}
+	}
+
+	open := httptest.NewServer((&Server{}).Handler())
+	defer open.Close()
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, open.URL+"/api/groups", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a server without token to refuse requests, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeStopsWithContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serveOn(ctx, listener, (&Server{}).Handler()) }()
+
+	if page := httpGet(t, "http://"+listener.Addr().String()+"/"); !strings.Contains(page, "<title>gosh</title>") {
+		t.Errorf("expected the dashboard page, got %q", page)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
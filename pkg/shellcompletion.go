@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CompletionFlag describes a command line flag for the shell completion scripts
+type CompletionFlag struct {
+	Name       string
+	Shorthand  string
+	Usage      string
+	TakesValue bool     // Whether the flag is followed by a value
+	Repeatable bool     // Whether the flag may be given several times
+	Values     []string // Values offered for the flag, local files if empty
+}
+
+// Subcommands are the words gosh accepts in place of the first host
+var Subcommands = []string{"run", "shell", "upload", "download", "help", "grep", "bench", "maintenance", "serve", "daemon", "completion"}
+
+// DefaultKnownHostsFile returns the location of the user's ssh known_hosts file
+func DefaultKnownHostsFile() string {
+	return filepath.Join(homeDir(), ".ssh", "known_hosts")
+}
+
+// KnownHostNames returns the host names of a known_hosts file, sorted and without duplicates. Hashed
+// entries, patterns and revoked keys are skipped, and a non-standard port is dropped from "[host]:port".
+// A missing file yields no names.
+func KnownHostNames(path string) []string {
+	file, err := os.Open(path) // #nosec G304 -- known_hosts path is derived from the local user's home directory
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // Certificate authority keys make long lines
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+			if fields[0] == "@revoked" {
+				continue
+			}
+			fields = fields[1:]
+		}
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "|") {
+			continue
+		}
+		for name := range strings.SplitSeq(fields[0], ",") {
+			if strings.HasPrefix(name, "[") {
+				if end := strings.Index(name, "]"); end > 0 {
+					name = name[1:end]
+				}
+			}
+			if name != "" && !strings.ContainsAny(name, "*?!") {
+				names = append(names, name)
+			}
+		}
+	}
+
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// CompletionTargets returns what the completion scripts offer as hosts: the groups as @name selectors,
+// then the hosts of known_hosts
+func CompletionTargets(groups map[string][]string, knownHostsFile string) []string {
+	targets := make([]string, 0, len(groups))
+	for name := range groups {
+		targets = append(targets, "@"+name)
+	}
+	slices.Sort(targets)
+	return append(targets, KnownHostNames(knownHostsFile)...)
+}
+
+// WriteCompletionScript writes the completion script of shell (bash, zsh or fish) for the given flags.
+// Hosts are looked up when completing by running "gosh completion hosts", so new groups and known hosts
+// are offered without regenerating the script.
+func WriteCompletionScript(w io.Writer, shell string, flags []CompletionFlag) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, flags)
+	case "zsh":
+		writeZshCompletion(w, flags)
+	case "fish":
+		writeFishCompletion(w, flags)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+// writeBashCompletion writes a completion function for bash's complete -F
+func writeBashCompletion(w io.Writer, flags []CompletionFlag) {
+	var words []string
+	fmt.Fprintln(w, "# bash completion for gosh, load with: source <(gosh completion bash)")
+	fmt.Fprintln(w, "_gosh() {")
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `    case "$prev" in`)
+	for _, flag := range flags {
+		names := flagNames(flag)
+		words = append(words, names...)
+		if !flag.TakesValue {
+			continue
+		}
+		reply := `compgen -f -- "$cur"`
+		if len(flag.Values) > 0 {
+			reply = fmt.Sprintf(`compgen -W "%s" -- "$cur"`, strings.Join(flag.Values, " "))
+		}
+		fmt.Fprintf(w, "        %s) COMPREPLY=($(%s)); return ;;\n", strings.Join(names, "|"), reply)
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, `    if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(words, " "))
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, `    if [[ "${COMP_WORDS[1]}" == completion && $COMP_CWORD -eq 2 ]]; then`)
+	fmt.Fprintln(w, `        COMPREPLY=($(compgen -W "bash zsh fish hosts" -- "$cur"))`)
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W \"%s $(gosh completion hosts 2>/dev/null)\" -- \"$cur\"))\n", strings.Join(Subcommands, " "))
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -F _gosh gosh")
+}
+
+// writeZshCompletion writes an _arguments based completion function for zsh
+func writeZshCompletion(w io.Writer, flags []CompletionFlag) {
+	fmt.Fprintln(w, "#compdef gosh")
+	fmt.Fprintln(w, "# zsh completion for gosh, load with: source <(gosh completion zsh)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_gosh_targets() {")
+	fmt.Fprintf(w, "    local -a targets=(%s ${(f)\"$(gosh completion hosts 2>/dev/null)\"})\n", strings.Join(Subcommands, " "))
+	fmt.Fprintln(w, "    compadd -a targets")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_gosh() {")
+	fmt.Fprintln(w, "    _arguments -s \\")
+	for _, flag := range flags {
+		names := flagNames(flag)
+		spec := "[" + zshEscape(flag.Usage) + "]"
+		if flag.TakesValue {
+			action := "_files"
+			if len(flag.Values) > 0 {
+				action = "(" + strings.Join(flag.Values, " ") + ")"
+			}
+			spec += ":value:" + action
+		}
+		switch {
+		case len(names) == 1 && flag.Repeatable:
+			spec = "'*" + names[0] + spec + "'"
+		case len(names) == 1:
+			spec = "'" + names[0] + spec + "'"
+		case flag.Repeatable:
+			spec = "'*'{" + strings.Join(names, ",") + "}'" + spec + "'"
+		default:
+			spec = "'(" + strings.Join(names, " ") + ")'{" + strings.Join(names, ",") + "}'" + spec + "'"
+		}
+		fmt.Fprintf(w, "        %s \\\n", spec)
+	}
+	fmt.Fprintln(w, "        '*:target:_gosh_targets'")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `if [[ "$funcstack[1]" == _gosh ]]; then _gosh "$@"; else compdef _gosh gosh; fi`)
+}
+
+// writeFishCompletion writes complete commands for fish
+func writeFishCompletion(w io.Writer, flags []CompletionFlag) {
+	fmt.Fprintln(w, "# fish completion for gosh, load with: gosh completion fish | source")
+	fmt.Fprintln(w, "complete -c gosh -f")
+	for _, flag := range flags {
+		line := "complete -c gosh"
+		if flag.Shorthand != "" {
+			line += " -s " + flag.Shorthand
+		}
+		line += " -l " + flag.Name
+		if flag.TakesValue {
+			if len(flag.Values) > 0 {
+				line += " -x -a " + fishQuote(strings.Join(flag.Values, " "))
+			} else {
+				line += " -r -F"
+			}
+		}
+		fmt.Fprintf(w, "%s -d %s\n", line, fishQuote(flag.Usage))
+	}
+	fmt.Fprintln(w, "complete -c gosh -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish hosts'")
+	fmt.Fprintf(w, "complete -c gosh -n 'not __fish_seen_subcommand_from completion' -a %s\n", fishQuote(strings.Join(Subcommands, " ")))
+	fmt.Fprintln(w, "complete -c gosh -n 'not __fish_seen_subcommand_from completion' -a '(gosh completion hosts 2>/dev/null)'")
+}
+
+// flagNames returns the short and long spelling of a flag, e.g. "-c" and "--command"
+func flagNames(flag CompletionFlag) []string {
+	if flag.Shorthand != "" {
+		return []string{"-" + flag.Shorthand, "--" + flag.Name}
+	}
+	return []string{"--" + flag.Name}
+}
+
+// zshEscape makes text safe inside a single quoted _arguments description
+func zshEscape(text string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`).Replace(text)
+}
+
+// fishQuote single quotes text for fish, where only \ and ' are special inside quotes
+func fishQuote(text string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(text) + "'"
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKnownHostNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	content := `web1,10.0.0.1 ssh-ed25519 AAAA
+[db1]:2222 ssh-ed25519 AAAA
+|1|hashed= ssh-ed25519 AAAA
+@cert-authority *.example.com ssh-ed25519 AAAA
+@revoked old1 ssh-ed25519 AAAA
+# comment
+web1 ecdsa-sha2-nistp256 AAAA
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := strings.Join(KnownHostNames(path), ","); names != "10.0.0.1,db1,web1" {
+		t.Errorf("unexpected names %q", names)
+	}
+	if names := KnownHostNames(filepath.Join(t.TempDir(), "missing")); len(names) != 0 {
+		t.Errorf("expected no names, got %q", names)
+	}
+
+	targets := CompletionTargets(map[string][]string{"web": {"web1"}, "db": {"db1"}}, path)
+	if strings.Join(targets, ",") != "@db,@web,10.0.0.1,db1,web1" {
+		t.Errorf("unexpected targets %q", targets)
+	}
+}
+
+func TestWriteCompletionScript(t *testing.T) {
+	flags := []CompletionFlag{
+		{Name: "command", Shorthand: "c", Usage: "Command to execute on all hosts", TakesValue: true},
+		{Name: "backend", Usage: "Transport: ssh or docker", TakesValue: true, Values: []string{"ssh", "docker"}},
+		{Name: "ssh-opt", Shorthand: "o", Usage: "Extra ssh option [repeatable]", TakesValue: true, Repeatable: true},
+		{Name: "no-color", Usage: "Disable colored output"},
+	}
+	expected := map[string][]string{
+		"bash": {"-c|--command) COMPREPLY=($(compgen -f", `--backend) COMPREPLY=($(compgen -W "ssh docker"`, "--no-color", "gosh completion hosts"},
+		"zsh":  {"'(-c --command)'{-c,--command}'[Command to execute on all hosts]:value:_files'", ":value:(ssh docker)", `'*'{-o,--ssh-opt}'[Extra ssh option \[repeatable\]]`, "'--no-color[Disable colored output]'"},
+		"fish": {"complete -c gosh -s c -l command -r -F -d 'Command to execute on all hosts'", "-l backend -x -a 'ssh docker'", "-l no-color -d"},
+	}
+	for shell, parts := range expected {
+		var buf bytes.Buffer
+		if err := WriteCompletionScript(&buf, shell, flags); err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		for _, part := range parts {
+			if !strings.Contains(buf.String(), part) {
+				t.Errorf("%s script lacks %q:\n%s", shell, part, buf.String())
+			}
+		}
+		if path, err := exec.LookPath(shell); err == nil {
+			check := exec.CommandContext(t.Context(), path, "-n")
+			check.Stdin = &buf
+			if output, err := check.CombinedOutput(); err != nil {
+				t.Errorf("%s rejects the script: %v\n%s", shell, err, output)
+			}
+		}
+	}
+
+	if err := WriteCompletionScript(&bytes.Buffer{}, "tcsh", flags); err == nil {
+		t.Error("expected an error for an unsupported shell")
+	}
+}
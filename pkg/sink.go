@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// OutputSink receives the output of streamed remote commands. Both methods are called concurrently from
+// per-host goroutines; Finish is called once per host after its last line.
+type OutputSink interface {
+	// WriteLine receives one line of output without the trailing newline. line is only valid during the call.
+	WriteLine(host string, stream Stream, line []byte)
+	// Finish receives the outcome of the command on host. Stdout and Stderr are not collected and stay empty;
+	// Err is the context's error when the command was interrupted.
+	Finish(result Result)
+}
+
+// Sink replaces the terminal as destination of remote command output when set, e.g. to render it in
+// another UI or to collect it in tests
+var Sink OutputSink
+
+// terminalSink prints lines with the colored host prefix through the shared output writer
+type terminalSink struct {
+	prefix string
+}
+
+// WriteLine prints "prefix: line"; stderr goes to stdout as well so the lines of all hosts stay in order
+func (t terminalSink) WriteLine(_ string, _ Stream, line []byte) {
+	writeHostLine(t.prefix, line)
+}
+
+// Finish reports a failed command below its output, unless it was interrupted. With ShowDuration the
+// wall time follows, e.g. "(1.42s)".
+func (t terminalSink) Finish(result Result) {
+	duration := ""
+	if ShowDuration && result.Duration > 0 {
+		duration = " (" + formatSeconds(result.Duration) + ")"
+	}
+	switch {
+	case result.Err != nil && !errors.Is(result.Err, context.Canceled):
+		writeHostLine(t.prefix, fmt.Appendf(nil, "ERROR: Command failed: %v%s", result.Err, duration))
+	case duration != "" && result.Err == nil:
+		writeHostLine(t.prefix, []byte(strings.TrimSpace(duration)))
+	}
+	flushOutput()
+}
+
+// sinkKey is the context key carrying the output sink of a command run
+type sinkKey struct{}
+
+// withSink sends the output of commands started with ctx to sink instead of Sink or the terminal
+func withSink(ctx context.Context, sink OutputSink) context.Context {
+	return context.WithValue(ctx, sinkKey{}, sink)
+}
+
+// sinkFor returns the sink carried by ctx, Sink, or a terminal sink printing with prefix
+func sinkFor(ctx context.Context, prefix string) OutputSink {
+	if sink, ok := ctx.Value(sinkKey{}).(OutputSink); ok {
+		return sink
+	}
+	if Sink != nil {
+		return Sink
+	}
+	return terminalSink{prefix: prefix}
+}
+
+// exitCode returns the remote exit status carried by err, 0 for nil and -1 if the command never exited
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink collects everything it receives
+type recordingSink struct {
+	mu      sync.Mutex
+	lines   []string
+	results []Result
+}
+
+func (r *recordingSink) WriteLine(host string, stream Stream, line []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, host+" "+string(stream)+" "+string(line))
+}
+
+func (r *recordingSink) Finish(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+func TestOutputSink(t *testing.T) {
+	sink := &recordingSink{}
+	Sink = sink
+	defer func() { Sink = nil }()
+
+	output := captureStdout(t, func() {
+		cmd := exec.CommandContext(context.Background(), "sh", "-c", "echo one; echo two >&2; exit 3")
+		_ = streamCommand(context.Background(), cmd, "web1", "web1", "")
+	})
+	if output != "" {
+		t.Errorf("expected nothing on the terminal, got %q", output)
+	}
+
+	for _, want := range []string{"web1 stdout one", "web1 stderr two"} {
+		if !slices.Contains(sink.lines, want) {
+			t.Errorf("expected line %q, got %q", want, sink.lines)
+		}
+	}
+	if len(sink.results) != 1 || sink.results[0].Host != "web1" || sink.results[0].ExitCode != 3 || sink.results[0].OK() {
+		t.Errorf("expected one failed result with exit code 3, got %+v", sink.results)
+	}
+
+	// Interrupted commands report the context's error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", "true")
+	_ = streamCommand(ctx, cmd, "web2", "web2", "")
+	if last := sink.results[len(sink.results)-1]; last.Host != "web2" || last.Err != context.Canceled {
+		t.Errorf("expected a cancelled result, got %+v", last)
+	}
+}
+
+func TestTerminalSinkFinish(t *testing.T) {
+	output := captureStdout(t, func() {
+		terminalSink{prefix: "web1"}.Finish(Result{Host: "web1", Err: context.Canceled})
+		terminalSink{prefix: "web2"}.Finish(Result{Host: "web2", ExitCode: 1, Err: exec.ErrNotFound})
+	})
+	if output != "web2: ERROR: Command failed: "+exec.ErrNotFound.Error()+"\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestShowDuration(t *testing.T) {
+	ShowDuration = true
+	defer func() { ShowDuration = false }()
+
+	output := captureStdout(t, func() {
+		terminalSink{prefix: "web1"}.Finish(Result{Host: "web1", Duration: 1420 * time.Millisecond})
+		terminalSink{prefix: "web2"}.Finish(Result{Host: "web2", ExitCode: 1, Err: exec.ErrNotFound, Duration: 3 * time.Second})
+	})
+	if output != "web1: (1.42s)\nweb2: ERROR: Command failed: "+exec.ErrNotFound.Error()+" (3.00s)\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	ctx, durations := withDurations(context.Background())
+	for host, seconds := range map[string]int{"web1": 1, "web2": 7, "web3": 3} {
+		recordDuration(ctx, host, time.Duration(seconds)*time.Second)
+	}
+	var summary strings.Builder
+	durations.printSlowest(&summary)
+	if summary.String() != "🐢 Slowest: web2 7.00s · web3 3.00s · web1 1.00s\n" {
+		t.Errorf("unexpected summary: %q", summary.String())
+	}
+}
@@ -4,12 +4,21 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
 // runCmdWithSeparateOutput runs a command and returns stdout, stderr, and error separately
@@ -22,169 +31,1068 @@ func runCmdWithSeparateOutput(cmd *exec.Cmd) (string, string, error) {
 	return stdout.String(), stderr.String(), err
 }
 
+// ConnectTimeout bounds how long establishing an SSH connection may take
+var ConnectTimeout = defaultConnectTimeout
+
+// defaultConnectTimeout is the ConnectTimeout of ssh invocations unless configured otherwise
+const defaultConnectTimeout = 5 * time.Second
+
+// ControlPersist is how long an idle control master stays alive
+var ControlPersist = 10 * time.Minute
+
+// DirectFallback reaches hosts over a new ssh connection per command when no control master can be
+// established or the server refuses multiplexed sessions, instead of leaving them out of the session
+var DirectFallback bool
+
+// ServerAliveInterval is how often control masters send keepalives, so NAT and firewall state doesn't
+// expire during long sessions; 0 disables them
+var ServerAliveInterval = 15 * time.Second
+
+// ServerAliveCountMax is how many keepalives may go unanswered before a control master gives up
+var ServerAliveCountMax = 3
+
+// IdleTimeout closes control masters no command used for this long; the next command re-establishes
+// them. 0 keeps them open.
+var IdleTimeout time.Duration
+
+// SocketDir overrides the control socket directory; empty selects defaultSocketDir
+var SocketDir string
+
+// defaultSocketDir prefers the per-user XDG_RUNTIME_DIR over the shared temp directory
+func defaultSocketDir() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "gosh")
+	}
+	return filepath.Join(os.TempDir(), "gosh-ssh-sockets")
+}
+
+// sshSeconds formats a duration as whole seconds for ssh options, rounding up to at least one second
+func sshSeconds(d time.Duration) string {
+	return strconv.Itoa(max(1, int((d+time.Second-1)/time.Second)))
+}
+
+// Profile names the environment the session targets; each profile pins host keys in its own known_hosts file
+var Profile string
+
+// knownHostsFile returns the pinned host key store for a profile
+func knownHostsFile(profile string) string {
+	return filepath.Join(homeDir(), ".gosh", "known_hosts", profile)
+}
+
+// SSHOptions are user-supplied "Key=Value" options passed as -o to every ssh and scp invocation
+var SSHOptions []string
+
+// userSSHOptions returns SSHOptions as ssh command line arguments
+func userSSHOptions() []string {
+	args := make([]string, 0, 2*len(SSHOptions))
+	for _, opt := range SSHOptions {
+		args = append(args, "-o", opt)
+	}
+	return args
+}
+
+// IdentityFiles are private keys passed with -i to every ssh and scp invocation that opens a new connection
+var IdentityFiles []string
+
+// homeDir returns the home directory of the local user, $HOME or %USERPROFILE% on Windows
+func homeDir() string {
+	home, _ := os.UserHomeDir()
+	return home
+}
+
+// expandHome replaces a leading ~/ (or ~\ on Windows) with the user's home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(filepath.ToSlash(path), "~/"); ok {
+		return filepath.Join(homeDir(), rest)
+	}
+	return path
+}
+
+// NoEcho keeps command echo and connection banners out of the output
+var NoEcho bool
+
+// isEchoedCommand reports whether an output line is the remote shell echoing the command back
+func isEchoedCommand(line []byte, command string) bool {
+	trimmed := strings.TrimSpace(string(line))
+	return trimmed != "" && (trimmed == strings.TrimSpace(command) || strings.HasSuffix(trimmed, noEchoPrefix+command))
+}
+
+// TTY requests a pseudo-terminal for remote commands so screen-oriented programs, pagers and sudo behave
+// as in a terminal. The remote side then merges stderr into stdout and ends lines with \r\n.
+var TTY bool
+
+// ttyArgs returns the ssh arguments that request a pseudo-terminal when TTY is set
+func ttyArgs() []string {
+	if !TTY {
+		return nil
+	}
+	return []string{"-tt", "-o", "LogLevel=ERROR"} // Drops "Connection to host closed."
+}
+
+// noEchoPrefix disables terminal echo before the command runs
+const noEchoPrefix = "stty -echo 2>/dev/null; "
+
+// extraSSHOptions returns options shared by every ssh and scp invocation that opens a new connection
+func extraSSHOptions() []string {
+	args := userSSHOptions()
+
+	if NoEcho {
+		args = append(args, "-o", "LogLevel=ERROR") // Drops pre-auth banners and "Permanently added" notices
+	}
+
+	for _, identity := range IdentityFiles {
+		args = append(args, "-i", expandHome(identity))
+	}
+
+	args = append(args, hostKeyOptions()...)
+	args = append(args, agentArgs()...)
+	return append(args, verboseArgs()...) // After LogLevel, which -v overrides
+}
+
+// ConnectionOptions returns the "Key=Value" options of SSHOptions, IdentityFiles, the host key policy and
+// ForwardAgent, for passing the ssh settings of the command line to WithSSHOptions
+func ConnectionOptions() []string {
+	options := slices.Clone(SSHOptions)
+	for _, identity := range IdentityFiles {
+		options = append(options, "IdentityFile="+expandHome(identity))
+	}
+	for i, arg := range hostKeyOptions() {
+		if i%2 == 1 { // Values following -o
+			options = append(options, arg)
+		}
+	}
+	if ForwardAgent {
+		options = append(options, "ForwardAgent=yes")
+	}
+	return options
+}
+
+// OutputMode controls which host output is printed
+type OutputMode int
+
+const (
+	// OutputAll prints all output as it arrives
+	OutputAll OutputMode = iota
+	// OutputQuiet suppresses stdout and only prints stderr and errors
+	OutputQuiet
+	// OutputOnlyFailures buffers output and prints it only for hosts whose command failed
+	OutputOnlyFailures
+)
+
+// Output selects the output mode for remote commands
+var Output OutputMode
+
+// maxBufferedLines caps the lines held back per host in only-failures mode, e.g. for a long-running tail
+const maxBufferedLines = 10000
+
+// refuseCommand checks a command entered by the user against the allow and deny lists. A refused command
+// is reported like a failed run on host and its error returned; commands gosh generates itself, like the
+// one of :kill, aren't checked.
+func refuseCommand(ctx context.Context, host, prefix, command string) error {
+	err := checkCommand(command)
+	if err != nil {
+		metrics.commandDone(host, err)
+		Hooks.hostDone(host, err, 0)
+		sinkFor(ctx, prefix).Finish(Result{Host: host, ExitCode: -1, Err: err})
+	}
+	return err
+}
+
+// streamCommand runs cmd and passes its output line by line to the output sink, printing with the host prefix
+// by default, and honoring the output mode. Every line is also appended to the host's log when --output-dir
+// is set. command is the remote command as typed, used to drop it if the remote side echoes it back in
+// --no-echo mode. It returns the command's error, which carries the remote exit status.
+func streamCommand(ctx context.Context, cmd *exec.Cmd, host, prefix, command string) error {
+	sink := sinkFor(ctx, prefix)
+	fail := func(err error) error {
+		metrics.commandDone(host, err)
+		Hooks.hostDone(host, err, 0)
+		sink.Finish(Result{Host: host, ExitCode: -1, Err: err})
+		return err
+	}
+	acquireFDs()
+	defer releaseFDs()
+
+	// Get stdout and stderr pipes
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fail(fmt.Errorf("failed to get stdout pipe: %w", err))
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fail(fmt.Errorf("failed to get stderr pipe: %w", err))
+	}
+
+	// Input set on cmd (broadcast stdin) or the sudo password, which goes to stdin so it never shows up
+	// in a process list. A pipe lets Wait return when the command exits even if input is still pending.
+	source := cmd.Stdin
+	if input := sudoInput(); input != nil {
+		source = bytes.NewReader(input)
+	}
+	var stdin io.WriteCloser
+	if source != nil {
+		cmd.Stdin = nil
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return fail(fmt.Errorf("failed to get stdin pipe: %w", err))
+		}
+	}
+
+	// Lines are held back until the exit status is known in only-failures mode
+	type bufferedLine struct {
+		stream Stream
+		line   []byte
+	}
+	var mu sync.Mutex
+	var buffered []bufferedLine
+	dropped := 0
+	var failure []byte // The first stderr line explaining a connection failure, if ssh fails
+	emit := func(stream Stream, line []byte) {
+		if Output == OutputOnlyFailures {
+			mu.Lock()
+			if len(buffered) == maxBufferedLines { // Keep the tail, which usually explains the failure
+				buffered = buffered[1:]
+				dropped++
+			}
+			buffered = append(buffered, bufferedLine{stream, bytes.Clone(line)})
+			mu.Unlock()
+			return
+		}
+		sink.WriteLine(host, stream, line)
+	}
+
+	// Start goroutines to read and display output in real-time
+	var wg sync.WaitGroup
+	readLines := func(stream io.Reader, name Stream, show bool) {
+		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(nil, maxLineLength+2)
+		scanner.Split(scanCappedLines(maxLineLength))
+		first := true
+		for scanner.Scan() {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				line := scanner.Bytes()
+				if TTY {
+					line = bytes.TrimSuffix(line, []byte("\r"))
+				}
+				if name == StreamStderr && isSSHDebugLine(line) {
+					writeSSHDebug(prefix, line)
+					continue
+				}
+				if name == StreamStderr && failure == nil {
+					if class, _ := matchFailure(line); class != "" {
+						failure = bytes.Clone(line)
+					}
+				}
+				if first && NoEcho && name == StreamStdout && isEchoedCommand(line, command) {
+					first = false
+					continue
+				}
+				first = false
+				metrics.received(name, len(line)+1)
+				// Only materialize a string when someone consumes it
+				if OutputDir != "" || Hooks.OnHostLine != nil {
+					text := string(line)
+					logHostLine(host, text)
+					Hooks.hostLine(host, name, text)
+				}
+				switch {
+				case !show || !outputFilter.match(line):
+				case redirect != nil && name == StreamStdout: // Like a shell redirect, stderr stays on the terminal
+					redirect.writeLine(host, line)
+				default:
+					emit(name, line)
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			emit(StreamStderr, fmt.Appendf(nil, "ERROR: Failed to read %s: %v", name, err))
+		}
+	}
+
+	// Handle stdout
+	wg.Go(func() {
+		readLines(stdout, StreamStdout, Output != OutputQuiet)
+	})
+
+	// Handle stderr
+	wg.Go(func() {
+		readLines(stderr, StreamStderr, true)
+	})
+
+	// Start the command
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return fail(err)
+	}
+	if stdin != nil {
+		go copyStdin(stdin, source)
+	}
+
+	// Wait for output readers to drain the pipes, then for the command to complete
+	wg.Wait()
+	err = classifySSHFailure(host, failure, cmd.Wait())
+	duration := time.Since(start)
+	recordDuration(ctx, host, duration)
+	metrics.commandDone(host, err)
+	Hooks.hostDone(host, err, duration)
+
+	if err != nil && Output == OutputOnlyFailures {
+		if dropped > 0 {
+			sink.WriteLine(host, StreamStderr, fmt.Appendf(nil, "… %d earlier line(s) dropped", dropped))
+		}
+		for _, b := range buffered {
+			sink.WriteLine(host, b.stream, b.line)
+		}
+	}
+
+	result := Result{Host: host, ExitCode: exitCode(err), Duration: duration, Err: err}
+	if err != nil && ctx.Err() != nil {
+		result.Err = ctx.Err()
+	}
+	sink.Finish(result)
+
+	return err
+}
+
 // SSHConnectionManager manages persistent SSH connections using ControlMaster
 type SSHConnectionManager struct {
 	mu          sync.Mutex
 	connections map[string]*SSHConnection
+	cwd         map[string]string            // Working directory per host set by cd, kept across reconnects
+	commands    map[string]cachedCommands    // Remote command names per host for completion
+	lookups     map[string]*completionLookup // Running completion lookups by host and command
+	forwards    []portForward                // Open tunnels, re-opened when a host reconnects
+	direct      map[string]bool              // Hosts without a control master, see DirectFallback
+	facts       map[string]hostFacts         // Facts per host gathered by :facts, kept across reconnects
+	hostLocks   map[string]*sync.Mutex       // Serialize closing and re-establishing the master of a host
 	socketDir   string
 	user        string
 }
 
 // SSHConnection represents a persistent SSH connection to a host
 type SSHConnection struct {
-	host       string
-	socketPath string
+	host        string
+	socketPath  string
+	connectedAt time.Time       // When the control master was established
+	lastRun     time.Duration   // Duration of the last command, 0 if none ran yet
+	lastUsed    time.Time       // When the last command started
+	active      int             // Commands running over the connection
+	idle        bool            // Closed after IdleTimeout, re-established by the next command
+	down        bool            // Lost its control master, until reconnect establishes a new one
+	shared      bool            // Rides on the master of another gosh process, e.g. the daemon, which is left open
+	shell       shellCapability // What the remote login shell supports
+	machineID   string          // Identifies the machine behind aliases and IPs, empty if unknown
 }
 
-// NewSSHConnectionManager creates a new connection manager
-func NewSSHConnectionManager(user string) *SSHConnectionManager {
-	socketDir := filepath.Join(os.TempDir(), "gosh-ssh-sockets")
+// shellCapability describes the remote shell environment of a host
+type shellCapability int
+
+const (
+	// shellUnknown means the host was not probed yet
+	shellUnknown shellCapability = iota
+	// shellBash means the login shell is bash
+	shellBash
+	// shellBashAvailable means the login shell is a POSIX shell and bash is installed
+	shellBashAvailable
+	// shellPOSIX means only a POSIX shell is available
+	shellPOSIX
+	// shellRestricted means the host did not accept a plain shell probe (restricted shell or appliance CLI)
+	shellRestricted
+)
+
+// String returns the capability name shown in :status
+func (c shellCapability) String() string {
+	switch c {
+	case shellBash:
+		return "bash"
+	case shellBashAvailable:
+		return "sh+bash"
+	case shellPOSIX:
+		return "sh"
+	case shellRestricted:
+		return "restricted"
+	default:
+		return "unknown"
+	}
+}
+
+// shellProbeCommand reports the remote shell flavor as one word
+const shellProbeCommand = `if [ -n "$BASH_VERSION" ]; then echo bash; elif command -v bash >/dev/null 2>&1; then echo sh+bash; else echo sh; fi`
+
+// parseShellProbe maps the output of shellProbeCommand to a capability; anything unexpected means a restricted shell
+func parseShellProbe(output string, err error) shellCapability {
+	if err != nil {
+		return shellRestricted
+	}
+	switch strings.TrimSpace(output) {
+	case "bash":
+		return shellBash
+	case "sh+bash":
+		return shellBashAvailable
+	case "sh":
+		return shellPOSIX
+	default:
+		return shellRestricted
+	}
+}
+
+// sessionCommand returns the command that runs a shell command on a connected host, over its control
+// socket with the ssh backend
+func (cm *SSHConnectionManager) sessionCommand(ctx context.Context, host, command string) *exec.Cmd {
+	if backendOf(host) != BackendSSH || cm.isDirect(host) {
+		return hostCommand(ctx, host, command, cm.user, false)
+	}
+	// #nosec G204 - host parameter is controlled by our connection manager, not user input
+	return exec.CommandContext(ctx, "ssh", "-S", cm.getSocketPath(host), "-o", "BatchMode=yes", "--", sshDestination(host), command)
+}
+
+// isDirect reports whether host is reached over a new connection per command, without a control master
+func (cm *SSHConnectionManager) isDirect(host string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.direct[host]
+}
+
+// multiplexes reports whether the control master of host accepts sessions; servers may refuse all but the
+// first, e.g. with MaxSessions 1
+func (cm *SSHConnectionManager) multiplexes(ctx context.Context, host string) bool {
+	// #nosec G204 - host parameter is controlled by our connection manager, not user input
+	err := exec.CommandContext(ctx, "ssh", "-S", cm.getSocketPath(host), "-o", "BatchMode=yes", "--", sshDestination(host), "true").Run()
+	return exitCode(err) != 255
+}
+
+// probeShell detects the remote shell capabilities of a connected host
+func (cm *SSHConnectionManager) probeShell(ctx context.Context, host string) shellCapability {
+	output, err := cm.sessionCommand(ctx, host, shellProbeCommand).Output()
+	return parseShellProbe(string(output), err)
+}
+
+// machineIDCommand prints a stable identifier of the remote machine
+const machineIDCommand = `cat /etc/machine-id 2>/dev/null || cat /var/lib/dbus/machine-id 2>/dev/null || hostid 2>/dev/null`
+
+// probeMachineID reads the machine identifier of a connected host, returning "" if it is unavailable
+func (cm *SSHConnectionManager) probeMachineID(ctx context.Context, host string) string {
+	output, err := cm.sessionCommand(ctx, host, machineIDCommand).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// machineIDs returns the known machine identifiers of the given hosts
+func (cm *SSHConnectionManager) machineIDs(hosts []string) map[string]string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	ids := make(map[string]string, len(hosts))
+	for _, host := range hosts {
+		if conn, exists := cm.connections[host]; exists && conn.machineID != "" {
+			ids[host] = conn.machineID
+		}
+	}
+	return ids
+}
+
+// shellOf returns the probed shell capability of a host
+func (cm *SSHConnectionManager) shellOf(host string) shellCapability {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if conn, exists := cm.connections[host]; exists {
+		return conn.shell
+	}
+	return shellUnknown
+}
+
+// socketDirectory returns the control socket directory, creating it if needed
+func socketDirectory() string {
+	socketDir := SocketDir
+	if socketDir == "" {
+		socketDir = defaultSocketDir()
+	}
 	if err := os.MkdirAll(socketDir, 0o700); err != nil {
 		// If we can't create the directory, we'll handle it when establishing connections
 		socketDir = os.TempDir() // fallback to temp dir
 	}
+	return socketDir
+}
 
-	return &SSHConnectionManager{
+// NewSSHConnectionManager creates a new connection manager
+func NewSSHConnectionManager(user string) *SSHConnectionManager {
+	cm := &SSHConnectionManager{
 		connections: make(map[string]*SSHConnection),
-		socketDir:   socketDir,
+		cwd:         make(map[string]string),
+		commands:    make(map[string]cachedCommands),
+		direct:      make(map[string]bool),
+		facts:       make(map[string]hostFacts),
+		socketDir:   socketDirectory(),
 		user:        user,
 	}
+	cm.cleanupStaleSockets()
+
+	return cm
 }
 
-// getSocketPath returns the socket path for a host
+// cleanupStaleSockets removes gosh-* control sockets whose master is gone, e.g. after a crashed session.
+// Live sockets may belong to another session or the daemon, under any login user, and are kept. It returns
+// the number of removed sockets.
+func (cm *SSHConnectionManager) cleanupStaleSockets() int {
+	entries, err := os.ReadDir(cm.socketDir)
+	if err != nil {
+		return 0
+	}
+
+	var removed atomic.Int32
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "gosh-") || entry.Type()&os.ModeSocket == 0 {
+			continue
+		}
+
+		wg.Go(func() {
+			path := filepath.Join(cm.socketDir, name)
+			if staleSocket(path) && os.Remove(path) == nil {
+				removed.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	return int(removed.Load())
+}
+
+// staleSocket reports whether no master listens on the control socket at path any more, i.e. connecting
+// to it is refused. Sockets that accept connections or fail otherwise, e.g. for permissions, are not stale.
+func staleSocket(path string) bool {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+	_ = conn.Close()
+	return false
+}
+
+// CleanupSockets removes orphaned control sockets from the socket directory and reports how many were removed
+func CleanupSockets() int {
+	cm := &SSHConnectionManager{socketDir: socketDirectory()}
+	return cm.cleanupStaleSockets()
+}
+
+// getSocketPath returns the socket path for a host. The login user is part of the name, so connections
+// reused by other gosh processes run as the user they ask for.
 func (cm *SSHConnectionManager) getSocketPath(host string) string {
-	return filepath.Join(cm.socketDir, "gosh-"+strings.ReplaceAll(host, "/", "_"))
+	name := host
+	if cm.user != "" {
+		name = cm.user + "@" + host
+	}
+	return filepath.Join(cm.socketDir, "gosh-"+hostFileName(name))
+}
+
+// sharedSocket returns the control socket another gosh process, e.g. a running daemon or interactive session,
+// keeps open for host and user, or "" if there is none
+func sharedSocket(host, user string) string {
+	path := (&SSHConnectionManager{socketDir: socketDirectory(), user: user}).getSocketPath(host)
+	if info, err := os.Stat(path); err != nil || info.Mode()&os.ModeSocket == 0 {
+		return ""
+	}
+	return path
+}
+
+// errNotConnected is returned for hosts that are not, or no longer, part of the session
+var errNotConnected = errors.New("not connected")
+
+// establishConnection establishes a persistent SSH connection to a host; cancelling ctx aborts it
+func (cm *SSHConnectionManager) establishConnection(ctx context.Context, host string) error {
+	conn, err := cm.connect(ctx, host)
+	if err != nil {
+		return err
+	}
+	cm.mu.Lock()
+	cm.connections[host] = conn
+	cm.mu.Unlock()
+	return nil
 }
 
-// establishConnection establishes a persistent SSH connection to a host
-func (cm *SSHConnectionManager) establishConnection(host string) error {
+// connect opens the control master of a host and probes its shell, returning the connection for the caller
+// to store. A master another gosh process keeps open for the host is used instead of starting one.
+func (cm *SSHConnectionManager) connect(ctx context.Context, host string) (*SSHConnection, error) {
 	socketPath := cm.getSocketPath(host)
+	shared := backendOf(host) == BackendSSH && controlMasterSupported && liveMaster(ctx, host, socketPath)
+	var err error
+	if !shared {
+		err = cm.startMaster(ctx, host, socketPath)
+	}
+	if err != nil && backendOf(host) != BackendSSH {
+		return nil, fmt.Errorf("failed to reach %s via %s: %w", host, backendOf(host), err)
+	}
+
+	// Hosts that refuse the master or its sessions may still accept a connection per command. Classified
+	// failures, e.g. a wrong key, would fail that way just the same.
+	direct, fallback := backendOf(host) == BackendSSH && !controlMasterSupported, false
+	if DirectFallback && backendOf(host) == BackendSSH && !direct && !shared {
+		var connErr *ConnectionError
+		switch {
+		case err == nil && !cm.multiplexes(ctx, host):
+			_ = exec.CommandContext(ctx, "ssh", "-S", socketPath, "-O", "exit", "--", sshDestination(host)).Run() // #nosec G204 -- host is ours
+			_ = os.Remove(socketPath)
+			direct, fallback = true, true
+		case err != nil && !errors.As(err, &connErr) && hostCommand(ctx, host, "true", cm.user, false).Run() == nil:
+			direct, fallback, err = true, true, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish SSH connection to %s: %w", host, err)
+	}
+	cm.mu.Lock()
+	if direct {
+		cm.direct[host] = true
+	} else {
+		delete(cm.direct, host)
+	}
+	cm.mu.Unlock()
+
+	// Store connection info
+	shell := cm.probeShell(ctx, host)
+	var machineID string
+	if shell != shellRestricted {
+		machineID = cm.probeMachineID(ctx, host)
+	}
+	conn := &SSHConnection{
+		host:        host,
+		socketPath:  socketPath,
+		connectedAt: time.Now(),
+		lastUsed:    time.Now(),
+		shared:      shared,
+		shell:       shell,
+		machineID:   machineID,
+	}
+
+	if shell == shellRestricted {
+		Log.Warn("Restricted shell detected, running in degraded mode without remote completion", "host", host)
+	}
+	if fallback {
+		Log.Warn("No persistent connection possible, connecting anew for every command", "host", host)
+	}
+
+	return conn, nil
+}
+
+// liveMaster reports whether a control master, e.g. of the gosh daemon or another session, already serves
+// host on socketPath. A socket left behind by a master that died is removed, so a new one can take its place.
+func liveMaster(ctx context.Context, host, socketPath string) bool {
+	if _, err := os.Stat(socketPath); err != nil {
+		return false
+	}
+	if staleSocket(socketPath) {
+		_ = os.Remove(socketPath)
+		return false
+	}
+	// #nosec G204 - host parameter is controlled by our connection manager, not user input
+	return exec.CommandContext(ctx, "ssh", "-S", socketPath, "-O", "check", "--", sshDestination(host)).Run() == nil
+}
 
-	// Establish new connection
+// startMaster starts the control master of host on socketPath, or checks that the host accepts commands
+// where no master is kept
+func (cm *SSHConnectionManager) startMaster(ctx context.Context, host, socketPath string) error {
 	args := []string{
 		"-M",             // Enable ControlMaster
 		"-S", socketPath, // Control socket path
-		"-o", "ControlPersist=10m", // Keep connection alive for 10 minutes
-		"-o", "ConnectTimeout=5",
+		"-o", "ControlPersist=" + sshSeconds(ControlPersist), // Keep idle connection alive
+		"-o", "ConnectTimeout=" + sshSeconds(ConnectTimeout),
 		"-o", "BatchMode=yes",
 		"-f", // Go to background after establishing connection
 	}
+	if ServerAliveInterval > 0 {
+		args = append(args,
+			"-o", "ServerAliveInterval="+sshSeconds(ServerAliveInterval),
+			"-o", "ServerAliveCountMax="+strconv.Itoa(ServerAliveCountMax),
+		)
+	}
+	args = append(args, extraSSHOptions()...)
 
 	if cm.user != "" {
 		args = append(args, "-l", cm.user)
 	}
 
-	args = append(args, host, "true") // Simple command to establish connection
+	args = append(args, "--", sshDestination(host), "true") // Simple command to establish connection
 
-	cmd := exec.CommandContext(context.Background(), "ssh", args...)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to establish SSH connection to %s: %w", host, err)
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	if backendOf(host) != BackendSSH || !controlMasterSupported {
+		// Other backends keep no connection open, neither does ssh without Unix sockets for a control master.
+		// Check that the host accepts commands instead.
+		cmd = hostCommand(ctx, host, "true", cm.user, false)
 	}
-
-	// Store connection info
-	cm.mu.Lock()
-	cm.connections[host] = &SSHConnection{
-		host:       host,
-		socketPath: socketPath,
+	stderr := captureStderr(cmd)
+	acquireFDs()
+	start := time.Now()
+	err := cmd.Run()
+	metrics.connected(host, time.Since(start), err)
+	releaseFDs()
+	output := splitSSHDebug(host, stderr())
+	if err = classifySSHFailure(host, output, err); exitCode(err) == 255 {
+		// Unclassified failures of ssh are still explained by its last message
+		if lines := strings.Split(strings.TrimSpace(string(output)), "\n"); lines[len(lines)-1] != "" {
+			err = fmt.Errorf("%w: %s", err, strings.TrimPrefix(lines[len(lines)-1], "ssh: "))
+		}
 	}
-	cm.mu.Unlock()
-
-	return nil
+	return err
 }
 
-// runSSHStreaming executes SSH command using persistent connection with real-time streaming output and context cancellation
-func (cm *SSHConnectionManager) runSSHStreaming(ctx context.Context, host, command string, idx, maxHostLen int, noColor bool) {
+// runSSHStreaming executes SSH command using persistent connection with real-time streaming output and context cancellation; stdin may be nil.
+// Commands started with a job context record their remote process group so they can be killed.
+// The returned error carries the remote exit status.
+func (cm *SSHConnectionManager) runSSHStreaming(ctx context.Context, host, command string, stdin io.Reader, idx, maxHostLen int, noColor bool) error {
+	cm.use(ctx, host)
+	defer cm.release(host)
 	socketPath := cm.getSocketPath(host)
 
 	args := []string{
 		"-S", socketPath, // Use existing control socket
 		"-o", "BatchMode=yes",
 	}
+	args = append(args, ttyArgs()...)
+	args = append(args, userSSHOptions()...)
+	args = append(args, agentArgs()...) // Forwarding is requested per session, not by the master
+	args = append(args, verboseArgs()...)
 
 	if cm.user != "" {
 		args = append(args, "-l", cm.user)
 	}
 
-	args = append(args, host, command)
+	// Restricted shells get the command as typed, without the cd of :cd or the pid file of :kill
+	restricted := cm.shellOf(host) == shellRestricted
+	remote := command
+	if !restricted {
+		remote = cdPrefix(cm.workDir(host)) + command
+	}
+	remote = prepareCommand(remote)
+	if token := jobFrom(ctx); token != "" && !restricted {
+		remote = trackJob(remote, token)
+	}
+	args = append(args, "--", sshDestination(host), remote)
 	cmd := exec.CommandContext(ctx, "ssh", args...)
+	if backendOf(host) != BackendSSH || cm.isDirect(host) {
+		cmd = hostCommand(ctx, host, remote, cm.user, TTY)
+	}
+	cmd.Stdin = stdin
 
-	// Get stdout and stderr pipes
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
-		fmt.Printf("%s: ERROR: Failed to get stdout pipe: %v\n", prefix, err)
-		return
+	prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
+	start := time.Now()
+	err := streamCommand(ctx, cmd, host, prefix, command)
+
+	cm.mu.Lock()
+	if conn, exists := cm.connections[host]; exists {
+		conn.lastRun = time.Since(start)
 	}
-	stderr, err := cmd.StderrPipe()
+	cm.mu.Unlock()
+	return err
+}
+
+// use marks the connection to host as in use, re-establishing it first if it was closed for being idle.
+// If that fails, ssh connects without the control master and reports the problem itself.
+func (cm *SSHConnectionManager) use(ctx context.Context, host string) {
+	if cm.isIdle(host) {
+		// Concurrent commands wait for the first one to re-establish the connection
+		unlock := cm.lockHost(host)
+		cm.mu.Lock()
+		conn := cm.connections[host]
+		idle := conn != nil && conn.idle
+		cm.mu.Unlock()
+		if idle {
+			_ = cm.reestablish(ctx, host, conn)
+		}
+		unlock()
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if conn := cm.connections[host]; conn != nil {
+		conn.active++
+		conn.lastUsed = time.Now()
+	}
+}
+
+// release marks the end of a command started after use
+func (cm *SSHConnectionManager) release(host string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if conn := cm.connections[host]; conn != nil && conn.active > 0 {
+		conn.active--
+	}
+}
+
+// isIdle reports whether the connection to host was closed for being idle
+func (cm *SSHConnectionManager) isIdle(host string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	conn := cm.connections[host]
+	return conn != nil && conn.idle
+}
+
+// lockHost locks closing and re-establishing the control master of host and returns the unlock function
+func (cm *SSHConnectionManager) lockHost(host string) func() {
+	cm.mu.Lock()
+	if cm.hostLocks == nil {
+		cm.hostLocks = make(map[string]*sync.Mutex)
+	}
+	lock := cm.hostLocks[host]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		cm.hostLocks[host] = lock
+	}
+	cm.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// suspendIfIdle closes the control master of host if no command used it for IdleTimeout, keeping the host
+// in the session. It reports whether the host's connection is suspended.
+func (cm *SSHConnectionManager) suspendIfIdle(host string) bool {
+	unlock := cm.lockHost(host)
+	defer unlock()
+
+	cm.mu.Lock()
+	conn := cm.connections[host]
+	switch {
+	case conn == nil:
+		cm.mu.Unlock()
+		return false
+	case conn.idle:
+		cm.mu.Unlock()
+		return true
+	case IdleTimeout <= 0 || cm.direct[host] || conn.shared || conn.active > 0 || time.Since(conn.lastUsed) < IdleTimeout || cm.hasForwards(host):
+		cm.mu.Unlock()
+		return false
+	}
+	// Commands arriving from now on wait for the host's lock and re-establish the connection
+	conn.idle = true
+	socketPath := conn.socketPath
+	cm.mu.Unlock()
+
+	closeMaster(host, socketPath, false)
+	return true
+}
+
+// checkConnection reports whether the control master for a host is still alive
+func (cm *SSHConnectionManager) checkConnection(host string) bool {
+	if backendOf(host) != BackendSSH || cm.isDirect(host) {
+		return true // Nothing is kept open that could drop
+	}
+	// #nosec G204 - host parameter is controlled by our connection manager, not user input
+	cmd := exec.CommandContext(context.Background(), "ssh", "-S", cm.getSocketPath(host), "-O", "check", "--", sshDestination(host))
+	return cmd.Run() == nil
+}
+
+// reconnect tears down the control master of a host, if any, and establishes a new one with the same tunnels.
+// The host keeps its place in the session, marked down while that fails, so it can be retried.
+func (cm *SSHConnectionManager) reconnect(ctx context.Context, host string) error {
+	unlock := cm.lockHost(host)
+	defer unlock()
+
+	cm.mu.Lock()
+	conn := cm.connections[host]
+	if conn == nil {
+		cm.mu.Unlock()
+		return fmt.Errorf("%s: %w", host, errNotConnected)
+	}
+	conn.down = true
+	socketPath, direct, shared := conn.socketPath, cm.direct[host], conn.shared
+	cm.mu.Unlock()
+	if !shared {
+		closeMaster(host, socketPath, direct) // A dead master may leave its socket behind
+	}
+
+	return cm.reestablish(ctx, host, conn)
+}
+
+// reestablish opens a new control master for the connection conn of host and updates it in place, with the
+// same tunnels. A host removed from the session meanwhile is not brought back. The caller holds the lock of
+// the host.
+func (cm *SSHConnectionManager) reestablish(ctx context.Context, host string, conn *SSHConnection) error {
+	fresh, err := cm.connect(ctx, host)
 	if err != nil {
-		prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
-		fmt.Printf("%s: ERROR: Failed to get stderr pipe: %v\n", prefix, err)
-		return
+		return err
 	}
+	cm.mu.Lock()
+	if current := cm.connections[host]; current != conn {
+		direct := cm.direct[host]
+		if current == nil {
+			delete(cm.direct, host)
+		}
+		cm.mu.Unlock()
+		if current == nil && !fresh.shared {
+			closeMaster(host, fresh.socketPath, direct)
+		}
+		return fmt.Errorf("%s: %w", host, errNotConnected)
+	}
+	conn.socketPath, conn.connectedAt, conn.lastUsed = fresh.socketPath, fresh.connectedAt, fresh.lastUsed
+	conn.shell, conn.machineID, conn.shared = fresh.shell, fresh.machineID, fresh.shared
+	conn.idle, conn.down = false, false
+	cm.mu.Unlock()
 
-	prefix := formatHostPrefix(host, idx, maxHostLen, noColor)
+	cm.reopenForwards(ctx, host)
+	return nil
+}
 
-	// Start goroutines to read and display output in real-time
-	var wg sync.WaitGroup
-	// Handle stdout
-	wg.Go(func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
+// healthCheckInterval is how often the health monitor checks control sockets
+const healthCheckInterval = 30 * time.Second
+
+// maxReconnectBackoff caps the delay between reconnection attempts to an unreachable host
+const maxReconnectBackoff = 5 * time.Minute
+
+// startHealthMonitor periodically checks all control sockets and re-establishes dead ones with exponential backoff.
+// Connections unused for IdleTimeout are closed instead. Notices are written to out; the monitor stops when ctx is cancelled
+// and closes the returned channel once it has.
+func (cm *SSHConnectionManager) startHealthMonitor(ctx context.Context, interval time.Duration, out io.Writer) <-chan struct{} {
+	type hostHealth struct {
+		failures    int
+		nextAttempt time.Time
+	}
+	health := make(map[string]*hostHealth)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
 			select {
 			case <-ctx.Done():
 				return
-			default:
-				line := scanner.Text()
-				fmt.Printf("%s: %s\n", prefix, line)
+			case <-ticker.C:
 			}
-		}
-	})
 
-	// Handle stderr
-	wg.Go(func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				line := scanner.Text()
-				fmt.Printf("%s: %s\n", prefix, line)
+			cm.mu.Lock()
+			hosts := make([]string, 0, len(cm.connections))
+			for host := range cm.connections {
+				hosts = append(hosts, host)
+			}
+			cm.mu.Unlock()
+
+			for _, host := range hosts {
+				if ctx.Err() != nil {
+					return
+				}
+
+				cm.mu.Lock()
+				_, connected := cm.connections[host]
+				cm.mu.Unlock()
+				if !connected { // Removed from the session since the snapshot
+					delete(health, host)
+					continue
+				}
+				state := health[host]
+				if cm.suspendIfIdle(host) {
+					delete(health, host)
+					continue
+				}
+				if cm.checkConnection(host) {
+					delete(health, host)
+					continue
+				}
+				if state == nil {
+					state = &hostHealth{}
+					health[host] = state
+					fmt.Fprintf(out, "⚠️  %s connection lost, reconnecting...\n", host)
+				}
+				if time.Now().Before(state.nextAttempt) {
+					continue
+				}
+
+				err := cm.reconnect(ctx, host)
+				if errors.Is(err, errNotConnected) {
+					delete(health, host)
+					continue
+				}
+				if err != nil {
+					state.failures++
+					backoff := min(interval<<min(state.failures, 10), maxReconnectBackoff)
+					state.nextAttempt = time.Now().Add(backoff)
+					Log.Info("Reconnect failed", "host", host, "retry_in", backoff, "err", err)
+					continue
+				}
+
+				delete(health, host)
+				fmt.Fprintf(out, "↻ %s reconnected\n", host)
 			}
 		}
-	})
+	}()
+	return done
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("%s: ERROR: Failed to start command: %v\n", prefix, err)
-		return
+// printStatus checks the control socket of every host in parallel and prints a status table
+func (cm *SSHConnectionManager) printStatus(hosts []string) {
+	alive := make([]bool, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Go(func() {
+			alive[i] = cm.checkConnection(host)
+		})
 	}
+	wg.Wait()
 
-	// Wait for command to complete and output readers to finish
-	if err := cmd.Wait(); err != nil {
-		// Only show error if context wasn't cancelled
-		if ctx.Err() == nil {
-			fmt.Printf("%s: ERROR: Command failed: %v\n", prefix, err)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATE\tSHELL\tAGE\tLAST COMMAND")
+	cm.mu.Lock()
+	for i, host := range hosts {
+		state, shell, age, last := "stale", shellUnknown, "-", "-"
+		if alive[i] {
+			state = "alive"
 		}
+		if conn, exists := cm.connections[host]; exists {
+			if conn.idle {
+				state = "idle"
+			}
+			if conn.down {
+				state = "down"
+			}
+			if cm.direct[host] {
+				state = "direct"
+			}
+			shell = conn.shell
+			age = time.Since(conn.connectedAt).Truncate(time.Second).String()
+			if conn.lastRun > 0 {
+				last = conn.lastRun.Truncate(time.Millisecond).String()
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", host, state, shell, age, last)
 	}
-	wg.Wait()
+	cm.mu.Unlock()
+	_ = w.Flush()
 }
 
-// closeConnection closes a persistent SSH connection
+// closeConnection closes a persistent SSH connection; the caller holds cm.mu. Masters of other gosh
+// processes are left open for them.
 func (cm *SSHConnectionManager) closeConnection(host string) {
 	if conn, exists := cm.connections[host]; exists {
-		// Close the SSH control connection
-		// Note: We need to be careful with the host parameter, but since it's controlled by our code
-		// and stored in our connections map, it should be safe
+		if !conn.shared {
+			closeMaster(host, conn.socketPath, cm.direct[host])
+		}
+		delete(cm.connections, host)
+	}
+}
+
+// closeMaster ends the control master of host listening on socketPath, if any, and removes the socket.
+// Hosts reached directly or over other backends have no master.
+func closeMaster(host, socketPath string, direct bool) {
+	if backendOf(host) == BackendSSH && !direct {
 		// #nosec G204 - host parameter is controlled by our connection manager, not user input
-		cmd := exec.CommandContext(context.Background(), "ssh", "-S", conn.socketPath, "-O", "exit", host)
-		_ = cmd.Run() // Ignore errors, connection might already be closed
+		_ = exec.CommandContext(context.Background(), "ssh", "-S", socketPath, "-O", "exit", "--", sshDestination(host)).Run()
+	}
+	_ = os.Remove(socketPath) // Ignore errors, the master may remove it itself or be gone already
+}
 
-		// Remove socket file
-		_ = os.Remove(conn.socketPath)
+// disconnect closes the persistent connection to a single host
+func (cm *SSHConnectionManager) disconnect(host string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 
-		delete(cm.connections, host)
-	}
+	cm.closeConnection(host)
 }
 
 // closeAllConnections closes all persistent SSH connections
@@ -196,6 +1104,6 @@ func (cm *SSHConnectionManager) closeAllConnections() {
 		cm.closeConnection(host)
 	}
 
-	// Remove socket directory
-	_ = os.RemoveAll(cm.socketDir)
+	// Remove socket directory if nothing else lives in it
+	_ = os.Remove(cm.socketDir)
 }
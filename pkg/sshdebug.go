@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+)
+
+// SSHVerbosity passes -v this many times to ssh and scp. Their debug output, e.g. of the handshake, is
+// printed with the host prefix on stderr, apart from the output of commands.
+var SSHVerbosity int
+
+// sshDebugOut receives the debug output of ssh; sshDebugMu keeps lines of different hosts apart
+var (
+	sshDebugOut io.Writer = os.Stderr
+	sshDebugMu  sync.Mutex
+)
+
+// sshDebugPrefixes start the lines ssh writes itself at increased verbosity
+var sshDebugPrefixes = [][]byte{
+	[]byte("debug1: "), []byte("debug2: "), []byte("debug3: "), []byte("OpenSSH_"),
+	[]byte("Authenticated to "), []byte("Transferred: "), []byte("Bytes per second: "),
+}
+
+// verboseArgs returns -v once per SSHVerbosity
+func verboseArgs() []string {
+	return slices.Repeat([]string{"-v"}, SSHVerbosity)
+}
+
+// isSSHDebugLine reports whether a stderr line is ssh's own debug output rather than output of the remote
+// command. Without SSHVerbosity there is none, so commands printing such lines are left alone.
+func isSSHDebugLine(line []byte) bool {
+	return SSHVerbosity > 0 && slices.ContainsFunc(sshDebugPrefixes, func(prefix []byte) bool {
+		return bytes.HasPrefix(line, prefix)
+	})
+}
+
+// writeSSHDebug prints a debug line of ssh with the host prefix on stderr
+func writeSSHDebug(prefix string, line []byte) {
+	sshDebugMu.Lock()
+	defer sshDebugMu.Unlock()
+	fmt.Fprintf(sshDebugOut, "%s: %s\n", prefix, bytes.TrimSuffix(line, []byte("\r")))
+}
+
+// splitSSHDebug prints the debug lines of ssh output with the host prefix and returns the other lines
+func splitSSHDebug(prefix string, output []byte) []byte {
+	if SSHVerbosity == 0 {
+		return output
+	}
+	var rest bytes.Buffer
+	for line := range bytes.Lines(output) {
+		if isSSHDebugLine(line) {
+			writeSSHDebug(prefix, bytes.TrimRight(line, "\r\n"))
+		} else {
+			rest.Write(line)
+		}
+	}
+	return rest.Bytes()
+}
+
+// captureStderr sends the stderr of cmd to a temporary file and returns a function reading what was written
+// to it, which also removes the file. Unlike a pipe, a file doesn't keep Run waiting for an ssh -f master
+// that inherited it; the master only writes to the removed file afterwards.
+func captureStderr(cmd *exec.Cmd) (read func() []byte) {
+	file, err := os.CreateTemp("", "gosh-ssh-*.log")
+	if err != nil {
+		return func() []byte { return nil }
+	}
+	cmd.Stderr = file
+	return func() []byte {
+		defer os.Remove(file.Name())
+		defer file.Close()
+		output, _ := os.ReadFile(file.Name())
+		return output
+	}
+}
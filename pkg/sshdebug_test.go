@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestSSHDebugOutput(t *testing.T) {
+	var debug bytes.Buffer
+	sshDebugOut = &debug
+	defer func() { sshDebugOut, SSHVerbosity = os.Stderr, 0 }()
+
+	output := []byte("OpenSSH_9.6p1, OpenSSL 3.0.13\ndebug1: Connecting to web1 port 22.\nscp: /etc/shadow: Permission denied\n")
+	if rest := splitSSHDebug("web1", output); !bytes.Equal(rest, output) {
+		t.Errorf("expected output to be left alone without verbosity, got %q", rest)
+	}
+	if isSSHDebugLine([]byte("debug1: from a remote program")) {
+		t.Error("expected no debug lines without verbosity")
+	}
+
+	SSHVerbosity = 2
+	if args := verboseArgs(); !slices.Equal(args, []string{"-v", "-v"}) {
+		t.Errorf("expected -v twice, got %q", args)
+	}
+	if !slices.Contains(extraSSHOptions(), "-v") {
+		t.Error("expected new connections to pass -v")
+	}
+	if rest := splitSSHDebug("web1", output); string(rest) != "scp: /etc/shadow: Permission denied\n" {
+		t.Errorf("expected only the error to remain, got %q", rest)
+	}
+	expected := "web1: OpenSSH_9.6p1, OpenSSL 3.0.13\nweb1: debug1: Connecting to web1 port 22.\n"
+	if debug.String() != expected {
+		t.Errorf("expected prefixed debug lines %q, got %q", expected, debug.String())
+	}
+
+	// Streamed commands keep their output apart from the debug lines
+	debug.Reset()
+	stdout := captureStdout(t, func() {
+		cmd := exec.CommandContext(context.Background(), "sh", "-c", "echo 'debug1: Sending command: uptime' >&2; echo up")
+		_ = streamCommand(context.Background(), cmd, "web2", "web2", "uptime")
+	})
+	if stdout != "web2: up\n" || debug.String() != "web2: debug1: Sending command: uptime\n" {
+		t.Errorf("expected the debug line on stderr, got stdout %q and stderr %q", stdout, debug.String())
+	}
+}
+
+func TestCaptureStderr(t *testing.T) {
+	cmd := exec.CommandContext(context.Background(), "sh", "-c", "echo debug1: hello >&2; (sleep 5 >&2 &)")
+	read := captureStderr(cmd)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if output := read(); string(output) != "debug1: hello\n" {
+		t.Errorf("expected the captured stderr, got %q", output)
+	}
+}
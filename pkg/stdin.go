@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/chzyer/readline"
+)
+
+// Stdin is duplicated to the remote command of every host when set, e.g. for `cat dump.sql | gosh -c psql db1 db2`
+var Stdin io.Reader
+
+// PipedStdin returns the local stdin when it is redirected from a file or pipe, nil for a terminal
+func PipedStdin() io.Reader {
+	if readline.IsTerminal(int(os.Stdin.Fd())) { // #nosec G115 -- file descriptors fit in int
+		return nil
+	}
+	return os.Stdin
+}
+
+// stdinSpool duplicates one stream to any number of readers. Input is appended to a temporary file and
+// every reader follows it at its own pace, so a slow host or one still waiting for a file descriptor
+// never stalls the others, and streams larger than memory still work.
+type stdinSpool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	file *os.File
+	size int64 // Bytes written to file so far
+	done bool  // Source exhausted
+	err  error // Source error other than io.EOF
+}
+
+// newStdinSpool creates a spool backed by a temporary file
+func newStdinSpool() (*stdinSpool, error) {
+	file, err := os.CreateTemp("", "gosh-stdin-")
+	if err != nil {
+		return nil, err
+	}
+	s := &stdinSpool{file: file}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// run copies src into the spool until it is exhausted
+func (s *stdinSpool) run(src io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := s.file.WriteAt(buf[:n], s.size); werr != nil {
+				err = werr
+			} else {
+				s.mu.Lock()
+				s.size += int64(n)
+				s.cond.Broadcast()
+				s.mu.Unlock()
+			}
+		}
+		if err != nil {
+			s.mu.Lock()
+			s.done = true
+			if err != io.EOF {
+				s.err = err
+			}
+			s.cond.Broadcast()
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// reader returns a new reader that starts at the beginning of the stream
+func (s *stdinSpool) reader() io.Reader {
+	return &spoolReader{spool: s}
+}
+
+// close removes the temporary file; readers must be done by then
+func (s *stdinSpool) close() {
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+}
+
+// spoolReader reads a stdinSpool from its own offset, waiting for more input as needed
+type spoolReader struct {
+	spool  *stdinSpool
+	offset int64
+}
+
+// Read implements io.Reader
+func (r *spoolReader) Read(p []byte) (int, error) {
+	s := r.spool
+	s.mu.Lock()
+	for r.offset >= s.size && !s.done {
+		s.cond.Wait()
+	}
+	available := s.size - r.offset
+	done, err := s.done, s.err
+	s.mu.Unlock()
+
+	if available == 0 {
+		if done && err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+
+	n, err := s.file.ReadAt(p[:min(int64(len(p)), available)], r.offset)
+	r.offset += int64(n)
+	if err == io.EOF {
+		err = nil // The spool may still grow
+	}
+	return n, err
+}
+
+// copyStdin feeds source into a command's stdin pipe and closes it at the end of input
+func copyStdin(stdin io.WriteCloser, source io.Reader) {
+	_, _ = io.Copy(stdin, source)
+	_ = stdin.Close()
+}
+
+// terminalInput reads the terminal for readline and lets gosh inject keys, which are delivered even while
+// readline is blocked waiting for the user. readline's own WriteStdin only takes effect after the next key press.
+type terminalInput struct {
+	mu      sync.Mutex
+	closed  bool // The terminal reached EOF, nothing more is delivered
+	ch      chan []byte
+	pending []byte
+	err     error
+}
+
+// newTerminalInput starts reading src in the background
+func newTerminalInput(src io.Reader) *terminalInput {
+	t := &terminalInput{ch: make(chan []byte, 16)}
+	go func() {
+		for {
+			buf := make([]byte, 256)
+			n, err := src.Read(buf)
+			if n > 0 {
+				t.ch <- buf[:n]
+			}
+			if err != nil {
+				t.mu.Lock()
+				t.err = err
+				t.closed = true
+				close(t.ch)
+				t.mu.Unlock()
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Read implements io.Reader
+func (t *terminalInput) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 {
+		data, ok := <-t.ch
+		if !ok {
+			return 0, t.err
+		}
+		t.pending = data
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// inject queues keys as if they were typed and reports whether they will be delivered
+func (t *terminalInput) inject(keys []byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.ch <- keys
+	return true
+}
+
+// isClosed reports whether the terminal reached EOF
+func (t *terminalInput) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
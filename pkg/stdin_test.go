@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStdinSpool(t *testing.T) {
+	spool, err := newStdinSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.close()
+
+	data := strings.Repeat("line\n", 50000)
+	src, w := io.Pipe()
+	go spool.run(src)
+
+	// Readers follow the stream at their own pace, including ones that start late
+	results := make([]string, 3)
+	var wg sync.WaitGroup
+	for i := range 2 {
+		reader := spool.reader()
+		wg.Go(func() {
+			out, _ := io.ReadAll(reader)
+			results[i] = string(out)
+		})
+	}
+	_, _ = io.WriteString(w, data)
+	_ = w.Close()
+	wg.Wait()
+
+	out, _ := io.ReadAll(spool.reader())
+	results[2] = string(out)
+
+	for i, result := range results {
+		if result != data {
+			t.Errorf("reader %d got %d bytes, want %d", i, len(result), len(data))
+		}
+	}
+}
+
+func TestStreamCommandStdin(t *testing.T) {
+	spool, err := newStdinSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.close()
+	src, w := io.Pipe()
+	go spool.run(src)
+	_, _ = io.WriteString(w, "hello\n")
+
+	output := captureStdout(t, func() {
+		// Commands that ignore stdin finish without waiting for the end of input
+		start := time.Now()
+		cmd := exec.CommandContext(context.Background(), "true")
+		cmd.Stdin = spool.reader()
+		_ = streamCommand(context.Background(), cmd, "web2", "web2", "true")
+		if time.Since(start) > 2*time.Second {
+			t.Error("command ignoring stdin took too long")
+		}
+
+		_ = w.Close()
+		cmd = exec.CommandContext(context.Background(), "cat")
+		cmd.Stdin = spool.reader()
+		_ = streamCommand(context.Background(), cmd, "web1", "web1", "cat")
+	})
+	if output != "web1: hello\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
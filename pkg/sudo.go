@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"errors"
+
+	"github.com/chzyer/readline"
+)
+
+// Sudo runs remote commands through sudo, feeding the password on stdin
+var Sudo bool
+
+// BecomeUser runs remote commands as this user via sudo, empty to run as the login user
+var BecomeUser string
+
+// sudoPassword is prompted once and written to each host's stdin; it never appears on a command line
+var sudoPassword []byte
+
+// sudoPrompt is shown when asking for the sudo password
+const sudoPrompt = "🔑 [sudo] password: "
+
+// PromptSudoPassword asks for the sudo password on the terminal without echoing it
+func PromptSudoPassword() error {
+	password, err := readline.Password(sudoPrompt)
+	if err != nil {
+		return err
+	}
+	return setSudoPassword(password)
+}
+
+// setSudoPassword stores the password sent to sudo on every host
+func setSudoPassword(password []byte) error {
+	if len(password) == 0 {
+		return errors.New("empty sudo password")
+	}
+	sudoPassword = password
+	return nil
+}
+
+// wrapSudo runs command through sudo, as user when set. With password set the password is read from stdin
+// without a prompt and the command's own stdin is detached, so it never sees the password when sudo had
+// cached credentials. Otherwise sudo must not need a password and stdin passes through.
+func wrapSudo(command, user string, password bool) string {
+	args := "sudo -n"
+	if password {
+		args = "sudo -S -p ''"
+		command = "exec </dev/null; " + command
+	}
+	if user != "" {
+		args += " -u " + shellQuote(user)
+	}
+	// sh is there on every host, and service accounts often have no login shell or bash at all
+	return args + " -- sh -c " + shellQuote(command)
+}
+
+// sudoInput returns the data written to a remote command's stdin, nil when sudo is off
+func sudoInput() []byte {
+	if !Sudo {
+		return nil
+	}
+	return passwordLine(sudoPassword)
+}
+
+// passwordLine returns password as a line for sudo to read, nil without a password
+func passwordLine(password []byte) []byte {
+	if password == nil {
+		return nil
+	}
+	return append(append([]byte(nil), password...), '\n')
+}
@@ -0,0 +1,219 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// HostTags maps host names or glob patterns to their tags, e.g. "web*" to role=web. Hosts are targeted by
+// their tags with @key=value wherever commands take hosts, like :on @role=web uptime.
+var HostTags = map[string]map[string]string{}
+
+// settingTags are the tags of --tag settings, from the command line or config file. They are not written to
+// TagsFile, and :tag overrides them.
+var settingTags = map[string]map[string]string{}
+
+// TagsFile is where :tag and :untag persist changes, empty keeps them for the session only
+var TagsFile string
+
+// DefaultTagsFile returns the default location of the tags file
+func DefaultTagsFile() string {
+	return filepath.Join(homeDir(), ".gosh", "tags")
+}
+
+// LoadTags reads tags of the form "host: key=value ...", where host may be a glob pattern; lines starting
+// with # are comments. A missing file yields no tags.
+func LoadTags(path string) (map[string]map[string]string, error) {
+	tags := make(map[string]map[string]string)
+
+	file, err := os.Open(path) // #nosec G304 -- tags file path is chosen by the local user
+	if errors.Is(err, os.ErrNotExist) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tags file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		host, definitions, _ := strings.Cut(line, ":")
+		host = strings.TrimSpace(host)
+		if err := addTags(tags, host, strings.Fields(definitions)); host == "" || err != nil {
+			return nil, fmt.Errorf("%s:%d: expected \"host: key=value ...\"", path, lineNo)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tags file %s: %w", path, err)
+	}
+
+	return tags, nil
+}
+
+// SaveTags writes tags sorted by host and key
+func SaveTags(path string, tags map[string]map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	for _, host := range slices.Sorted(maps.Keys(tags)) {
+		if len(tags[host]) > 0 {
+			sb.WriteString(host + ": " + formatTags(tags[host]) + "\n")
+		}
+	}
+
+	return os.WriteFile(path, []byte(sb.String()), 0o600)
+}
+
+// AddTag applies a --tag setting "host:key=value" for this run; host may be a glob pattern
+func AddTag(setting string) error {
+	host, tag, found := strings.Cut(setting, ":")
+	if !found || host == "" {
+		return fmt.Errorf("expected host:key=value, got %q", setting)
+	}
+	return addTags(settingTags, host, []string{tag})
+}
+
+// addTags adds "key=value" definitions to the tags of host
+func addTags(tags map[string]map[string]string, host string, definitions []string) error {
+	for _, definition := range definitions {
+		key, value, err := parseTag(definition)
+		if err != nil {
+			return err
+		}
+		if tags[host] == nil {
+			tags[host] = make(map[string]string)
+		}
+		tags[host][key] = value
+	}
+	return nil
+}
+
+// parseTag splits a "key=value" tag; keys are alias-like names
+func parseTag(definition string) (key, value string, err error) {
+	key, value, found := strings.Cut(definition, "=")
+	if !found || !isAliasName(key) || value == "" {
+		return "", "", fmt.Errorf("invalid tag %q, expected key=value", definition)
+	}
+	return key, value, nil
+}
+
+// formatTags returns tags as "key=value" pairs sorted by key
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, key+"="+tags[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// tagsOf returns the tags of host: those of the patterns it matches in sorted order, then its own, which
+// take precedence. Tags of the tags file take precedence over --tag settings.
+func tagsOf(host string) map[string]string {
+	tags := make(map[string]string)
+	for _, source := range []map[string]map[string]string{settingTags, HostTags} {
+		for _, pattern := range slices.Sorted(maps.Keys(source)) {
+			if ok, _ := path.Match(pattern, host); ok && pattern != host {
+				maps.Copy(tags, source[pattern])
+			}
+		}
+		maps.Copy(tags, source[host])
+	}
+	return tags
+}
+
+// hasTag reports whether host has the tag of a selector without its "@": "key=value", where value may be
+// a glob pattern, or just "key" for any value
+func hasTag(host, selector string) bool {
+	key, pattern, withValue := strings.Cut(selector, "=")
+	value, ok := tagsOf(host)[key]
+	if !ok || !withValue {
+		return ok
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched || pattern == value
+}
+
+// editTags handles ":tag [hosts key=value...]" and ":untag <hosts> <key>...": without arguments it lists
+// the tags of the connected hosts, otherwise it changes those of the matching hosts and persists them
+func (s *session) editTags(args string, remove bool) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0 && !remove:
+		s.listTags()
+		return
+	case len(fields) < 2 && remove:
+		fmt.Println("🏷️  Usage: :untag <hosts> <key>...")
+		return
+	case len(fields) < 2:
+		fmt.Println("🏷️  Usage: :tag <hosts> key=value...")
+		return
+	}
+
+	hosts := s.targetHostsOf(matchHosts(s.hosts, fields[0]))
+	if len(hosts) == 0 {
+		fmt.Printf("⚠️  No connected hosts match %q\n", fields[0])
+		return
+	}
+	for _, definition := range fields[1:] {
+		if _, _, err := parseTag(definition); err != nil && !remove {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+	}
+	for _, host := range hosts {
+		if !remove {
+			_ = addTags(HostTags, host, fields[1:])
+			continue
+		}
+		for _, key := range fields[1:] {
+			delete(HostTags[host], key)
+			if value, inherited := tagsOf(host)[key]; inherited {
+				fmt.Printf("⚠️  %s keeps %s=%s from a host pattern or --tag\n", host, key, value)
+			}
+		}
+		if len(HostTags[host]) == 0 {
+			delete(HostTags, host)
+		}
+	}
+	if remove {
+		fmt.Printf("🏷️  Untagged %d host(s)\n", len(hosts))
+	} else {
+		fmt.Printf("🏷️  Tagged %d host(s)\n", len(hosts))
+	}
+
+	if TagsFile != "" {
+		if err := SaveTags(TagsFile, HostTags); err != nil {
+			fmt.Printf("❌ Error: failed to save tags: %v\n", err)
+		}
+	}
+}
+
+// listTags prints the tags of the connected hosts
+func (s *session) listTags() {
+	tagged := 0
+	for _, host := range s.hosts {
+		if tags := tagsOf(host); len(tags) > 0 {
+			fmt.Printf("  %s: %s\n", host, formatTags(tags))
+			tagged++
+		}
+	}
+	if tagged == 0 {
+		fmt.Println("🏷️  No connected host is tagged; tag one with :tag <hosts> key=value")
+	}
+}
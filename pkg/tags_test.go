@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadAndSaveTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags")
+	content := "# Roles\nweb*: role=web zone=a\nweb2: zone=b\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := LoadTags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags["web*"]["role"] != "web" || tags["web2"]["zone"] != "b" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	if err := SaveTags(path, tags); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(path)
+	if string(saved) != "web*: role=web zone=a\nweb2: zone=b\n" {
+		t.Errorf("expected the tags sorted by host and key, got %q", saved)
+	}
+
+	if err := os.WriteFile(path, []byte("web1: role\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTags(path); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("expected an error for line 1, got %v", err)
+	}
+	if tags, err := LoadTags(filepath.Join(t.TempDir(), "missing")); err != nil || len(tags) != 0 {
+		t.Errorf("expected no tags without a file, got %v (%v)", tags, err)
+	}
+}
+
+func TestMatchHostsByTag(t *testing.T) {
+	defer func() { HostTags, settingTags = map[string]map[string]string{}, map[string]map[string]string{} }()
+	HostTags = map[string]map[string]string{
+		"web*": {"role": "web", "zone": "a"},
+		"web2": {"zone": "b"},
+		"db1":  {"role": "db"},
+	}
+	if err := AddTag("db*:zone=c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTag("role=web"); err == nil {
+		t.Error("expected a setting without host to fail")
+	}
+
+	hosts := []string{"web1", "web2", "db1", "cache1"}
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"@role=web", []string{"web1", "web2"}},
+		{"@zone=a", []string{"web1"}},
+		{"@zone=[bc]", []string{"web2", "db1"}},
+		{"@role", []string{"web1", "web2", "db1"}},
+		{"@role=db,cache*", []string{"db1", "cache1"}},
+		{"@team", nil},
+	}
+	for _, test := range tests {
+		matched := matchHosts(hosts, test.pattern)
+		if len(matched) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.pattern, test.expected, matched)
+		}
+		for _, host := range test.expected {
+			if !matched[host] {
+				t.Errorf("%s: expected %s to match", test.pattern, host)
+			}
+		}
+	}
+}
+
+func TestSessionTags(t *testing.T) {
+	defer func() { HostTags, TagsFile = map[string]map[string]string{}, "" }()
+	HostTags = map[string]map[string]string{"web*": {"role": "web"}}
+	TagsFile = filepath.Join(t.TempDir(), "tags")
+	sess := &session{hosts: []string{"web1", "web2", "db1"}}
+
+	if output := captureStdout(t, func() { sess.editTags(" db1 role", false) }); !strings.Contains(output, "invalid tag") {
+		t.Errorf("expected a tag without value to be refused, got %q", output)
+	}
+	if output := captureStdout(t, func() { sess.editTags(" @role=web,db1 zone=a", false) }); !strings.Contains(output, "Tagged 3 host(s)") {
+		t.Errorf("expected 3 hosts to be tagged, got %q", output)
+	}
+	if output := captureStdout(t, func() { sess.editTags("", false) }); !strings.Contains(output, "web1: role=web zone=a") || !strings.Contains(output, "db1: zone=a") {
+		t.Errorf("expected the tags to be listed, got %q", output)
+	}
+
+	// Tags of patterns stay, as they are not the host's own
+	output := captureStdout(t, func() { sess.editTags(" web1 role zone", true) })
+	if !strings.Contains(output, "web1 keeps role=web") || tagsOf("web1")["zone"] != "" {
+		t.Errorf("expected the own tag to be removed, got %q", output)
+	}
+
+	saved, err := LoadTags(TagsFile)
+	if err != nil || saved["db1"]["zone"] != "a" || saved["web2"]["zone"] != "a" || saved["web1"] != nil {
+		t.Errorf("expected the changes to be saved, got %v (%v)", saved, err)
+	}
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultTailLines is the number of existing lines :tail shows before following
+const defaultTailLines = 10
+
+// tailCommand builds the remote command for ":tail [-n N] <file>...". tail -F follows the file name,
+// so it reopens logs that are rotated or recreated instead of following the old file.
+func tailCommand(args string) (string, error) {
+	fields := strings.Fields(args)
+	lines := defaultTailLines
+	if len(fields) > 0 && fields[0] == "-n" {
+		if len(fields) < 2 {
+			return "", errors.New("-n needs a number of lines")
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("invalid line count %q", fields[1])
+		}
+		lines, fields = n, fields[2:]
+	}
+	if len(fields) == 0 {
+		return "", errors.New("no file to follow")
+	}
+
+	command := "tail -n " + strconv.Itoa(lines) + " -F --"
+	for _, file := range fields {
+		command += " " + quoteRemotePath(file)
+	}
+	return command, nil
+}
+
+// tail follows log files on the targeted hosts with host prefixes until Ctrl+C
+func (s *session) tail(args string) {
+	if args == "" {
+		fmt.Println("📜 Usage: :tail [-n N] <file>...")
+		return
+	}
+	command, err := tailCommand(args)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	fmt.Printf("📜 Following on %d host(s), Ctrl+C to stop\n", len(s.targetHosts()))
+	s.run(command, s.selected, nil)
+}
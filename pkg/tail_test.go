@@ -0,0 +1,22 @@
+package pkg
+
+import "testing"
+
+func TestTailCommand(t *testing.T) {
+	tests := []struct {
+		args, want string
+		wantErr    bool
+	}{
+		{"/var/log/app.log", "tail -n 10 -F -- '/var/log/app.log'", false},
+		{"-n 100 app.log ~/logs/err.log", `tail -n 100 -F -- 'app.log' "$HOME"/'logs/err.log'`, false},
+		{"-n", "", true},
+		{"-n many app.log", "", true},
+		{"-n 5", "", true},
+	}
+	for _, tt := range tests {
+		got, err := tailCommand(tt.args)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("tailCommand(%q) = %q, %v", tt.args, got, err)
+		}
+	}
+}
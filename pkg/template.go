@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// hostPlaceholders lists the placeholders expanded per host in commands
+var hostPlaceholders = []commandInfo{
+	{"{host}", "", "Placeholder: the host name as given"},
+	{"{shorthost}", "", "Placeholder: the host name up to the first dot"},
+	{"{index}", "", "Placeholder: the host's position in the host list, starting at 0"},
+}
+
+func init() {
+	paletteSources = append(paletteSources, func() []commandInfo { return hostPlaceholders })
+}
+
+// shortHost returns host up to the first dot; IP addresses are returned unchanged
+func shortHost(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	short, _, _ := strings.Cut(host, ".")
+	return short
+}
+
+// expandHostTemplate replaces {host}, {shorthost} and {index} in command for one host
+func expandHostTemplate(command, host string, index int) string {
+	if !strings.Contains(command, "{") {
+		return command
+	}
+	return strings.NewReplacer(
+		"{host}", host,
+		"{shorthost}", shortHost(host),
+		"{index}", strconv.Itoa(index),
+	).Replace(command)
+}
@@ -0,0 +1,23 @@
+package pkg
+
+import "testing"
+
+func TestExpandHostTemplate(t *testing.T) {
+	tests := []struct {
+		command, host string
+		index         int
+		want          string
+	}{
+		{"echo {host} > /etc/nodename", "web1.prod.example.com", 0, "echo web1.prod.example.com > /etc/nodename"},
+		{"hostnamectl set-hostname {shorthost}-{index}", "web1.prod.example.com", 3, "hostnamectl set-hostname web1-3"},
+		{"echo {shorthost}", "10.0.0.5", 1, "echo 10.0.0.5"},
+		{"awk '{print $1}' /etc/hosts", "web1", 0, "awk '{print $1}' /etc/hosts"},
+		{"uptime", "web1", 0, "uptime"},
+	}
+
+	for _, tt := range tests {
+		if got := expandHostTemplate(tt.command, tt.host, tt.index); got != tt.want {
+			t.Errorf("expandHostTemplate(%q, %q, %d) = %q, want %q", tt.command, tt.host, tt.index, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// tmuxSSHCommand returns the shell command a pane runs to log into host interactively
+func tmuxSSHCommand(host, user string) string {
+	switch backendOf(host) {
+	case BackendDocker:
+		return "docker " + shellJoin(dockerShellArgs(host, user))
+	case BackendKubectl:
+		return "kubectl " + shellJoin(kubectlShellArgs(host))
+	case BackendLocal:
+		return `cd && exec "${SHELL:-sh}"`
+	}
+
+	words := []string{"ssh"}
+	for _, arg := range extraSSHOptions() {
+		words = append(words, shellQuote(arg))
+	}
+	if user != "" {
+		words = append(words, "-l", shellQuote(user))
+	}
+	return strings.Join(append(words, "--", shellQuote(sshDestination(host))), " ")
+}
+
+// tmuxCommands returns the tmux invocations that build session: one pane per host running ssh, tiled,
+// titled with the host name and with synchronized input
+func tmuxCommands(session string, hosts []string, user string) [][]string {
+	commands := [][]string{
+		{"new-session", "-d", "-s", session, "-n", "gosh", tmuxSSHCommand(hosts[0], user)},
+		{"select-pane", "-t", session, "-T", hosts[0]},
+	}
+	for _, host := range hosts[1:] {
+		// Re-tiling after every split keeps room for the next pane
+		commands = append(commands,
+			[]string{"split-window", "-t", session, tmuxSSHCommand(host, user)},
+			[]string{"select-pane", "-t", session, "-T", host},
+			[]string{"select-layout", "-t", session, "tiled"},
+		)
+	}
+	return append(commands,
+		[]string{"set-option", "-t", session, "pane-border-status", "top"},
+		[]string{"set-option", "-t", session, "pane-border-format", " #{pane_title} "},
+		[]string{"set-window-option", "-t", session, "synchronize-panes", "on"},
+	)
+}
+
+// Tmux opens a tmux session with one ssh pane per host and typing synchronized across all panes,
+// then attaches to it, or switches to it when already inside tmux. Toggle the synchronization with
+// ":setw synchronize-panes" in tmux.
+func Tmux(ctx context.Context, hosts []string, user string) error {
+	if len(hosts) == 0 {
+		return errors.New("no hosts given")
+	}
+	if Restricted() {
+		return fmt.Errorf("%w: tmux opens shells, which an allow list can't restrict", ErrRestricted)
+	}
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return errors.New("tmux is not installed")
+	}
+
+	session := fmt.Sprintf("gosh-%d", os.Getpid())
+	for _, args := range tmuxCommands(session, hosts, user) {
+		if output, err := exec.CommandContext(ctx, "tmux", args...).CombinedOutput(); err != nil {
+			_ = exec.CommandContext(context.Background(), "tmux", "kill-session", "-t", session).Run()
+			return fmt.Errorf("tmux %s: %s", args[0], strings.TrimSpace(string(output)))
+		}
+	}
+
+	attach := "attach-session"
+	if os.Getenv("TMUX") != "" {
+		attach = "switch-client"
+	}
+	cmd := exec.CommandContext(ctx, "tmux", attach, "-t", session)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,26 @@
+package pkg
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTmuxCommands(t *testing.T) {
+	SSHOptions = []string{"ConnectTimeout=5"}
+	defer func() { SSHOptions = nil }()
+
+	commands := tmuxCommands("gosh-1", []string{"web1", "web2"}, "deploy")
+
+	first := commands[0]
+	if first[0] != "new-session" || first[len(first)-1] != "ssh '-o' 'ConnectTimeout=5' -l 'deploy' -- 'web1'" {
+		t.Errorf("unexpected first command: %q", first)
+	}
+	if !slices.ContainsFunc(commands, func(c []string) bool {
+		return c[0] == "split-window" && c[len(c)-1] == "ssh '-o' 'ConnectTimeout=5' -l 'deploy' -- 'web2'"
+	}) {
+		t.Errorf("expected a pane for web2, got %q", commands)
+	}
+	if last := commands[len(commands)-1]; !slices.Equal(last, []string{"set-window-option", "-t", "gosh-1", "synchronize-panes", "on"}) {
+		t.Errorf("expected synchronized panes, got %q", last)
+	}
+}
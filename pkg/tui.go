@@ -0,0 +1,453 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+)
+
+const (
+	enterAltScreen = "\033[?1049h\033[H"
+	leaveAltScreen = "\033[?1049l"
+
+	// tuiScrollback is the number of output lines kept per host for the zoomed view
+	tuiScrollback = 1000
+	// tuiRefresh redraws running durations and picks up terminal resizes
+	tuiRefresh = 500 * time.Millisecond
+)
+
+// paneStatus is the state of the last command on a host
+type paneStatus int
+
+const (
+	paneIdle paneStatus = iota
+	paneRunning
+	paneOK
+	paneFailed
+	paneInterrupted
+)
+
+// hostPane holds the dashboard state of one host
+type hostPane struct {
+	host     string
+	status   paneStatus
+	exitCode int
+	started  time.Time
+	duration time.Duration
+	lines    []string
+}
+
+// statusText describes the pane's state in the host list
+func (p *hostPane) statusText(now time.Time) string {
+	switch p.status {
+	case paneRunning:
+		return "⏳ " + now.Sub(p.started).Round(100*time.Millisecond).String()
+	case paneOK:
+		return "✅ " + p.duration.Round(time.Millisecond).String()
+	case paneFailed:
+		return fmt.Sprintf("❌ exit %d", p.exitCode)
+	case paneInterrupted:
+		return "🛑 stopped"
+	default:
+		return "·"
+	}
+}
+
+// dashboard is the full-screen --tui view: one row per host with its status and last line, a command
+// input and a zoomed view of a single host's output. It receives the output as the OutputSink.
+type dashboard struct {
+	mu       sync.Mutex
+	panes    []*hostPane
+	byHost   map[string]*hostPane
+	noColor  bool
+	selected int
+	top      int // First host row on screen
+	zoomed   bool
+	input    []rune
+	command  string
+	cancel   context.CancelFunc // Interrupts the running command, nil when idle
+	redraw   chan struct{}
+}
+
+// newDashboard returns an idle dashboard for hosts
+func newDashboard(hosts []string, noColor bool) *dashboard {
+	d := &dashboard{byHost: make(map[string]*hostPane, len(hosts)), noColor: noColor, redraw: make(chan struct{}, 1)}
+	for _, host := range hosts {
+		pane := &hostPane{host: host}
+		d.panes = append(d.panes, pane)
+		d.byHost[host] = pane
+	}
+	return d
+}
+
+// notify requests a redraw without blocking; bursts of lines collapse into one
+func (d *dashboard) notify() {
+	select {
+	case d.redraw <- struct{}{}:
+	default:
+	}
+}
+
+// WriteLine keeps the line in the host's scrollback
+func (d *dashboard) WriteLine(host string, _ Stream, line []byte) {
+	d.mu.Lock()
+	if pane := d.byHost[host]; pane != nil {
+		if len(pane.lines) == tuiScrollback {
+			pane.lines = pane.lines[1:]
+		}
+		pane.lines = append(pane.lines, string(line))
+	}
+	d.mu.Unlock()
+	d.notify()
+}
+
+// Finish records the outcome of the command on the host
+func (d *dashboard) Finish(result Result) {
+	d.mu.Lock()
+	if pane := d.byHost[result.Host]; pane != nil {
+		pane.exitCode, pane.duration = result.ExitCode, result.Duration
+		switch {
+		case result.Err == nil:
+			pane.status = paneOK
+		case errors.Is(result.Err, context.Canceled):
+			pane.status = paneInterrupted
+		default:
+			pane.status = paneFailed
+		}
+	}
+	d.mu.Unlock()
+	d.notify()
+}
+
+// tuiAction is what the TUI loop should do after a key press
+type tuiAction int
+
+const (
+	tuiNone tuiAction = iota
+	tuiRun
+	tuiInterrupt
+	tuiQuit
+)
+
+// handleKey applies a key from readKeys and returns the resulting action; tuiRun comes with the command
+func (d *dashboard) handleKey(key string) (tuiAction, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch key {
+	case "ctrl-c":
+		if d.cancel != nil {
+			return tuiInterrupt, ""
+		}
+		return tuiQuit, ""
+	case "ctrl-d":
+		if len(d.input) == 0 && d.cancel == nil {
+			return tuiQuit, ""
+		}
+	case "up":
+		d.selected = max(d.selected-1, 0)
+	case "down":
+		d.selected = min(d.selected+1, len(d.panes)-1)
+	case "pgup":
+		d.selected = max(d.selected-10, 0)
+	case "pgdown":
+		d.selected = min(d.selected+10, len(d.panes)-1)
+	case "esc":
+		d.zoomed = false
+	case "backspace":
+		if len(d.input) > 0 {
+			d.input = d.input[:len(d.input)-1]
+		}
+	case "enter":
+		command := strings.TrimSpace(string(d.input))
+		switch {
+		case command == "":
+			d.zoomed = !d.zoomed
+		case d.cancel == nil: // One command at a time, the input is kept while one runs
+			d.input = nil
+			return tuiRun, command
+		}
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			d.input = append(d.input, []rune(key)...)
+		}
+	}
+	return tuiNone, ""
+}
+
+// start runs command on all hosts in the background and signals done when it finished
+func (d *dashboard) start(parent context.Context, hosts []string, command, user string, done chan<- struct{}) {
+	ctx, cancel := context.WithCancel(withSink(parent, d))
+
+	d.mu.Lock()
+	d.command, d.cancel = command, cancel
+	now := time.Now()
+	for _, pane := range d.panes {
+		pane.status, pane.started, pane.lines = paneRunning, now, nil
+	}
+	d.mu.Unlock()
+
+	go func() {
+		runOnHosts(ctx, hosts, nil, command, user, nil, true)
+		cancel()
+		d.mu.Lock()
+		d.cancel = nil
+		d.mu.Unlock()
+		done <- struct{}{}
+	}()
+}
+
+// interrupt cancels the running command, if any
+func (d *dashboard) interrupt() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+// render returns the screen for a terminal of the given size, lines separated by "\r\n" for raw mode
+func (d *dashboard) render(width, height int, now time.Time) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var running, ok, failed int
+	for _, pane := range d.panes {
+		switch pane.status {
+		case paneRunning:
+			running++
+		case paneOK:
+			ok++
+		case paneFailed:
+			failed++
+		}
+	}
+
+	lines := []string{truncateWidth(fmt.Sprintf("gosh · %d host(s) · %d running · %d ok · %d failed · %s",
+		len(d.panes), running, ok, failed, d.command), width)}
+	body := max(height-3, 1)
+	if d.zoomed && len(d.panes) > 0 {
+		lines = append(lines, d.renderZoom(width, body, now)...)
+	} else {
+		lines = append(lines, d.renderList(width, body, now)...)
+	}
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+
+	help := "↑/↓ select · Enter on empty input: zoom · Ctrl+C: interrupt/quit"
+	if d.zoomed {
+		help = "↑/↓ switch host · Esc: back to list · Ctrl+C: interrupt/quit"
+	}
+	lines = append(lines, truncateWidth(help, width), "> "+string(d.input))
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\033[K")
+	}
+	return b.String()
+}
+
+// renderList returns one row per host, scrolled so the selected host is visible
+func (d *dashboard) renderList(width, rows int, now time.Time) []string {
+	hosts := make([]string, len(d.panes))
+	for i, pane := range d.panes {
+		hosts[i] = pane.host
+	}
+	hostLen := maxLen(hosts)
+
+	d.top = min(d.top, d.selected)
+	if d.selected >= d.top+rows {
+		d.top = d.selected - rows + 1
+	}
+
+	var lines []string
+	for i := d.top; i < len(d.panes) && i < d.top+rows; i++ {
+		pane := d.panes[i]
+		marker := "  "
+		if i == d.selected {
+			marker = "› "
+		}
+		last := ""
+		if len(pane.lines) > 0 {
+			last = pane.lines[len(pane.lines)-1]
+		}
+		status := fmt.Sprintf("%-12s", pane.statusText(now))
+		rest := truncateWidth(status+"  "+last, width-utf8.RuneCountInString(marker)-hostLen-2)
+		lines = append(lines, marker+formatHostPrefix(pane.host, i, hostLen, d.noColor)+"  "+rest)
+	}
+	return lines
+}
+
+// renderZoom returns the selected host's title and as many of its last lines as fit
+func (d *dashboard) renderZoom(width, rows int, now time.Time) []string {
+	pane := d.panes[d.selected]
+	lines := []string{truncateWidth(fmt.Sprintf("── %s  %s", pane.host, pane.statusText(now)), width)}
+	output := pane.lines[max(len(pane.lines)-(rows-1), 0):]
+	for _, line := range output {
+		lines = append(lines, truncateWidth(line, width))
+	}
+	return lines
+}
+
+// truncateWidth cuts s to at most width runes; wide characters may still overflow slightly
+func truncateWidth(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	s = strings.ReplaceAll(s, "\t", "    ")
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// parseKeys converts raw terminal input into key names: "up", "down", "pgup", "pgdown", "esc", "enter",
+// "backspace", "ctrl-c", "ctrl-d", or the typed character. Unknown escape sequences are dropped.
+func parseKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		switch c := input[0]; {
+		case c == 0x1b && len(input) > 2 && (input[1] == '[' || input[1] == 'O'):
+			// CSI or SS3: parameters end at the first byte in 0x40-0x7e
+			end := 2
+			for end < len(input) && (input[end] < 0x40 || input[end] > 0x7e) {
+				end++
+			}
+			switch string(input[2:min(end+1, len(input))]) {
+			case "A":
+				keys = append(keys, "up")
+			case "B":
+				keys = append(keys, "down")
+			case "5~":
+				keys = append(keys, "pgup")
+			case "6~":
+				keys = append(keys, "pgdown")
+			}
+			input = input[min(end+1, len(input)):]
+			continue
+		case c == 0x1b:
+			keys = append(keys, "esc")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+		case c == 0x04:
+			keys = append(keys, "ctrl-d")
+		case c < 0x20:
+		default:
+			r, size := utf8.DecodeRune(input)
+			if r != utf8.RuneError {
+				keys = append(keys, string(r))
+			}
+			input = input[size:]
+			continue
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// readKeys sends the keys read from r until it fails
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// TUI shows a full-screen dashboard with the status and output of every host and runs the commands typed
+// into its input line over new connections. command, if set, runs right away. It returns when the user
+// quits or ctx ends.
+func TUI(ctx context.Context, hosts []string, command, user string, noColor bool) error {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd()) // #nosec G115 -- file descriptors fit in int
+	if !readline.IsTerminal(in) || !readline.IsTerminal(out) {
+		return errors.New("--tui needs a terminal")
+	}
+	state, err := readline.MakeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = readline.Restore(in, state) }()
+	fmt.Print(enterAltScreen)
+	defer fmt.Print(leaveAltScreen)
+
+	d := newDashboard(hosts, noColor)
+	defer closeHostLogs()
+
+	keys := make(chan string, 64)
+	go readKeys(os.Stdin, keys)
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+
+	done := make(chan struct{}, 1)
+	running := false
+	run := func(command string) {
+		running = true
+		d.start(ctx, hosts, command, user, done)
+	}
+	if command != "" {
+		run(command)
+	}
+
+	for {
+		width, height, err := readline.GetSize(out)
+		if err != nil {
+			width, height = 80, 24
+		}
+		fmt.Print(d.render(width, height, time.Now()))
+
+		select {
+		case <-ctx.Done():
+			if running {
+				<-done
+			}
+			return ctx.Err()
+		case <-done:
+			running = false
+		case <-d.redraw:
+		case <-ticker.C:
+		case key, ok := <-keys:
+			action, command := tuiQuit, ""
+			if ok {
+				action, command = d.handleKey(key)
+			}
+			switch action {
+			case tuiRun:
+				run(command)
+			case tuiInterrupt:
+				d.interrupt()
+			case tuiQuit:
+				if running {
+					d.interrupt()
+					<-done
+				}
+				return nil
+			case tuiNone:
+			}
+		}
+	}
+}
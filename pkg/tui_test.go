@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	input := []byte("ls\x1b[A\x1b[B\x1b[5~\x1b[6~\x1b[1;5C\r\x7f\x03\x04ü\x1b")
+	want := []string{"l", "s", "up", "down", "pgup", "pgdown", "enter", "backspace", "ctrl-c", "ctrl-d", "ü", "esc"}
+	if got := parseKeys(input); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDashboardKeys(t *testing.T) {
+	d := newDashboard([]string{"web1", "web2", "web3"}, true)
+
+	for _, key := range []string{"d", "f", "x", "backspace"} {
+		d.handleKey(key)
+	}
+	if action, command := d.handleKey("enter"); action != tuiRun || command != "df" {
+		t.Errorf("expected to run df, got %v %q", action, command)
+	}
+
+	d.handleKey("down")
+	d.handleKey("down")
+	d.handleKey("down")
+	if d.selected != 2 {
+		t.Errorf("expected the selection to stop at the last host, got %d", d.selected)
+	}
+	if d.handleKey("enter"); !d.zoomed {
+		t.Error("expected enter on an empty input to zoom")
+	}
+	if d.handleKey("esc"); d.zoomed {
+		t.Error("expected esc to leave the zoomed view")
+	}
+
+	if action, _ := d.handleKey("ctrl-c"); action != tuiQuit {
+		t.Errorf("expected ctrl-c to quit while idle, got %v", action)
+	}
+	d.cancel = func() {}
+	if action, _ := d.handleKey("ctrl-c"); action != tuiInterrupt {
+		t.Errorf("expected ctrl-c to interrupt a running command, got %v", action)
+	}
+	d.input = []rune("uptime")
+	if action, _ := d.handleKey("enter"); action != tuiNone || string(d.input) != "uptime" {
+		t.Errorf("expected the input to wait while a command runs, got %v %q", action, string(d.input))
+	}
+}
+
+func TestDashboardRender(t *testing.T) {
+	useFakeSSH(t)
+	d := newDashboard([]string{"web1", "web2"}, true)
+
+	done := make(chan struct{}, 1)
+	d.start(context.Background(), []string{"web1", "web2"}, "echo hello {host}; test {host} = web1", "", done)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not finish")
+	}
+
+	screen := d.render(60, 10, time.Now())
+	lines := strings.Split(screen, "\r\n")
+	if len(lines) != 10 {
+		t.Fatalf("expected 10 lines, got %d: %q", len(lines), screen)
+	}
+	if !strings.Contains(lines[0], "1 ok · 1 failed") {
+		t.Errorf("unexpected header: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "› web1") || !strings.Contains(lines[1], "hello web1") {
+		t.Errorf("unexpected row for web1: %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "exit 1") || !strings.Contains(lines[2], "hello web2") {
+		t.Errorf("unexpected row for web2: %q", lines[2])
+	}
+
+	d.selected, d.zoomed = 1, true
+	screen = d.render(60, 10, time.Now())
+	if !strings.Contains(screen, "── web2") || strings.Contains(screen, "hello web1") {
+		t.Errorf("unexpected zoomed screen: %q", screen)
+	}
+}
+
+func TestTruncateWidth(t *testing.T) {
+	if got := truncateWidth("abcdef", 4); got != "abc…" {
+		t.Errorf("got %q", got)
+	}
+	if got := truncateWidth("abc", 4); got != "abc" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// clearScreen moves the cursor home and clears the terminal
+const clearScreen = "\033[H\033[2J"
+
+// parseWatchInterval parses a watch interval such as "5s" or "1m"; a bare number means seconds like watch -n
+func parseWatchInterval(s string) (time.Duration, error) {
+	interval, err := time.ParseDuration(s)
+	if seconds, convErr := strconv.ParseFloat(s, 64); convErr == nil {
+		interval, err = time.Duration(seconds*float64(time.Second)), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q", s)
+	}
+	if interval <= 0 {
+		return 0, errors.New("interval must be positive")
+	}
+	return interval, nil
+}
+
+// printWatchHeader starts a watch round: on a terminal the previous round is cleared so the output
+// updates in place, otherwise rounds are separated by the header
+func printWatchHeader(interval time.Duration, command string, round int) {
+	if readline.IsTerminal(int(os.Stdout.Fd())) { // #nosec G115 -- file descriptors fit in int
+		fmt.Print(clearScreen)
+	}
+	fmt.Printf("⏱️  Every %s: %s  (round %d, %s)\n\n", interval, command, round, time.Now().Format(time.TimeOnly))
+}
+
+// waitRound waits for the next round and reports false when ctx ended first
+func waitRound(ctx context.Context, interval time.Duration) bool {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Watch runs command on all hosts every interval until ctx ends.
+// Each round waits for all hosts before the next one is scheduled.
+func Watch(ctx context.Context, hosts []string, command, user string, noColor bool, interval time.Duration) {
+	defer closeHostLogs()
+
+	for round := 1; ; round++ {
+		printWatchHeader(interval, command, round)
+		runOnHosts(ctx, hosts, nil, command, user, nil, noColor)
+		if !waitRound(ctx, interval) {
+			return
+		}
+	}
+}
+
+// watch re-runs a command on the targeted hosts on an interval until Ctrl+C, e.g. ":watch 5s df -h"
+func (s *session) watch(args string) {
+	value, command, _ := strings.Cut(strings.TrimSpace(args), " ")
+	command = strings.TrimSpace(command)
+	if command == "" {
+		fmt.Println("⏱️  Usage: :watch <interval> <command>")
+		return
+	}
+	interval, err := parseWatchInterval(value)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	command = expandAlias(command)
+	if !s.confirm(command, len(s.targetHosts())) {
+		return
+	}
+
+	// Ctrl+C while a round runs interrupts it; between rounds the terminal is not in raw mode
+	// and Ctrl+C arrives as a signal
+	ctx, stop := signal.NotifyContext(s.context(), os.Interrupt)
+	defer stop()
+
+	for round := 1; ; round++ {
+		printWatchHeader(interval, command, round)
+		if s.run(command, s.selected, nil) || !waitRound(ctx, interval) {
+			fmt.Println("🛑 Watch stopped")
+			return
+		}
+	}
+}
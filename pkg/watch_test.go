@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWatchInterval(t *testing.T) {
+	tests := map[string]time.Duration{"5s": 5 * time.Second, "1m": time.Minute, "2": 2 * time.Second, "0.5": 500 * time.Millisecond}
+	for input, want := range tests {
+		if got, err := parseWatchInterval(input); err != nil || got != want {
+			t.Errorf("parseWatchInterval(%q) = %v, %v", input, got, err)
+		}
+	}
+	for _, input := range []string{"", "soon", "0", "-5s"} {
+		if _, err := parseWatchInterval(input); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestWaitRound(t *testing.T) {
+	if !waitRound(context.Background(), time.Millisecond) {
+		t.Error("expected the interval to pass")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if waitRound(ctx, time.Hour) {
+		t.Error("expected a cancelled context to stop waiting")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	useFakeSSH(t)
+	rounds := filepath.Join(t.TempDir(), "rounds")
+
+	// Cancel once two rounds ran on both hosts
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			content, _ := os.ReadFile(rounds)
+			if strings.Count(string(content), "\n") >= 4 {
+				cancel()
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	done := make(chan string)
+	go func() {
+		done <- captureStdout(t, func() {
+			Watch(ctx, []string{"web1", "web2"}, "echo {host} >> "+rounds, "", true, 10*time.Millisecond)
+		})
+	}()
+
+	select {
+	case output := <-done:
+		if !strings.Contains(output, "round 2") {
+			t.Errorf("unexpected output: %q", output)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not stop when the context was cancelled")
+	}
+}
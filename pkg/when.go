@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// When is a guard command, e.g. "test -f /etc/redhat-release": commands only run on the hosts where it
+// succeeds, so heterogeneous fleets can branch per OS without an if-statement in every command
+var When string
+
+// guardCommand returns the remote command that runs a guard with the exported variables and shell of the
+// session. It doesn't go through sudo or --at, it only decides whether the command runs.
+func guardCommand(guard string) string {
+	command := envPrefix(Env) + guard
+	if Shell != "" {
+		command = Shell + " -lc " + shellQuote(command)
+	}
+	return command
+}
+
+// checkGuard runs the guard command of each targeted host, as built by guard, in parallel. Hosts where it
+// succeeds are returned as pass, the ones where it fails as skipped in display order. Hosts it couldn't run
+// on, e.g. as they are unreachable, are neither; their errors are printed and returned indexed like hosts.
+func checkGuard(ctx context.Context, hosts []string, targets map[string]bool, noColor bool, guard func(i int, host string) (*exec.Cmd, error)) (pass map[string]bool, skipped []string, errs []error) {
+	maxHostLen := maxLen(hosts)
+	errs = make([]error, len(hosts))
+	failed := make([]bool, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		if targets != nil && !targets[host] {
+			continue
+		}
+		wg.Go(func() {
+			cmd, err := guard(i, host)
+			if err == nil {
+				acquireFDs()
+				output, runErr := cmd.CombinedOutput()
+				releaseFDs()
+				err = classifySSHFailure(host, output, runErr)
+			}
+			var exitErr *exec.ExitError
+			switch {
+			case err == nil:
+			case ctx.Err() == nil && errors.As(err, &exitErr) && (exitCode(err) != 255 || backendOf(host) != BackendSSH):
+				failed[i] = true
+			default:
+				errs[i] = fmt.Errorf("guard: %w", err)
+				printOutput("%s: ❌ Error: %v\n", formatHostPrefix(host, i, maxHostLen, noColor), errs[i])
+			}
+		})
+	}
+	wg.Wait()
+
+	pass = make(map[string]bool)
+	for i, host := range hosts {
+		switch {
+		case targets != nil && !targets[host], errs[i] != nil:
+		case failed[i]:
+			skipped = append(skipped, host)
+		default:
+			pass[host] = true
+		}
+	}
+	return pass, skipped, errs
+}
+
+// printSkipped reports the hosts a command was skipped on because the guard failed there
+func printSkipped(w io.Writer, guard string, skipped []string) {
+	if len(skipped) > 0 {
+		fmt.Fprintf(w, "⏭️  Skipped %d host(s) where %q failed: %s\n", len(skipped), guard, strings.Join(skipped, ", "))
+	}
+}
+
+// mergeGuardErrors adds the errors of hosts the guard couldn't run on to the errors of the command
+func mergeGuardErrors(errs, guardErrs []error) []error {
+	for i, err := range guardErrs {
+		if err != nil {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// checkGuardOnHosts runs the When guard on the targeted hosts over new connections, see checkGuard
+func checkGuardOnHosts(ctx context.Context, hosts []string, targets map[string]bool, user string, noColor bool) (map[string]bool, []string, []error) {
+	return checkGuard(ctx, hosts, targets, noColor, func(i int, host string) (*exec.Cmd, error) {
+		guard := expandHostTemplate(When, host, i)
+		if err := checkCommand(guard); err != nil {
+			return nil, err
+		}
+		return hostCommand(ctx, host, guardCommand(guard), user, false), nil
+	})
+}
+
+// checkGuard runs the guard of the session on the targeted hosts over their connections, in their working
+// directory and with facts expanded, see checkGuard
+func (s *session) checkGuard(ctx context.Context, targets map[string]bool) (map[string]bool, []string, []error) {
+	cm := s.connManager
+	if usesFacts(s.when) {
+		cm.gatherFacts(ctx, s.targetHostsOf(targets), false)
+	}
+	return checkGuard(ctx, s.hosts, targets, s.noColor, func(i int, host string) (*exec.Cmd, error) {
+		guard, err := cm.expandFacts(expandHostTemplate(s.when, host, i), host)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCommand(guard); err != nil {
+			return nil, err
+		}
+		return cm.sessionCommand(ctx, host, guardCommand(cdPrefix(cm.workDir(host))+guard)), nil
+	})
+}
+
+// setWhen handles ":when [guard|off]": subsequent commands only run on the targeted hosts where the guard
+// succeeds, until it is turned off
+func (s *session) setWhen(args string) {
+	switch guard := strings.TrimSpace(args); guard {
+	case "":
+		if s.when == "" {
+			fmt.Println("🚦 Commands run on all targeted hosts; set a guard with :when <command>")
+		} else {
+			fmt.Printf("🚦 Commands only run where %q succeeds; :when off runs them everywhere\n", s.when)
+		}
+	case "off":
+		s.when = ""
+		fmt.Println("🚦 Commands run on all targeted hosts")
+	default:
+		s.when = guard
+		fmt.Printf("🚦 Commands only run where %q succeeds\n", guard)
+	}
+}
+
+// targetsWithout returns targets, all of hosts for nil, without the given hosts
+func targetsWithout(hosts []string, targets map[string]bool, without []string) map[string]bool {
+	remaining := make(map[string]bool)
+	for _, host := range hosts {
+		if (targets == nil || targets[host]) && !slices.Contains(without, host) {
+			remaining[host] = true
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExecuteCommandWhen(t *testing.T) {
+	LocalExec, When = true, "test {host} = localhost"
+	defer func() { LocalExec, When = false, "" }()
+
+	hosts := []string{"localhost", "127.0.0.1"}
+	output := captureStdout(t, func() {
+		if err := ExecuteCommand(context.Background(), hosts, "echo ran on {host}", "", true); err != nil {
+			t.Errorf("expected skipped hosts not to fail the run, got %v", err)
+		}
+	})
+	if !strings.Contains(output, "localhost: ran on localhost") || strings.Contains(output, "ran on 127.0.0.1") {
+		t.Errorf("expected the command to run on localhost only, got %q", output)
+	}
+
+	// A guard refused by the deny list can't decide anything
+	defer func() { denyList = nil }()
+	denyList = []commandPattern{compileCommandPattern("test *")}
+	captureStdout(t, func() {
+		if err := ExecuteCommand(context.Background(), hosts, "echo ran", "", true); err == nil {
+			t.Error("expected a refused guard to fail the run")
+		}
+	})
+}
+
+func TestPrintSkipped(t *testing.T) {
+	var out bytes.Buffer
+	printSkipped(&out, "test -f /etc/redhat-release", nil)
+	if out.Len() != 0 {
+		t.Errorf("expected nothing without skipped hosts, got %q", out.String())
+	}
+	printSkipped(&out, "test -f /etc/redhat-release", []string{"web1", "web2"})
+	if expected := "⏭️  Skipped 2 host(s) where \"test -f /etc/redhat-release\" failed: web1, web2\n"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestSessionWhen(t *testing.T) {
+	LocalExec = true
+	defer func() { LocalExec = false }()
+
+	hosts := []string{"localhost", "127.0.0.1"}
+	sess := &session{ctx: context.Background(), connManager: NewSSHConnectionManager(""), hosts: hosts, noColor: true}
+	if output := captureStdout(t, func() { sess.setWhen("") }); !strings.Contains(output, "run on all targeted hosts") {
+		t.Errorf("expected no guard by default, got %q", output)
+	}
+	captureStdout(t, func() { sess.setWhen(" test {index} = 1") })
+
+	output := captureStdout(t, func() { sess.run("echo ran on {host}", nil, nil) })
+	if !strings.Contains(output, "127.0.0.1: ran on 127.0.0.1") || strings.Contains(output, "ran on localhost") {
+		t.Errorf("expected the command to run on 127.0.0.1 only, got %q", output)
+	}
+	if !strings.Contains(output, "Skipped 1 host(s)") || !strings.Contains(output, ": localhost") {
+		t.Errorf("expected localhost to be reported as skipped, got %q", output)
+	}
+	if sess.last == nil || sess.last.summary() != "1✓" {
+		t.Errorf("expected skipped hosts to be left out of the result, got %+v", sess.last)
+	}
+
+	// Unknown facts fail the guard, so the command runs nowhere
+	captureStdout(t, func() { sess.setWhen("test {facts.distro} = debian") })
+	output = captureStdout(t, func() { sess.run("echo ran", nil, nil) })
+	if strings.Contains(output, ": ran") || !strings.Contains(output, "unknown fact") || sess.last.summary() != "2✗" {
+		t.Errorf("expected the guard to fail on both hosts, got %q", output)
+	}
+
+	captureStdout(t, func() { sess.setWhen("off") })
+	if output := captureStdout(t, func() { sess.run("echo ran on {host}", nil, nil) }); strings.Count(output, "ran on") != 2 {
+		t.Errorf("expected the command to run everywhere without a guard, got %q", output)
+	}
+}